@@ -0,0 +1,102 @@
+// Command eval runs an evaluation suite of prompts against personas and
+// models and prints a report that can be diffed between runs.
+//
+//	go run ./cmd/eval -suite evals/personas.yaml -provider fake
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"proomptmachinee/internal/config"
+	"proomptmachinee/internal/eval"
+	"proomptmachinee/internal/services/openai"
+	"proomptmachinee/internal/services/openai/completions"
+	"proomptmachinee/internal/services/personas"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func main() {
+	suitePath := flag.String("suite", "evals/personas.yaml", "path to the suite")
+	configPath := flag.String("config", "configs/config.yaml", "config with the api key and personas, optional for the fake provider")
+	providerName := flag.String("provider", "openai", "provider to run the suite against: openai or fake")
+	outPath := flag.String("out", "", "write the report to this file instead of stdout")
+	verbose := flag.Bool("v", false, "include passing assertions and answers in the report")
+	timeout := flag.Duration("timeout", 10*time.Minute, "timeout for the whole run")
+	flag.Parse()
+
+	report, err := run(*suitePath, *configPath, *providerName, *outPath, *verbose, *timeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "eval:", err)
+		os.Exit(2)
+	}
+	if failed := report.Failed(); failed > 0 {
+		fmt.Fprintf(os.Stderr, "eval: %d of %d cases failed\n", failed, len(report.Results))
+		os.Exit(1)
+	}
+}
+
+func run(suitePath, configPath, providerName, outPath string, verbose bool, timeout time.Duration) (*eval.Report, error) {
+	suite, err := eval.LoadSuite(suitePath)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := loadConfig(configPath, providerName == "fake")
+	if err != nil {
+		return nil, err
+	}
+
+	var provider completions.Provider
+	switch providerName {
+	case "openai":
+		if cfg.OpenAi.ApiKey == "" {
+			return nil, errors.New("openai provider needs openai.api_key in config")
+		}
+		provider = completions.NewCompletionsClient(cfg.OpenAi.ApiKey, &http.Client{Timeout: time.Minute}, openai.Gpt4oMini)
+	case "fake":
+		provider = completions.NewFakeProvider(suite.FakeResponses())
+	default:
+		return nil, fmt.Errorf("unknown provider %q", providerName)
+	}
+
+	catalog := personas.NewCatalog(append(cfg.Personas, suite.Personas...))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	report := eval.NewRunner(provider, catalog).Run(ctx, suite)
+
+	out := os.Stdout
+	if outPath != "" {
+		if out, err = os.Create(outPath); err != nil {
+			return nil, fmt.Errorf("couldn't create report: %w", err)
+		}
+		defer out.Close()
+	}
+	if err := report.Write(out, verbose); err != nil {
+		return nil, fmt.Errorf("couldn't write report: %w", err)
+	}
+
+	return report, nil
+}
+
+func loadConfig(path string, optional bool) (*config.Config, error) {
+	cfg := &config.Config{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && optional {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal config: %w", err)
+	}
+
+	return cfg, nil
+}
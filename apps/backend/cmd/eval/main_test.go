@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestFakeSuite runs the persona suite the way CI does, every case has
+// to pass against its fake response
+func TestFakeSuite(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "report.txt")
+	report, err := run("../../evals/personas.yaml", filepath.Join(t.TempDir(), "missing.yaml"), "fake", out, true, time.Minute)
+	if err != nil {
+		t.Fatalf("couldn't run the suite: %v", err)
+	}
+	if len(report.Results) == 0 {
+		t.Fatal("the suite has no cases")
+	}
+	if failed := report.Failed(); failed > 0 {
+		data, _ := os.ReadFile(out)
+		t.Errorf("%d of %d cases failed:\n%s", failed, len(report.Results), data)
+	}
+}
@@ -0,0 +1,280 @@
+// Command fakecall places a fake phone call against the telephony bridge.
+// It speaks the media stream protocol like a telephony provider would,
+// streams a WAV file or a tone as 8kHz μ-law in real time and prints what
+// the proxy plays back.
+//
+//	go run ./cmd/fakecall -to +15550100 -wav question.wav -out answer.wav
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"proomptmachinee/internal/audio"
+	"proomptmachinee/internal/services/openai/realtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// providers send 20ms chunks
+	chunkDuration = 20 * time.Millisecond
+	chunkSamples  = audio.TelephonySampleRate / 50
+)
+
+type options struct {
+	url     string
+	to      string
+	persona string
+	secret  string
+	// authToken signs the upgrade like the provider
+	authToken string
+	language  string
+	consent   bool
+	wav       string
+	tone      float64
+	duration  time.Duration
+	linger    time.Duration
+	out       string
+}
+
+func main() {
+	o := &options{}
+	flag.StringVar(&o.url, "url", "ws://localhost:4000/v1/telephony/media-stream", "media stream endpoint of the proxy")
+	flag.StringVar(&o.to, "to", "", "called number, picks the persona when it is configured")
+	flag.StringVar(&o.persona, "persona", "", "persona parameter of the call")
+	flag.StringVar(&o.secret, "secret", "", "shared secret of the telephony config")
+	flag.StringVar(&o.authToken, "auth-token", "", "auth token of the telephony config, signs the upgrade")
+	flag.StringVar(&o.language, "language", "", "language parameter of the call")
+	flag.BoolVar(&o.consent, "recording-consent", false, "consent to the call being recorded")
+	flag.StringVar(&o.wav, "wav", "", "PCM16 WAV file the caller says, a tone is sent without it")
+	flag.Float64Var(&o.tone, "tone", 440, "frequency of the tone in Hz")
+	flag.DurationVar(&o.duration, "duration", 2*time.Second, "length of the tone")
+	flag.DurationVar(&o.linger, "linger", 10*time.Second, "how long to stay on the line, sending silence, once the audio was sent")
+	flag.StringVar(&o.out, "out", "", "write the audio played back to this WAV file")
+	flag.Parse()
+
+	if err := run(o); err != nil {
+		fmt.Fprintln(os.Stderr, "fakecall:", err)
+		os.Exit(1)
+	}
+}
+
+func run(o *options) error {
+	samples, err := callerAudio(o)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	if o.authToken != "" {
+		header.Set(realtime.TwilioSignatureHeader, base64.StdEncoding.EncodeToString(realtime.SignStreamURL(o.authToken, o.url)))
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(o.url, header)
+	if err != nil {
+		return fmt.Errorf("couldn't connect: %w", err)
+	}
+	defer conn.Close()
+
+	c := &call{conn: conn, streamSid: "MZ" + uuid.NewString(), start: time.Now()}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.receive()
+	}()
+
+	params := map[string]string{realtime.CallParameterRecordingConsent: strconv.FormatBool(o.consent)}
+	for name, value := range map[string]string{
+		realtime.CallParameterTo:       o.to,
+		realtime.CallParameterPersona:  o.persona,
+		realtime.CallParameterLanguage: o.language,
+		realtime.CallParameterSecret:   o.secret,
+	} {
+		if value != "" {
+			params[name] = value
+		}
+	}
+	if err := c.send(&realtime.MediaStreamMessage{Event: realtime.MediaStreamEventConnected, Protocol: "Call", Version: "1.0.0"}); err != nil {
+		return err
+	}
+	err = c.send(&realtime.MediaStreamMessage{
+		Event: realtime.MediaStreamEventStart,
+		Start: &realtime.MediaStreamStart{
+			StreamSid:        c.streamSid,
+			CallSid:          "CA" + uuid.NewString(),
+			Tracks:           []string{"inbound"},
+			CustomParameters: params,
+			MediaFormat:      realtime.MediaFormat{Encoding: realtime.MediaEncodingMulaw, SampleRate: audio.TelephonySampleRate, Channels: 1},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// the audio goes out in real time, followed by silence so the server
+	// VAD notices the caller stopped talking
+	silence := make([]int16, int(o.linger.Seconds()*audio.TelephonySampleRate))
+	if err := c.stream(append(samples, silence...), done); err != nil {
+		return err
+	}
+
+	_ = c.send(&realtime.MediaStreamMessage{Event: realtime.MediaStreamEventStop, Stop: &realtime.MediaStreamStop{}})
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "call ended"), time.Now().Add(time.Second))
+		<-done
+	}
+
+	if o.out == "" {
+		return nil
+	}
+	f, err := os.Create(o.out)
+	if err != nil {
+		return fmt.Errorf("couldn't create %s: %w", o.out, err)
+	}
+	defer f.Close()
+	if err := audio.WriteWAV(f, audio.DecodeMulaw(c.received), audio.TelephonySampleRate); err != nil {
+		return fmt.Errorf("couldn't write %s: %w", o.out, err)
+	}
+	c.printf("wrote %d ms of played back audio to %s", len(c.received)/(audio.TelephonySampleRate/1000), o.out)
+
+	return nil
+}
+
+// callerAudio returns what the caller says at 8kHz
+func callerAudio(o *options) ([]int16, error) {
+	if o.wav == "" {
+		samples := make([]int16, int(o.duration.Seconds()*audio.TelephonySampleRate))
+		for i := range samples {
+			samples[i] = int16(8000 * math.Sin(2*math.Pi*o.tone*float64(i)/audio.TelephonySampleRate))
+		}
+		return samples, nil
+	}
+
+	f, err := os.Open(o.wav)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open %s: %w", o.wav, err)
+	}
+	defer f.Close()
+	samples, sampleRate, err := audio.ReadWAV(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", o.wav, err)
+	}
+	if sampleRate != audio.TelephonySampleRate {
+		samples = audio.NewResampler(sampleRate, audio.TelephonySampleRate).Process(samples)
+	}
+
+	return samples, nil
+}
+
+type call struct {
+	conn      *websocket.Conn
+	streamSid string
+	start     time.Time
+
+	// sequence belongs to the sender, received to the receiver until it
+	// is done
+	sequence int
+	received []byte
+
+	mu sync.Mutex
+}
+
+func (c *call) send(msg *realtime.MediaStreamMessage) error {
+	c.sequence++
+	msg.SequenceNumber = strconv.Itoa(c.sequence)
+	if msg.Event != realtime.MediaStreamEventConnected {
+		msg.StreamSid = c.streamSid
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		return fmt.Errorf("couldn't send %s: %w", msg.Event, err)
+	}
+
+	return nil
+}
+
+// stream sends samples as 20ms media chunks, paced like a live call
+func (c *call) stream(samples []int16, done <-chan struct{}) error {
+	ticker := time.NewTicker(chunkDuration)
+	defer ticker.Stop()
+	for chunk := 0; chunk*chunkSamples < len(samples); chunk++ {
+		end := min((chunk+1)*chunkSamples, len(samples))
+		err := c.send(&realtime.MediaStreamMessage{
+			Event: realtime.MediaStreamEventMedia,
+			Media: &realtime.MediaStreamMedia{
+				Track:     "inbound",
+				Chunk:     strconv.Itoa(chunk + 1),
+				Timestamp: strconv.FormatInt(int64(chunk)*chunkDuration.Milliseconds(), 10),
+				Payload:   base64.StdEncoding.EncodeToString(audio.EncodeMulaw(samples[chunk*chunkSamples : end])),
+			},
+		})
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return errors.New("the proxy hung up")
+		}
+	}
+
+	return nil
+}
+
+// receive prints what the proxy sends until it closes the stream
+func (c *call) receive() {
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				c.printf("closed %d %s", closeErr.Code, closeErr.Text)
+			}
+			return
+		}
+		var msg realtime.MediaStreamMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.printf("%s", data)
+			continue
+		}
+		switch msg.Event {
+		case realtime.MediaStreamEventMedia:
+			if msg.Media == nil {
+				continue
+			}
+			payload, err := base64.StdEncoding.DecodeString(msg.Media.Payload)
+			if err != nil {
+				c.printf("invalid media payload: %v", err)
+				continue
+			}
+			c.received = append(c.received, payload...)
+			c.printf("media %d bytes", len(payload))
+		case realtime.MediaStreamEventMark:
+			if msg.Mark != nil {
+				c.printf("mark %s", msg.Mark.Name)
+			}
+		default:
+			c.printf("%s", msg.Event)
+		}
+	}
+}
+
+func (c *call) printf(format string, args ...interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Printf("%8dms %s\n", time.Since(c.start).Milliseconds(), fmt.Sprintf(format, args...))
+}
@@ -1,14 +1,25 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"os"
 	"proomptmachinee/internal/api"
+	"proomptmachinee/internal/bible"
 	"proomptmachinee/internal/config"
+	"proomptmachinee/internal/database"
+	"proomptmachinee/internal/services/conversations"
+	"proomptmachinee/internal/services/experiments"
+	"proomptmachinee/internal/services/feedback"
 	"proomptmachinee/internal/services/keycloak"
 	"proomptmachinee/internal/services/openai"
 	"proomptmachinee/internal/services/openai/completions"
 	"proomptmachinee/internal/services/openai/realtime"
+	"proomptmachinee/internal/services/openai/speech"
+	"proomptmachinee/internal/services/personas"
+	"proomptmachinee/internal/services/recordings"
+	"proomptmachinee/internal/services/tools"
+	"proomptmachinee/internal/services/usage"
 	resp_errors "proomptmachinee/pkg/errors"
 	"proomptmachinee/pkg/logger"
 	"proomptmachinee/pkg/resputil"
@@ -28,10 +39,76 @@ func main() {
 	if err != nil {
 		log.Fatal("couldn't unmarshal config", err)
 	}
+	db, err := database.Open(context.Background(), cfg.Database.DSN)
+	if err != nil {
+		log.Fatal("couldn't connect to database", err)
+	}
+	defer db.Close()
+	err = database.Migrate(context.Background(), db)
+	if err != nil {
+		log.Fatal("couldn't migrate database", err)
+	}
+	exps, err := experiments.New(cfg.Experiments)
+	if err != nil {
+		log.Fatal("invalid experiments config", err)
+	}
 	key := cfg.OpenAi.ApiKey
 	httpClient := &http.Client{}
 	completionsClient := completions.NewCompletionsClient(key, httpClient, openai.Gpt4oMini)
-	realtimeClient := realtime.NewRealtimeClient(key, openai.Gpt40RealtimePreview)
+	ledger := usage.NewLedger(db)
+	personaCatalog := personas.NewCatalog(cfg.Personas)
+	conversationStore := conversations.NewStore(db)
+	var recs *recordings.Recordings
+	if cfg.Recordings.Enabled {
+		storage, err := recordings.NewDiskStorage(cfg.Recordings.Dir)
+		if err != nil {
+			log.Fatal("couldn't open recordings storage", err)
+		}
+		recs = recordings.New(cfg.Recordings, storage)
+		go recs.RunRetention(context.Background(), time.Hour)
+	}
+	// journals hold audio and transcripts too, they are pruned like
+	// recordings but by their own limits
+	if j := cfg.Realtime.Journal; j.Enabled {
+		storage, err := recordings.NewDiskStorage(j.Dir)
+		if err != nil {
+			log.Fatal("couldn't open journal directory", err)
+		}
+		retention := config.RecordingsConfig{MaxAge: j.MaxAge, MaxTotalMB: j.MaxTotalMB}
+		go recordings.New(retention, storage).RunRetention(context.Background(), time.Hour)
+	}
+	var bibleText *bible.Text
+	if cfg.Bible.Text != "" {
+		if bibleText, err = bible.LoadText(cfg.Bible.Text); err != nil {
+			log.Fatal("couldn't load bible text", err)
+		}
+	}
+	toolRegistry := tools.NewRegistry()
+	toolRegistry.Register(tools.BibleLookup(bibleText))
+	for _, p := range cfg.Personas {
+		if _, err := toolRegistry.Definitions(p.Tools); err != nil {
+			log.Fatal("invalid tools of persona "+p.Name, err)
+		}
+	}
+	if t := cfg.Realtime.Telephony; t.Enabled && t.Secret == "" && t.AuthToken == "" {
+		log.Fatal("invalid telephony config", realtime.ErrTelephonyUnsecured)
+	}
+	realtimeClient := realtime.NewRealtimeClient(key, openai.Gpt40RealtimePreview, cfg.Realtime, personaCatalog, ledger, conversationStore, recs, toolRegistry)
+	if f := cfg.Realtime.Fallback; f.Enabled {
+		if f.Fake {
+			realtimeClient.UseFallback(realtime.NewFakeFallback("Hello, who are you?", "Peace be with you. I am here to listen."))
+		} else {
+			speechClient := speech.NewClient(key, f.TranscriptionModel, f.SpeechModel)
+			realtimeClient.UseFallback(&realtime.Fallback{
+				Transcriber: speechClient,
+				Synthesizer: speechClient,
+				Chat:        completionsClient,
+				ChatModel:   f.ChatModel,
+				TranscriptionModel: speechClient.TranscriptionModel(),
+				SpeechModel:        speechClient.SpeechModel(),
+			})
+		}
+	}
 	kcValidator := keycloak.NewValidator(cfg.Keycloak.Oauth2IssuerURL)
 	errResp := resp_errors.New(log)
 	resp := resputil.NewResputil()
@@ -40,7 +117,14 @@ func main() {
 		log,
 		realtimeClient,
 		resp,
-		errResp)
+		errResp,
+		personaCatalog,
+		conversationStore,
+		feedback.NewStore(db),
+		exps,
+		experiments.NewStore(db),
+		ledger,
+		cfg.Keycloak.AdminRole)
 	server := &http.Server{
 		Addr:        ":4000",
 		Handler:     chatBotApi.Routes(),
@@ -0,0 +1,263 @@
+// Command rtreplay replays a realtime session journal against the proxy.
+// The proxy runs in process with a fake OpenAI upstream, the client and
+// upstream frames of the journal are sent with their original timing and
+// everything the proxy passes on is printed.
+//
+//	go run ./cmd/rtreplay -journal journals/20240101T120000Z_<session>.jsonl
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"proomptmachinee/internal/config"
+	"proomptmachinee/internal/services/openai"
+	"proomptmachinee/internal/services/openai/realtime"
+	"proomptmachinee/internal/services/personas"
+	"proomptmachinee/internal/services/tools"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"gopkg.in/yaml.v3"
+)
+
+func main() {
+	journalPath := flag.String("journal", "", "path to the journal to replay")
+	configPath := flag.String("config", "configs/config.yaml", "config with the personas and realtime settings, optional")
+	speed := flag.Float64("speed", 1, "replay speed, 2 replays twice as fast")
+	linger := flag.Duration("linger", time.Second, "how long to wait for the proxy after the last frame")
+	journalDir := flag.String("journal-out", "", "journal the replayed session into this directory")
+	flag.Parse()
+
+	if *journalPath == "" || *speed <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*journalPath, *configPath, *speed, *linger, *journalDir); err != nil {
+		fmt.Fprintln(os.Stderr, "rtreplay:", err)
+		os.Exit(1)
+	}
+}
+
+func run(journalPath, configPath string, speed float64, linger time.Duration, journalDir string) error {
+	f, err := os.Open(journalPath)
+	if err != nil {
+		return fmt.Errorf("couldn't open journal: %w", err)
+	}
+	entries, err := realtime.ReadJournal(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 || entries[0].From != realtime.PeerProxy || entries[0].SessionID == "" {
+		return errors.New("journal doesn't start with a session start entry")
+	}
+
+	var clientFrames, upstreamFrames []*realtime.JournalEntry
+	for _, e := range entries[1:] {
+		switch e.From {
+		case realtime.PeerClient:
+			clientFrames = append(clientFrames, e)
+		case realtime.PeerUpstream:
+			upstreamFrames = append(upstreamFrames, e)
+		}
+	}
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	r := &replay{speed: speed, out: os.Stdout}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.serveUpstream(w, req, upstreamFrames)
+	}))
+	defer upstream.Close()
+
+	realtimeCfg := cfg.Realtime
+	realtimeCfg.URL = "ws" + strings.TrimPrefix(upstream.URL, "http")
+	realtimeCfg.Journal = config.RealtimeJournal{Enabled: journalDir != "", Dir: journalDir, IncludeAudio: true}
+	// nothing is stored while replaying
+	realtimeCfg.StoreTranscripts = false
+	// the replayed client isn't authenticated
+	realtimeCfg.AllowAnonymous = true
+	client := realtime.NewRealtimeClient("replay", openai.Gpt40RealtimePreview, realtimeCfg, personas.NewCatalog(cfg.Personas), nil, nil, nil, replayTools())
+	// hijacked connections aren't tracked by the test server, the session
+	// is waited for so its journal is complete
+	var sessions sync.WaitGroup
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sessions.Add(1)
+		defer sessions.Done()
+		if err := client.WsHandler(w, req, nil); err != nil {
+			r.printf("proxy", "session ended: %v", err)
+		}
+	}))
+	defer proxy.Close()
+
+	r.start = time.Now()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(proxy.URL, "http")+"/?"+entries[0].Query, nil)
+	if err != nil {
+		return fmt.Errorf("couldn't connect to the proxy: %w", err)
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.receive(conn, "client")
+	}()
+
+	for _, e := range clientFrames {
+		r.wait(e.OffsetMs)
+		if err := r.send(conn, e); err != nil {
+			return fmt.Errorf("couldn't send client frame: %w", err)
+		}
+	}
+	if len(upstreamFrames) > 0 {
+		r.wait(upstreamFrames[len(upstreamFrames)-1].OffsetMs)
+	}
+	time.Sleep(linger)
+
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "replay finished")
+	_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		return errors.New("proxy didn't close the session")
+	}
+	sessions.Wait()
+	r.wg.Wait()
+
+	return nil
+}
+
+// replayTools are the tools of the server, without a bible text so
+// replays don't depend on one
+func replayTools() *tools.Registry {
+	registry := tools.NewRegistry()
+	registry.Register(tools.BibleLookup(nil))
+
+	return registry
+}
+
+type replay struct {
+	speed float64
+	start time.Time
+	wg    sync.WaitGroup
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// wait sleeps until the journal offset, scaled by the replay speed
+func (r *replay) wait(offsetMs int64) {
+	at := r.start.Add(time.Duration(float64(offsetMs)/r.speed) * time.Millisecond)
+	time.Sleep(time.Until(at))
+}
+
+func (r *replay) send(conn *websocket.Conn, e *realtime.JournalEntry) error {
+	msg, err := e.Message()
+	if err != nil {
+		return err
+	}
+
+	return conn.WriteMessage(msg.Type, msg.Content)
+}
+
+// serveUpstream plays the OpenAI side of the journal
+func (r *replay) serveUpstream(w http.ResponseWriter, req *http.Request, frames []*realtime.JournalEntry) {
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		r.printf("upstream", "couldn't upgrade: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.receive(conn, "upstream")
+	}()
+
+	for _, e := range frames {
+		r.wait(e.OffsetMs)
+		if err := r.send(conn, e); err != nil {
+			r.printf("upstream", "couldn't send frame: %v", err)
+			return
+		}
+	}
+	r.wg.Wait()
+}
+
+// receive prints the frames the proxy sends to peer until it closes
+func (r *replay) receive(conn *websocket.Conn, peer string) {
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				r.printf(peer, "closed %d %s", closeErr.Code, closeErr.Text)
+			}
+			return
+		}
+		if messageType != websocket.TextMessage {
+			r.printf(peer, "binary frame of %d bytes", len(data))
+			continue
+		}
+		event, err := realtime.ParseServerEvent(data)
+		if err != nil {
+			// client events reach the upstream
+			event, err = realtime.ParseClientEvent(data)
+		}
+		if err != nil {
+			r.printf(peer, "%s", data)
+			continue
+		}
+		switch e := event.(type) {
+		case *realtime.ErrorEvent:
+			r.printf(peer, "%s %s: %s", e.Type, e.Error.Code, e.Error.Message)
+		case *realtime.InputAudioBufferAppendEvent:
+			r.printf(peer, "%s %d audio bytes", e.Type, base64.StdEncoding.DecodedLen(len(e.Audio)))
+		case *realtime.ResponseDeltaEvent:
+			if e.Type == realtime.EventTypeResponseAudioDelta {
+				r.printf(peer, "%s %d audio bytes", e.Type, base64.StdEncoding.DecodedLen(len(e.Delta)))
+				continue
+			}
+			r.printf(peer, "%s", e.Type)
+		default:
+			r.printf(peer, "%s", event.EventType())
+		}
+	}
+}
+
+func (r *replay) printf(peer, format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	offset := time.Since(r.start).Milliseconds()
+	fmt.Fprintf(r.out, "%8dms %-8s <- %s\n", offset, peer, fmt.Sprintf(format, args...))
+}
+
+func loadConfig(path string) (*config.Config, error) {
+	cfg := &config.Config{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal config: %w", err)
+	}
+
+	return cfg, nil
+}
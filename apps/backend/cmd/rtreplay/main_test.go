@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"proomptmachinee/internal/services/openai/realtime"
+	"testing"
+	"time"
+)
+
+const testJournal = `{"time":"2024-01-01T12:00:00Z","offset_ms":0,"session_id":"recorded","query":"","from":"proxy"}
+{"time":"2024-01-01T12:00:00.01Z","offset_ms":10,"from":"client","to":"upstream","data":{"type":"conversation.item.create","item":{"type":"message","role":"user","content":[{"type":"input_text","text":"hello"}]}}}
+{"time":"2024-01-01T12:00:00.05Z","offset_ms":50,"from":"upstream","to":"client","data":{"type":"response.text.delta","delta":"peace"}}
+`
+
+const testConfig = `personas:
+  - name: test
+    instructions: You are a test.
+`
+
+// TestReplay replays a journal and checks the proxy passed both sides on
+// in the journal of the replayed session
+func TestReplay(t *testing.T) {
+	dir := t.TempDir()
+	journalPath := filepath.Join(dir, "recorded.jsonl")
+	configPath := filepath.Join(dir, "config.yaml")
+	os.WriteFile(journalPath, []byte(testJournal), 0o600)
+	os.WriteFile(configPath, []byte(testConfig), 0o600)
+	out := filepath.Join(dir, "out")
+
+	if err := run(journalPath, configPath, 5, 100*time.Millisecond, out); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(out, "*.jsonl"))
+	if len(files) != 1 {
+		t.Fatalf("replay wrote journals %v, want one", files)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatalf("couldn't open replayed journal: %v", err)
+	}
+	defer f.Close()
+	entries, err := realtime.ReadJournal(f)
+	if err != nil {
+		t.Fatalf("couldn't read replayed journal: %v", err)
+	}
+
+	seen := map[string]bool{}
+	for _, e := range entries[1:] {
+		event, err := realtime.ParseServerEvent(e.Data)
+		if err != nil {
+			event, err = realtime.ParseClientEvent(e.Data)
+		}
+		if err == nil {
+			seen[e.From+">"+e.To+" "+event.EventType()] = true
+		}
+	}
+	for _, want := range []string{
+		"proxy>upstream " + realtime.EventTypeSessionUpdate,
+		"client>upstream " + realtime.EventTypeConversationItemCreate,
+		"upstream>client " + realtime.EventTypeResponseTextDelta,
+	} {
+		if !seen[want] {
+			t.Errorf("replay didn't pass on %s, saw %v", want, seen)
+		}
+	}
+}
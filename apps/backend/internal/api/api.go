@@ -1,9 +1,14 @@
 package api
 
 import (
+	"proomptmachinee/internal/services/conversations"
+	"proomptmachinee/internal/services/experiments"
+	"proomptmachinee/internal/services/feedback"
 	"proomptmachinee/internal/services/keycloak"
 	"proomptmachinee/internal/services/openai/completions"
 	"proomptmachinee/internal/services/openai/realtime"
+	"proomptmachinee/internal/services/personas"
+	"proomptmachinee/internal/services/usage"
 	"proomptmachinee/pkg/errors"
 	"proomptmachinee/pkg/logger"
 	"proomptmachinee/pkg/resputil"
@@ -13,6 +18,14 @@ type Api struct {
 	completionsClient *completions.Client
 	realtimeClient    *realtime.Client
 	keycloakValidator *keycloak.Validator
+	personas          *personas.Catalog
+	conversations     *conversations.Store
+	feedback          *feedback.Store
+	experiments       *experiments.Experiments
+	experimentResults *experiments.Store
+	ledger            *usage.Ledger
+	wsTickets         *wsTickets
+	adminRole         string
 	logger            logger.Logger
 	resputil          resputil.Resputil
 	errResp           resp_errors.ErrResponder
@@ -24,11 +37,26 @@ func New(compClient *completions.Client,
 	realtimeClient *realtime.Client,
 	resputil resputil.Resputil,
 	errResp resp_errors.ErrResponder,
+	personas *personas.Catalog,
+	conversations *conversations.Store,
+	feedback *feedback.Store,
+	experiments *experiments.Experiments,
+	experimentResults *experiments.Store,
+	ledger *usage.Ledger,
+	adminRole string,
 ) *Api {
 	return &Api{
 		completionsClient: compClient,
 		realtimeClient:    realtimeClient,
 		keycloakValidator: keycloakValidator,
+		personas:          personas,
+		conversations:     conversations,
+		feedback:          feedback,
+		experiments:       experiments,
+		experimentResults: experimentResults,
+		ledger:            ledger,
+		wsTickets:         newWsTickets(),
+		adminRole:         adminRole,
 		resputil:          resputil,
 		logger:            logger,
 		errResp:           errResp,
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"proomptmachinee/internal/services/experiments"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func (api *Api) handleExperimentResults(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	exp, ok := api.experiments.Get(params.ByName("name"))
+	if !ok {
+		api.errResp.NotFound(w)
+		return
+	}
+
+	query := r.URL.Query()
+	from, err := parseDate(query.Get("from"))
+	if err != nil {
+		api.errResp.BadRequest(w)
+		return
+	}
+	to, err := parseDate(query.Get("to"))
+	if err != nil {
+		api.errResp.BadRequest(w)
+		return
+	}
+
+	results, err := api.experimentResults.Results(r.Context(), exp.Name, from, to)
+	if err != nil {
+		api.errResp.InternalServerError(w, err)
+		return
+	}
+
+	// list every configured variant, even the ones without answers yet
+	byVariant := make(map[string]*experiments.VariantResult, len(results))
+	for _, res := range results {
+		byVariant[res.Variant] = res
+	}
+	resp := &ExperimentResultsResponse{
+		Experiment: exp.Name,
+		Persona:    exp.Persona,
+		Variants:   make([]*experiments.VariantResult, 0, len(exp.Variants)),
+	}
+	for _, v := range exp.Variants {
+		res, ok := byVariant[v.Name]
+		if !ok {
+			res = &experiments.VariantResult{Variant: v.Name}
+		}
+		resp.Variants = append(resp.Variants, res)
+	}
+	if !from.IsZero() {
+		resp.From = from.Format(time.RFC3339)
+	}
+	if !to.IsZero() {
+		resp.To = to.Format(time.RFC3339)
+	}
+
+	if err := api.resputil.Ok(w, resp); err != nil {
+		api.errResp.InternalServerError(w, err)
+	}
+}
@@ -1,7 +1,32 @@
 package api
 
+import "proomptmachinee/internal/services/experiments"
+
 type TestData struct {
 	UserId string `json:"user_id"`
 	Age    int    `json:"age"`
 	Email  string `json:"email"`
 }
+
+type FeedbackRequest struct {
+	// Rating is either `up` or `down`
+	Rating   string `json:"rating"`
+	Category string `json:"category"`
+	Comment  string `json:"comment"`
+}
+
+type FeedbackResponse struct {
+	MessageID string `json:"message_id"`
+	Rating    string `json:"rating"`
+	Category  string `json:"category,omitempty"`
+	Comment   string `json:"comment,omitempty"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+type ExperimentResultsResponse struct {
+	Experiment string                       `json:"experiment"`
+	Persona    string                       `json:"persona"`
+	From       string                       `json:"from,omitempty"`
+	To         string                       `json:"to,omitempty"`
+	Variants   []*experiments.VariantResult `json:"variants"`
+}
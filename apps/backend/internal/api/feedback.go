@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"proomptmachinee/internal/services/conversations"
+	"proomptmachinee/internal/services/feedback"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+var ratings = map[string]int{
+	"up":   feedback.RatingUp,
+	"down": feedback.RatingDown,
+}
+
+func ratingName(rating int) string {
+	if rating == feedback.RatingUp {
+		return "up"
+	}
+
+	return "down"
+}
+
+func (api *Api) handleMessageFeedback(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	messageID, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		api.errResp.NotFound(w)
+		return
+	}
+
+	var req FeedbackRequest
+	if err := readJSON(w, r, &req); err != nil {
+		api.errResp.BadRequest(w)
+		return
+	}
+
+	userID := userIDFromContext(r)
+	msg, err := api.conversations.GetMessage(r.Context(), messageID, userID)
+	if errors.Is(err, conversations.ErrNotFound) {
+		api.errResp.NotFound(w)
+		return
+	}
+	if err != nil {
+		api.errResp.InternalServerError(w, err)
+		return
+	}
+	// only answers can be rated
+	if msg.Role != conversations.RoleAssistant {
+		api.errResp.BadRequest(w)
+		return
+	}
+
+	fb := &feedback.Feedback{
+		MessageID: msg.ID,
+		UserID:    userID,
+		Rating:    ratings[req.Rating],
+		Category:  req.Category,
+		Comment:   req.Comment,
+	}
+	if err := fb.Validate(); err != nil {
+		api.errResp.BadRequest(w)
+		return
+	}
+	if err := api.feedback.Save(r.Context(), fb); err != nil {
+		api.errResp.InternalServerError(w, err)
+		return
+	}
+
+	err = api.resputil.Ok(w, &FeedbackResponse{
+		MessageID: fb.MessageID.String(),
+		Rating:    ratingName(fb.Rating),
+		Category:  fb.Category,
+		Comment:   fb.Comment,
+		UpdatedAt: fb.UpdatedAt.Format(time.RFC3339),
+	})
+	if err != nil {
+		api.errResp.InternalServerError(w, err)
+	}
+}
+
+// handleFeedbackExport streams the rated prompt/response pairs as JSONL
+func (api *Api) handleFeedbackExport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := feedback.ExportFilter{
+		Persona: query.Get("persona"),
+		Model:   query.Get("model"),
+	}
+
+	var err error
+	if filter.From, err = parseDate(query.Get("from")); err != nil {
+		api.errResp.BadRequest(w)
+		return
+	}
+	if filter.To, err = parseDate(query.Get("to")); err != nil {
+		api.errResp.BadRequest(w)
+		return
+	}
+	if rating := query.Get("rating"); rating != "" {
+		var ok bool
+		if filter.Rating, ok = ratings[rating]; !ok {
+			api.errResp.BadRequest(w)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="feedback.jsonl"`)
+	enc := json.NewEncoder(w)
+	err = api.feedback.Export(r.Context(), filter, func(p *feedback.Pair) error {
+		return enc.Encode(p)
+	})
+	if err != nil {
+		// the status line is already written, all we can do is log it
+		api.logger.Error("couldn't export feedback", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
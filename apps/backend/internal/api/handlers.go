@@ -2,24 +2,152 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"proomptmachinee/internal/services/conversations"
+	"proomptmachinee/internal/services/keycloak"
+	"proomptmachinee/internal/services/openai"
+	"proomptmachinee/internal/services/openai/realtime"
+	"proomptmachinee/internal/services/usage"
 	"strings"
 	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 )
 
 func (api *Api) handleStream(w http.ResponseWriter, r *http.Request) {
 	queryParams := r.URL.Query()
 	message := queryParams.Get("prompt")
-	response, err := api.completionsClient.SendPrompt(message)
+	persona, err := api.personas.Get(queryParams.Get("persona"))
 	if err != nil {
-		log.Fatal(err)
+		api.errResp.BadRequest(w)
+		return
 	}
 
-	err = response.Receive(w)
+	conversationID := uuid.New()
+	if id := queryParams.Get("conversation_id"); id != "" {
+		if conversationID, err = uuid.Parse(id); err != nil {
+			api.errResp.BadRequest(w)
+			return
+		}
+	}
+	conversation, err := api.conversations.Ensure(r.Context(), conversationID, userIDFromContext(r), persona.Name)
+	if errors.Is(err, conversations.ErrNotFound) {
+		api.errResp.NotFound(w)
+		return
+	}
 	if err != nil {
 		api.errResp.InternalServerError(w, err)
+		return
+	}
+
+	// anonymous users are bucketed per conversation
+	subject := userIDFromContext(r)
+	if subject == "" {
+		subject = conversation.ID.String()
+	}
+	assignment := api.experiments.Assign(persona, subject)
+	persona = assignment.Persona
+
+	model := persona.Model
+	if model == "" {
+		model = api.completionsClient.Model()
+	}
+	prompt := &conversations.Message{
+		ConversationID: conversation.ID,
+		Role:           conversations.RoleUser,
+		Content:        message,
+		Persona:        persona.Name,
+		Model:          model,
+		Experiment:     assignment.Experiment,
+		Variant:        assignment.Variant,
+	}
+	if err := api.conversations.AddMessage(r.Context(), prompt); err != nil {
+		api.errResp.InternalServerError(w, err)
+		return
+	}
+
+	response, err := api.completionsClient.SendPrompt(r.Context(), message, persona.Instructions, model)
+	if err != nil {
+		api.recordCompletionError(r, conversation.UserID, &conversation.ID, model, openai.Usage{})
+		api.errResp.InternalServerError(w, err)
+		return
+	}
+
+	answer := &conversations.Message{
+		ID:             uuid.New(),
+		ConversationID: conversation.ID,
+		ParentID:       &prompt.ID,
+		Role:           conversations.RoleAssistant,
+		Persona:        persona.Name,
+		Model:          model,
+		Experiment:     assignment.Experiment,
+		Variant:        assignment.Variant,
+	}
+	completion, streamErr := response.Receive(w, map[string]string{
+		"conversation_id": conversation.ID.String(),
+		"message_id":      answer.ID.String(),
+	})
+	if streamErr != nil {
+		// the event stream has started, all we can do is log it
+		api.logger.Error("couldn't stream answer", map[string]interface{}{
+			"error":           streamErr.Error(),
+			"conversation_id": conversation.ID.String(),
+		})
+	}
+	answer.Content = completion.Content
+	if answer.Content == "" {
+		api.recordCompletionError(r, conversation.UserID, &conversation.ID, model, completion.Usage)
+		return
+	}
+	entry := &usage.Entry{
+		UserID:         conversation.UserID,
+		ConversationID: &conversation.ID,
+		MessageID:      &answer.ID,
+		Source:         usage.SourceCompletion,
+		Model:          model,
+		Usage:          completion.Usage,
+	}
+	// the request context may already be cancelled if the client went away
+	// mid stream, the partial answer is still worth keeping
+	ctx := context.WithoutCancel(r.Context())
+	if err := api.conversations.AddMessage(ctx, answer); err != nil {
+		api.logger.Error("couldn't store answer", map[string]interface{}{
+			"error":           err.Error(),
+			"conversation_id": conversation.ID.String(),
+		})
+		// the tokens were paid for all the same
+		entry.MessageID = nil
+	}
+	// a stream cut short still produced tokens we pay for
+	if streamErr != nil {
+		entry.Status = usage.StatusError
+	}
+	if err := api.ledger.Record(ctx, entry); err != nil {
+		api.logger.Error("couldn't record usage", map[string]interface{}{
+			"error":           err.Error(),
+			"conversation_id": conversation.ID.String(),
+		})
+	}
+}
+
+// recordCompletionError records a completion that produced no answer,
+// so it counts towards the error rate, with the tokens it used anyway
+func (api *Api) recordCompletionError(r *http.Request, userID string, conversationID *uuid.UUID, model string, tokens openai.Usage) {
+	err := api.ledger.Record(context.WithoutCancel(r.Context()), &usage.Entry{
+		UserID:         userID,
+		ConversationID: conversationID,
+		Source:         usage.SourceCompletion,
+		Model:          model,
+		Status:         usage.StatusError,
+		Usage:          tokens,
+	})
+	if err != nil {
+		api.logger.Error("couldn't record usage", map[string]interface{}{
+			"error": err.Error(),
+		})
 	}
 }
 
@@ -38,9 +166,26 @@ func (api *Api) handleGetTestData(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *Api) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	err := api.realtimeClient.WsHandler(w, r)
+	var caller *realtime.Caller
+	if claims := claimsFromContext(r); claims != nil {
+		caller = &realtime.Caller{UserID: claims.UserID, Roles: claims.Roles, ExpiresAt: claims.ExpiresAt}
+	}
+	// the connection is hijacked, all that is left is to log why it ended
+	err := api.realtimeClient.WsHandler(w, r, caller)
+	if err != nil {
+		api.logger.Error("realtime session failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
+func (api *Api) handleTelephony(w http.ResponseWriter, r *http.Request) {
+	// like handleWebSocket, the call is bridged on the hijacked connection
+	err := api.realtimeClient.TelephonyHandler(w, r)
 	if err != nil {
-		log.Fatal(err)
+		api.logger.Error("telephony session failed", map[string]interface{}{
+			"error": err.Error(),
+		})
 	}
 }
 
@@ -59,9 +204,8 @@ func (api *Api) healthcheck(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *Api) testToken(w http.ResponseWriter, r *http.Request) {
-	// just for testing, this could cause panic if the userId is not present
-	userId := r.Context().Value("userId").(string)
-	w.Write([]byte(fmt.Sprintf("User ID: %s\n", userId)))
+	// just for testing, the user id is empty if the route isn't authenticated
+	w.Write([]byte(fmt.Sprintf("User ID: %s\n", userIDFromContext(r))))
 }
 
 // should be moved to middleware
@@ -69,17 +213,77 @@ func (api *Api) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		token := strings.TrimPrefix(authHeader, "Bearer ")
-		userId, err := api.keycloakValidator.ValidateTokenSignature(token)
+		claims, err := api.keycloakValidator.ValidateTokenSignature(token)
 		if err != nil {
 			api.errResp.Unauthorized(w)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), "userId", userId)
+		ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// optionalAuthMiddleware lets anonymous requests through, but a token
+// that is sent has to be valid
+func (api *Api) optionalAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		api.authMiddleware(next).ServeHTTP(w, r)
+	})
+}
+
+// wsAuthMiddleware authenticates WebSocket upgrades, browsers send a
+// ticket from /v1/ws-tickets or the token as a `bearer.<token>`
+// subprotocol instead of the Authorization header. Requests without any
+// are let through, the realtime client decides about anonymous sessions.
+func (api *Api) wsAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var claims *keycloak.Claims
+		if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+			claims = api.wsTickets.redeem(ticket, time.Now())
+			if claims == nil {
+				api.errResp.Unauthorized(w)
+				return
+			}
+		} else {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if token == "" {
+				token = realtime.BearerFromSubprotocols(websocket.Subprotocols(r))
+			}
+			if token == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			var err error
+			claims, err = api.keycloakValidator.ValidateTokenSignature(token)
+			if err != nil {
+				api.errResp.Unauthorized(w)
+				return
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), claimsContextKey, claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// adminMiddleware has to run after authMiddleware
+func (api *Api) adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := claimsFromContext(r)
+		if claims == nil || api.adminRole == "" || !claims.HasRole(api.adminRole) {
+			api.errResp.Forbidden(w)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // same as above
 func (api *Api) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"proomptmachinee/internal/services/keycloak"
+	"proomptmachinee/internal/services/openai/realtime"
+	resp_errors "proomptmachinee/pkg/errors"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+// testIssuer is a keycloak realm serving the key its tokens are signed
+// with
+type testIssuer struct {
+	url    string
+	signer jose.Signer
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("couldn't generate key: %v", err)
+	}
+	jwks := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "test", Use: "sig", Algorithm: string(jose.RS256)}}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwks)
+	}))
+	t.Cleanup(server.Close)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithHeader("kid", "test"))
+	if err != nil {
+		t.Fatalf("couldn't create signer: %v", err)
+	}
+
+	return &testIssuer{url: server.URL, signer: signer}
+}
+
+// token signs a token for the user that expires after expiresIn
+func (i *testIssuer) token(t *testing.T, userID string, expiresIn time.Duration) string {
+	t.Helper()
+	claims := jwt.Claims{Subject: userID, Issuer: i.url, Expiry: jwt.NewNumericDate(time.Now().Add(expiresIn))}
+	token, err := jwt.Signed(i.signer).Claims(claims).Serialize()
+	if err != nil {
+		t.Fatalf("couldn't sign token: %v", err)
+	}
+
+	return token
+}
+
+func TestWsAuthMiddleware(t *testing.T) {
+	issuer := newTestIssuer(t)
+	api := &Api{
+		keycloakValidator: keycloak.NewValidator(issuer.url),
+		wsTickets:         newWsTickets(),
+		errResp:           resp_errors.New(nil),
+	}
+	ticket, _, _ := api.wsTickets.issue(&keycloak.Claims{UserID: "ticket-user"}, time.Now())
+	valid := issuer.token(t, "alice", time.Minute)
+
+	for _, tc := range []struct {
+		name   string
+		query  string
+		header http.Header
+		status int
+		// userID is empty when the request goes on without claims
+		userID string
+	}{
+		{"anonymous", "", nil, http.StatusOK, ""},
+		{"authorization header", "", http.Header{"Authorization": {"Bearer " + valid}}, http.StatusOK, "alice"},
+		{"bearer subprotocol", "", http.Header{"Sec-Websocket-Protocol": {"realtime, " + realtime.BearerSubprotocolPrefix + valid}}, http.StatusOK, "alice"},
+		{"invalid subprotocol token", "", http.Header{"Sec-Websocket-Protocol": {"realtime, " + realtime.BearerSubprotocolPrefix + "not.a.token"}}, http.StatusUnauthorized, ""},
+		{"expired token", "", http.Header{"Authorization": {"Bearer " + issuer.token(t, "alice", -time.Minute)}}, http.StatusUnauthorized, ""},
+		{"token without key id", "", http.Header{"Authorization": {"Bearer e30.e30.sig"}}, http.StatusUnauthorized, ""},
+		{"foreign token", "", http.Header{"Authorization": {"Bearer " + newTestIssuer(t).token(t, "mallory", time.Minute)}}, http.StatusUnauthorized, ""},
+		{"ticket", "?ticket=" + ticket, nil, http.StatusOK, "ticket-user"},
+		{"ticket redeemed before", "?ticket=" + ticket, nil, http.StatusUnauthorized, ""},
+		{"unknown ticket", "?ticket=unknown", http.Header{"Authorization": {"Bearer " + valid}}, http.StatusUnauthorized, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// the next handler stands in for the proxy dialing OpenAI
+			dialed := false
+			var claims *keycloak.Claims
+			handler := api.wsAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				dialed, claims = true, claimsFromContext(r)
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/v1/speech_to_speech"+tc.query, nil)
+			for k, v := range tc.header {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tc.status || dialed != (tc.status == http.StatusOK) {
+				t.Fatalf("got status %d, dialed %t, want %d", w.Code, dialed, tc.status)
+			}
+			switch {
+			case !dialed:
+			case tc.userID == "" && claims != nil:
+				t.Errorf("anonymous request got claims %+v", claims)
+			case tc.userID != "" && (claims == nil || claims.UserID != tc.userID):
+				t.Errorf("got claims %+v, want user %s", claims, tc.userID)
+			}
+		})
+	}
+}
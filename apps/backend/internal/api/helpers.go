@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"proomptmachinee/internal/services/keycloak"
+	"time"
+)
+
+type contextKey string
+
+const claimsContextKey contextKey = "claims"
+
+const maxRequestBodyBytes = 1 << 20
+
+// claimsFromContext returns the claims of the authenticated user, or nil
+// when the request went through without a token.
+func claimsFromContext(r *http.Request) *keycloak.Claims {
+	claims, _ := r.Context().Value(claimsContextKey).(*keycloak.Claims)
+	return claims
+}
+
+func userIDFromContext(r *http.Request) string {
+	if claims := claimsFromContext(r); claims != nil {
+		return claims.UserID
+	}
+
+	return ""
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return fmt.Errorf("couldn't decode request body: %w", err)
+	}
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		return errors.New("request body must contain a single JSON value")
+	}
+
+	return nil
+}
+
+// parseDate accepts either a full RFC3339 timestamp or a plain date
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.DateOnly, value)
+}
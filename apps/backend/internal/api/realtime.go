@@ -0,0 +1,67 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"proomptmachinee/internal/services/openai/realtime"
+	"proomptmachinee/internal/services/personas"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// handleCreateRealtimeSession mints a short lived client secret for a
+// browser that talks to OpenAI over WebRTC, the persona and the session
+// options are locked in upstream
+func (api *Api) handleCreateRealtimeSession(w http.ResponseWriter, r *http.Request) {
+	// an empty body takes the defaults
+	var opts realtime.SessionOptions
+	if r.ContentLength != 0 {
+		if err := readJSON(w, r, &opts); err != nil {
+			api.errResp.BadRequest(w)
+			return
+		}
+	}
+
+	session, err := api.realtimeClient.CreateClientSession(r.Context(), userIDFromContext(r), &opts)
+	switch {
+	case errors.Is(err, realtime.ErrInvalidOptions), errors.Is(err, personas.ErrNotFound):
+		api.errResp.BadRequest(w)
+		return
+	case errors.Is(err, realtime.ErrQuotaExceeded):
+		api.errResp.TooManyRequests(w)
+		return
+	case err != nil:
+		api.errResp.InternalServerError(w, err)
+		return
+	}
+
+	if err := api.resputil.Ok(w, session); err != nil {
+		api.errResp.InternalServerError(w, err)
+	}
+}
+
+func (api *Api) handleActiveRealtimeSessions(w http.ResponseWriter, r *http.Request) {
+	sessions := api.realtimeClient.ActiveSessions()
+	if err := api.resputil.Ok(w, map[string]interface{}{"sessions": sessions}); err != nil {
+		api.errResp.InternalServerError(w, err)
+	}
+}
+
+// handleKillRealtimeSession ends an open session, the client is closed
+// with a reason telling it an admin ended it
+func (api *Api) handleKillRealtimeSession(w http.ResponseWriter, r *http.Request) {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+	err := api.realtimeClient.KillSession(id)
+	switch {
+	case errors.Is(err, realtime.ErrSessionNotFound):
+		api.errResp.NotFound(w)
+		return
+	case err != nil:
+		api.errResp.InternalServerError(w, err)
+		return
+	}
+
+	if err := api.resputil.Ok(w, map[string]interface{}{"id": id, "killed": true}); err != nil {
+		api.errResp.InternalServerError(w, err)
+	}
+}
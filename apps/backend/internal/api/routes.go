@@ -12,11 +12,26 @@ func (api *Api) Routes() *httprouter.Router {
 
 	// chain := alice.New(api.corsMiddleware, api.authMiddleware)
 	chain := alice.New(api.corsMiddleware)
+	authChain := chain.Append(api.authMiddleware)
+	adminChain := authChain.Append(api.adminMiddleware)
 	router.Handler(http.MethodGet, "/v1/test", chain.Then(http.HandlerFunc(api.testToken)))
 	router.HandlerFunc(http.MethodGet, "/v1/data", api.handleGetTestData)
-	router.Handler(http.MethodGet, "/v1/chat_bot", chain.Then(http.HandlerFunc(api.handleStream)))
-	router.HandlerFunc(http.MethodGet, "/v1/speech_to_speech", api.handleWebSocket)
+	router.Handler(http.MethodGet, "/v1/chat_bot", chain.Append(api.optionalAuthMiddleware).Then(http.HandlerFunc(api.handleStream)))
+	router.Handler(http.MethodPost, "/v1/messages/:id/feedback", authChain.Then(http.HandlerFunc(api.handleMessageFeedback)))
+	router.Handler(http.MethodGet, "/v1/speech_to_speech", chain.Append(api.wsAuthMiddleware).Then(http.HandlerFunc(api.handleWebSocket)))
+	router.Handler(http.MethodPost, "/v1/ws-tickets", authChain.Then(http.HandlerFunc(api.handleCreateWsTicket)))
+	router.HandlerFunc(http.MethodGet, "/v1/telephony/media-stream", api.handleTelephony)
+	router.Handler(http.MethodPost, "/v1/realtime/sessions", authChain.Then(http.HandlerFunc(api.handleCreateRealtimeSession)))
 	router.Handler(http.MethodGet, "/v1/healthcheck", api.loggingMiddleware(http.HandlerFunc(api.healthcheck)))
+	router.Handler(http.MethodGet, "/v1/admin/feedback/export", adminChain.Then(http.HandlerFunc(api.handleFeedbackExport)))
+	router.Handler(http.MethodGet, "/v1/admin/experiments/:name/results", adminChain.Then(http.HandlerFunc(api.handleExperimentResults)))
+	router.Handler(http.MethodGet, "/v1/admin/usage/top-users", adminChain.Then(http.HandlerFunc(api.handleTopUsers)))
+	router.Handler(http.MethodGet, "/v1/admin/usage/daily", adminChain.Then(http.HandlerFunc(api.handleDailyUsage)))
+	router.Handler(http.MethodGet, "/v1/admin/usage/realtime-minutes", adminChain.Then(http.HandlerFunc(api.handleRealtimeMinutes)))
+	router.Handler(http.MethodGet, "/v1/admin/usage/realtime-users", adminChain.Then(http.HandlerFunc(api.handleRealtimeUsers)))
+	router.Handler(http.MethodGet, "/v1/admin/usage/errors", adminChain.Then(http.HandlerFunc(api.handleErrorRates)))
+	router.Handler(http.MethodGet, "/v1/admin/realtime/sessions", adminChain.Then(http.HandlerFunc(api.handleActiveRealtimeSessions)))
+	router.Handler(http.MethodDelete, "/v1/admin/realtime/sessions/:id", adminChain.Then(http.HandlerFunc(api.handleKillRealtimeSession)))
 	router.GlobalOPTIONS = http.HandlerFunc(api.corsPreflight)
 
 	return router
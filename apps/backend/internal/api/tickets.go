@@ -0,0 +1,86 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"proomptmachinee/internal/services/keycloak"
+	"sync"
+	"time"
+)
+
+// wsTicketTTL is how long a ticket can be redeemed, it only has to
+// outlive the browser opening the socket
+const wsTicketTTL = 30 * time.Second
+
+// wsTickets are single use tickets standing in for a token on WebSocket
+// upgrades, browsers can't send the Authorization header there
+type wsTickets struct {
+	mu      sync.Mutex
+	tickets map[string]*wsTicket
+}
+
+type wsTicket struct {
+	claims    *keycloak.Claims
+	expiresAt time.Time
+}
+
+func newWsTickets() *wsTickets {
+	return &wsTickets{tickets: make(map[string]*wsTicket)}
+}
+
+// issue stores a ticket for the claims and sweeps the expired ones
+func (t *wsTickets) issue(claims *keycloak.Claims, now time.Time) (string, time.Time, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	id := hex.EncodeToString(b)
+	expiresAt := now.Add(wsTicketTTL)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k, ticket := range t.tickets {
+		if !now.Before(ticket.expiresAt) {
+			delete(t.tickets, k)
+		}
+	}
+	t.tickets[id] = &wsTicket{claims: claims, expiresAt: expiresAt}
+
+	return id, expiresAt, nil
+}
+
+// redeem returns the claims of a ticket and removes it, nil when it is
+// unknown or expired
+func (t *wsTickets) redeem(id string, now time.Time) *keycloak.Claims {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ticket, ok := t.tickets[id]
+	if !ok {
+		return nil
+	}
+	delete(t.tickets, id)
+	if !now.Before(ticket.expiresAt) {
+		return nil
+	}
+
+	return ticket.claims
+}
+
+// handleCreateWsTicket issues a ticket for opening a voice session with
+// `/v1/speech_to_speech?ticket=<ticket>`
+func (api *Api) handleCreateWsTicket(w http.ResponseWriter, r *http.Request) {
+	ticket, expiresAt, err := api.wsTickets.issue(claimsFromContext(r), time.Now())
+	if err != nil {
+		api.errResp.InternalServerError(w, err)
+		return
+	}
+
+	data := map[string]interface{}{
+		"ticket":     ticket,
+		"expires_at": expiresAt,
+	}
+	if err := api.resputil.Ok(w, data); err != nil {
+		api.errResp.InternalServerError(w, err)
+	}
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"proomptmachinee/internal/services/keycloak"
+	"testing"
+	"time"
+)
+
+func TestWsTickets(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name   string
+		redeem []time.Duration
+		want   []bool
+	}{
+		{"redeemed once", []time.Duration{time.Second}, []bool{true}},
+		{"single use", []time.Duration{time.Second, 2 * time.Second}, []bool{true, false}},
+		{"just before expiry", []time.Duration{wsTicketTTL - time.Nanosecond}, []bool{true}},
+		{"expired", []time.Duration{wsTicketTTL}, []bool{false}},
+		{"expired ticket is gone", []time.Duration{wsTicketTTL, 0}, []bool{false, false}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tickets := newWsTickets()
+			claims := &keycloak.Claims{UserID: "alice"}
+			id, expiresAt, err := tickets.issue(claims, now)
+			if err != nil {
+				t.Fatalf("couldn't issue ticket: %v", err)
+			}
+			if !expiresAt.Equal(now.Add(wsTicketTTL)) {
+				t.Errorf("ticket expires at %s", expiresAt)
+			}
+			for i, after := range tc.redeem {
+				if got := tickets.redeem(id, now.Add(after)); (got == claims) != tc.want[i] {
+					t.Errorf("redeem %d after %s got %v, want redeemed %t", i, after, got, tc.want[i])
+				}
+			}
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		tickets := newWsTickets()
+		if claims := tickets.redeem("unknown", now); claims != nil {
+			t.Errorf("redeemed %v", claims)
+		}
+	})
+
+	t.Run("issuing sweeps expired tickets", func(t *testing.T) {
+		tickets := newWsTickets()
+		tickets.issue(&keycloak.Claims{UserID: "alice"}, now)
+		tickets.issue(&keycloak.Claims{UserID: "bob"}, now.Add(wsTicketTTL/2))
+		tickets.issue(&keycloak.Claims{UserID: "carol"}, now.Add(wsTicketTTL))
+		if n := len(tickets.tickets); n != 2 {
+			t.Errorf("%d tickets kept, want bob's and carol's", n)
+		}
+	})
+}
@@ -0,0 +1,252 @@
+package api
+
+import (
+	"net/http"
+	"proomptmachinee/internal/services/usage"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultTopUsers = 20
+	maxTopUsers     = 1000
+	// reports default to the last 30 days
+	defaultReportRange = 30 * 24 * time.Hour
+)
+
+type reportRange struct {
+	from time.Time
+	to   time.Time
+	csv  bool
+}
+
+func (api *Api) readReportRange(w http.ResponseWriter, r *http.Request) (*reportRange, bool) {
+	query := r.URL.Query()
+	rr := &reportRange{csv: query.Get("format") == "csv"}
+
+	var err error
+	if rr.from, err = parseDate(query.Get("from")); err != nil {
+		api.errResp.BadRequest(w)
+		return nil, false
+	}
+	if rr.to, err = parseDate(query.Get("to")); err != nil {
+		api.errResp.BadRequest(w)
+		return nil, false
+	}
+	if rr.from.IsZero() {
+		rr.from = time.Now().UTC().Add(-defaultReportRange).Truncate(24 * time.Hour)
+	}
+
+	return rr, true
+}
+
+func (api *Api) handleTopUsers(w http.ResponseWriter, r *http.Request) {
+	rr, ok := api.readReportRange(w, r)
+	if !ok {
+		return
+	}
+	limit := defaultTopUsers
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 || limit > maxTopUsers {
+			api.errResp.BadRequest(w)
+			return
+		}
+	}
+
+	rows, err := api.ledger.Aggregate(r.Context(), usage.Query{
+		From:    rr.from,
+		To:      rr.to,
+		GroupBy: []usage.Dimension{usage.ByUser},
+		Limit:   limit,
+	})
+	if err != nil {
+		api.errResp.InternalServerError(w, err)
+		return
+	}
+
+	if rr.csv {
+		records := make([][]string, 0, len(rows))
+		for _, a := range rows {
+			records = append(records, append([]string{a.UserID}, aggregateRecord(a)...))
+		}
+		api.writeCSV(w, "top_users.csv", append([]string{"user_id"}, aggregateHeader...), records)
+		return
+	}
+	api.writeReport(w, rows)
+}
+
+func (api *Api) handleDailyUsage(w http.ResponseWriter, r *http.Request) {
+	rr, ok := api.readReportRange(w, r)
+	if !ok {
+		return
+	}
+	groupBy := []usage.Dimension{usage.ByDay}
+	byModel := r.URL.Query().Get("group") == "model"
+	if byModel {
+		groupBy = append(groupBy, usage.ByModel)
+	}
+
+	rows, err := api.ledger.Aggregate(r.Context(), usage.Query{
+		From:    rr.from,
+		To:      rr.to,
+		GroupBy: groupBy,
+	})
+	if err != nil {
+		api.errResp.InternalServerError(w, err)
+		return
+	}
+
+	if rr.csv {
+		header := []string{"day"}
+		if byModel {
+			header = append(header, "model")
+		}
+		records := make([][]string, 0, len(rows))
+		for _, a := range rows {
+			record := []string{a.Day.Format(time.DateOnly)}
+			if byModel {
+				record = append(record, a.Model)
+			}
+			records = append(records, append(record, aggregateRecord(a)...))
+		}
+		api.writeCSV(w, "daily_usage.csv", append(header, aggregateHeader...), records)
+		return
+	}
+	api.writeReport(w, rows)
+}
+
+func (api *Api) handleRealtimeMinutes(w http.ResponseWriter, r *http.Request) {
+	rr, ok := api.readReportRange(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := api.ledger.RealtimeMinutes(r.Context(), rr.from, rr.to)
+	if err != nil {
+		api.errResp.InternalServerError(w, err)
+		return
+	}
+
+	if rr.csv {
+		records := make([][]string, 0, len(rows))
+		for _, m := range rows {
+			records = append(records, []string{
+				m.Day.Format(time.DateOnly),
+				strconv.Itoa(m.Sessions),
+				strconv.Itoa(m.Users),
+				strconv.FormatFloat(m.Minutes, 'f', 2, 64),
+				strconv.Itoa(m.Failed),
+			})
+		}
+		api.writeCSV(w, "realtime_minutes.csv", []string{"day", "sessions", "users", "minutes", "failed"}, records)
+		return
+	}
+	api.writeReport(w, rows)
+}
+
+func (api *Api) handleRealtimeUsers(w http.ResponseWriter, r *http.Request) {
+	rr, ok := api.readReportRange(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := api.ledger.RealtimeUsageByUser(r.Context(), rr.from, rr.to, r.URL.Query().Get("user_id"))
+	if err != nil {
+		api.errResp.InternalServerError(w, err)
+		return
+	}
+
+	if rr.csv {
+		records := make([][]string, 0, len(rows))
+		for _, u := range rows {
+			records = append(records, []string{
+				u.UserID,
+				strconv.Itoa(u.Sessions),
+				strconv.FormatFloat(u.Minutes, 'f', 2, 64),
+				strconv.Itoa(u.Responses),
+				strconv.FormatInt(u.InputTokens, 10),
+				strconv.FormatInt(u.OutputTokens, 10),
+				strconv.FormatInt(u.CachedTokens, 10),
+				strconv.FormatInt(u.AudioInputTokens, 10),
+				strconv.FormatInt(u.AudioOutputTokens, 10),
+				strconv.FormatFloat(u.CostUSD, 'f', 6, 64),
+			})
+		}
+		header := append([]string{"user_id", "sessions", "minutes"}, aggregateHeader...)
+		api.writeCSV(w, "realtime_users.csv", header, records)
+		return
+	}
+	api.writeReport(w, rows)
+}
+
+func (api *Api) handleErrorRates(w http.ResponseWriter, r *http.Request) {
+	rr, ok := api.readReportRange(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := api.ledger.ErrorRates(r.Context(), rr.from, rr.to)
+	if err != nil {
+		api.errResp.InternalServerError(w, err)
+		return
+	}
+
+	if rr.csv {
+		records := make([][]string, 0, len(rows))
+		for _, e := range rows {
+			records = append(records, []string{
+				e.Day.Format(time.DateOnly),
+				e.Source,
+				strconv.Itoa(e.Responses),
+				strconv.Itoa(e.Errors),
+				strconv.FormatFloat(e.Rate, 'f', 4, 64),
+			})
+		}
+		api.writeCSV(w, "error_rates.csv", []string{"day", "source", "responses", "errors", "rate"}, records)
+		return
+	}
+	api.writeReport(w, rows)
+}
+
+var aggregateHeader = []string{
+	"responses",
+	"input_tokens",
+	"output_tokens",
+	"cached_tokens",
+	"audio_input_tokens",
+	"audio_output_tokens",
+	"transcription_seconds",
+	"speech_characters",
+	"cost_usd",
+}
+
+func aggregateRecord(a *usage.Aggregate) []string {
+	return []string{
+		strconv.Itoa(a.Responses),
+		strconv.FormatInt(a.InputTokens, 10),
+		strconv.FormatInt(a.OutputTokens, 10),
+		strconv.FormatInt(a.CachedTokens, 10),
+		strconv.FormatInt(a.AudioInputTokens, 10),
+		strconv.FormatInt(a.AudioOutputTokens, 10),
+		strconv.FormatFloat(a.TranscriptionSeconds, 'f', 3, 64),
+		strconv.FormatInt(a.SpeechCharacters, 10),
+		strconv.FormatFloat(a.CostUSD, 'f', 6, 64),
+	}
+}
+
+func (api *Api) writeReport(w http.ResponseWriter, rows interface{}) {
+	if err := api.resputil.Ok(w, map[string]interface{}{"rows": rows}); err != nil {
+		api.errResp.InternalServerError(w, err)
+	}
+}
+
+func (api *Api) writeCSV(w http.ResponseWriter, filename string, header []string, records [][]string) {
+	if err := api.resputil.CSV(w, filename, header, records); err != nil {
+		// the status line is already written, all we can do is log it
+		api.logger.Error("couldn't write csv report", map[string]interface{}{
+			"error":    err.Error(),
+			"filename": filename,
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package audio
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// Encodings, named like the OpenAI realtime audio formats
+const (
+	EncodingPCM16 = "pcm16"
+	EncodingMulaw = "g711_ulaw"
+	EncodingAlaw  = "g711_alaw"
+)
+
+const (
+	// RealtimeSampleRate is the rate of the PCM16 audio OpenAI realtime
+	// sessions take and produce
+	RealtimeSampleRate = 24000
+	// TelephonySampleRate is the rate of G.711 audio
+	TelephonySampleRate = 8000
+)
+
+// SampleRates lists the PCM16 rates that can be converted
+var SampleRates = []int{8000, 16000, 24000, 44100, 48000}
+
+// Format of an audio stream
+type Format struct {
+	Encoding   string
+	SampleRate int
+}
+
+// Realtime is the format OpenAI realtime sessions use
+var Realtime = Format{Encoding: EncodingPCM16, SampleRate: RealtimeSampleRate}
+
+// Validate checks the format can be converted, G.711 is always 8 kHz
+func (f Format) Validate() error {
+	switch f.Encoding {
+	case EncodingMulaw, EncodingAlaw:
+		if f.SampleRate != TelephonySampleRate {
+			return fmt.Errorf("%s is only supported at %d Hz", f.Encoding, TelephonySampleRate)
+		}
+	case EncodingPCM16:
+		for _, rate := range SampleRates {
+			if f.SampleRate == rate {
+				return nil
+			}
+		}
+		return fmt.Errorf("sample rate %d is not supported", f.SampleRate)
+	default:
+		return fmt.Errorf("encoding %q is not supported", f.Encoding)
+	}
+
+	return nil
+}
+
+func (f Format) decode(data []byte) []int16 {
+	switch f.Encoding {
+	case EncodingMulaw:
+		return DecodeMulaw(data)
+	case EncodingAlaw:
+		return DecodeAlaw(data)
+	default:
+		return DecodePCM16(data)
+	}
+}
+
+func (f Format) encode(samples []int16) []byte {
+	switch f.Encoding {
+	case EncodingMulaw:
+		return EncodeMulaw(samples)
+	case EncodingAlaw:
+		return EncodeAlaw(samples)
+	default:
+		return EncodePCM16(samples)
+	}
+}
+
+// Converter converts a stream of audio chunks from one format to
+// another, it isn't safe for concurrent use
+type Converter struct {
+	from, to  Format
+	resampler *Resampler
+}
+
+func NewConverter(from, to Format) (*Converter, error) {
+	if err := from.Validate(); err != nil {
+		return nil, err
+	}
+	if err := to.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &Converter{from: from, to: to, resampler: NewResampler(from.SampleRate, to.SampleRate)}, nil
+}
+
+// Convert converts the next chunk of the stream
+func (c *Converter) Convert(data []byte) []byte {
+	if c.from == c.to {
+		return data
+	}
+
+	return c.to.encode(c.resampler.Process(c.from.decode(data)))
+}
+
+// ConvertBase64 converts a base64 encoded chunk, as carried by the
+// realtime audio events
+func (c *Converter) ConvertBase64(data string) (string, error) {
+	if c.from == c.to {
+		return data, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", fmt.Errorf("couldn't decode audio: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(c.Convert(raw)), nil
+}
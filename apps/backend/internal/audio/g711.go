@@ -0,0 +1,84 @@
+package audio
+
+import "math/bits"
+
+// G.711 as in the ITU reference implementation, μ-law is used by North
+// American and Japanese telephony, A-law everywhere else
+
+const (
+	mulawBias = 0x84
+	mulawClip = 32635
+)
+
+// MulawEncode compresses a 16 bit sample to μ-law
+func MulawEncode(sample int16) byte {
+	s := int(sample)
+	sign := 0
+	if s < 0 {
+		sign = 0x80
+		s = -s
+	}
+	if s > mulawClip {
+		s = mulawClip
+	}
+	s += mulawBias
+	exponent := bits.Len(uint(s>>7)) - 1
+	if exponent < 0 {
+		exponent = 0
+	}
+	mantissa := (s >> (exponent + 3)) & 0x0F
+
+	return ^byte(sign | exponent<<4 | mantissa)
+}
+
+// MulawDecode expands a μ-law byte to a 16 bit sample
+func MulawDecode(u byte) int16 {
+	u = ^u
+	t := (int(u&0x0F)<<3 + mulawBias) << ((u & 0x70) >> 4)
+	if u&0x80 != 0 {
+		return int16(mulawBias - t)
+	}
+
+	return int16(t - mulawBias)
+}
+
+// AlawEncode compresses a 16 bit sample to A-law
+func AlawEncode(sample int16) byte {
+	v := int(sample) >> 3
+	mask := byte(0xD5)
+	if v < 0 {
+		mask = 0x55
+		v = -v - 1
+	}
+	segment := bits.Len(uint(v >> 5))
+	if segment >= 8 {
+		return 0x7F ^ mask
+	}
+	a := byte(segment << 4)
+	if segment < 2 {
+		a |= byte(v>>1) & 0x0F
+	} else {
+		a |= byte(v>>segment) & 0x0F
+	}
+
+	return a ^ mask
+}
+
+// AlawDecode expands an A-law byte to a 16 bit sample
+func AlawDecode(a byte) int16 {
+	a ^= 0x55
+	t := int(a&0x0F) << 4
+	switch segment := int(a&0x70) >> 4; segment {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t = (t + 0x108) << (segment - 1)
+	}
+	if a&0x80 != 0 {
+		return int16(t)
+	}
+
+	return int16(-t)
+}
@@ -0,0 +1,110 @@
+package audio
+
+import "testing"
+
+// the expected codes and samples are those of the Sun g711.c reference
+// implementation that ITU-T G.191 ships
+
+func TestMulawEncode(t *testing.T) {
+	for _, tc := range []struct {
+		sample int16
+		code   byte
+	}{
+		{0, 0xFF},
+		{-1, 0x7F},
+		{100, 0xF2},
+		// segment 0 ends at 123, segment 1 starts at 124
+		{123, 0xF0},
+		{124, 0xEF},
+		{-124, 0x6F},
+		// segment 6 ends at 16251, segment 7 starts at 16252
+		{16251, 0x90},
+		{16252, 0x8F},
+		// clipped to 32635
+		{32635, 0x80},
+		{32767, 0x80},
+		{-32768, 0x00},
+	} {
+		if got := MulawEncode(tc.sample); got != tc.code {
+			t.Errorf("MulawEncode(%d) = %#02x, want %#02x", tc.sample, got, tc.code)
+		}
+	}
+}
+
+func TestMulawDecode(t *testing.T) {
+	for _, tc := range []struct {
+		code   byte
+		sample int16
+	}{
+		{0xFF, 0},
+		{0x7F, 0},
+		{0xFE, 8},
+		{0xF0, 120},
+		{0xEF, 132},
+		{0xE0, 372},
+		{0xDF, 396},
+		{0x8F, 16764},
+		{0x80, 32124},
+		{0x00, -32124},
+		{0x6F, -132},
+	} {
+		if got := MulawDecode(tc.code); got != tc.sample {
+			t.Errorf("MulawDecode(%#02x) = %d, want %d", tc.code, got, tc.sample)
+		}
+	}
+}
+
+func TestAlawEncode(t *testing.T) {
+	for _, tc := range []struct {
+		sample int16
+		code   byte
+	}{
+		{0, 0xD5},
+		{-1, 0x55},
+		// segment 0 ends at 255, segment 1 starts at 256
+		{255, 0xDA},
+		{256, 0xC5},
+		{512, 0xF5},
+		{-256, 0x5A},
+		{32767, 0xAA},
+		{-32768, 0x2A},
+	} {
+		if got := AlawEncode(tc.sample); got != tc.code {
+			t.Errorf("AlawEncode(%d) = %#02x, want %#02x", tc.sample, got, tc.code)
+		}
+	}
+}
+
+func TestAlawDecode(t *testing.T) {
+	for _, tc := range []struct {
+		code   byte
+		sample int16
+	}{
+		{0xD5, 8},
+		{0x55, -8},
+		{0xDA, 248},
+		{0xC5, 264},
+		{0xF5, 528},
+		{0xAA, 32256},
+		{0x2A, -32256},
+	} {
+		if got := AlawDecode(tc.code); got != tc.sample {
+			t.Errorf("AlawDecode(%#02x) = %d, want %d", tc.code, got, tc.sample)
+		}
+	}
+}
+
+func TestG711RoundTrip(t *testing.T) {
+	for c := range 256 {
+		code := byte(c)
+		// μ-law has a negative zero, it encodes as the positive one
+		if code != 0x7F {
+			if got := MulawEncode(MulawDecode(code)); got != code {
+				t.Errorf("μ-law %#02x decodes to %d and encodes to %#02x", code, MulawDecode(code), got)
+			}
+		}
+		if got := AlawEncode(AlawDecode(code)); got != code {
+			t.Errorf("A-law %#02x decodes to %d and encodes to %#02x", code, AlawDecode(code), got)
+		}
+	}
+}
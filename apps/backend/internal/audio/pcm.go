@@ -0,0 +1,60 @@
+package audio
+
+import "encoding/binary"
+
+// DecodePCM16 reads little endian 16 bit samples, a trailing odd byte is
+// dropped
+func DecodePCM16(data []byte) []int16 {
+	samples := make([]int16, len(data)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(data[2*i:]))
+	}
+
+	return samples
+}
+
+// EncodePCM16 writes little endian 16 bit samples
+func EncodePCM16(samples []int16) []byte {
+	data := make([]byte, 2*len(samples))
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(data[2*i:], uint16(s))
+	}
+
+	return data
+}
+
+func DecodeMulaw(data []byte) []int16 {
+	samples := make([]int16, len(data))
+	for i, b := range data {
+		samples[i] = MulawDecode(b)
+	}
+
+	return samples
+}
+
+func EncodeMulaw(samples []int16) []byte {
+	data := make([]byte, len(samples))
+	for i, s := range samples {
+		data[i] = MulawEncode(s)
+	}
+
+	return data
+}
+
+func DecodeAlaw(data []byte) []int16 {
+	samples := make([]int16, len(data))
+	for i, b := range data {
+		samples[i] = AlawDecode(b)
+	}
+
+	return samples
+}
+
+func EncodeAlaw(samples []int16) []byte {
+	data := make([]byte, len(samples))
+	for i, s := range samples {
+		data[i] = AlawEncode(s)
+	}
+
+	return data
+}
@@ -0,0 +1,127 @@
+package audio
+
+import "math"
+
+// filterTaps is the length of the low pass filter applied before
+// downsampling, long enough to keep telephony audio free of aliasing
+const filterTaps = 31
+
+// Resampler converts a stream of samples between sample rates. It keeps
+// state between calls so chunked audio comes out without clicks at the
+// chunk boundaries. Downsampling low pass filters first, upsampling
+// interpolates linearly.
+type Resampler struct {
+	step float64
+	// pos is where the next output sample lies, relative to last
+	pos     float64
+	last    float64
+	hasLast bool
+
+	filter  []float64
+	history []float64
+}
+
+func NewResampler(from, to int) *Resampler {
+	r := &Resampler{step: float64(from) / float64(to)}
+	if to < from {
+		r.filter = lowPass(filterTaps, 0.5*float64(to)/float64(from))
+		r.history = make([]float64, filterTaps-1)
+	}
+
+	return r
+}
+
+// Process resamples the next chunk of the stream
+func (r *Resampler) Process(in []int16) []int16 {
+	if r.step == 1 {
+		return append([]int16(nil), in...)
+	}
+
+	samples := r.applyFilter(in)
+	// the last sample of the previous chunk leads the buffer so output
+	// samples between chunks can be interpolated
+	buf := samples
+	if r.hasLast {
+		buf = append([]float64{r.last}, samples...)
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+
+	out := make([]int16, 0, int(float64(len(samples))/r.step)+1)
+	end := float64(len(buf) - 1)
+	for ; r.pos <= end; r.pos += r.step {
+		i := int(r.pos)
+		v := buf[i]
+		if frac := r.pos - float64(i); frac > 0 && i+1 < len(buf) {
+			v += frac * (buf[i+1] - v)
+		}
+		out = append(out, clamp(v))
+	}
+	r.pos -= end
+	r.last = buf[len(buf)-1]
+	r.hasLast = true
+
+	return out
+}
+
+func (r *Resampler) applyFilter(in []int16) []float64 {
+	out := make([]float64, len(in))
+	if r.filter == nil {
+		for i, s := range in {
+			out[i] = float64(s)
+		}
+		return out
+	}
+
+	window := make([]float64, len(r.history)+len(in))
+	copy(window, r.history)
+	for i, s := range in {
+		window[len(r.history)+i] = float64(s)
+	}
+	for i := range in {
+		var acc float64
+		for k, c := range r.filter {
+			acc += c * window[i+len(r.filter)-1-k]
+		}
+		out[i] = acc
+	}
+	copy(r.history, window[len(in):])
+
+	return out
+}
+
+// lowPass returns a Hamming windowed sinc filter with the cutoff given
+// as a fraction of the sample rate
+func lowPass(taps int, cutoff float64) []float64 {
+	h := make([]float64, taps)
+	m := float64(taps - 1)
+	var sum float64
+	for i := range h {
+		x := float64(i) - m/2
+		sinc := 2 * cutoff
+		if x != 0 {
+			sinc = math.Sin(2*math.Pi*cutoff*x) / (math.Pi * x)
+		}
+		h[i] = sinc * (0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/m))
+		sum += h[i]
+	}
+	// unity gain at DC
+	for i := range h {
+		h[i] /= sum
+	}
+
+	return h
+}
+
+func clamp(v float64) int16 {
+	v = math.Round(v)
+	if v > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if v < math.MinInt16 {
+		return math.MinInt16
+	}
+
+	return int16(v)
+}
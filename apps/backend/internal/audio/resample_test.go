@@ -0,0 +1,109 @@
+package audio
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+var resampleRates = []struct{ from, to int }{
+	{8000, 24000},
+	{16000, 24000},
+	{44100, 24000},
+	{48000, 24000},
+	{24000, 8000},
+	{24000, 16000},
+	{24000, 44100},
+	{24000, 48000},
+}
+
+func tone(freq float64, rate, n int, amplitude float64) []int16 {
+	out := make([]int16, n)
+	for i := range out {
+		out[i] = int16(amplitude * math.Sin(2*math.Pi*freq*float64(i)/float64(rate)))
+	}
+
+	return out
+}
+
+func TestResamplerLength(t *testing.T) {
+	for _, r := range resampleRates {
+		// one second
+		out := NewResampler(r.from, r.to).Process(make([]int16, r.from))
+		// output past the last input sample waits for the next chunk
+		held := (r.to + r.from - 1) / r.from
+		if len(out) < r.to-held || len(out) > r.to+1 {
+			t.Errorf("%d -> %d Hz: %d samples from one second, want %d", r.from, r.to, len(out), r.to)
+		}
+	}
+}
+
+func TestResamplerDCGain(t *testing.T) {
+	const level = 10000
+	for _, r := range resampleRates {
+		in := make([]int16, r.from/10)
+		for i := range in {
+			in[i] = level
+		}
+		out := NewResampler(r.from, r.to).Process(in)
+		// the low pass filter starts from silence
+		for i, s := range out[filterTaps:] {
+			if s < level-1 || s > level+1 {
+				t.Errorf("%d -> %d Hz: sample %d is %d, want %d", r.from, r.to, filterTaps+i, s, level)
+				break
+			}
+		}
+	}
+}
+
+func TestResamplerAliasing(t *testing.T) {
+	const amplitude = 10000
+	for _, r := range resampleRates {
+		if r.to > r.from {
+			continue
+		}
+		// halfway between the new and the old Nyquist frequency
+		freq := float64(r.to+r.from) / 4
+		out := NewResampler(r.from, r.to).Process(tone(freq, r.from, r.from/10, amplitude))
+		var sum float64
+		for _, s := range out[filterTaps:] {
+			sum += float64(s) * float64(s)
+		}
+		rms := math.Sqrt(sum / float64(len(out)-filterTaps))
+		// a full scale tone has an rms of amplitude/√2, -20 dB of it
+		if limit := amplitude / math.Sqrt2 / 10; rms > limit {
+			t.Errorf("%d -> %d Hz: %.0f Hz tone has an rms of %.0f, want below %.0f", r.from, r.to, freq, rms, limit)
+		}
+	}
+}
+
+func TestResamplerChunks(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, r := range resampleRates {
+		in := make([]int16, r.from/5)
+		for i := range in {
+			in[i] = int16(rng.Intn(2*8000) - 8000)
+		}
+		whole := NewResampler(r.from, r.to).Process(in)
+
+		chunked := NewResampler(r.from, r.to)
+		var out []int16
+		for rest := in; len(rest) > 0; {
+			n := min(len(rest), 1+rng.Intn(200))
+			out = append(out, chunked.Process(rest[:n])...)
+			rest = rest[n:]
+		}
+
+		if len(out) != len(whole) {
+			t.Errorf("%d -> %d Hz: %d samples in chunks, %d in one call", r.from, r.to, len(out), len(whole))
+			continue
+		}
+		for i := range out {
+			// the position is accumulated differently, rounding may differ
+			if d := int(out[i]) - int(whole[i]); d < -1 || d > 1 {
+				t.Errorf("%d -> %d Hz: sample %d is %d in chunks, %d in one call", r.from, r.to, i, out[i], whole[i])
+				break
+			}
+		}
+	}
+}
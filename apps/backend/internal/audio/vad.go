@@ -0,0 +1,156 @@
+package audio
+
+import "math"
+
+// the VAD classifies audio in frames of this length
+const vadFrameMs = 10
+
+// VADConfig tunes the voice activity detector
+type VADConfig struct {
+	// Threshold is the RMS level, as a fraction of full scale, a frame
+	// needs to count as speech
+	Threshold float64
+	// MaxZeroCrossingRate is the share of samples changing sign above
+	// which a loud frame is taken for noise rather than voice
+	MaxZeroCrossingRate float64
+	// MinSpeechMs of voiced frames in a row start speech
+	MinSpeechMs int
+	// SilenceMs without voiced frames end speech
+	SilenceMs int
+	// PrefixPaddingMs of audio before the speech started is kept
+	PrefixPaddingMs int
+}
+
+var DefaultVADConfig = VADConfig{
+	Threshold:           0.02,
+	MaxZeroCrossingRate: 0.25,
+	MinSpeechMs:         100,
+	SilenceMs:           500,
+	PrefixPaddingMs:     300,
+}
+
+// VAD detects speech in a PCM16 stream by the energy and the zero
+// crossing rate of its frames. Audio outside of speech is held back, so
+// only speech with its padding is forwarded.
+type VAD struct {
+	cfg        VADConfig
+	sampleRate int
+	frameSize  int
+
+	// pending is the start of a frame that isn't complete yet
+	pending []int16
+	// prefix is the recent audio while there is no speech
+	prefix   []int16
+	speaking bool
+	voiced   int
+	silent   int
+	// samples processed so far
+	position int64
+}
+
+func NewVAD(cfg VADConfig, sampleRate int) *VAD {
+	return &VAD{
+		cfg:        cfg,
+		sampleRate: sampleRate,
+		frameSize:  sampleRate * vadFrameMs / 1000,
+	}
+}
+
+// VADResult is what a chunk of audio changed. StartMs and EndMs are
+// offsets into the stream the VAD processed.
+type VADResult struct {
+	// Forward is the audio that belongs to speech
+	Forward []int16
+	Started bool
+	StartMs int
+	Stopped bool
+	EndMs   int
+}
+
+// Speaking reports whether the stream is in speech
+func (v *VAD) Speaking() bool {
+	return v.speaking
+}
+
+// Process classifies the samples. A chunk that doesn't fill a frame is
+// kept until the next one completes it.
+func (v *VAD) Process(samples []int16) *VADResult {
+	r := &VADResult{}
+	v.pending = append(v.pending, samples...)
+	offset := 0
+	for ; offset+v.frameSize <= len(v.pending); offset += v.frameSize {
+		v.frame(v.pending[offset:offset+v.frameSize], r)
+	}
+	v.pending = append(v.pending[:0], v.pending[offset:]...)
+
+	return r
+}
+
+func (v *VAD) frame(frame []int16, r *VADResult) {
+	voiced := v.isVoiced(frame)
+	v.position += int64(len(frame))
+
+	if !v.speaking {
+		v.prefix = append(v.prefix, frame...)
+		v.voiced++
+		if !voiced {
+			v.voiced = 0
+		}
+		if v.voiced*vadFrameMs >= v.cfg.MinSpeechMs {
+			v.speaking, v.silent = true, 0
+			r.Started = true
+			r.StartMs = v.ms() - v.voiced*vadFrameMs
+			r.Forward = append(r.Forward, v.prefix...)
+			v.prefix = v.prefix[:0]
+			return
+		}
+		// the padding and the voiced frames are all that may be needed
+		keep := (v.cfg.PrefixPaddingMs/vadFrameMs + v.voiced) * v.frameSize
+		if len(v.prefix) > keep {
+			n := copy(v.prefix, v.prefix[len(v.prefix)-keep:])
+			v.prefix = v.prefix[:n]
+		}
+		return
+	}
+
+	r.Forward = append(r.Forward, frame...)
+	if voiced {
+		v.silent = 0
+		return
+	}
+	v.silent++
+	if v.silent*vadFrameMs >= v.cfg.SilenceMs {
+		v.speaking, v.voiced = false, 0
+		r.Stopped = true
+		r.EndMs = v.ms()
+	}
+}
+
+func (v *VAD) isVoiced(frame []int16) bool {
+	crossings := 0
+	for i := 1; i < len(frame); i++ {
+		if (frame[i] >= 0) != (frame[i-1] >= 0) {
+			crossings++
+		}
+	}
+	zcr := float64(crossings) / float64(len(frame)-1)
+
+	return Level(frame) >= v.cfg.Threshold && zcr <= v.cfg.MaxZeroCrossingRate
+}
+
+// Level is the RMS level of the samples as a fraction of full scale
+func Level(samples []int16) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	var energy float64
+	for _, s := range samples {
+		energy += float64(s) * float64(s)
+	}
+
+	return math.Sqrt(energy/float64(len(samples))) / 32768
+}
+
+func (v *VAD) ms() int {
+	return int(v.position * 1000 / int64(v.sampleRate))
+}
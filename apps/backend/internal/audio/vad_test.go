@@ -0,0 +1,92 @@
+package audio
+
+import (
+	"slices"
+	"testing"
+)
+
+// vadTestRate makes a 10ms frame ten samples long
+const vadTestRate = 1000
+
+var vadTestConfig = VADConfig{
+	Threshold:           0.02,
+	MaxZeroCrossingRate: 0.25,
+	MinSpeechMs:         30,
+	SilenceMs:           50,
+	PrefixPaddingMs:     20,
+}
+
+// vadFrames spells out audio a frame at a time: v is voice, s silence, q
+// voice too quiet to count and n loud noise crossing zero every sample
+func vadFrames(spec string) []int16 {
+	var samples []int16
+	for _, c := range spec {
+		for i := range vadTestRate * vadFrameMs / 1000 {
+			var s int16
+			switch c {
+			case 'v':
+				s = 8000
+			case 'q':
+				s = 100
+			case 'n':
+				s = 8000
+				if i%2 == 1 {
+					s = -8000
+				}
+			}
+			samples = append(samples, s)
+		}
+	}
+
+	return samples
+}
+
+func TestVAD(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		frames string
+		// chunk is how many samples are processed at once
+		chunk     int
+		starts    []int
+		stops     []int
+		forwarded int
+	}{
+		{"silence", "ssssssssss", 10, nil, nil, 0},
+		{"quiet voice", "qqqqqqqqqq", 10, nil, nil, 0},
+		{"noise", "nnnnnnnnnn", 10, nil, nil, 0},
+		{"shorter than min speech", "svvsvvsvvs", 10, nil, nil, 0},
+		{"start after min speech", "sssssvvv", 10, []int{50}, nil, 50},
+		{"prefix padding", "sssssvvvs", 10, []int{50}, nil, 60},
+		{"padding shorter than the silence before", "svvvs", 10, []int{10}, nil, 50},
+		{"stop after silence", "vvvsssss", 10, []int{0}, []int{80}, 80},
+		{"silence interrupted", "vvvssssvsssss", 10, []int{0}, []int{130}, 130},
+		{"silence after the stop is held back", "vvvssssssssss", 10, []int{0}, []int{80}, 80},
+		{"second turn", "vvvsssssssssvvvsssss", 10, []int{0, 120}, []int{80, 200}, 180},
+		{"unaligned chunks", "sssssvvvsssss", 7, []int{50}, []int{130}, 100},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			vad := NewVAD(vadTestConfig, vadTestRate)
+			samples := vadFrames(tc.frames)
+			var starts, stops []int
+			forwarded := 0
+			for len(samples) > 0 {
+				n := min(tc.chunk, len(samples))
+				r := vad.Process(samples[:n])
+				samples = samples[n:]
+				if r.Started {
+					starts = append(starts, r.StartMs)
+				}
+				if r.Stopped {
+					stops = append(stops, r.EndMs)
+				}
+				forwarded += len(r.Forward)
+			}
+			if !slices.Equal(starts, tc.starts) || !slices.Equal(stops, tc.stops) {
+				t.Errorf("started at %v and stopped at %v, want %v and %v", starts, stops, tc.starts, tc.stops)
+			}
+			if forwarded != tc.forwarded {
+				t.Errorf("forwarded %d samples, want %d", forwarded, tc.forwarded)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrUnsupportedWAV = errors.New("unsupported WAV file")
+
+// ReadWAV reads the samples of a PCM16 WAV file, only the first channel
+// of a multi channel file is kept
+func ReadWAV(r io.Reader) ([]int16, int, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, 0, fmt.Errorf("couldn't read WAV header: %w", err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, 0, fmt.Errorf("%w: not a RIFF WAVE file", ErrUnsupportedWAV)
+	}
+
+	var channels, sampleRate int
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return nil, 0, fmt.Errorf("%w: no data chunk", ErrUnsupportedWAV)
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		switch string(chunk[0:4]) {
+		case "fmt ":
+			if size < 16 {
+				return nil, 0, fmt.Errorf("%w: short fmt chunk", ErrUnsupportedWAV)
+			}
+			format := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, format); err != nil {
+				return nil, 0, fmt.Errorf("couldn't read fmt chunk: %w", err)
+			}
+			if tag := binary.LittleEndian.Uint16(format[0:2]); tag != 1 {
+				return nil, 0, fmt.Errorf("%w: format %d isn't PCM", ErrUnsupportedWAV, tag)
+			}
+			if bits := binary.LittleEndian.Uint16(format[14:16]); bits != 16 {
+				return nil, 0, fmt.Errorf("%w: %d bits per sample", ErrUnsupportedWAV, bits)
+			}
+			channels = int(binary.LittleEndian.Uint16(format[2:4]))
+			sampleRate = int(binary.LittleEndian.Uint32(format[4:8]))
+		case "data":
+			if channels == 0 {
+				return nil, 0, fmt.Errorf("%w: data before fmt chunk", ErrUnsupportedWAV)
+			}
+			data, err := io.ReadAll(io.LimitReader(r, size))
+			if err != nil {
+				return nil, 0, fmt.Errorf("couldn't read data chunk: %w", err)
+			}
+			interleaved := DecodePCM16(data)
+			samples := make([]int16, 0, len(interleaved)/channels)
+			for i := 0; i+channels <= len(interleaved); i += channels {
+				samples = append(samples, interleaved[i])
+			}
+			return samples, sampleRate, nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+				return nil, 0, fmt.Errorf("couldn't skip %q chunk: %w", chunk[0:4], err)
+			}
+		}
+	}
+}
+
+// WAVHeaderSize is the size of the header WAVHeader returns
+const WAVHeaderSize = 44
+
+// WAVHeader returns the header of a PCM16 WAV file holding dataSize
+// bytes of interleaved samples
+func WAVHeader(channels, sampleRate int, dataSize int64) []byte {
+	h := make([]byte, WAVHeaderSize)
+	blockAlign := channels * 2
+	copy(h[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:8], uint32(36+dataSize))
+	copy(h[8:12], "WAVE")
+	copy(h[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(h[16:20], 16)
+	binary.LittleEndian.PutUint16(h[20:22], 1) // PCM
+	binary.LittleEndian.PutUint16(h[22:24], uint16(channels))
+	binary.LittleEndian.PutUint32(h[24:28], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(h[28:32], uint32(sampleRate*blockAlign))
+	binary.LittleEndian.PutUint16(h[32:34], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(h[34:36], 16)
+	copy(h[36:40], "data")
+	binary.LittleEndian.PutUint32(h[40:44], uint32(dataSize))
+
+	return h
+}
+
+// WriteWAV writes mono samples as a PCM16 WAV file
+func WriteWAV(w io.Writer, samples []int16, sampleRate int) error {
+	if _, err := w.Write(WAVHeader(1, sampleRate, int64(len(samples)*2))); err != nil {
+		return err
+	}
+	_, err := w.Write(EncodePCM16(samples))
+
+	return err
+}
@@ -0,0 +1,47 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+func TestWAVRoundTrip(t *testing.T) {
+	samples := []int16{0, 1, -1, 32767, -32768, 1234}
+	var buf bytes.Buffer
+	if err := WriteWAV(&buf, samples, TelephonySampleRate); err != nil {
+		t.Fatalf("couldn't write WAV: %v", err)
+	}
+	if buf.Len() != WAVHeaderSize+2*len(samples) {
+		t.Fatalf("wrote %d bytes, want %d", buf.Len(), WAVHeaderSize+2*len(samples))
+	}
+
+	read, sampleRate, err := ReadWAV(&buf)
+	if err != nil {
+		t.Fatalf("couldn't read WAV: %v", err)
+	}
+	if sampleRate != TelephonySampleRate || !slices.Equal(read, samples) {
+		t.Errorf("read %v at %dHz, want %v at %dHz", read, sampleRate, samples, TelephonySampleRate)
+	}
+}
+
+func TestWAVHeaderStereo(t *testing.T) {
+	h := WAVHeader(2, 24000, 96)
+	for _, tc := range []struct {
+		name   string
+		offset int
+		got    uint32
+		want   uint32
+	}{
+		{"riff size", 4, binary.LittleEndian.Uint32(h[4:8]), 36 + 96},
+		{"channels", 22, uint32(binary.LittleEndian.Uint16(h[22:24])), 2},
+		{"byte rate", 28, binary.LittleEndian.Uint32(h[28:32]), 24000 * 4},
+		{"block align", 32, uint32(binary.LittleEndian.Uint16(h[32:34])), 4},
+		{"data size", 40, binary.LittleEndian.Uint32(h[40:44]), 96},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s at %d is %d, want %d", tc.name, tc.offset, tc.got, tc.want)
+		}
+	}
+}
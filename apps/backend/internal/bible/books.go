@@ -0,0 +1,87 @@
+package bible
+
+// Book is a book of the Bible with the names it is cited by. Chapter
+// counts follow the longest common numbering (e.g. Joel has 4 chapters
+// in Catholic editions and 3 in English ones) so valid citations from
+// either tradition are accepted.
+type Book struct {
+	ID       string
+	Chapters int
+	Names    []string
+}
+
+var Books = []Book{
+	{"GEN", 50, []string{"Genesis", "Gen", "Gn", "Postanak", "Post"}},
+	{"EXO", 40, []string{"Exodus", "Exod", "Ex", "Izlazak", "Izl"}},
+	{"LEV", 27, []string{"Leviticus", "Lev", "Levitski zakonik", "Lv"}},
+	{"NUM", 36, []string{"Numbers", "Num", "Nm", "Brojevi", "Br"}},
+	{"DEU", 34, []string{"Deuteronomy", "Deut", "Dt", "Ponovljeni zakon", "Pnz"}},
+	{"JOS", 24, []string{"Joshua", "Josh", "Jošua", "Jš"}},
+	{"JDG", 21, []string{"Judges", "Judg", "Suci", "Suc"}},
+	{"RUT", 4, []string{"Ruth", "Ruta", "Rut", "Ru"}},
+	{"1SA", 31, []string{"1 Samuel", "1 Sam", "1 Samuelova"}},
+	{"2SA", 24, []string{"2 Samuel", "2 Sam", "2 Samuelova"}},
+	{"1KI", 22, []string{"1 Kings", "1 Kgs", "1 Kraljevi", "1 Kraljevima", "1 Kr"}},
+	{"2KI", 25, []string{"2 Kings", "2 Kgs", "2 Kraljevi", "2 Kraljevima", "2 Kr"}},
+	{"1CH", 29, []string{"1 Chronicles", "1 Chr", "1 Ljetopisa", "1 Ljet", "1 Ljetopis"}},
+	{"2CH", 36, []string{"2 Chronicles", "2 Chr", "2 Ljetopisa", "2 Ljet", "2 Ljetopis"}},
+	{"EZR", 10, []string{"Ezra", "Ezr"}},
+	{"NEH", 13, []string{"Nehemiah", "Neh", "Nehemija"}},
+	{"TOB", 14, []string{"Tobit", "Tob", "Tobija"}},
+	{"JDT", 16, []string{"Judith", "Jdt", "Judita"}},
+	{"EST", 16, []string{"Esther", "Esth", "Est", "Estera"}},
+	{"1MA", 16, []string{"1 Maccabees", "1 Macc", "1 Makabejci", "1 Mak"}},
+	{"2MA", 15, []string{"2 Maccabees", "2 Macc", "2 Makabejci", "2 Mak"}},
+	{"JOB", 42, []string{"Job"}},
+	{"PSA", 150, []string{"Psalms", "Psalm", "Ps", "Psalmi", "Psalam"}},
+	{"PRO", 31, []string{"Proverbs", "Prov", "Mudre izreke", "Izreke", "Izr"}},
+	{"ECC", 12, []string{"Ecclesiastes", "Eccl", "Propovjednik", "Prop"}},
+	{"SNG", 8, []string{"Song of Songs", "Song of Solomon", "Song", "Pjesma nad pjesmama", "Pj"}},
+	{"WIS", 19, []string{"Wisdom", "Wis", "Mudrost", "Mudr"}},
+	{"SIR", 51, []string{"Sirach", "Sir", "Sirah"}},
+	{"ISA", 66, []string{"Isaiah", "Isa", "Izaija", "Iz"}},
+	{"JER", 52, []string{"Jeremiah", "Jer", "Jeremija", "Jr"}},
+	{"LAM", 5, []string{"Lamentations", "Lam", "Tužaljke", "Tuž"}},
+	{"BAR", 6, []string{"Baruch", "Bar", "Baruh"}},
+	{"EZK", 48, []string{"Ezekiel", "Ezek", "Ez"}},
+	{"DAN", 14, []string{"Daniel", "Dan", "Dn"}},
+	{"HOS", 14, []string{"Hosea", "Hos", "Hošea", "Hoš"}},
+	{"JOL", 4, []string{"Joel", "Jl"}},
+	{"AMO", 9, []string{"Amos", "Am"}},
+	{"OBA", 1, []string{"Obadiah", "Obad", "Obadija", "Ob"}},
+	{"JON", 4, []string{"Jonah", "Jona", "Jon"}},
+	{"MIC", 7, []string{"Micah", "Mic", "Mihej", "Mih"}},
+	{"NAM", 3, []string{"Nahum", "Nah"}},
+	{"HAB", 3, []string{"Habakkuk", "Hab", "Habakuk"}},
+	{"ZEP", 3, []string{"Zephaniah", "Zeph", "Sefanija", "Sef"}},
+	{"HAG", 2, []string{"Haggai", "Hag", "Hagaj"}},
+	{"ZEC", 14, []string{"Zechariah", "Zech", "Zaharija", "Zah"}},
+	{"MAL", 4, []string{"Malachi", "Mal", "Malahija"}},
+	{"MAT", 28, []string{"Matthew", "Matt", "Mt", "Matej", "Mateju"}},
+	{"MRK", 16, []string{"Mark", "Mk", "Marko", "Marku"}},
+	{"LUK", 24, []string{"Luke", "Lk", "Luka", "Luki"}},
+	{"JHN", 21, []string{"John", "Jn", "Ivan", "Ivanu", "Iv"}},
+	{"ACT", 28, []string{"Acts", "Djela apostolska", "Djela", "Dj"}},
+	{"ROM", 16, []string{"Romans", "Rom", "Rimljanima", "Rim"}},
+	{"1CO", 16, []string{"1 Corinthians", "1 Cor", "1 Korinćanima", "1 Kor"}},
+	{"2CO", 13, []string{"2 Corinthians", "2 Cor", "2 Korinćanima", "2 Kor"}},
+	{"GAL", 6, []string{"Galatians", "Gal", "Galaćanima"}},
+	{"EPH", 6, []string{"Ephesians", "Eph", "Efežanima", "Ef"}},
+	{"PHP", 4, []string{"Philippians", "Phil", "Filipljanima", "Fil"}},
+	{"COL", 4, []string{"Colossians", "Col", "Kološanima", "Kol"}},
+	{"1TH", 5, []string{"1 Thessalonians", "1 Thess", "1 Solunjanima", "1 Sol"}},
+	{"2TH", 3, []string{"2 Thessalonians", "2 Thess", "2 Solunjanima", "2 Sol"}},
+	{"1TI", 6, []string{"1 Timothy", "1 Tim", "1 Timoteju"}},
+	{"2TI", 4, []string{"2 Timothy", "2 Tim", "2 Timoteju"}},
+	{"TIT", 3, []string{"Titus", "Titu", "Tit"}},
+	{"PHM", 1, []string{"Philemon", "Phlm", "Filemonu", "Flm"}},
+	{"HEB", 13, []string{"Hebrews", "Heb", "Hebrejima"}},
+	{"JAS", 5, []string{"James", "Jas", "Jakovljeva", "Jakov", "Jak"}},
+	{"1PE", 5, []string{"1 Peter", "1 Pet", "1 Petrova", "1 Pt"}},
+	{"2PE", 3, []string{"2 Peter", "2 Pet", "2 Petrova", "2 Pt"}},
+	{"1JN", 5, []string{"1 John", "1 Jn", "1 Ivanova", "1 Iv"}},
+	{"2JN", 1, []string{"2 John", "2 Jn", "2 Ivanova", "2 Iv"}},
+	{"3JN", 1, []string{"3 John", "3 Jn", "3 Ivanova", "3 Iv"}},
+	{"JUD", 1, []string{"Jude", "Judina", "Jd"}},
+	{"REV", 22, []string{"Revelation", "Rev", "Otkrivenje", "Otk"}},
+}
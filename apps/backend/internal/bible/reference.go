@@ -0,0 +1,118 @@
+package bible
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The longest chapter in the Bible is Psalm 119 with 176 verses
+const maxVerses = 176
+
+// Reference is a citation like `John 3:16` or `Iv 3,16-18`
+type Reference struct {
+	Book     *Book
+	Raw      string
+	Chapter  int
+	Verse    int
+	VerseEnd int
+}
+
+func (r *Reference) String() string {
+	return r.Raw
+}
+
+// Validate checks the reference points to a chapter and verse that can exist
+func (r *Reference) Validate() error {
+	if r.Chapter < 1 || r.Chapter > r.Book.Chapters {
+		return fmt.Errorf("%s: %s has %d chapters", r.Raw, r.Book.Names[0], r.Book.Chapters)
+	}
+	if r.Verse < 1 || r.Verse > maxVerses {
+		return fmt.Errorf("%s: verse %d is out of range", r.Raw, r.Verse)
+	}
+	if r.VerseEnd != 0 && (r.VerseEnd < r.Verse || r.VerseEnd > maxVerses) {
+		return fmt.Errorf("%s: verse range %d-%d is invalid", r.Raw, r.Verse, r.VerseEnd)
+	}
+
+	return nil
+}
+
+var (
+	booksByName    = make(map[string]*Book)
+	referenceRegex *regexp.Regexp
+)
+
+func init() {
+	var names []string
+	for i := range Books {
+		for _, name := range Books[i].Names {
+			booksByName[normalizeName(name)] = &Books[i]
+			names = append(names, name)
+		}
+	}
+	// longest names first, so `1 John` wins over `John`
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	for i, name := range names {
+		quoted := regexp.QuoteMeta(name)
+		// `1 John`, `1John` and `1. John` are all in use
+		names[i] = strings.Replace(quoted, " ", `\.?\s*`, 1)
+	}
+
+	referenceRegex = regexp.MustCompile(`(?i)(?:^|[^\pL\d])(` + strings.Join(names, "|") + `)\.?\s+(\d{1,3})\s*[:,]\s*(\d{1,3})(?:\s*[-–]\s*(\d{1,3}))?`)
+}
+
+func normalizeName(name string) string {
+	name = strings.ToLower(name)
+	name = strings.ReplaceAll(name, ".", "")
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// FindBook returns the book cited by the given name or abbreviation
+func FindBook(name string) (*Book, bool) {
+	name = normalizeName(name)
+	if b, ok := booksByName[name]; ok {
+		return b, true
+	}
+	// `1John` style names without the space
+	if len(name) > 1 && name[0] >= '1' && name[0] <= '3' && name[1] != ' ' {
+		b, ok := booksByName[name[:1]+" "+name[1:]]
+		return b, ok
+	}
+
+	return nil, false
+}
+
+// FindReferences returns every citation of a known book in the text
+func FindReferences(text string) []*Reference {
+	var refs []*Reference
+	for _, m := range referenceRegex.FindAllStringSubmatchIndex(text, -1) {
+		book, ok := FindBook(text[m[2]:m[3]])
+		if !ok {
+			continue
+		}
+		ref := &Reference{
+			Book: book,
+			Raw:  strings.TrimSpace(text[m[2]:m[1]]),
+		}
+		ref.Chapter, _ = strconv.Atoi(text[m[4]:m[5]])
+		ref.Verse, _ = strconv.Atoi(text[m[6]:m[7]])
+		if m[8] != -1 {
+			ref.VerseEnd, _ = strconv.Atoi(text[m[8]:m[9]])
+		}
+		refs = append(refs, ref)
+	}
+
+	return refs
+}
+
+// ParseReference parses a single citation
+func ParseReference(text string) (*Reference, error) {
+	refs := FindReferences(text)
+	if len(refs) != 1 {
+		return nil, fmt.Errorf("%q is not a single bible reference", text)
+	}
+
+	return refs[0], nil
+}
@@ -0,0 +1,89 @@
+package bible
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Verse is the text of a single verse
+type Verse struct {
+	Chapter int    `json:"chapter"`
+	Verse   int    `json:"verse"`
+	Text    string `json:"text"`
+}
+
+type verseKey struct {
+	book    string
+	chapter int
+	verse   int
+}
+
+// Text is a translation of the Bible verses can be looked up in
+type Text struct {
+	verses map[verseKey]string
+}
+
+// LoadText reads a translation from a tab separated file with a verse per
+// line: book ID, chapter, verse and text, e.g. `JHN	3	16	For God so...`.
+// Empty lines and lines starting with # are skipped.
+func LoadText(path string) (*Text, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open bible text: %w", err)
+	}
+	defer f.Close()
+
+	return ReadText(f)
+}
+
+func ReadText(r io.Reader) (*Text, error) {
+	t := &Text{verses: make(map[verseKey]string)}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if scanner.Text() == "" || strings.HasPrefix(scanner.Text(), "#") {
+			continue
+		}
+		fields := strings.SplitN(scanner.Text(), "\t", 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("line %d: expected book, chapter, verse and text", line)
+		}
+		chapter, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid chapter %q", line, fields[1])
+		}
+		verse, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid verse %q", line, fields[2])
+		}
+		t.verses[verseKey{strings.ToUpper(fields[0]), chapter, verse}] = strings.TrimSpace(fields[3])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("couldn't read bible text: %w", err)
+	}
+
+	return t, nil
+}
+
+// Verses returns the verses of the reference the text has, a nil Text
+// has none
+func (t *Text) Verses(ref *Reference) []Verse {
+	if t == nil {
+		return nil
+	}
+	end := ref.VerseEnd
+	if end == 0 {
+		end = ref.Verse
+	}
+	var verses []Verse
+	for v := ref.Verse; v <= end; v++ {
+		if text, ok := t.verses[verseKey{ref.Book.ID, ref.Chapter, v}]; ok {
+			verses = append(verses, Verse{Chapter: ref.Chapter, Verse: v, Text: text})
+		}
+	}
+
+	return verses
+}
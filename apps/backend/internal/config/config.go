@@ -1,9 +1,24 @@
 package config
 
+import "time"
+
 type Config struct {
-	OpenAi   OpenAIConfig   `yaml:"openai"`
-	Keycloak KeycloakConfig `yaml:"keycloak"`
-	Server   ServerConfig   `yaml:"server"`
+	OpenAi      OpenAIConfig       `yaml:"openai"`
+	Keycloak    KeycloakConfig     `yaml:"keycloak"`
+	Server      ServerConfig       `yaml:"server"`
+	Database    DatabaseConfig     `yaml:"database"`
+	Personas    []PersonaConfig    `yaml:"personas"`
+	Experiments []ExperimentConfig `yaml:"experiments"`
+	Realtime    RealtimeConfig     `yaml:"realtime"`
+	Recordings  RecordingsConfig   `yaml:"recordings"`
+	Bible       BibleConfig        `yaml:"bible"`
+}
+
+// BibleConfig points the bible_lookup tool at a translation
+type BibleConfig struct {
+	// Text is a tab separated file of book ID, chapter, verse and text,
+	// without it verses are only validated
+	Text string `yaml:"text"`
 }
 
 type OpenAIConfig struct {
@@ -13,8 +28,255 @@ type OpenAIConfig struct {
 
 type KeycloakConfig struct {
 	Oauth2IssuerURL string `yaml:"oauth2_issuer_url"`
+	// Realm role required to access the /v1/admin endpoints
+	AdminRole string `yaml:"admin_role"`
 }
 
 type ServerConfig struct {
 	Port string `yaml:"port"`
 }
+
+type DatabaseConfig struct {
+	DSN string `yaml:"dsn"`
+}
+
+// PersonaConfig describes a persona the assistant can take on. The first
+// persona in the list is used when the client doesn't ask for one.
+type PersonaConfig struct {
+	Name         string `yaml:"name"`
+	Instructions string `yaml:"instructions"`
+	Voice        string `yaml:"voice"`
+	Language     string `yaml:"language"`
+	Model        string `yaml:"model"`
+	// Tools are the names of the server side tools the persona may call
+	// in voice sessions
+	Tools []string `yaml:"tools"`
+}
+
+// ExperimentConfig splits the traffic of a persona between variants that
+// override its instructions or model. Only one enabled experiment per
+// persona is taken into account.
+type ExperimentConfig struct {
+	Name     string          `yaml:"name"`
+	Persona  string          `yaml:"persona"`
+	Enabled  bool            `yaml:"enabled"`
+	Variants []VariantConfig `yaml:"variants"`
+}
+
+type VariantConfig struct {
+	Name string `yaml:"name"`
+	// Weight is the relative share of users assigned to the variant
+	Weight int `yaml:"weight"`
+	// Instructions and Model override the persona when set
+	Instructions string `yaml:"instructions"`
+	Model        string `yaml:"model"`
+}
+
+// RealtimeConfig lists what clients may choose when they open a voice
+// session, empty lists fall back to the built in defaults
+type RealtimeConfig struct {
+	// URL of the OpenAI realtime endpoint, only set to point the proxy
+	// at a fake upstream
+	URL string `yaml:"url"`
+	// SessionsURL of the endpoint minting sessions for direct WebRTC
+	// clients, only set to point the proxy at a fake upstream
+	SessionsURL         string   `yaml:"sessions_url"`
+	Voices              []string `yaml:"voices"`
+	TranscriptionModels []string `yaml:"transcription_models"`
+	// Languages maps language codes clients may ask for to the language
+	// name used in the instructions, e.g. `hr: Croatian`
+	Languages      map[string]string `yaml:"languages"`
+	TurnDetection  []string          `yaml:"turn_detection"`
+	MinTemperature float64           `yaml:"min_temperature"`
+	MaxTemperature float64           `yaml:"max_temperature"`
+	// MaxResponseOutputTokens caps the output tokens of every response,
+	// 0 leaves them unlimited
+	MaxResponseOutputTokens int `yaml:"max_response_output_tokens"`
+	// StoreTranscripts turns input audio transcription on for every
+	// session and stores both sides in the user's conversation history
+	StoreTranscripts bool                 `yaml:"store_transcripts"`
+	Defaults         RealtimeDefaults     `yaml:"defaults"`
+	ClientEvents     RealtimeClientEvents `yaml:"client_events"`
+	Journal          RealtimeJournal      `yaml:"journal"`
+	Telephony        TelephonyConfig      `yaml:"telephony"`
+	Quota            RealtimeQuota        `yaml:"quota"`
+	// ToolTimeout limits how long a tool call may run, 10s when zero
+	ToolTimeout time.Duration  `yaml:"tool_timeout"`
+	VAD         RealtimeVAD    `yaml:"vad"`
+	Limits      RealtimeLimits `yaml:"limits"`
+	// MaxSessions caps the open proxied sessions, and so the upstream
+	// connections, MaxSessionsPerUser those of a signed in user. Zero
+	// doesn't limit.
+	MaxSessions        int                `yaml:"max_sessions"`
+	MaxSessionsPerUser int                `yaml:"max_sessions_per_user"`
+	Fallback           RealtimeFallback   `yaml:"fallback"`
+	Rooms              RealtimeRooms      `yaml:"rooms"`
+	Connection         RealtimeConnection `yaml:"connection"`
+	// AllowAnonymous lets voice sessions open without a token, they get
+	// the default limits and their usage isn't attributed
+	AllowAnonymous bool `yaml:"allow_anonymous"`
+	// ResumeAttempts is how often a dropped OpenAI connection is redialed
+	// before the session is closed, 3 when zero, negative doesn't resume
+	ResumeAttempts int `yaml:"resume_attempts"`
+}
+
+// RealtimeRooms let several participants share one voice session, one
+// of them speaks at a time and everyone hears the persona
+type RealtimeRooms struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxParticipants caps who can be in a room at once, zero doesn't
+	// limit
+	MaxParticipants int `yaml:"max_participants"`
+}
+
+// RealtimeFallback is the cascaded speech to text, chat and text to
+// speech pipeline voice sessions fall back to when OpenAI realtime can't
+// be reached
+type RealtimeFallback struct {
+	Enabled bool `yaml:"enabled"`
+	// Fake answers with local fakes instead of calling OpenAI, for
+	// development and CI
+	Fake               bool   `yaml:"fake"`
+	TranscriptionModel string `yaml:"transcription_model"`
+	SpeechModel        string `yaml:"speech_model"`
+	// ChatModel defaults to the model of the text chat
+	ChatModel string `yaml:"chat_model"`
+}
+
+// RealtimeConnection guards the client connections of voice sessions,
+// violations close the socket
+type RealtimeConnection struct {
+	// AllowedOrigins may open sessions from a browser, `*` allows any.
+	// When empty only pages served from the api's own host may.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// MaxMessageSize in bytes, 1 MiB when zero
+	MaxMessageSize int64 `yaml:"max_message_size"`
+	// MaxAppendsPerSecond caps the input_audio_buffer.append events of a
+	// session, zero doesn't limit
+	MaxAppendsPerSecond int `yaml:"max_appends_per_second"`
+	// WriteTimeout is how long a frame to a client may take to be written
+	// before the client is considered stalled, 10s when zero
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+}
+
+// RealtimeLimits bound how long voice sessions may last. The defaults
+// apply to everyone, a realm role in Roles replaces them for its users
+// and a user with several gets the most generous. Zero durations don't
+// limit.
+type RealtimeLimits struct {
+	MaxDuration time.Duration `yaml:"max_duration"`
+	// IdleTimeout ends sessions without client events or speech
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	// WrapUp is how long before a limit the persona is asked to say
+	// goodbye, 20s when zero
+	WrapUp time.Duration                 `yaml:"wrap_up"`
+	Roles  map[string]RealtimeRoleLimits `yaml:"roles"`
+}
+
+type RealtimeRoleLimits struct {
+	MaxDuration time.Duration `yaml:"max_duration"`
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+}
+
+// RealtimeVAD tunes the proxy_vad turn detection, zero values use the
+// defaults. Sessions may override the threshold, padding and silence.
+type RealtimeVAD struct {
+	// Threshold is the RMS level of speech as a fraction of full scale
+	Threshold float64 `yaml:"threshold"`
+	// MaxZeroCrossingRate is the share of samples changing sign above
+	// which loud audio is taken for noise
+	MaxZeroCrossingRate float64 `yaml:"max_zero_crossing_rate"`
+	MinSpeechMs         int     `yaml:"min_speech_ms"`
+	SilenceDurationMs   int     `yaml:"silence_duration_ms"`
+	PrefixPaddingMs     int     `yaml:"prefix_padding_ms"`
+}
+
+type RealtimeDefaults struct {
+	Modalities         []string `yaml:"modalities"`
+	TurnDetection      string   `yaml:"turn_detection"`
+	Transcription      bool     `yaml:"transcription"`
+	TranscriptionModel string   `yaml:"transcription_model"`
+	Temperature        float64  `yaml:"temperature"`
+}
+
+// RealtimeClientEvents decides which events a client may send to OpenAI
+// once the session is open, empty lists fall back to the built in
+// defaults which leave the instructions and tools to the server
+type RealtimeClientEvents struct {
+	// Allowed lists the client event types forwarded to OpenAI
+	Allowed []string `yaml:"allowed"`
+	// SessionFields lists the session fields a `session.update` may change
+	SessionFields []string `yaml:"session_fields"`
+	// ResponseFields lists the fields a `response.create` may override
+	ResponseFields []string `yaml:"response_fields"`
+}
+
+// RealtimeJournal writes every frame of every session to a JSONL file in
+// Dir, which cmd/rtreplay can replay
+type RealtimeJournal struct {
+	Enabled bool   `yaml:"enabled"`
+	Dir     string `yaml:"dir"`
+	// IncludeAudio keeps the base64 audio of the frames of sessions with
+	// recording consent, it is left out by default as it makes up most of
+	// the journal
+	IncludeAudio bool `yaml:"include_audio"`
+	// MaxAge and MaxTotalMB limit what is kept, the oldest journals are
+	// deleted first. Zero values don't limit.
+	MaxAge     time.Duration `yaml:"max_age"`
+	MaxTotalMB int64         `yaml:"max_total_mb"`
+}
+
+// RealtimeQuota limits what each user may use per UTC day, proxied
+// sessions and sessions minted for direct clients alike. Zero values
+// don't limit.
+type RealtimeQuota struct {
+	// DailyCostUSD caps the recorded realtime usage cost, estimates for
+	// minted sessions included
+	DailyCostUSD float64 `yaml:"daily_cost_usd"`
+	// DailyClientSessions caps the sessions minted for direct clients
+	DailyClientSessions int `yaml:"daily_client_sessions"`
+	// DailyVoiceMinutes caps the minutes of proxied sessions, a session
+	// is closed when the user runs out
+	DailyVoiceMinutes float64 `yaml:"daily_voice_minutes"`
+	// ClientSessionMinutes is how long a minted session is assumed to
+	// last, its estimated cost is charged to DailyCostUSD as the proxy
+	// never sees its usage. 10 minutes when zero.
+	ClientSessionMinutes float64 `yaml:"client_session_minutes"`
+}
+
+// TelephonyConfig bridges phone calls, streamed in the style of Twilio
+// Media Streams, into realtime sessions
+type TelephonyConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Secret, when set, must be sent as the `secret` custom parameter of
+	// the stream. Calls are refused unless it or AuthToken is set.
+	Secret string `yaml:"secret"`
+	// AuthToken, when set, verifies the X-Twilio-Signature of the stream
+	// upgrade
+	AuthToken string `yaml:"auth_token"`
+	// PublicURL is the scheme and host the provider connects to, e.g.
+	// `wss://voice.example.com`, the signature covers it. The request's
+	// host is used when empty.
+	PublicURL string `yaml:"public_url"`
+	// Persona answers the calls no number or parameter picks one for
+	Persona string `yaml:"persona"`
+	// Numbers maps the called number, sent as the `to` custom parameter,
+	// to the persona answering it
+	Numbers map[string]string `yaml:"numbers"`
+}
+
+// RecordingsConfig controls the recording of voice sessions for quality
+// review. A session is only recorded when recordings are enabled and the
+// client gave its consent.
+type RecordingsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Dir is where the WAV files are stored on the local disk
+	Dir string `yaml:"dir"`
+	// Stereo mixes both sides into one file, the user on the left and the
+	// assistant on the right channel, instead of one file per side
+	Stereo bool `yaml:"stereo"`
+	// MaxAge and MaxTotalMB limit what is kept, the oldest recordings are
+	// deleted first. Zero values don't limit.
+	MaxAge     time.Duration `yaml:"max_age"`
+	MaxTotalMB int64         `yaml:"max_total_mb"`
+}
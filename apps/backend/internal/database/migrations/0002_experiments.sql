@@ -0,0 +1,4 @@
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS experiment TEXT NOT NULL DEFAULT '';
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS variant TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS messages_experiment_idx ON messages (experiment, variant) WHERE experiment <> '';
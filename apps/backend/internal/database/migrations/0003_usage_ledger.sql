@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS usage_ledger (
+    id BIGSERIAL PRIMARY KEY,
+    user_id TEXT NOT NULL DEFAULT '',
+    conversation_id UUID,
+    message_id UUID,
+    -- realtime sessions aren't tied to a conversation
+    session_id TEXT NOT NULL DEFAULT '',
+    source TEXT NOT NULL,
+    model TEXT NOT NULL,
+    input_tokens INTEGER NOT NULL DEFAULT 0,
+    output_tokens INTEGER NOT NULL DEFAULT 0,
+    cached_tokens INTEGER NOT NULL DEFAULT 0,
+    audio_input_tokens INTEGER NOT NULL DEFAULT 0,
+    audio_output_tokens INTEGER NOT NULL DEFAULT 0,
+    cost_usd NUMERIC(14, 8) NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS usage_ledger_created_at_idx ON usage_ledger (created_at);
+CREATE INDEX IF NOT EXISTS usage_ledger_user_id_idx ON usage_ledger (user_id, created_at);
+CREATE INDEX IF NOT EXISTS usage_ledger_message_id_idx ON usage_ledger (message_id);
@@ -0,0 +1,14 @@
+ALTER TABLE usage_ledger ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'ok';
+
+CREATE TABLE IF NOT EXISTS realtime_sessions (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL DEFAULT '',
+    model TEXT NOT NULL,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ended_at TIMESTAMPTZ,
+    -- why the session ended, empty for a normal close
+    error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS realtime_sessions_started_at_idx ON realtime_sessions (started_at);
+CREATE INDEX IF NOT EXISTS realtime_sessions_user_id_idx ON realtime_sessions (user_id, started_at);
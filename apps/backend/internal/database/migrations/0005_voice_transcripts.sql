@@ -0,0 +1,4 @@
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT 'text';
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS session_id TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS messages_session_idx ON messages (session_id) WHERE session_id <> '';
@@ -0,0 +1,8 @@
+-- totals of the responses of a session, written when it ends
+ALTER TABLE realtime_sessions ADD COLUMN IF NOT EXISTS responses INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE realtime_sessions ADD COLUMN IF NOT EXISTS input_tokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE realtime_sessions ADD COLUMN IF NOT EXISTS output_tokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE realtime_sessions ADD COLUMN IF NOT EXISTS cached_tokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE realtime_sessions ADD COLUMN IF NOT EXISTS audio_input_tokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE realtime_sessions ADD COLUMN IF NOT EXISTS audio_output_tokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE realtime_sessions ADD COLUMN IF NOT EXISTS cost_usd NUMERIC(14, 8) NOT NULL DEFAULT 0;
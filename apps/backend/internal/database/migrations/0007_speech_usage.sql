@@ -0,0 +1,4 @@
+-- transcription and speech models are billed by the second and the
+-- character rather than by tokens
+ALTER TABLE usage_ledger ADD COLUMN IF NOT EXISTS transcription_seconds NUMERIC(12, 3) NOT NULL DEFAULT 0;
+ALTER TABLE usage_ledger ADD COLUMN IF NOT EXISTS speech_characters INTEGER NOT NULL DEFAULT 0;
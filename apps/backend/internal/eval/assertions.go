@@ -0,0 +1,139 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"proomptmachinee/internal/bible"
+	"regexp"
+	"strings"
+)
+
+const (
+	AssertContains       = "contains"
+	AssertNotContains    = "not_contains"
+	AssertLanguage       = "language"
+	AssertRegex          = "regex"
+	AssertVerseCitations = "verse_citations"
+	AssertJSONSchema     = "json_schema"
+)
+
+type Assertion struct {
+	Type  string `yaml:"type"`
+	Value string `yaml:"value"`
+	// IgnoreCase applies to contains and not_contains
+	IgnoreCase bool `yaml:"ignore_case"`
+	// Min is the least number of citations verse_citations expects
+	Min int `yaml:"min"`
+	// Schema is the JSON schema the answer has to satisfy
+	Schema map[string]interface{} `yaml:"schema"`
+
+	regex *regexp.Regexp
+}
+
+func (a *Assertion) compile() error {
+	switch a.Type {
+	case AssertContains, AssertNotContains:
+		if a.Value == "" {
+			return fmt.Errorf("%s assertion needs a value", a.Type)
+		}
+	case AssertLanguage:
+		if _, ok := stopwords[a.Value]; !ok {
+			return fmt.Errorf("language %q is not supported", a.Value)
+		}
+	case AssertRegex:
+		re, err := regexp.Compile(a.Value)
+		if err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+		a.regex = re
+	case AssertVerseCitations:
+	case AssertJSONSchema:
+		if a.Schema == nil {
+			return fmt.Errorf("%s assertion needs a schema", a.Type)
+		}
+	default:
+		return fmt.Errorf("unknown assertion type %q", a.Type)
+	}
+
+	return nil
+}
+
+// Check returns a description of why the answer failed, or an empty
+// string if it passed
+func (a *Assertion) Check(answer string) string {
+	switch a.Type {
+	case AssertContains:
+		if !contains(answer, a.Value, a.IgnoreCase) {
+			return fmt.Sprintf("answer doesn't contain %q", a.Value)
+		}
+	case AssertNotContains:
+		if contains(answer, a.Value, a.IgnoreCase) {
+			return fmt.Sprintf("answer contains %q", a.Value)
+		}
+	case AssertLanguage:
+		if lang := DetectLanguage(answer); lang != a.Value {
+			return fmt.Sprintf("answer language is %q, expected %q", lang, a.Value)
+		}
+	case AssertRegex:
+		if !a.regex.MatchString(answer) {
+			return fmt.Sprintf("answer doesn't match %q", a.Value)
+		}
+	case AssertVerseCitations:
+		refs := bible.FindReferences(answer)
+		if len(refs) < a.Min {
+			return fmt.Sprintf("answer has %d verse citations, expected at least %d", len(refs), a.Min)
+		}
+		var invalid []string
+		for _, ref := range refs {
+			if err := ref.Validate(); err != nil {
+				invalid = append(invalid, err.Error())
+			}
+		}
+		if len(invalid) > 0 {
+			return "invalid verse citations: " + strings.Join(invalid, "; ")
+		}
+	case AssertJSONSchema:
+		var doc interface{}
+		if err := json.Unmarshal([]byte(extractJSON(answer)), &doc); err != nil {
+			return fmt.Sprintf("answer isn't valid JSON: %v", err)
+		}
+		if errs := validateSchema(a.Schema, doc, "$"); len(errs) > 0 {
+			return "answer doesn't match schema: " + strings.Join(errs, "; ")
+		}
+	}
+
+	return ""
+}
+
+// Describe is the stable label of the assertion used in reports
+func (a *Assertion) Describe() string {
+	switch a.Type {
+	case AssertVerseCitations:
+		return fmt.Sprintf("%s(min=%d)", a.Type, a.Min)
+	case AssertJSONSchema:
+		return a.Type
+	default:
+		return fmt.Sprintf("%s(%q)", a.Type, a.Value)
+	}
+}
+
+func contains(s, substr string, ignoreCase bool) bool {
+	if ignoreCase {
+		return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+	}
+
+	return strings.Contains(s, substr)
+}
+
+// extractJSON strips the markdown code fence models like to wrap JSON in
+func extractJSON(answer string) string {
+	answer = strings.TrimSpace(answer)
+	if !strings.HasPrefix(answer, "```") {
+		return answer
+	}
+	answer = strings.TrimPrefix(answer, "```json")
+	answer = strings.TrimPrefix(answer, "```")
+	answer = strings.TrimSuffix(answer, "```")
+
+	return strings.TrimSpace(answer)
+}
@@ -0,0 +1,43 @@
+package eval
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Small stopword lists are enough to tell apart the languages personas
+// answer in, answers are paragraphs long and full of function words.
+// Words that are common in English too, like "i" and "to", are left out
+// of the Croatian list.
+var stopwords = map[string][]string{
+	"hr": {"je", "u", "da", "se", "na", "su", "za", "od", "ne", "što", "koji", "koja", "kao", "ali", "sam", "si", "smo", "te", "bog", "mi", "ti", "ja", "iz", "po", "ili", "bi", "će", "kako"},
+	"en": {"the", "and", "is", "of", "to", "in", "that", "it", "you", "for", "was", "with", "as", "are", "be", "this", "have", "not", "but", "my", "your", "god", "will", "who"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ich", "du", "zu", "den", "mit", "sich", "des", "auf", "für", "ein", "eine", "dem", "auch", "es", "wie", "gott"},
+}
+
+// DetectLanguage returns the language whose stopwords make up most of
+// the text, or an empty string if none of them is present
+func DetectLanguage(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+
+	best, bestScore := "", 0
+	for _, lang := range []string{"hr", "en", "de"} {
+		score := 0
+		set := make(map[string]bool, len(stopwords[lang]))
+		for _, w := range stopwords[lang] {
+			set[w] = true
+		}
+		for _, w := range words {
+			if set[w] {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = lang, score
+		}
+	}
+
+	return best
+}
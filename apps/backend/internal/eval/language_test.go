@@ -0,0 +1,19 @@
+package eval
+
+import "testing"
+
+func TestDetectLanguage(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		lang string
+	}{
+		{"I want to go to the sea, I think I need to rest.", "en"},
+		{"Ja sam rekao da je to dobro i da ćemo ići na more.", "hr"},
+		{"Ich weiß nicht, wie das Wetter morgen wird, aber es ist schön.", "de"},
+		{"12345", ""},
+	} {
+		if lang := DetectLanguage(tc.text); lang != tc.lang {
+			t.Errorf("%q detected as %q, want %q", tc.text, lang, tc.lang)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package eval
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+type Report struct {
+	Suite   string
+	Results []*Result
+}
+
+type Result struct {
+	Case    string
+	Model   string
+	Persona string
+	Answer  string
+	// Error is set when the case couldn't be run at all
+	Error  string
+	Checks []*Check
+}
+
+type Check struct {
+	Assertion string
+	// Failure is empty when the assertion passed
+	Failure string
+}
+
+func (r *Result) Passed() bool {
+	if r.Error != "" {
+		return false
+	}
+	for _, c := range r.Checks {
+		if c.Failure != "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *Report) Failed() int {
+	failed := 0
+	for _, res := range r.Results {
+		if !res.Passed() {
+			failed++
+		}
+	}
+
+	return failed
+}
+
+// Write prints the report grouped by model. The output has no timings
+// or other run specific data, so reports of two runs can be diffed to
+// spot regressions.
+func (r *Report) Write(w io.Writer, verbose bool) error {
+	byModel := make(map[string][]*Result)
+	var models []string
+	for _, res := range r.Results {
+		if _, ok := byModel[res.Model]; !ok {
+			models = append(models, res.Model)
+		}
+		byModel[res.Model] = append(byModel[res.Model], res)
+	}
+	sort.Strings(models)
+
+	ew := &errWriter{w: w}
+	ew.printf("suite: %s\n", r.Suite)
+	for _, model := range models {
+		results := byModel[model]
+		sort.Slice(results, func(i, j int) bool { return results[i].Case < results[j].Case })
+
+		passed := 0
+		ew.printf("\nmodel: %s\n", model)
+		for _, res := range results {
+			status := "FAIL"
+			if res.Passed() {
+				status = "PASS"
+				passed++
+			}
+			ew.printf("  %s %s (persona: %s)\n", status, res.Case, res.Persona)
+			if res.Error != "" {
+				ew.printf("       error: %s\n", res.Error)
+			}
+			for _, c := range res.Checks {
+				if c.Failure != "" {
+					ew.printf("       %s: %s\n", c.Assertion, c.Failure)
+				} else if verbose {
+					ew.printf("       %s: ok\n", c.Assertion)
+				}
+			}
+			if verbose && res.Answer != "" {
+				ew.printf("       answer: %q\n", res.Answer)
+			}
+		}
+		ew.printf("  %d/%d passed\n", passed, len(results))
+	}
+	ew.printf("\ntotal: %d passed, %d failed\n", len(r.Results)-r.Failed(), r.Failed())
+
+	return ew.err
+}
+
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) printf(format string, args ...interface{}) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = fmt.Fprintf(e.w, format, args...)
+}
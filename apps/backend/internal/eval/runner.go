@@ -0,0 +1,78 @@
+package eval
+
+import (
+	"context"
+	"fmt"
+	"proomptmachinee/internal/services/openai/completions"
+	"proomptmachinee/internal/services/personas"
+)
+
+type Runner struct {
+	provider completions.Provider
+	personas *personas.Catalog
+}
+
+func NewRunner(provider completions.Provider, personas *personas.Catalog) *Runner {
+	return &Runner{provider: provider, personas: personas}
+}
+
+// Run runs every case against each of its models. Provider errors are
+// recorded as failed results, so one flaky call doesn't hide the rest of
+// the report.
+func (r *Runner) Run(ctx context.Context, suite *Suite) *Report {
+	report := &Report{Suite: suite.Name}
+
+	for _, c := range suite.Cases {
+		models := c.Models
+		if len(models) == 0 {
+			models = suite.Models
+		}
+		for _, model := range models {
+			report.Results = append(report.Results, r.runCase(ctx, c, model))
+		}
+	}
+
+	return report
+}
+
+func (r *Runner) runCase(ctx context.Context, c *Case, model string) *Result {
+	result := &Result{Case: c.Name, Model: model}
+
+	persona, err := r.personas.Get(c.Persona)
+	if err != nil {
+		result.Error = fmt.Sprintf("persona %q: %v", c.Persona, err)
+		return result
+	}
+	result.Persona = persona.Name
+
+	var messages []*completions.CompletionRequestMessage
+	if persona.Instructions != "" {
+		messages = append(messages, &completions.CompletionRequestMessage{
+			Role:    completions.CompletionRequestMessageRoleSystem,
+			Content: persona.Instructions,
+		})
+	}
+	messages = append(messages, &completions.CompletionRequestMessage{
+		Role:    completions.CompletionRequestMessageRoleUser,
+		Content: c.Prompt,
+	})
+
+	completion, err := r.provider.Complete(ctx, &completions.CompletionRequest{
+		Model:    model,
+		Messages: messages,
+	})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Answer = completion.Content
+
+	for _, a := range c.Assertions {
+		result.Checks = append(result.Checks, &Check{
+			Assertion: a.Describe(),
+			Failure:   a.Check(completion.Content),
+		})
+	}
+
+	return result
+}
@@ -0,0 +1,110 @@
+package eval
+
+import (
+	"fmt"
+	"sort"
+)
+
+// validateSchema checks doc against the subset of JSON schema the suites
+// need: type, required, properties, additionalProperties (bool), items,
+// enum, minItems and minLength.
+func validateSchema(schema map[string]interface{}, doc interface{}, path string) []string {
+	var errs []string
+
+	if t, ok := schema["type"].(string); ok && !hasType(doc, t) {
+		return []string{fmt.Sprintf("%s: expected %s", path, t)}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, v := range enum {
+			if fmt.Sprint(v) == fmt.Sprint(doc) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("%s: value isn't one of %v", path, enum))
+		}
+	}
+
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		props, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, r := range required {
+				if _, ok := v[fmt.Sprint(r)]; !ok {
+					errs = append(errs, fmt.Sprintf("%s: missing required property %v", path, r))
+				}
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			propSchema, ok := props[k].(map[string]interface{})
+			if !ok {
+				if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+					errs = append(errs, fmt.Sprintf("%s: unexpected property %s", path, k))
+				}
+				continue
+			}
+			errs = append(errs, validateSchema(propSchema, v[k], path+"."+k)...)
+		}
+	case []interface{}:
+		if min, ok := toInt(schema["minItems"]); ok && len(v) < min {
+			errs = append(errs, fmt.Sprintf("%s: expected at least %d items", path, min))
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				errs = append(errs, validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case string:
+		if min, ok := toInt(schema["minLength"]); ok && len([]rune(v)) < min {
+			errs = append(errs, fmt.Sprintf("%s: expected at least %d characters", path, min))
+		}
+	}
+
+	return errs
+}
+
+func hasType(doc interface{}, t string) bool {
+	switch t {
+	case "object":
+		_, ok := doc.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := doc.([]interface{})
+		return ok
+	case "string":
+		_, ok := doc.(string)
+		return ok
+	case "number":
+		_, ok := doc.(float64)
+		return ok
+	case "integer":
+		f, ok := doc.(float64)
+		return ok && f == float64(int64(f))
+	case "boolean":
+		_, ok := doc.(bool)
+		return ok
+	case "null":
+		return doc == nil
+	}
+
+	return false
+}
+
+func toInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case float64:
+		return int(n), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,91 @@
+package eval
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"proomptmachinee/internal/config"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Suite is a set of prompts that are run against every listed model
+type Suite struct {
+	Name string `yaml:"name"`
+	// Models the cases are run against, a case can narrow this down
+	Models []string `yaml:"models"`
+	// Personas override or extend the configured personas, which allows
+	// trying out new instructions before they land in config
+	Personas []config.PersonaConfig `yaml:"personas"`
+	Cases    []*Case                `yaml:"cases"`
+}
+
+type Case struct {
+	Name    string   `yaml:"name"`
+	Persona string   `yaml:"persona"`
+	Prompt  string   `yaml:"prompt"`
+	Models  []string `yaml:"models"`
+	// FakeResponse is what the fake provider answers, it lets the suite
+	// itself run in CI without calling OpenAI
+	FakeResponse string       `yaml:"fake_response"`
+	Assertions   []*Assertion `yaml:"assert"`
+}
+
+func LoadSuite(path string) (*Suite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read suite: %w", err)
+	}
+
+	suite := &Suite{}
+	if err := yaml.Unmarshal(data, suite); err != nil {
+		return nil, fmt.Errorf("couldn't unmarshal suite: %w", err)
+	}
+	if err := suite.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid suite %s: %w", path, err)
+	}
+
+	return suite, nil
+}
+
+func (s *Suite) Validate() error {
+	if len(s.Cases) == 0 {
+		return errors.New("suite has no cases")
+	}
+
+	names := make(map[string]bool)
+	for i, c := range s.Cases {
+		if c.Name == "" {
+			return fmt.Errorf("case %d has no name", i)
+		}
+		if names[c.Name] {
+			return fmt.Errorf("case %s is defined twice", c.Name)
+		}
+		names[c.Name] = true
+		if c.Prompt == "" {
+			return fmt.Errorf("case %s has no prompt", c.Name)
+		}
+		if len(c.Models) == 0 && len(s.Models) == 0 {
+			return fmt.Errorf("case %s has no models to run against", c.Name)
+		}
+		for _, a := range c.Assertions {
+			if err := a.compile(); err != nil {
+				return fmt.Errorf("case %s: %w", c.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// FakeResponses maps every prompt of the suite to its fake response
+func (s *Suite) FakeResponses() map[string]string {
+	responses := make(map[string]string, len(s.Cases))
+	for _, c := range s.Cases {
+		if c.FakeResponse != "" {
+			responses[c.Prompt] = c.FakeResponse
+		}
+	}
+
+	return responses
+}
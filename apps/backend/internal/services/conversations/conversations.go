@@ -0,0 +1,145 @@
+package conversations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+
+	SourceText  = "text"
+	SourceVoice = "voice"
+)
+
+var ErrNotFound = errors.New("not found")
+
+type Conversation struct {
+	ID        uuid.UUID
+	UserID    string
+	Persona   string
+	CreatedAt time.Time
+}
+
+type Message struct {
+	ID             uuid.UUID
+	ConversationID uuid.UUID
+	ParentID       *uuid.UUID
+	Role           string
+	Content        string
+	Persona        string
+	Model          string
+	// Experiment and Variant the message was produced in, if any
+	Experiment string
+	Variant    string
+	// Source is SourceText for chat messages and SourceVoice for the
+	// transcripts of realtime sessions, SessionID is the realtime session
+	Source    string
+	SessionID string
+	CreatedAt time.Time
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Ensure returns the conversation with the given id, creating it for
+// the user if it doesn't exist yet. A conversation owned by another
+// user is reported as not found. Anonymous callers can't be told apart,
+// so an anonymous conversation can only be created, never continued.
+func (s *Store) Ensure(ctx context.Context, id uuid.UUID, userID, persona string) (*Conversation, error) {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO conversations (id, user_id, persona)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (id) DO NOTHING`, id, userID, persona)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create conversation: %w", err)
+	}
+	if userID == "" {
+		created, err := res.RowsAffected()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't create conversation: %w", err)
+		}
+		if created == 0 {
+			return nil, ErrNotFound
+		}
+	}
+
+	c := &Conversation{}
+	err = s.db.QueryRowContext(ctx, `
+		SELECT id, user_id, persona, created_at
+		FROM conversations
+		WHERE id = $1`, id).Scan(&c.ID, &c.UserID, &c.Persona, &c.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get conversation: %w", err)
+	}
+	if c.UserID != userID {
+		return nil, ErrNotFound
+	}
+
+	return c, nil
+}
+
+func (s *Store) AddMessage(ctx context.Context, m *Message) error {
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	if m.Source == "" {
+		m.Source = SourceText
+	}
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO messages (id, conversation_id, parent_id, role, content, persona, model, experiment, variant, source, session_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING created_at`,
+		m.ID, m.ConversationID, m.ParentID, m.Role, m.Content, m.Persona, m.Model, m.Experiment, m.Variant,
+		m.Source, m.SessionID,
+	).Scan(&m.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("couldn't insert message: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateMessageContent replaces the content of a message, e.g. a voice
+// transcript cut to what the user heard
+func (s *Store) UpdateMessageContent(ctx context.Context, id uuid.UUID, content string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE messages SET content = $2 WHERE id = $1`, id, content)
+	if err != nil {
+		return fmt.Errorf("couldn't update message: %w", err)
+	}
+
+	return nil
+}
+
+// GetMessage returns the message only if it belongs to one of the
+// user's conversations.
+func (s *Store) GetMessage(ctx context.Context, id uuid.UUID, userID string) (*Message, error) {
+	m := &Message{}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT m.id, m.conversation_id, m.parent_id, m.role, m.content, m.persona, m.model,
+			m.experiment, m.variant, m.source, m.session_id, m.created_at
+		FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE m.id = $1 AND c.user_id = $2`, id, userID,
+	).Scan(&m.ID, &m.ConversationID, &m.ParentID, &m.Role, &m.Content, &m.Persona, &m.Model,
+		&m.Experiment, &m.Variant, &m.Source, &m.SessionID, &m.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get message: %w", err)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,129 @@
+package experiments
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"proomptmachinee/internal/config"
+	"proomptmachinee/internal/services/personas"
+)
+
+type Variant struct {
+	Name         string
+	Weight       int
+	Instructions string
+	Model        string
+}
+
+type Experiment struct {
+	Name     string
+	Persona  string
+	Variants []*Variant
+	total    int
+}
+
+// Assignment is the variant a subject sees in an experiment, Persona has
+// the variant overrides applied
+type Assignment struct {
+	Experiment string
+	Variant    string
+	Persona    *personas.Persona
+}
+
+type Experiments struct {
+	byName    map[string]*Experiment
+	byPersona map[string]*Experiment
+}
+
+func New(cfg []config.ExperimentConfig) (*Experiments, error) {
+	e := &Experiments{
+		byName:    make(map[string]*Experiment),
+		byPersona: make(map[string]*Experiment),
+	}
+
+	for _, ec := range cfg {
+		if ec.Name == "" {
+			return nil, fmt.Errorf("experiment without a name")
+		}
+		if _, ok := e.byName[ec.Name]; ok {
+			return nil, fmt.Errorf("experiment %s is defined twice", ec.Name)
+		}
+		exp := &Experiment{Name: ec.Name, Persona: ec.Persona}
+		names := make(map[string]bool)
+		for _, vc := range ec.Variants {
+			if vc.Name == "" || names[vc.Name] {
+				return nil, fmt.Errorf("experiment %s has an unnamed or duplicate variant", ec.Name)
+			}
+			if vc.Weight < 0 {
+				return nil, fmt.Errorf("experiment %s variant %s has a negative weight", ec.Name, vc.Name)
+			}
+			names[vc.Name] = true
+			exp.Variants = append(exp.Variants, &Variant{
+				Name:         vc.Name,
+				Weight:       vc.Weight,
+				Instructions: vc.Instructions,
+				Model:        vc.Model,
+			})
+			exp.total += vc.Weight
+		}
+		e.byName[exp.Name] = exp
+
+		if !ec.Enabled {
+			continue
+		}
+		if exp.total == 0 {
+			return nil, fmt.Errorf("enabled experiment %s has no weighted variants", ec.Name)
+		}
+		if _, ok := e.byPersona[ec.Persona]; !ok {
+			e.byPersona[ec.Persona] = exp
+		}
+	}
+
+	return e, nil
+}
+
+func (e *Experiments) Get(name string) (*Experiment, bool) {
+	exp, ok := e.byName[name]
+	return exp, ok
+}
+
+// Assign returns the persona the subject should get. The variant is
+// picked by hashing the subject with the experiment name, so a user
+// always lands in the same variant and different experiments split
+// users independently. Without an enabled experiment for the persona
+// the persona is returned as is with empty experiment and variant.
+func (e *Experiments) Assign(persona *personas.Persona, subject string) *Assignment {
+	exp, ok := e.byPersona[persona.Name]
+	if !ok || subject == "" {
+		return &Assignment{Persona: persona}
+	}
+
+	variant := exp.pick(subject)
+	p := *persona
+	if variant.Instructions != "" {
+		p.Instructions = variant.Instructions
+	}
+	if variant.Model != "" {
+		p.Model = variant.Model
+	}
+
+	return &Assignment{
+		Experiment: exp.Name,
+		Variant:    variant.Name,
+		Persona:    &p,
+	}
+}
+
+func (exp *Experiment) pick(subject string) *Variant {
+	sum := sha256.Sum256([]byte(exp.Name + ":" + subject))
+	bucket := int(binary.BigEndian.Uint64(sum[:8]) % uint64(exp.total))
+	for _, v := range exp.Variants {
+		if bucket < v.Weight {
+			return v
+		}
+		bucket -= v.Weight
+	}
+
+	// unreachable as long as total is the sum of the weights
+	return exp.Variants[len(exp.Variants)-1]
+}
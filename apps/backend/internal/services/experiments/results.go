@@ -0,0 +1,80 @@
+package experiments
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// VariantResult aggregates the answers given in one variant along with
+// their feedback and usage
+type VariantResult struct {
+	Variant       string  `json:"variant"`
+	Conversations int     `json:"conversations"`
+	Answers       int     `json:"answers"`
+	Rated         int     `json:"rated"`
+	Up            int     `json:"up"`
+	Down          int     `json:"down"`
+	UpRate        float64 `json:"up_rate"`
+	InputTokens   int64   `json:"input_tokens"`
+	OutputTokens  int64   `json:"output_tokens"`
+	CostUSD       float64 `json:"cost_usd"`
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Results returns per variant numbers of the experiment for answers
+// given in [from, to), zero times leave the range open
+func (s *Store) Results(ctx context.Context, experiment string, from, to time.Time) ([]*VariantResult, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT m.variant,
+			COUNT(DISTINCT m.conversation_id),
+			COUNT(DISTINCT m.id),
+			COUNT(f.message_id),
+			COUNT(f.message_id) FILTER (WHERE f.rating = 1),
+			COUNT(f.message_id) FILTER (WHERE f.rating = -1),
+			COALESCE(SUM(u.input_tokens), 0),
+			COALESCE(SUM(u.output_tokens), 0),
+			COALESCE(SUM(u.cost_usd), 0)::float8
+		FROM messages m
+		LEFT JOIN message_feedback f ON f.message_id = m.id
+		LEFT JOIN usage_ledger u ON u.message_id = m.id
+		WHERE m.experiment = $1
+			AND m.role = 'assistant'
+			AND ($2::timestamptz IS NULL OR m.created_at >= $2)
+			AND ($3::timestamptz IS NULL OR m.created_at < $3)
+		GROUP BY m.variant
+		ORDER BY m.variant`,
+		experiment, nullTime(from), nullTime(to))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't query experiment results: %w", err)
+	}
+	defer rows.Close()
+
+	var results []*VariantResult
+	for rows.Next() {
+		r := &VariantResult{}
+		err := rows.Scan(&r.Variant, &r.Conversations, &r.Answers, &r.Rated, &r.Up, &r.Down,
+			&r.InputTokens, &r.OutputTokens, &r.CostUSD)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't scan experiment results: %w", err)
+		}
+		if r.Rated > 0 {
+			r.UpRate = float64(r.Up) / float64(r.Rated)
+		}
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,169 @@
+package feedback
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	RatingUp   = 1
+	RatingDown = -1
+)
+
+// Categories a user can file feedback under, an empty category is
+// always allowed.
+var Categories = map[string]bool{
+	"accuracy":   true,
+	"scripture":  true,
+	"language":   true,
+	"tone":       true,
+	"off_topic":  true,
+	"harmful":    true,
+	"incomplete": true,
+	"other":      true,
+}
+
+const maxCommentLength = 2000
+
+var (
+	ErrInvalidRating   = errors.New("rating must be up or down")
+	ErrInvalidCategory = errors.New("unknown feedback category")
+	ErrCommentTooLong  = errors.New("comment is too long")
+)
+
+type Feedback struct {
+	MessageID uuid.UUID
+	UserID    string
+	Rating    int
+	Category  string
+	Comment   string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (f *Feedback) Validate() error {
+	if f.Rating != RatingUp && f.Rating != RatingDown {
+		return ErrInvalidRating
+	}
+	if f.Category != "" && !Categories[f.Category] {
+		return ErrInvalidCategory
+	}
+	if len(f.Comment) > maxCommentLength {
+		return ErrCommentTooLong
+	}
+
+	return nil
+}
+
+// ExportFilter narrows the exported pairs, zero values don't filter.
+type ExportFilter struct {
+	Persona string
+	Model   string
+	From    time.Time
+	To      time.Time
+	// Rating filters on RatingUp or RatingDown when not zero
+	Rating int
+}
+
+// Pair is a rated prompt/response pair. It is serialized as one JSONL
+// line using the chat `messages` layout, so the export can be fed into
+// evaluation or fine-tuning tooling as is.
+type Pair struct {
+	Messages   []PairMessage `json:"messages"`
+	MessageID  uuid.UUID     `json:"message_id"`
+	Persona    string        `json:"persona"`
+	Model      string        `json:"model"`
+	Experiment string        `json:"experiment,omitempty"`
+	Variant    string        `json:"variant,omitempty"`
+	Source     string        `json:"source"`
+	Rating     int           `json:"rating"`
+	Category   string        `json:"category,omitempty"`
+	Comment    string        `json:"comment,omitempty"`
+	RatedAt    time.Time     `json:"rated_at"`
+}
+
+type PairMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Save stores the feedback, a user rating the same message again
+// replaces their previous feedback.
+func (s *Store) Save(ctx context.Context, f *Feedback) error {
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO message_feedback (message_id, user_id, rating, category, comment)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (message_id, user_id) DO UPDATE
+		SET rating = EXCLUDED.rating,
+			category = EXCLUDED.category,
+			comment = EXCLUDED.comment,
+			updated_at = now()
+		RETURNING created_at, updated_at`,
+		f.MessageID, f.UserID, f.Rating, f.Category, f.Comment,
+	).Scan(&f.CreatedAt, &f.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("couldn't save feedback: %w", err)
+	}
+
+	return nil
+}
+
+// Export calls fn for every rated assistant message that has the user
+// prompt it answered, oldest feedback first.
+func (s *Store) Export(ctx context.Context, filter ExportFilter, fn func(*Pair) error) error {
+	query := `
+		SELECT p.content, m.content, m.id, m.persona, m.model, m.experiment, m.variant, m.source,
+			f.rating, f.category, f.comment, f.updated_at
+		FROM message_feedback f
+		JOIN messages m ON m.id = f.message_id
+		JOIN messages p ON p.id = m.parent_id
+		WHERE ($1 = '' OR m.persona = $1)
+			AND ($2 = '' OR m.model = $2)
+			AND ($3::timestamptz IS NULL OR f.updated_at >= $3)
+			AND ($4::timestamptz IS NULL OR f.updated_at < $4)
+			AND ($5 = 0 OR f.rating = $5)
+		ORDER BY f.updated_at, m.id`
+
+	rows, err := s.db.QueryContext(ctx, query,
+		filter.Persona, filter.Model, nullTime(filter.From), nullTime(filter.To), filter.Rating)
+	if err != nil {
+		return fmt.Errorf("couldn't query feedback: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var prompt, response string
+		p := &Pair{}
+		err := rows.Scan(&prompt, &response, &p.MessageID, &p.Persona, &p.Model, &p.Experiment, &p.Variant, &p.Source,
+			&p.Rating, &p.Category, &p.Comment, &p.RatedAt)
+		if err != nil {
+			return fmt.Errorf("couldn't scan feedback: %w", err)
+		}
+		p.Messages = []PairMessage{
+			{Role: "user", Content: prompt},
+			{Role: "assistant", Content: response},
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
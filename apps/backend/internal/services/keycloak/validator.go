@@ -12,6 +12,30 @@ import (
 	"time"
 )
 
+// Claims are the token claims the api cares about
+type Claims struct {
+	UserID    string
+	Roles     []string
+	ExpiresAt time.Time
+}
+
+func (c *Claims) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+type keycloakClaims struct {
+	jwt.Claims
+	RealmAccess struct {
+		Roles []string `json:"roles"`
+	} `json:"realm_access"`
+}
+
 type Validator struct {
 	Oauth2IssuerUrl string
 }
@@ -46,44 +70,48 @@ func (v *Validator) getKeycloakPublicKey(kid string) (*rsa.PublicKey, error) {
 	return nil, fmt.Errorf("keycloak public key not found")
 }
 
-func (v *Validator) ValidateTokenSignature(token string) (string, error) {
+func (v *Validator) ValidateTokenSignature(token string) (*Claims, error) {
 	kid, err := extractJwtKid(token)
 	if err != nil {
-		return "", fmt.Errorf("coudln't extract JWT key id from token: %w", err)
+		return nil, fmt.Errorf("coudln't extract JWT key id from token: %w", err)
 	}
 
 	kcPubKey, err := v.getKeycloakPublicKey(kid)
 	if err != nil {
-		return "", fmt.Errorf("couldn't get keycloak public key: %w", err)
+		return nil, fmt.Errorf("couldn't get keycloak public key: %w", err)
 	}
 
 	parsedToken, err := jwt.ParseSigned(token, []jose.SignatureAlgorithm{jose.RS256})
 	if err != nil {
-		return "", fmt.Errorf("couldn't parse token: %w", err)
+		return nil, fmt.Errorf("couldn't parse token: %w", err)
 	}
 
-	var claims jwt.Claims
+	var claims keycloakClaims
 	err = parsedToken.Claims(kcPubKey, &claims)
 	if err != nil {
-		return "", fmt.Errorf("couldn't parse token claims: %w", err)
+		return nil, fmt.Errorf("couldn't parse token claims: %w", err)
 	}
 
 	if claims.Expiry == nil {
-		return "", fmt.Errorf("token claims has no expiry time")
+		return nil, fmt.Errorf("token claims has no expiry time")
 	}
 
 	// TODO wrap claims with internal type, also check `aud` and
 	// `iss` with the payload to ensure. This is just a quick and
 	// dirty solution for basic token checking
 	if exp := int64(*claims.Expiry); exp < time.Now().Unix() {
-		return "", fmt.Errorf("token expired")
+		return nil, fmt.Errorf("token expired")
 	}
 
 	if claims.Issuer != v.Oauth2IssuerUrl {
-		return "", fmt.Errorf("token issuer is not %s", v.Oauth2IssuerUrl)
+		return nil, fmt.Errorf("token issuer is not %s", v.Oauth2IssuerUrl)
 	}
 
-	return claims.ID, nil
+	return &Claims{
+		UserID:    claims.Subject,
+		Roles:     claims.RealmAccess.Roles,
+		ExpiresAt: claims.Expiry.Time(),
+	}, nil
 }
 
 func decodeBase64string(input string) ([]byte, error) {
@@ -111,5 +139,10 @@ func extractJwtKid(token string) (string, error) {
 		return "", fmt.Errorf("error decoding header: %s", err)
 	}
 
-	return header["kid"].(string), nil
+	kid, ok := header["kid"].(string)
+	if !ok {
+		return "", fmt.Errorf("header has no key id")
+	}
+
+	return kid, nil
 }
@@ -3,12 +3,14 @@ package completions
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strings"
 )
 
 const (
@@ -23,15 +25,20 @@ type Client struct {
 }
 
 type StreamResponse struct {
-	resp *http.Response
+	resp  *http.Response
+	model string
 }
 
-func (s *StreamResponse) Receive(w http.ResponseWriter) error {
+// Receive streams the completion to the client and returns the full
+// content that was streamed along with the usage of the request. The
+// metadata is sent to the client with the final status message.
+func (s *StreamResponse) Receive(w http.ResponseWriter, metadata map[string]string) (*Completion, error) {
 	defer s.Close()
+	completion := &Completion{Model: s.model}
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "streaming unsupported!", http.StatusInternalServerError)
-		return errors.New("streaming unsupported")
+		return completion, errors.New("streaming unsupported")
 	}
 
 	w.Header().Set("Content-Type", "text/event-stream")
@@ -40,9 +47,13 @@ func (s *StreamResponse) Receive(w http.ResponseWriter) error {
 
 	if s.resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(s.resp.Body)
-		return fmt.Errorf("error: %s\nBody: %s", s.resp.Status, string(bodyBytes))
+		return completion, fmt.Errorf("error: %s\nBody: %s", s.resp.Status, string(bodyBytes))
 	}
 
+	var content strings.Builder
+	defer func() {
+		completion.Content = content.String()
+	}()
 	// Read the streaming response line by line
 	reader := bufio.NewReader(s.resp.Body)
 	for {
@@ -51,7 +62,7 @@ func (s *StreamResponse) Receive(w http.ResponseWriter) error {
 			if err == io.EOF {
 				break
 			}
-			return err
+			return completion, err
 		}
 
 		// Skip empty lines
@@ -72,14 +83,20 @@ func (s *StreamResponse) Receive(w http.ResponseWriter) error {
 				log.Fatalf("Error parsing JSON: %s : %v", string(line), err)
 			}
 
+			// sent on the last chunk because of include_usage
+			if completionResp.Usage != nil {
+				completion.Usage = completionResp.Usage.Usage()
+			}
+
 			if len(completionResp.Choices) > 0 {
-				content := completionResp.Choices[0].Delta.Content
-				contentResp := &ContentResponse{Content: content}
+				delta := completionResp.Choices[0].Delta.Content
+				content.WriteString(delta)
+				contentResp := &ContentResponse{Content: delta}
 				jsonChunk, _ := json.Marshal(contentResp)
 
 				_, err := w.Write([]byte("data: " + string(jsonChunk) + "\n"))
 				if err != nil {
-					return err
+					return completion, err
 				}
 
 				flusher.Flush()
@@ -89,22 +106,21 @@ func (s *StreamResponse) Receive(w http.ResponseWriter) error {
 	}
 	_, doneErr := w.Write([]byte("data: [DONE]\n\n"))
 	if doneErr != nil {
-		return doneErr
+		return completion, doneErr
 	}
 	flusher.Flush()
-	//https://cookbook.openai.com/examples/how_to_stream_completions#4-how-to-get-token-usage-data-for-streamed-chat-completion-response
-	// you can use stream_options={"include_usage": true} to get the usage metadata
-	// the `usage` field on the last chunk contains the usage statistics for the entire request
-	// it can still be a cool excercise to try to calculate the tokens yourself
 	status := &ContentResponse{
 		Metadata: map[string]string{
 			"requests_remaining": "zero",
 		},
 	}
+	for k, v := range metadata {
+		status.Metadata[k] = v
+	}
 	statusResp, _ := json.Marshal(status)
 	w.Write([]byte("data: " + string(statusResp) + "\n\n"))
 	flusher.Flush()
-	return nil
+	return completion, nil
 }
 
 func (s *StreamResponse) Close() error {
@@ -120,28 +136,43 @@ func NewCompletionsClient(key string, client *http.Client, model string) *Client
 	}
 }
 
+// Model returns the model used when a prompt doesn't ask for one
+func (c *Client) Model() string {
+	return c.model
+}
+
 // TODO think of a mechanism that will allow previous prompts for user to be
 // persisted if they exist
-func (c *Client) SendPrompt(prompt string) (*StreamResponse, error) {
-	msg := &CompletionRequestMessage{
+func (c *Client) SendPrompt(ctx context.Context, prompt, instructions, model string) (*StreamResponse, error) {
+	messages := make([]*CompletionRequestMessage, 0, 2)
+	if instructions != "" {
+		messages = append(messages, &CompletionRequestMessage{
+			Role:    CompletionRequestMessageRoleSystem,
+			Content: instructions,
+		})
+	}
+	messages = append(messages, &CompletionRequestMessage{
 		Role:    CompletionRequestMessageRoleUser,
 		Content: prompt,
+	})
+	if model == "" {
+		model = c.model
 	}
 
-	// TODO add stream_options={"include_usage": true} to the request
 	// TODO add more fields like temperature, max_tokens, etc. that can
 	// passed from the frontend
 	completionReq := &CompletionRequest{
-		Model:    c.model,
-		Messages: []*CompletionRequestMessage{msg},
-		Stream:   CompletionRequestStreamEnabled,
+		Model:         model,
+		Messages:      messages,
+		Stream:        CompletionRequestStreamEnabled,
+		StreamOptions: &StreamOptions{IncludeUsage: true},
 	}
 
 	jsonData, err := json.Marshal(completionReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal json: %w", err)
 	}
-	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -154,5 +185,5 @@ func (c *Client) SendPrompt(prompt string) (*StreamResponse, error) {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 
-	return &StreamResponse{resp: resp}, nil
+	return &StreamResponse{resp: resp, model: model}, nil
 }
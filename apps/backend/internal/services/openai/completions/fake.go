@@ -0,0 +1,73 @@
+package completions
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// FakeProvider answers prompts from a fixed table instead of calling
+// OpenAI, it is meant for CI and local runs.
+type FakeProvider struct {
+	mu sync.Mutex
+	// Responses maps the last user prompt to its answer
+	Responses map[string]string
+	// Default is returned for unknown prompts, when it's empty unknown
+	// prompts are an error
+	Default string
+	// Requests records every request the fake received
+	Requests []*CompletionRequest
+}
+
+func NewFakeProvider(responses map[string]string) *FakeProvider {
+	if responses == nil {
+		responses = make(map[string]string)
+	}
+	return &FakeProvider{Responses: responses}
+}
+
+func (f *FakeProvider) Complete(ctx context.Context, req *CompletionRequest) (*Completion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Requests = append(f.Requests, req)
+
+	var prompt string
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == CompletionRequestMessageRoleUser {
+			prompt = req.Messages[i].Content
+			break
+		}
+	}
+
+	content, ok := f.Responses[prompt]
+	if !ok {
+		if f.Default == "" {
+			return nil, fmt.Errorf("fake provider has no response for prompt %q", prompt)
+		}
+		content = f.Default
+	}
+
+	return &Completion{Content: content, Model: req.Model}, nil
+}
+
+// Stream answers like Complete and hands the answer over word by word
+func (f *FakeProvider) Stream(ctx context.Context, req *CompletionRequest, onDelta func(delta string) error) (*Completion, error) {
+	completion, err := f.Complete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	for _, word := range strings.SplitAfter(completion.Content, " ") {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := onDelta(word); err != nil {
+			return nil, err
+		}
+	}
+
+	return completion, nil
+}
@@ -1,19 +1,50 @@
 package completions
 
+import "proomptmachinee/internal/services/openai"
+
 type CompletionRequest struct {
 	Model               string                      `json:"model"`
 	Messages            []*CompletionRequestMessage `json:"messages"`
 	Stream              bool                        `json:"stream"`
+	StreamOptions       *StreamOptions              `json:"stream_options,omitempty"`
 	MaxCompletionTokens int                         `json:"max_completion_tokens,omitempty"`
 }
 
+type StreamOptions struct {
+	// IncludeUsage adds a last chunk with no choices and the usage
+	// statistics of the whole request
+	IncludeUsage bool `json:"include_usage"`
+}
+
 type CompletionRequestMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
 }
 
 type CompletionResponse struct {
-	Choices []Choice `json:"choices"`
+	Model   string           `json:"model"`
+	Choices []Choice         `json:"choices"`
+	Usage   *CompletionUsage `json:"usage"`
+}
+
+type CompletionUsage struct {
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	PromptTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+}
+
+func (u *CompletionUsage) Usage() openai.Usage {
+	if u == nil {
+		return openai.Usage{}
+	}
+
+	return openai.Usage{
+		InputTokens:       u.PromptTokens,
+		CachedInputTokens: u.PromptTokensDetails.CachedTokens,
+		OutputTokens:      u.CompletionTokens,
+	}
 }
 
 type Delta struct {
@@ -21,7 +52,15 @@ type Delta struct {
 }
 
 type Choice struct {
-	Delta Delta `json:"delta"`
+	Delta   Delta                    `json:"delta"`
+	Message CompletionRequestMessage `json:"message"`
+}
+
+// Completion is the result of a completion
+type Completion struct {
+	Content string
+	Model   string
+	Usage   openai.Usage
 }
 
 type ContentResponse struct {
@@ -31,6 +70,7 @@ type ContentResponse struct {
 
 const (
 	CompletionRequestStreamEnabled     = true
+	CompletionRequestStreamDisabled    = false
 	CompletionRequestMessageRoleUser   = "user"
 	CompletionRequestMessageRoleSystem = "system"
 )
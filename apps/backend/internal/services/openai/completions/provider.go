@@ -0,0 +1,141 @@
+package completions
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Provider generates a single, non streamed completion. It is what
+// tooling like the evaluation harness talks to, so it can be swapped
+// with a fake.
+type Provider interface {
+	Complete(ctx context.Context, req *CompletionRequest) (*Completion, error)
+}
+
+// Streamer generates a completion and hands its content over as it is
+// generated, e.g. to voice it sentence by sentence. It can be swapped with
+// a fake like Provider.
+type Streamer interface {
+	Stream(ctx context.Context, req *CompletionRequest, onDelta func(delta string) error) (*Completion, error)
+}
+
+func (c *Client) Complete(ctx context.Context, req *CompletionRequest) (*Completion, error) {
+	completionReq := *req
+	completionReq.Stream = CompletionRequestStreamDisabled
+	completionReq.StreamOptions = nil
+	if completionReq.Model == "" {
+		completionReq.Model = c.model
+	}
+
+	jsonData, err := json.Marshal(&completionReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal json: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.key))
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("error: %s\nBody: %s", resp.Status, string(bodyBytes))
+	}
+
+	var completionResp CompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&completionResp); err != nil {
+		return nil, fmt.Errorf("couldn't decode completion: %w", err)
+	}
+	if len(completionResp.Choices) == 0 {
+		return nil, errors.New("completion has no choices")
+	}
+
+	return &Completion{
+		Content: completionResp.Choices[0].Message.Content,
+		Model:   completionReq.Model,
+		Usage:   completionResp.Usage.Usage(),
+	}, nil
+}
+
+// Stream sends a streamed completion request and calls onDelta with
+// every chunk of content, an error returned by onDelta stops the stream
+func (c *Client) Stream(ctx context.Context, req *CompletionRequest, onDelta func(delta string) error) (*Completion, error) {
+	completionReq := *req
+	completionReq.Stream = CompletionRequestStreamEnabled
+	completionReq.StreamOptions = &StreamOptions{IncludeUsage: true}
+	if completionReq.Model == "" {
+		completionReq.Model = c.model
+	}
+
+	jsonData, err := json.Marshal(&completionReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal json: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.key))
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("error: %s\nBody: %s", resp.Status, string(bodyBytes))
+	}
+
+	completion := &Completion{Model: completionReq.Model}
+	var content strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if strings.TrimSpace(line) == "[DONE]" {
+			break
+		}
+
+		var chunk CompletionResponse
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			return nil, fmt.Errorf("couldn't decode completion chunk: %w", err)
+		}
+		// sent on the last chunk because of include_usage
+		if chunk.Usage != nil {
+			completion.Usage = chunk.Usage.Usage()
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+		delta := chunk.Choices[0].Delta.Content
+		content.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("couldn't read completion stream: %w", err)
+	}
+	completion.Content = content.String()
+
+	return completion, nil
+}
@@ -1,6 +1,90 @@
 package openai
 
 const (
+	Gpt4o                = "gpt-4o"
 	Gpt4oMini            = "gpt-4o-mini"
 	Gpt40RealtimePreview = "gpt-4o-realtime-preview-2024-10-01"
+	Whisper1             = "whisper-1"
+	Tts1                 = "tts-1"
+	Tts1HD               = "tts-1-hd"
 )
+
+// Pricing is in USD per million tokens, transcription and speech models
+// are priced by the minute and the character instead
+type Pricing struct {
+	Input            float64
+	CachedInput      float64
+	Output           float64
+	AudioInput       float64
+	CachedAudioInput float64
+	AudioOutput      float64
+	// TranscriptionMinute is per minute of transcribed audio
+	TranscriptionMinute float64
+	// SpeechCharacters is per million characters voiced
+	SpeechCharacters float64
+}
+
+// Prices of the models we use, keep in sync with https://openai.com/api/pricing
+var Prices = map[string]Pricing{
+	Gpt4o: {
+		Input:       2.50,
+		CachedInput: 1.25,
+		Output:      10.00,
+	},
+	Gpt4oMini: {
+		Input:       0.15,
+		CachedInput: 0.075,
+		Output:      0.60,
+	},
+	Gpt40RealtimePreview: {
+		Input:            5.00,
+		CachedInput:      2.50,
+		Output:           20.00,
+		AudioInput:       100.00,
+		CachedAudioInput: 20.00,
+		AudioOutput:      200.00,
+	},
+	Whisper1: {
+		TranscriptionMinute: 0.006,
+	},
+	Tts1: {
+		SpeechCharacters: 15.00,
+	},
+	Tts1HD: {
+		SpeechCharacters: 30.00,
+	},
+}
+
+// Usage is the token usage of a single response. Cached tokens are a
+// part of the input tokens of the same kind, like OpenAI reports them.
+type Usage struct {
+	InputTokens            int
+	CachedInputTokens      int
+	OutputTokens           int
+	AudioInputTokens       int
+	CachedAudioInputTokens int
+	AudioOutputTokens      int
+	// TranscriptionSeconds and SpeechCharacters are what transcription
+	// and speech models are billed by
+	TranscriptionSeconds float64
+	SpeechCharacters     int
+}
+
+// Cost returns the cost of the usage in USD, and false if the model
+// isn't in the price table
+func Cost(model string, u Usage) (float64, bool) {
+	p, ok := Prices[model]
+	if !ok {
+		return 0, false
+	}
+
+	cost := float64(u.InputTokens-u.CachedInputTokens)*p.Input +
+		float64(u.CachedInputTokens)*p.CachedInput +
+		float64(u.OutputTokens)*p.Output +
+		float64(u.AudioInputTokens-u.CachedAudioInputTokens)*p.AudioInput +
+		float64(u.CachedAudioInputTokens)*p.CachedAudioInput +
+		float64(u.AudioOutputTokens)*p.AudioOutput +
+		float64(u.SpeechCharacters)*p.SpeechCharacters
+
+	return cost/1_000_000 + u.TranscriptionSeconds/60*p.TranscriptionMinute, true
+}
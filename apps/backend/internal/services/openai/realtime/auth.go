@@ -0,0 +1,33 @@
+package realtime
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	// RealtimeSubprotocol is the subprotocol the proxy speaks, browsers
+	// offer it next to the bearer one so the upgrade has one to accept
+	RealtimeSubprotocol = "realtime"
+	// BearerSubprotocolPrefix carries a token in Sec-WebSocket-Protocol,
+	// browsers can't set the Authorization header on upgrades
+	BearerSubprotocolPrefix = "bearer."
+
+	// CloseTokenExpired is the close code of sessions whose caller's
+	// token expired
+	CloseTokenExpired = 4003
+)
+
+var ErrUnauthenticated = errors.New("realtime session needs an authenticated caller")
+
+// BearerFromSubprotocols returns the token of a `bearer.<token>`
+// subprotocol, empty when none was offered
+func BearerFromSubprotocols(protocols []string) string {
+	for _, p := range protocols {
+		if token, ok := strings.CutPrefix(p, BearerSubprotocolPrefix); ok {
+			return token
+		}
+	}
+
+	return ""
+}
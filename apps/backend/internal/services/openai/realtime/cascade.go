@@ -0,0 +1,683 @@
+package realtime
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"proomptmachinee/internal/audio"
+	"proomptmachinee/internal/services/openai"
+	"proomptmachinee/internal/services/openai/completions"
+	"proomptmachinee/internal/services/openai/speech"
+	"proomptmachinee/internal/services/usage"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// ModelCascade is recorded as the model of sessions served by the
+	// fallback
+	ModelCascade = "cascade"
+
+	cascadeBufferSize = 64
+	cascadeJobsSize   = 8
+	// the shortest input the fallback transcribes, like upstream
+	cascadeMinCommitMs = 100
+	// the longest input buffer, older audio is dropped
+	cascadeMaxBufferMs = 5 * 60 * 1000
+	// the answer is voiced in sentences of at least this many bytes
+	cascadeMinSentence = 20
+	// audio deltas are sent in chunks of this length
+	cascadeAudioChunkMs = 200
+)
+
+var ErrCascadeClosed = errors.New("fallback closed")
+
+// Fallback is the cascaded voice pipeline sessions fall back to when the
+// realtime model can't be reached. The input audio is transcribed, the
+// persona answers through chat completions and the answer is voiced,
+// behind the same events the realtime API sends.
+type Fallback struct {
+	Transcriber speech.Transcriber
+	Synthesizer speech.Synthesizer
+	Chat        completions.Streamer
+	// ChatModel is the completions model, the streamer's default when
+	// empty
+	ChatModel string
+	// TranscriptionModel and SpeechModel are what the transcriber and
+	// synthesizer run, they price their usage in the ledger
+	TranscriptionModel string
+	SpeechModel        string
+}
+
+// UseFallback makes sessions fall back to the cascaded pipeline when
+// OpenAI realtime can't be reached, nil turns the fallback off
+func (c *Client) UseFallback(f *Fallback) {
+	c.fallback = f
+}
+
+// cascadeJob is a turn of the conversation, transcribing the input and
+// answering it are done in order on a worker
+type cascadeJob struct {
+	// audio is the committed input, nil for responses alone
+	audio   []int16
+	itemID  string
+	respond bool
+	// response overrides the session config
+	response *ResponseConfig
+}
+
+type cascadeMessage struct {
+	itemID  string
+	role    string
+	content string
+}
+
+// cascade stands in for the OpenAI connection of a session. The proxy's
+// upstream writer hands it client events, the upstream reader takes the
+// server events it emits.
+type cascade struct {
+	fallback  *Fallback
+	ledger    Ledger
+	userID    string
+	sessionID string
+	vadConfig audio.VADConfig
+
+	out       chan []byte
+	jobs      chan *cascadeJob
+	closed    chan struct{}
+	closeOnce sync.Once
+	// ctx is cancelled once the cascade is closed, it bounds the calls
+	// to the providers
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// buffer and vad are only touched by the proxy's upstream writer
+	buffer []int16
+	vad    *audio.VAD
+
+	mu             sync.Mutex
+	session        Session
+	history        []*cascadeMessage
+	cancelResponse context.CancelFunc
+}
+
+func (f *Fallback) open(c *Client, userID, sessionID string) *cascade {
+	ctx, cancel := context.WithCancel(context.Background())
+	cc := &cascade{
+		fallback:  f,
+		ledger:    c.ledger,
+		userID:    userID,
+		sessionID: sessionID,
+		vadConfig: c.allowlist.vad,
+		out:       make(chan []byte, cascadeBufferSize),
+		jobs:      make(chan *cascadeJob, cascadeJobsSize),
+		closed:    make(chan struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	cc.emit(&SessionCreatedEvent{Event: Event{Type: EventTypeSessionCreated}, Session: &Session{}})
+	go cc.work()
+
+	return cc
+}
+
+func (c *cascade) ReadMessage() (int, []byte, error) {
+	select {
+	case data := <-c.out:
+		return websocket.TextMessage, data, nil
+	case <-c.closed:
+		return 0, nil, &websocket.CloseError{Code: websocket.CloseNormalClosure, Text: ErrCascadeClosed.Error()}
+	}
+}
+
+// WriteMessage handles a client event, audio is buffered and turns are
+// queued for the worker so the proxy is never held up by the providers
+func (c *cascade) WriteMessage(messageType int, data []byte) error {
+	select {
+	case <-c.closed:
+		return ErrCascadeClosed
+	default:
+	}
+	event, err := ParseClientEvent(data)
+	if err != nil {
+		c.fail("invalid_request_error", err.Error(), "")
+		return nil
+	}
+
+	switch e := event.(type) {
+	case *SessionUpdate:
+		c.update(data)
+	case *InputAudioBufferAppendEvent:
+		c.append(e)
+	case *InputAudioBufferCommitEvent:
+		c.commit(e.EventID, false)
+	case *InputAudioBufferClearEvent:
+		c.buffer = nil
+		c.vad = c.newVAD()
+		c.emit(&InputAudioBufferClearedEvent{Event: Event{Type: EventTypeInputAudioBufferCleared}})
+	case *ConversationItemCreateEvent:
+		c.createItem(e)
+	case *ConversationItemDeleteEvent:
+		c.deleteItem(e)
+	case *ConversationItemTruncateEvent:
+		// the answer is kept as text, there is no audio to cut
+		c.emit(&ConversationItemTruncatedEvent{
+			Event:        Event{Type: EventTypeConversationItemTruncated},
+			ItemID:       e.ItemID,
+			ContentIndex: e.ContentIndex,
+			AudioEndMs:   e.AudioEndMs,
+		})
+	case *ResponseCreateEvent:
+		c.queue(&cascadeJob{respond: true, response: e.Response})
+	case *ResponseCancelEvent:
+		c.mu.Lock()
+		if c.cancelResponse != nil {
+			c.cancelResponse()
+		}
+		c.mu.Unlock()
+	default:
+		c.fail("invalid_request_error", fmt.Sprintf("%s isn't supported by the fallback", event.EventType()), "")
+	}
+
+	return nil
+}
+
+func (c *cascade) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	return nil
+}
+
+func (c *cascade) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *cascade) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.cancel()
+	})
+
+	return nil
+}
+
+// update applies a session update, fields it leaves out are kept
+func (c *cascade) update(data []byte) {
+	var update struct {
+		Session map[string]json.RawMessage `json:"session"`
+	}
+	var session struct {
+		Session *Session `json:"session"`
+	}
+	if json.Unmarshal(data, &update) != nil || json.Unmarshal(data, &session) != nil || session.Session == nil {
+		c.fail("invalid_request_error", "invalid session update", "session")
+		return
+	}
+
+	c.mu.Lock()
+	s := session.Session
+	if s.Modalities != nil {
+		c.session.Modalities = s.Modalities
+	}
+	if s.Instructions != "" {
+		c.session.Instructions = s.Instructions
+	}
+	if s.Voice != "" {
+		c.session.Voice = s.Voice
+	}
+	if _, ok := update.Session["input_audio_transcription"]; ok {
+		c.session.InputAudioTranscription = s.InputAudioTranscription
+	}
+	if _, ok := update.Session["turn_detection"]; ok {
+		c.session.TurnDetection = s.TurnDetection
+	}
+	updated := c.session
+	c.mu.Unlock()
+
+	c.vad = c.newVAD()
+	c.emit(&SessionUpdatedEvent{Event: Event{Type: EventTypeSessionUpdated}, Session: &updated})
+}
+
+// newVAD detects the turns for server_vad, nil when the client commits
+func (c *cascade) newVAD() *audio.VAD {
+	c.mu.Lock()
+	td := c.session.TurnDetection
+	c.mu.Unlock()
+	if td == nil || td.Type == TurnDetectionNone {
+		return nil
+	}
+	cfg := c.vadConfig
+	if td.PrefixPaddingMs != nil {
+		cfg.PrefixPaddingMs = *td.PrefixPaddingMs
+	}
+	if td.SilenceDurationMs != nil {
+		cfg.SilenceMs = *td.SilenceDurationMs
+	}
+
+	return audio.NewVAD(cfg, audio.RealtimeSampleRate)
+}
+
+func (c *cascade) append(e *InputAudioBufferAppendEvent) {
+	pcm, err := base64.StdEncoding.DecodeString(e.Audio)
+	if err != nil {
+		c.fail("invalid_request_error", "audio must be base64 encoded", "audio")
+		return
+	}
+	samples := audio.DecodePCM16(pcm)
+	if c.vad == nil {
+		c.buffer = append(c.buffer, samples...)
+		if excess := len(c.buffer) - cascadeMaxBufferMs*audio.RealtimeSampleRate/1000; excess > 0 {
+			c.buffer = c.buffer[excess:]
+		}
+		return
+	}
+
+	result := c.vad.Process(samples)
+	if result.Started {
+		c.emit(&InputAudioBufferSpeechStartedEvent{
+			Event:        Event{Type: EventTypeInputAudioBufferSpeechStarted},
+			AudioStartMs: result.StartMs,
+		})
+	}
+	c.buffer = append(c.buffer, result.Forward...)
+	if result.Stopped {
+		c.emit(&InputAudioBufferSpeechStoppedEvent{
+			Event:      Event{Type: EventTypeInputAudioBufferSpeechStopped},
+			AudioEndMs: result.EndMs,
+		})
+		c.commit("", true)
+	}
+}
+
+// commit queues the buffered input to be transcribed
+func (c *cascade) commit(eventID string, respond bool) {
+	if len(c.buffer) < cascadeMinCommitMs*audio.RealtimeSampleRate/1000 {
+		c.fail("invalid_request_error", "buffer too small, it needs at least 100ms of audio", "")
+		return
+	}
+	itemID := cascadeID("item")
+	c.emit(&InputAudioBufferCommittedEvent{Event: Event{Type: EventTypeInputAudioBufferCommitted}, ItemID: itemID})
+	c.emit(&ConversationItemCreatedEvent{
+		Event: Event{Type: EventTypeConversationItemCreated},
+		Item: &Item{
+			ID:      itemID,
+			Object:  "realtime.item",
+			Type:    ItemTypeMessage,
+			Status:  "completed",
+			Role:    ItemRoleUser,
+			Content: []*ContentPart{{Type: ContentTypeInputAudio}},
+		},
+	})
+	c.queue(&cascadeJob{audio: c.buffer, itemID: itemID, respond: respond})
+	c.buffer = nil
+}
+
+func (c *cascade) createItem(e *ConversationItemCreateEvent) {
+	item := e.Item
+	if item == nil || item.Type != ItemTypeMessage {
+		c.fail("invalid_request_error", "the fallback only takes messages", "item")
+		return
+	}
+	var text strings.Builder
+	for _, part := range item.Content {
+		text.WriteString(part.Text)
+	}
+	if item.ID == "" {
+		item.ID = cascadeID("item")
+	}
+	item.Object, item.Status = "realtime.item", "completed"
+
+	c.mu.Lock()
+	c.history = append(c.history, &cascadeMessage{itemID: item.ID, role: item.Role, content: text.String()})
+	c.mu.Unlock()
+	c.emit(&ConversationItemCreatedEvent{Event: Event{Type: EventTypeConversationItemCreated}, Item: item})
+}
+
+func (c *cascade) deleteItem(e *ConversationItemDeleteEvent) {
+	c.mu.Lock()
+	c.history = slices.DeleteFunc(c.history, func(m *cascadeMessage) bool {
+		return m.itemID == e.ItemID
+	})
+	c.mu.Unlock()
+	c.emit(&ConversationItemDeletedEvent{Event: Event{Type: EventTypeConversationItemDeleted}, ItemID: e.ItemID})
+}
+
+func (c *cascade) queue(job *cascadeJob) {
+	select {
+	case c.jobs <- job:
+	case <-c.closed:
+	}
+}
+
+// work transcribes and answers the turns in order
+func (c *cascade) work() {
+	for {
+		select {
+		case <-c.closed:
+			return
+		case job := <-c.jobs:
+			if job.audio != nil && !c.transcribe(job) {
+				continue
+			}
+			if job.respond {
+				c.respond(job.response)
+			}
+		}
+	}
+}
+
+// transcribe adds the input of job to the conversation, it returns false
+// when there is nothing to answer
+func (c *cascade) transcribe(job *cascadeJob) bool {
+	transcript, err := c.fallback.Transcriber.Transcribe(c.ctx, job.audio, audio.RealtimeSampleRate, "")
+	if err != nil {
+		log.Printf("realtime session %s: fallback couldn't transcribe: %v", c.sessionID, err)
+		c.emit(&InputAudioTranscriptionFailedEvent{
+			Event:  Event{Type: EventTypeInputAudioTranscriptionFailed},
+			ItemID: job.itemID,
+			Error:  &ErrorDetails{Type: "server_error", Message: "couldn't transcribe the audio"},
+		})
+		return false
+	}
+
+	c.record(usage.SourceRealtimeFallbackTranscription, c.fallback.TranscriptionModel, openai.Usage{
+		TranscriptionSeconds: float64(len(job.audio)) / audio.RealtimeSampleRate,
+	})
+
+	c.mu.Lock()
+	c.history = append(c.history, &cascadeMessage{itemID: job.itemID, role: ItemRoleUser, content: transcript})
+	transcription := c.session.InputAudioTranscription
+	c.mu.Unlock()
+	if transcription != nil && transcription.Model != "" {
+		c.emit(&InputAudioTranscriptionCompletedEvent{
+			Event:      Event{Type: EventTypeInputAudioTranscriptionCompleted},
+			ItemID:     job.itemID,
+			Transcript: transcript,
+		})
+	}
+
+	return strings.TrimSpace(transcript) != ""
+}
+
+// respond streams the persona's answer to the conversation, voicing it a
+// sentence at a time
+func (c *cascade) respond(config *ResponseConfig) {
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+	c.mu.Lock()
+	c.cancelResponse = cancel
+	session := c.session
+	messages := make([]*completions.CompletionRequestMessage, 0, len(c.history)+1)
+	c.mu.Unlock()
+	if config != nil {
+		if config.Instructions != "" {
+			session.Instructions = config.Instructions
+		}
+		if config.Modalities != nil {
+			session.Modalities = config.Modalities
+		}
+		if config.Voice != "" {
+			session.Voice = config.Voice
+		}
+	}
+	spoken := len(session.Modalities) == 0 || slices.Contains(session.Modalities, ContentTypeAudio)
+
+	if session.Instructions != "" {
+		messages = append(messages, &completions.CompletionRequestMessage{
+			Role:    completions.CompletionRequestMessageRoleSystem,
+			Content: session.Instructions,
+		})
+	}
+	c.mu.Lock()
+	for _, m := range c.history {
+		messages = append(messages, &completions.CompletionRequestMessage{Role: m.role, Content: m.content})
+	}
+	c.mu.Unlock()
+
+	r := &cascadeResponse{
+		cascade: c,
+		id:      cascadeID("resp"),
+		itemID:  cascadeID("item"),
+		spoken:  spoken,
+		voice:   session.Voice,
+	}
+	r.start()
+	completion, err := c.fallback.Chat.Stream(ctx, &completions.CompletionRequest{
+		Model:    c.fallback.ChatModel,
+		Messages: messages,
+	}, func(delta string) error {
+		return r.delta(ctx, delta)
+	})
+	if err == nil {
+		err = r.speak(ctx, true)
+	}
+
+	status := "completed"
+	switch {
+	case ctx.Err() != nil && c.ctx.Err() == nil:
+		status = "cancelled"
+	case err != nil:
+		status = "failed"
+		log.Printf("realtime session %s: fallback couldn't respond: %v", c.sessionID, err)
+	}
+	r.finish(status)
+
+	c.mu.Lock()
+	c.cancelResponse = nil
+	if text := r.text.String(); text != "" {
+		c.history = append(c.history, &cascadeMessage{itemID: r.itemID, role: ItemRoleAssistant, content: text})
+	}
+	c.mu.Unlock()
+
+	if completion != nil {
+		c.record(usage.SourceRealtimeFallback, completion.Model, completion.Usage)
+	}
+	if r.characters > 0 {
+		c.record(usage.SourceRealtimeFallbackSpeech, c.fallback.SpeechModel, openai.Usage{SpeechCharacters: r.characters})
+	}
+}
+
+// record writes the usage of one of the fallback's providers to the
+// ledger
+func (c *cascade) record(source, model string, u openai.Usage) {
+	if c.ledger == nil {
+		return
+	}
+	err := c.ledger.Record(context.Background(), &usage.Entry{
+		UserID:    c.userID,
+		SessionID: c.sessionID,
+		Source:    source,
+		Model:     model,
+		Usage:     u,
+	})
+	if err != nil {
+		log.Printf("couldn't record fallback usage: %v", err)
+	}
+}
+
+// cascadeResponse emits the events of a response as it is generated
+type cascadeResponse struct {
+	cascade *cascade
+	id      string
+	itemID  string
+	spoken  bool
+	voice   string
+	text    strings.Builder
+	// unspoken is the text that isn't voiced yet
+	unspoken strings.Builder
+	// characters is how much of the text was voiced
+	characters int
+}
+
+func (r *cascadeResponse) part() *ContentPart {
+	if r.spoken {
+		return &ContentPart{Type: ContentTypeAudio, Transcript: r.text.String()}
+	}
+
+	return &ContentPart{Type: ContentTypeText, Text: r.text.String()}
+}
+
+func (r *cascadeResponse) item(status string) *Item {
+	return &Item{
+		ID:      r.itemID,
+		Object:  "realtime.item",
+		Type:    ItemTypeMessage,
+		Status:  status,
+		Role:    ItemRoleAssistant,
+		Content: []*ContentPart{r.part()},
+	}
+}
+
+func (r *cascadeResponse) start() {
+	c := r.cascade
+	c.emit(&ResponseCreatedEvent{
+		Event:    Event{Type: EventTypeResponseCreated},
+		Response: &Response{ID: r.id, Object: "realtime.response", Status: "in_progress"},
+	})
+	c.emit(&ResponseOutputItemEvent{
+		Event:      Event{Type: EventTypeResponseOutputItemAdded},
+		ResponseID: r.id,
+		Item:       &Item{ID: r.itemID, Object: "realtime.item", Type: ItemTypeMessage, Status: "in_progress", Role: ItemRoleAssistant},
+	})
+	c.emit(&ResponseContentPartEvent{
+		Event:      Event{Type: EventTypeResponseContentPartAdded},
+		ResponseID: r.id,
+		ItemID:     r.itemID,
+		Part:       r.part(),
+	})
+}
+
+func (r *cascadeResponse) delta(ctx context.Context, delta string) error {
+	eventType := EventTypeResponseTextDelta
+	if r.spoken {
+		eventType = EventTypeResponseAudioTranscriptDelta
+	}
+	r.text.WriteString(delta)
+	r.cascade.emit(&ResponseDeltaEvent{
+		Event:      Event{Type: eventType},
+		ResponseID: r.id,
+		ItemID:     r.itemID,
+		Delta:      delta,
+	})
+	if !r.spoken {
+		return nil
+	}
+	r.unspoken.WriteString(delta)
+
+	return r.speak(ctx, false)
+}
+
+// speak voices the complete sentences of the unspoken text, or all of it
+// once the answer is complete
+func (r *cascadeResponse) speak(ctx context.Context, all bool) error {
+	if !r.spoken {
+		return nil
+	}
+	text := r.unspoken.String()
+	end := len(text)
+	if !all {
+		end = sentenceEnd(text)
+	}
+	sentence := strings.TrimSpace(text[:end])
+	if sentence == "" || (!all && len(sentence) < cascadeMinSentence) {
+		return nil
+	}
+	r.unspoken.Reset()
+	r.unspoken.WriteString(text[end:])
+
+	samples, err := r.cascade.fallback.Synthesizer.Synthesize(ctx, sentence, r.voice)
+	if err != nil {
+		return fmt.Errorf("couldn't voice the answer: %w", err)
+	}
+	r.characters += utf8.RuneCountInString(sentence)
+	chunk := cascadeAudioChunkMs * audio.RealtimeSampleRate / 1000
+	for start := 0; start < len(samples); start += chunk {
+		r.cascade.emit(&ResponseDeltaEvent{
+			Event:      Event{Type: EventTypeResponseAudioDelta},
+			ResponseID: r.id,
+			ItemID:     r.itemID,
+			Delta:      base64.StdEncoding.EncodeToString(audio.EncodePCM16(samples[start:min(start+chunk, len(samples))])),
+		})
+	}
+
+	return nil
+}
+
+func (r *cascadeResponse) finish(status string) {
+	c := r.cascade
+	if r.spoken {
+		c.emit(&ResponseAudioDoneEvent{Event: Event{Type: EventTypeResponseAudioDone}, ResponseID: r.id, ItemID: r.itemID})
+		c.emit(&ResponseAudioTranscriptDoneEvent{
+			Event:      Event{Type: EventTypeResponseAudioTranscriptDone},
+			ResponseID: r.id,
+			ItemID:     r.itemID,
+			Transcript: r.text.String(),
+		})
+	} else {
+		c.emit(&ResponseTextDoneEvent{
+			Event:      Event{Type: EventTypeResponseTextDone},
+			ResponseID: r.id,
+			ItemID:     r.itemID,
+			Text:       r.text.String(),
+		})
+	}
+	c.emit(&ResponseContentPartEvent{
+		Event:      Event{Type: EventTypeResponseContentPartDone},
+		ResponseID: r.id,
+		ItemID:     r.itemID,
+		Part:       r.part(),
+	})
+	itemStatus := "completed"
+	if status != "completed" {
+		itemStatus = "incomplete"
+	}
+	item := r.item(itemStatus)
+	c.emit(&ResponseOutputItemEvent{Event: Event{Type: EventTypeResponseOutputItemDone}, ResponseID: r.id, Item: item})
+	c.emit(&ResponseDoneEvent{
+		Event:    Event{Type: EventTypeResponseDone},
+		Response: &Response{ID: r.id, Object: "realtime.response", Status: status, Output: []*Item{item}},
+	})
+}
+
+// sentenceEnd returns where the last complete sentence of text ends, 0
+// when there is none yet
+func sentenceEnd(text string) int {
+	for i := len(text) - 1; i > 0; i-- {
+		if (text[i] == ' ' || text[i] == '\n') && strings.ContainsRune(".!?\n", rune(text[i-1])) {
+			return i
+		}
+	}
+
+	return 0
+}
+
+// emit queues a server event for the proxy
+func (c *cascade) emit(event TypedEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("couldn't encode %s event: %v", event.EventType(), err)
+		return
+	}
+	select {
+	case c.out <- data:
+	case <-c.closed:
+	}
+}
+
+func (c *cascade) fail(errorType, message, param string) {
+	c.emit(&ErrorEvent{
+		Event: Event{Type: EventTypeError},
+		Error: &ErrorDetails{Type: errorType, Message: message, Param: param},
+	})
+}
+
+func cascadeID(prefix string) string {
+	return prefix + "_" + strings.ReplaceAll(uuid.NewString(), "-", "")[:20]
+}
@@ -0,0 +1,68 @@
+package realtime
+
+import (
+	"encoding/json"
+	"proomptmachinee/internal/config"
+	"proomptmachinee/internal/services/openai"
+	"proomptmachinee/internal/services/usage"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCascadeMetering(t *testing.T) {
+	c := NewRealtimeClient("test", openai.Gpt40RealtimePreview, config.RealtimeConfig{}, nil, nil, nil, nil, nil)
+	ledger := &fakeLedger{}
+	c.UseLedger(ledger)
+	f := NewFakeFallback("hello", "hi there")
+	f.ChatModel = openai.Gpt4oMini
+	cc := f.open(c, "user-1", "session-1")
+	defer cc.Close()
+
+	appendFrames(t, cc, 1500, true)
+	for _, event := range []string{`{"type":"input_audio_buffer.commit"}`, `{"type":"response.create"}`} {
+		if err := cc.WriteMessage(websocket.TextMessage, []byte(event)); err != nil {
+			t.Fatalf("couldn't send %s: %v", event, err)
+		}
+	}
+	for {
+		_, data, err := cc.ReadMessage()
+		if err != nil {
+			t.Fatalf("fallback didn't respond: %v", err)
+		}
+		var e Event
+		if json.Unmarshal(data, &e) == nil && e.Type == EventTypeResponseDone {
+			break
+		}
+	}
+
+	// the usage is recorded once the response is done
+	deadline := time.Now().Add(testWait)
+	for len(ledger.bySource(usage.SourceRealtimeFallbackSpeech)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, tc := range []struct {
+		source string
+		model  string
+		usage  openai.Usage
+		cost   float64
+	}{
+		{usage.SourceRealtimeFallbackTranscription, openai.Whisper1, openai.Usage{TranscriptionSeconds: 1.5}, 0.00015},
+		{usage.SourceRealtimeFallback, openai.Gpt4oMini, openai.Usage{}, 0},
+		{usage.SourceRealtimeFallbackSpeech, openai.Tts1, openai.Usage{SpeechCharacters: 8}, 0.00012},
+	} {
+		entries := ledger.bySource(tc.source)
+		if len(entries) != 1 {
+			t.Errorf("recorded %d %s entries, want 1", len(entries), tc.source)
+			continue
+		}
+		e := entries[0]
+		if e.UserID != "user-1" || e.SessionID != "session-1" || e.Model != tc.model || e.Usage != tc.usage {
+			t.Errorf("recorded %+v for %s", e, tc.source)
+		}
+		if diff := e.CostUSD - tc.cost; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("%s cost $%g, want $%g", tc.source, e.CostUSD, tc.cost)
+		}
+	}
+}
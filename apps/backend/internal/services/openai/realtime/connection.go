@@ -0,0 +1,129 @@
+package realtime
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// defaultMaxMessageSize fits a few seconds of pcm16 audio in one
+	// append
+	defaultMaxMessageSize = 1 << 20
+	// defaultWriteTimeout is how long a frame to a client may take to be
+	// written before it is considered stalled
+	defaultWriteTimeout = 10 * time.Second
+)
+
+var ErrMessageTooBig = errors.New("message too big")
+
+// newUpgrader accepts the client connections of the allowed origins,
+// see checkOrigin
+func newUpgrader(allowedOrigins []string) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		// Buffer sizes for real-time audio streaming
+		ReadBufferSize:  8192, // 8 KB for raw PCM (24kHz)
+		WriteBufferSize: 8192, // Can adjust for specific formats
+
+		// Adjust for G.711 if needed
+		// ReadBufferSize:  2048, // 2 KB for G.711 (8kHz)
+		// WriteBufferSize: 2048,
+
+		// the bearer subprotocol is never echoed back
+		Subprotocols: []string{RealtimeSubprotocol},
+
+		CheckOrigin: checkOrigin(allowedOrigins),
+	}
+}
+
+// checkOrigin lets requests without an Origin through, they don't come
+// from a browser page. Browsers have to be on an allowed origin, or on
+// the api's own host when none are configured.
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		if len(allowed) == 0 {
+			u, err := url.Parse(origin)
+			return err == nil && strings.EqualFold(u.Host, r.Host)
+		}
+		for _, a := range allowed {
+			if a == "*" || strings.EqualFold(strings.TrimSuffix(a, "/"), origin) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// upgrade opens a client connection whose messages are limited to the
+// maximum size
+func (c *Client) upgrade(w http.ResponseWriter, r *http.Request, header http.Header) (*limitedConn, error) {
+	conn, err := c.upgrader.Upgrade(w, r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &limitedConn{Conn: conn, limit: c.maxMessageSize}, nil
+}
+
+// limitedConn is a client connection failing reads of messages over the
+// limit with ErrMessageTooBig. The websocket read limit would close the
+// connection itself with code 1009, the session closes it with a policy
+// violation instead.
+type limitedConn struct {
+	*websocket.Conn
+	limit int64
+}
+
+func (c *limitedConn) ReadMessage() (int, []byte, error) {
+	messageType, r, err := c.NextReader()
+	if err != nil {
+		return messageType, nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, c.limit+1))
+	if err == nil && int64(len(data)) > c.limit {
+		err = ErrMessageTooBig
+	}
+
+	return messageType, data, err
+}
+
+// isTimeout reports whether a write failed because its deadline passed,
+// the websocket package doesn't wrap the net errors
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// appendRate counts the audio appends of a session in one second
+// windows, only the client reader touches it
+type appendRate struct {
+	// max of zero doesn't limit
+	max    int
+	window time.Time
+	count  int
+}
+
+// allow counts an append and reports whether the window still has room
+// for it
+func (a *appendRate) allow(now time.Time) bool {
+	if a.max <= 0 {
+		return true
+	}
+	if now.Sub(a.window) >= time.Second {
+		a.window, a.count = now, 0
+	}
+	a.count++
+
+	return a.count <= a.max
+}
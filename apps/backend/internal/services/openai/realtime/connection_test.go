@@ -0,0 +1,66 @@
+package realtime
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"proomptmachinee/internal/config"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	for _, tc := range []struct {
+		allowed []string
+		origin  string
+		ok      bool
+	}{
+		{nil, "", true},
+		{nil, "http://api.test", true},
+		{nil, "http://evil.test", false},
+		{[]string{"http://app.test"}, "http://app.test", true},
+		{[]string{"http://app.test/"}, "http://APP.test", true},
+		{[]string{"http://app.test"}, "http://evil.test", false},
+		{[]string{"http://app.test"}, "", true},
+		{[]string{"*"}, "http://evil.test", true},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "http://api.test/v1/speech_to_speech", nil)
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		if ok := checkOrigin(tc.allowed)(r); ok != tc.ok {
+			t.Errorf("origin %q with %v allowed: %v, want %v", tc.origin, tc.allowed, ok, tc.ok)
+		}
+	}
+}
+
+func TestMessageTooBig(t *testing.T) {
+	p := newTestProxy(t, config.RealtimeConfig{Connection: config.RealtimeConnection{MaxMessageSize: 1024}})
+	client, upstream := p.open(t)
+	defer upstream.Close()
+	defer client.Close()
+
+	client.WriteMessage(websocket.TextMessage, []byte(`{"type":"input_audio_buffer.append","audio":"`+strings.Repeat("A", 2048)+`"}`))
+
+	if closeErr := readClose(t, client); closeErr.Code != websocket.ClosePolicyViolation {
+		t.Errorf("client got close %d %q, want a policy violation", closeErr.Code, closeErr.Text)
+	}
+	p.ended(t)
+}
+
+func TestAppendRate(t *testing.T) {
+	p := newTestProxy(t, config.RealtimeConfig{Connection: config.RealtimeConnection{MaxAppendsPerSecond: 3}})
+	client, upstream := p.open(t)
+	defer upstream.Close()
+	defer client.Close()
+
+	for range 5 {
+		client.WriteMessage(websocket.TextMessage, []byte(`{"type":"input_audio_buffer.append","audio":"AAAA"}`))
+	}
+
+	if closeErr := readClose(t, client); closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != "too many audio appends" {
+		t.Errorf("client got close %d %q, want a policy violation", closeErr.Code, closeErr.Text)
+	}
+	p.ended(t)
+}
@@ -0,0 +1,472 @@
+package realtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Client events, https://platform.openai.com/docs/api-reference/realtime-client-events
+const (
+	// EventTypeSessionUpdate is declared with the session types
+	EventTypeInputAudioBufferAppend   = "input_audio_buffer.append"
+	EventTypeInputAudioBufferCommit   = "input_audio_buffer.commit"
+	EventTypeInputAudioBufferClear    = "input_audio_buffer.clear"
+	EventTypeConversationItemCreate   = "conversation.item.create"
+	EventTypeConversationItemTruncate = "conversation.item.truncate"
+	EventTypeConversationItemDelete   = "conversation.item.delete"
+	EventTypeResponseCreate           = "response.create"
+	EventTypeResponseCancel           = "response.cancel"
+)
+
+// Server events, https://platform.openai.com/docs/api-reference/realtime-server-events
+const (
+	EventTypeError                            = "error"
+	EventTypeSessionCreated                   = "session.created"
+	EventTypeSessionUpdated                   = "session.updated"
+	EventTypeConversationCreated              = "conversation.created"
+	EventTypeConversationItemCreated          = "conversation.item.created"
+	EventTypeInputAudioTranscriptionCompleted = "conversation.item.input_audio_transcription.completed"
+	EventTypeInputAudioTranscriptionFailed    = "conversation.item.input_audio_transcription.failed"
+	EventTypeConversationItemTruncated        = "conversation.item.truncated"
+	EventTypeConversationItemDeleted          = "conversation.item.deleted"
+	EventTypeInputAudioBufferCommitted        = "input_audio_buffer.committed"
+	EventTypeInputAudioBufferCleared          = "input_audio_buffer.cleared"
+	EventTypeInputAudioBufferSpeechStarted    = "input_audio_buffer.speech_started"
+	EventTypeInputAudioBufferSpeechStopped    = "input_audio_buffer.speech_stopped"
+	EventTypeResponseCreated                  = "response.created"
+	// EventTypeResponseDone is declared with the usage types
+	EventTypeResponseOutputItemAdded            = "response.output_item.added"
+	EventTypeResponseOutputItemDone             = "response.output_item.done"
+	EventTypeResponseContentPartAdded           = "response.content_part.added"
+	EventTypeResponseContentPartDone            = "response.content_part.done"
+	EventTypeResponseTextDelta                  = "response.text.delta"
+	EventTypeResponseTextDone                   = "response.text.done"
+	EventTypeResponseAudioTranscriptDelta       = "response.audio_transcript.delta"
+	EventTypeResponseAudioTranscriptDone        = "response.audio_transcript.done"
+	EventTypeResponseAudioDelta                 = "response.audio.delta"
+	EventTypeResponseAudioDone                  = "response.audio.done"
+	EventTypeResponseFunctionCallArgumentsDelta = "response.function_call_arguments.delta"
+	EventTypeResponseFunctionCallArgumentsDone  = "response.function_call_arguments.done"
+	EventTypeRateLimitsUpdated                  = "rate_limits.updated"
+)
+
+const (
+	ItemTypeMessage            = "message"
+	ItemTypeFunctionCall       = "function_call"
+	ItemTypeFunctionCallOutput = "function_call_output"
+
+	ItemRoleUser      = "user"
+	ItemRoleAssistant = "assistant"
+	ItemRoleSystem    = "system"
+
+	ContentTypeInputText  = "input_text"
+	ContentTypeInputAudio = "input_audio"
+	ContentTypeText       = "text"
+	ContentTypeAudio      = "audio"
+)
+
+// MaxTokens is an output token limit, OpenAI takes either a number or
+// "inf". Zero stands for "inf".
+type MaxTokens int
+
+func (m MaxTokens) MarshalJSON() ([]byte, error) {
+	if m == 0 {
+		return []byte(`"inf"`), nil
+	}
+
+	return []byte(strconv.Itoa(int(m))), nil
+}
+
+func (m *MaxTokens) UnmarshalJSON(data []byte) error {
+	if string(data) == `"inf"` {
+		*m = 0
+		return nil
+	}
+	var n int
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("max tokens must be a number or \"inf\": %w", err)
+	}
+	*m = MaxTokens(n)
+
+	return nil
+}
+
+// Event is the envelope every client and server event shares
+type Event struct {
+	Type    string `json:"type"`
+	EventID string `json:"event_id,omitempty"`
+}
+
+func (e *Event) EventType() string {
+	return e.Type
+}
+
+// ContentPart is a part of a conversation item
+type ContentPart struct {
+	Type       string `json:"type"`
+	Text       string `json:"text,omitempty"`
+	Audio      string `json:"audio,omitempty"`
+	Transcript string `json:"transcript,omitempty"`
+}
+
+// Item is a conversation item: a message, a function call or its output
+type Item struct {
+	ID        string         `json:"id,omitempty"`
+	Object    string         `json:"object,omitempty"`
+	Type      string         `json:"type"`
+	Status    string         `json:"status,omitempty"`
+	Role      string         `json:"role,omitempty"`
+	Content   []*ContentPart `json:"content,omitempty"`
+	CallID    string         `json:"call_id,omitempty"`
+	Name      string         `json:"name,omitempty"`
+	Arguments string         `json:"arguments,omitempty"`
+	Output    string         `json:"output,omitempty"`
+}
+
+// ResponseConfig overrides the session config for a single response
+type ResponseConfig struct {
+	Modalities              []string   `json:"modalities,omitempty"`
+	Instructions            string     `json:"instructions,omitempty"`
+	Voice                   string     `json:"voice,omitempty"`
+	OutputAudioFormat       string     `json:"output_audio_format,omitempty"`
+	Tools                   []Tool     `json:"tools,omitempty"`
+	ToolChoice              string     `json:"tool_choice,omitempty"`
+	Temperature             float64    `json:"temperature,omitempty"`
+	MaxResponseOutputTokens *MaxTokens `json:"max_response_output_tokens,omitempty"`
+}
+
+// Response is the response object of server events
+type Response struct {
+	ID            string         `json:"id"`
+	Object        string         `json:"object,omitempty"`
+	Status        string         `json:"status"`
+	StatusDetails interface{}    `json:"status_details,omitempty"`
+	Output        []*Item        `json:"output,omitempty"`
+	Usage         *ResponseUsage `json:"usage,omitempty"`
+}
+
+type InputAudioBufferAppendEvent struct {
+	Event
+	// Audio is base64 encoded audio in the session's input format
+	Audio string `json:"audio"`
+}
+
+type InputAudioBufferCommitEvent struct {
+	Event
+}
+
+type InputAudioBufferClearEvent struct {
+	Event
+}
+
+type ConversationItemCreateEvent struct {
+	Event
+	PreviousItemID string `json:"previous_item_id,omitempty"`
+	Item           *Item  `json:"item"`
+}
+
+type ConversationItemTruncateEvent struct {
+	Event
+	ItemID       string `json:"item_id"`
+	ContentIndex int    `json:"content_index"`
+	AudioEndMs   int    `json:"audio_end_ms"`
+}
+
+type ConversationItemDeleteEvent struct {
+	Event
+	ItemID string `json:"item_id"`
+}
+
+type ResponseCreateEvent struct {
+	Event
+	Response *ResponseConfig `json:"response,omitempty"`
+}
+
+type ResponseCancelEvent struct {
+	Event
+	ResponseID string `json:"response_id,omitempty"`
+}
+
+type ErrorDetails struct {
+	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+	Param   string `json:"param,omitempty"`
+	// EventID is the id of the client event that caused the error
+	EventID string `json:"event_id,omitempty"`
+}
+
+type ErrorEvent struct {
+	Event
+	Error *ErrorDetails `json:"error"`
+}
+
+type SessionCreatedEvent struct {
+	Event
+	Session *Session `json:"session"`
+}
+
+type SessionUpdatedEvent struct {
+	Event
+	Session *Session `json:"session"`
+}
+
+type ConversationCreatedEvent struct {
+	Event
+	Conversation struct {
+		ID     string `json:"id"`
+		Object string `json:"object"`
+	} `json:"conversation"`
+}
+
+type ConversationItemCreatedEvent struct {
+	Event
+	PreviousItemID string `json:"previous_item_id"`
+	Item           *Item  `json:"item"`
+}
+
+type InputAudioTranscriptionCompletedEvent struct {
+	Event
+	ItemID       string `json:"item_id"`
+	ContentIndex int    `json:"content_index"`
+	Transcript   string `json:"transcript"`
+}
+
+type InputAudioTranscriptionFailedEvent struct {
+	Event
+	ItemID       string        `json:"item_id"`
+	ContentIndex int           `json:"content_index"`
+	Error        *ErrorDetails `json:"error"`
+}
+
+type ConversationItemTruncatedEvent struct {
+	Event
+	ItemID       string `json:"item_id"`
+	ContentIndex int    `json:"content_index"`
+	AudioEndMs   int    `json:"audio_end_ms"`
+}
+
+type ConversationItemDeletedEvent struct {
+	Event
+	ItemID string `json:"item_id"`
+}
+
+type InputAudioBufferCommittedEvent struct {
+	Event
+	PreviousItemID string `json:"previous_item_id"`
+	ItemID         string `json:"item_id"`
+}
+
+type InputAudioBufferClearedEvent struct {
+	Event
+}
+
+type InputAudioBufferSpeechStartedEvent struct {
+	Event
+	AudioStartMs int    `json:"audio_start_ms"`
+	ItemID       string `json:"item_id"`
+}
+
+type InputAudioBufferSpeechStoppedEvent struct {
+	Event
+	AudioEndMs int    `json:"audio_end_ms"`
+	ItemID     string `json:"item_id"`
+}
+
+type ResponseCreatedEvent struct {
+	Event
+	Response *Response `json:"response"`
+}
+
+type ResponseDoneEvent struct {
+	Event
+	Response *Response `json:"response"`
+}
+
+type ResponseOutputItemEvent struct {
+	Event
+	ResponseID  string `json:"response_id"`
+	OutputIndex int    `json:"output_index"`
+	Item        *Item  `json:"item"`
+}
+
+type ResponseContentPartEvent struct {
+	Event
+	ResponseID   string       `json:"response_id"`
+	ItemID       string       `json:"item_id"`
+	OutputIndex  int          `json:"output_index"`
+	ContentIndex int          `json:"content_index"`
+	Part         *ContentPart `json:"part"`
+}
+
+// ResponseDeltaEvent covers the text, audio transcript and audio deltas
+type ResponseDeltaEvent struct {
+	Event
+	ResponseID   string `json:"response_id"`
+	ItemID       string `json:"item_id"`
+	OutputIndex  int    `json:"output_index"`
+	ContentIndex int    `json:"content_index"`
+	Delta        string `json:"delta"`
+}
+
+type ResponseTextDoneEvent struct {
+	Event
+	ResponseID   string `json:"response_id"`
+	ItemID       string `json:"item_id"`
+	OutputIndex  int    `json:"output_index"`
+	ContentIndex int    `json:"content_index"`
+	Text         string `json:"text"`
+}
+
+type ResponseAudioTranscriptDoneEvent struct {
+	Event
+	ResponseID   string `json:"response_id"`
+	ItemID       string `json:"item_id"`
+	OutputIndex  int    `json:"output_index"`
+	ContentIndex int    `json:"content_index"`
+	Transcript   string `json:"transcript"`
+}
+
+type ResponseAudioDoneEvent struct {
+	Event
+	ResponseID   string `json:"response_id"`
+	ItemID       string `json:"item_id"`
+	OutputIndex  int    `json:"output_index"`
+	ContentIndex int    `json:"content_index"`
+}
+
+type ResponseFunctionCallArgumentsDeltaEvent struct {
+	Event
+	ResponseID  string `json:"response_id"`
+	ItemID      string `json:"item_id"`
+	OutputIndex int    `json:"output_index"`
+	CallID      string `json:"call_id"`
+	Delta       string `json:"delta"`
+}
+
+type ResponseFunctionCallArgumentsDoneEvent struct {
+	Event
+	ResponseID  string `json:"response_id"`
+	ItemID      string `json:"item_id"`
+	OutputIndex int    `json:"output_index"`
+	CallID      string `json:"call_id"`
+	Name        string `json:"name"`
+	Arguments   string `json:"arguments"`
+}
+
+type RateLimit struct {
+	Name         string  `json:"name"`
+	Limit        int     `json:"limit"`
+	Remaining    int     `json:"remaining"`
+	ResetSeconds float64 `json:"reset_seconds"`
+}
+
+type RateLimitsUpdatedEvent struct {
+	Event
+	RateLimits []*RateLimit `json:"rate_limits"`
+}
+
+// TypedEvent is implemented by every event type
+type TypedEvent interface {
+	EventType() string
+}
+
+var clientEvents = map[string]func() TypedEvent{
+	EventTypeSessionUpdate:            func() TypedEvent { return &SessionUpdate{} },
+	EventTypeInputAudioBufferAppend:   func() TypedEvent { return &InputAudioBufferAppendEvent{} },
+	EventTypeInputAudioBufferCommit:   func() TypedEvent { return &InputAudioBufferCommitEvent{} },
+	EventTypeInputAudioBufferClear:    func() TypedEvent { return &InputAudioBufferClearEvent{} },
+	EventTypeConversationItemCreate:   func() TypedEvent { return &ConversationItemCreateEvent{} },
+	EventTypeConversationItemTruncate: func() TypedEvent { return &ConversationItemTruncateEvent{} },
+	EventTypeConversationItemDelete:   func() TypedEvent { return &ConversationItemDeleteEvent{} },
+	EventTypeResponseCreate:           func() TypedEvent { return &ResponseCreateEvent{} },
+	EventTypeResponseCancel:           func() TypedEvent { return &ResponseCancelEvent{} },
+}
+
+var serverEvents = map[string]func() TypedEvent{
+	EventTypeError:                              func() TypedEvent { return &ErrorEvent{} },
+	EventTypeSessionCreated:                     func() TypedEvent { return &SessionCreatedEvent{} },
+	EventTypeSessionUpdated:                     func() TypedEvent { return &SessionUpdatedEvent{} },
+	EventTypeConversationCreated:                func() TypedEvent { return &ConversationCreatedEvent{} },
+	EventTypeConversationItemCreated:            func() TypedEvent { return &ConversationItemCreatedEvent{} },
+	EventTypeInputAudioTranscriptionCompleted:   func() TypedEvent { return &InputAudioTranscriptionCompletedEvent{} },
+	EventTypeInputAudioTranscriptionFailed:      func() TypedEvent { return &InputAudioTranscriptionFailedEvent{} },
+	EventTypeConversationItemTruncated:          func() TypedEvent { return &ConversationItemTruncatedEvent{} },
+	EventTypeConversationItemDeleted:            func() TypedEvent { return &ConversationItemDeletedEvent{} },
+	EventTypeInputAudioBufferCommitted:          func() TypedEvent { return &InputAudioBufferCommittedEvent{} },
+	EventTypeInputAudioBufferCleared:            func() TypedEvent { return &InputAudioBufferClearedEvent{} },
+	EventTypeInputAudioBufferSpeechStarted:      func() TypedEvent { return &InputAudioBufferSpeechStartedEvent{} },
+	EventTypeInputAudioBufferSpeechStopped:      func() TypedEvent { return &InputAudioBufferSpeechStoppedEvent{} },
+	EventTypeResponseCreated:                    func() TypedEvent { return &ResponseCreatedEvent{} },
+	EventTypeResponseDone:                       func() TypedEvent { return &ResponseDoneEvent{} },
+	EventTypeResponseOutputItemAdded:            func() TypedEvent { return &ResponseOutputItemEvent{} },
+	EventTypeResponseOutputItemDone:             func() TypedEvent { return &ResponseOutputItemEvent{} },
+	EventTypeResponseContentPartAdded:           func() TypedEvent { return &ResponseContentPartEvent{} },
+	EventTypeResponseContentPartDone:            func() TypedEvent { return &ResponseContentPartEvent{} },
+	EventTypeResponseTextDelta:                  func() TypedEvent { return &ResponseDeltaEvent{} },
+	EventTypeResponseTextDone:                   func() TypedEvent { return &ResponseTextDoneEvent{} },
+	EventTypeResponseAudioTranscriptDelta:       func() TypedEvent { return &ResponseDeltaEvent{} },
+	EventTypeResponseAudioTranscriptDone:        func() TypedEvent { return &ResponseAudioTranscriptDoneEvent{} },
+	EventTypeResponseAudioDelta:                 func() TypedEvent { return &ResponseDeltaEvent{} },
+	EventTypeResponseAudioDone:                  func() TypedEvent { return &ResponseAudioDoneEvent{} },
+	EventTypeResponseFunctionCallArgumentsDelta: func() TypedEvent { return &ResponseFunctionCallArgumentsDeltaEvent{} },
+	EventTypeResponseFunctionCallArgumentsDone:  func() TypedEvent { return &ResponseFunctionCallArgumentsDoneEvent{} },
+	EventTypeRateLimitsUpdated:                  func() TypedEvent { return &RateLimitsUpdatedEvent{} },
+}
+
+// ParseClientEvent decodes a client event into its typed struct
+func ParseClientEvent(data []byte) (TypedEvent, error) {
+	return parseEvent(data, clientEvents)
+}
+
+// ParseServerEvent decodes a server event into its typed struct
+func ParseServerEvent(data []byte) (TypedEvent, error) {
+	return parseEvent(data, serverEvents)
+}
+
+func parseEvent(data []byte, types map[string]func() TypedEvent) (TypedEvent, error) {
+	var envelope Event
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("couldn't decode event: %w", err)
+	}
+	newEvent, ok := types[envelope.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown event type %q", envelope.Type)
+	}
+	event := newEvent()
+	if err := json.Unmarshal(data, event); err != nil {
+		return nil, fmt.Errorf("couldn't decode %s event: %w", envelope.Type, err)
+	}
+
+	return event, nil
+}
+
+// audioField returns the field holding the base64 audio of the events
+// that carry audio
+func audioField(eventType string) string {
+	switch eventType {
+	case EventTypeInputAudioBufferAppend:
+		return "audio"
+	case EventTypeResponseAudioDelta:
+		return "delta"
+	}
+
+	return ""
+}
+
+// setStringField replaces a top level field of an event and leaves the
+// rest of it as it was
+func setStringField(data []byte, field, value string) ([]byte, error) {
+	var event map[string]json.RawMessage
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, fmt.Errorf("couldn't decode event: %w", err)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	event[field] = encoded
+
+	return json.Marshal(event)
+}
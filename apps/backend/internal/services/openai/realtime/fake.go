@@ -0,0 +1,61 @@
+package realtime
+
+import (
+	"context"
+	"fmt"
+	"proomptmachinee/internal/services/openai/completions"
+	"proomptmachinee/internal/services/openai/speech"
+	"sync"
+	"time"
+)
+
+// FakeSessionMinter mints client sessions without calling OpenAI, it is
+// meant for CI and local runs
+type FakeSessionMinter struct {
+	mu sync.Mutex
+	// TTL is how long the client secrets are valid, one minute like
+	// upstream when zero
+	TTL time.Duration
+	// Requests records every request the fake received
+	Requests []*SessionRequest
+}
+
+func (f *FakeSessionMinter) CreateSession(ctx context.Context, req *SessionRequest) (*ClientSession, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Requests = append(f.Requests, req)
+
+	ttl := f.TTL
+	if ttl == 0 {
+		ttl = time.Minute
+	}
+	n := len(f.Requests)
+
+	return &ClientSession{
+		ID:    fmt.Sprintf("sess_fake_%d", n),
+		Model: req.Model,
+		ClientSecret: ClientSecret{
+			Value:     fmt.Sprintf("ek_fake_%d", n),
+			ExpiresAt: time.Now().Add(ttl).Unix(),
+		},
+	}, nil
+}
+
+// NewFakeFallback is a cascaded fallback of local fakes, every turn is
+// heard as transcript and answered with answer voiced as a tone
+func NewFakeFallback(transcript, answer string) *Fallback {
+	chat := completions.NewFakeProvider(nil)
+	chat.Default = answer
+
+	return &Fallback{
+		Transcriber: &speech.FakeTranscriber{Transcripts: []string{transcript}},
+		Synthesizer: &speech.FakeSynthesizer{},
+		Chat:        chat,
+		// priced like the providers the fakes stand in for
+		TranscriptionModel: speech.DefaultTranscriptionModel,
+		SpeechModel:        speech.DefaultSpeechModel,
+	}
+}
@@ -0,0 +1,198 @@
+package realtime
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Peers of a journal entry
+const (
+	PeerClient   = "client"
+	PeerProxy    = "proxy"
+	PeerUpstream = "upstream"
+)
+
+// JournalEntry is one line of a session journal. The first entry of a
+// journal is the session start with the query of the upgrade request,
+// every other entry is a frame From one peer To another.
+type JournalEntry struct {
+	Time time.Time `json:"time"`
+	// OffsetMs is the time since the session started
+	OffsetMs  int64  `json:"offset_ms"`
+	SessionID string `json:"session_id,omitempty"`
+	Query     string `json:"query,omitempty"`
+	From      string `json:"from"`
+	To        string `json:"to,omitempty"`
+	Binary    bool   `json:"binary,omitempty"`
+	// Data is the JSON event of a text frame, binary frames are stored
+	// base64 encoded as a JSON string
+	Data json.RawMessage `json:"data,omitempty"`
+	// AudioOmitted is set when the audio payload was left out of Data
+	AudioOmitted bool `json:"audio_omitted,omitempty"`
+}
+
+// Message returns the frame of the entry
+func (e *JournalEntry) Message() (*Message, error) {
+	if !e.Binary {
+		return &Message{Content: e.Data, Type: websocket.TextMessage}, nil
+	}
+	var encoded string
+	if err := json.Unmarshal(e.Data, &encoded); err != nil {
+		return nil, fmt.Errorf("invalid binary frame: %w", err)
+	}
+	content, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid binary frame: %w", err)
+	}
+
+	return &Message{Content: content, Type: websocket.BinaryMessage}, nil
+}
+
+// ReadJournal reads the entries of a journal in order
+func ReadJournal(r io.Reader) ([]*JournalEntry, error) {
+	var entries []*JournalEntry
+	scanner := bufio.NewScanner(r)
+	// audio frames are large
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		e := &JournalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("couldn't read journal: %w", err)
+	}
+
+	return entries, nil
+}
+
+// journal writes the frames of a session to a JSONL file. Both readers
+// and the proxy write to it, so writes are serialized. A nil journal
+// drops everything.
+type journal struct {
+	// includeAudio is only set once the session's recording consent is
+	// known, before the session's goroutines start
+	includeAudio bool
+	started      time.Time
+
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+	enc  *json.Encoder
+}
+
+// openJournal creates the journal of a session, audio is left out until
+// withAudio
+func openJournal(dir, sessionID string) (*journal, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("couldn't create journal directory: %w", err)
+	}
+	started := time.Now()
+	name := fmt.Sprintf("%s_%s.jsonl", started.UTC().Format("20060102T150405Z"), sessionID)
+	file, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create journal: %w", err)
+	}
+	w := bufio.NewWriter(file)
+
+	return &journal{
+		started: started,
+		file:    file,
+		w:       w,
+		enc:     json.NewEncoder(w),
+	}, nil
+}
+
+// withAudio keeps the audio of the frames from now on, like recordings
+// only for sessions the user consented to record
+func (j *journal) withAudio(include bool) {
+	if j == nil {
+		return
+	}
+	j.includeAudio = include
+}
+
+func (j *journal) start(sessionID, query string) {
+	if j == nil {
+		return
+	}
+	j.write(&JournalEntry{SessionID: sessionID, Query: query, From: PeerProxy})
+}
+
+func (j *journal) frame(from, to string, msg *Message) {
+	if j == nil {
+		return
+	}
+	e := &JournalEntry{From: from, To: to}
+	if msg.Type == websocket.TextMessage && json.Valid(msg.Content) {
+		e.Data = msg.Content
+		if !j.includeAudio {
+			e.Data, e.AudioOmitted = omitAudio(msg.Content)
+		}
+	} else {
+		e.Binary = true
+		e.Data, _ = json.Marshal(base64.StdEncoding.EncodeToString(msg.Content))
+	}
+	j.write(e)
+}
+
+func (j *journal) write(e *JournalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.enc == nil {
+		return
+	}
+	e.Time = time.Now()
+	e.OffsetMs = e.Time.Sub(j.started).Milliseconds()
+	if err := j.enc.Encode(e); err != nil {
+		log.Printf("couldn't write journal %s: %v", j.file.Name(), err)
+	}
+}
+
+func (j *journal) close() {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.w.Flush(); err != nil {
+		log.Printf("couldn't write journal %s: %v", j.file.Name(), err)
+	}
+	if err := j.file.Close(); err != nil {
+		log.Printf("couldn't close journal %s: %v", j.file.Name(), err)
+	}
+	j.enc = nil
+}
+
+// omitAudio blanks the base64 audio of the events that carry it
+func omitAudio(data []byte) (json.RawMessage, bool) {
+	var envelope Event
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return data, false
+	}
+	field := audioField(envelope.Type)
+	if field == "" {
+		return data, false
+	}
+	omitted, err := setStringField(data, field, "")
+	if err != nil {
+		return data, false
+	}
+
+	return omitted, true
+}
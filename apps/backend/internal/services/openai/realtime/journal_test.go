@@ -0,0 +1,94 @@
+package realtime
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// writeJournal journals a session start, an audio append, a text event
+// and a binary frame and reads the entries back
+func writeJournal(t *testing.T, withAudio bool) []*JournalEntry {
+	t.Helper()
+	dir := t.TempDir()
+	j, err := openJournal(dir, "session")
+	if err != nil {
+		t.Fatalf("couldn't open journal: %v", err)
+	}
+	j.withAudio(withAudio)
+	j.start("session", "persona=test")
+	j.frame(PeerClient, PeerUpstream, &Message{Type: websocket.TextMessage, Content: []byte(`{"type":"input_audio_buffer.append","audio":"AAAA"}`)})
+	j.frame(PeerUpstream, PeerClient, &Message{Type: websocket.TextMessage, Content: []byte(`{"type":"response.text.delta","delta":"hi"}`)})
+	j.frame(PeerClient, PeerProxy, &Message{Type: websocket.BinaryMessage, Content: []byte{1, 2, 3}})
+	j.close()
+	// writes after close are dropped
+	j.frame(PeerClient, PeerUpstream, &Message{Type: websocket.TextMessage, Content: []byte(`{}`)})
+
+	files, err := filepath.Glob(filepath.Join(dir, "*_session.jsonl"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("found journals %v, want one: %v", files, err)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatalf("couldn't open journal: %v", err)
+	}
+	defer f.Close()
+	entries, err := ReadJournal(f)
+	if err != nil {
+		t.Fatalf("couldn't read journal: %v", err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("read %d entries, want 4", len(entries))
+	}
+
+	return entries
+}
+
+func TestJournal(t *testing.T) {
+	entries := writeJournal(t, true)
+	if start := entries[0]; start.SessionID != "session" || start.Query != "persona=test" || start.From != PeerProxy {
+		t.Errorf("journal starts with %+v", start)
+	}
+	for i, e := range entries[1:] {
+		if e.OffsetMs < 0 || e.OffsetMs < entries[i].OffsetMs {
+			t.Errorf("entry %d is at %dms, after %dms", i+1, e.OffsetMs, entries[i].OffsetMs)
+		}
+	}
+
+	for i, want := range []struct {
+		from, to string
+		typ      int
+		content  string
+	}{
+		{PeerClient, PeerUpstream, websocket.TextMessage, `{"type":"input_audio_buffer.append","audio":"AAAA"}`},
+		{PeerUpstream, PeerClient, websocket.TextMessage, `{"type":"response.text.delta","delta":"hi"}`},
+		{PeerClient, PeerProxy, websocket.BinaryMessage, "\x01\x02\x03"},
+	} {
+		e := entries[i+1]
+		msg, err := e.Message()
+		if err != nil {
+			t.Fatalf("entry %d: %v", i+1, err)
+		}
+		if e.From != want.from || e.To != want.to || msg.Type != want.typ || string(msg.Content) != want.content {
+			t.Errorf("entry %d is %s->%s %d %q, want %s->%s %d %q", i+1, e.From, e.To, msg.Type, msg.Content, want.from, want.to, want.typ, want.content)
+		}
+	}
+}
+
+func TestJournalOmitsAudio(t *testing.T) {
+	entries := writeJournal(t, false)
+	appended := entries[1]
+	var event InputAudioBufferAppendEvent
+	if err := json.Unmarshal(appended.Data, &event); err != nil {
+		t.Fatalf("couldn't decode append: %v", err)
+	}
+	if !appended.AudioOmitted || event.Audio != "" {
+		t.Errorf("audio of a session without consent was journaled: %s", appended.Data)
+	}
+	if text := entries[2]; text.AudioOmitted {
+		t.Error("text event marked as without audio")
+	}
+}
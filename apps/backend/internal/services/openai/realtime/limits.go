@@ -0,0 +1,242 @@
+package realtime
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"proomptmachinee/internal/audio"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// CloseSessionTooLong and CloseSessionIdle are the close codes of
+	// sessions ended by their limits, from the range left to
+	// applications
+	CloseSessionTooLong = 4000
+	CloseSessionIdle    = 4001
+
+	// how long before a limit the persona says goodbye when the config
+	// doesn't say
+	defaultWrapUp = 20 * time.Second
+	// how often the limits are checked
+	limitCheckInterval = time.Second
+
+	wrapUpTooLong = "The time for this conversation is almost up. Say a short, warm goodbye in the language of the conversation, in one or two sentences."
+	wrapUpIdle    = "The user hasn't said anything for a while. Say a short goodbye in the language of the conversation, in one or two sentences, and tell them they are welcome to come back."
+)
+
+// sessionLimits is how long a session may last, zero durations don't
+// limit
+type sessionLimits struct {
+	maxDuration time.Duration
+	idleTimeout time.Duration
+	// quota is the voice time the user has left today
+	quota  time.Duration
+	wrapUp time.Duration
+	// expires is when the caller's token expires, zero when it doesn't
+	expires time.Time
+}
+
+// limitsFor picks the limits of a user with roles, the defaults unless
+// a role has its own
+func (c *Client) limitsFor(roles []string) sessionLimits {
+	l := sessionLimits{
+		maxDuration: c.limits.MaxDuration,
+		idleTimeout: c.limits.IdleTimeout,
+		wrapUp:      c.limits.WrapUp,
+	}
+	if l.wrapUp <= 0 {
+		l.wrapUp = defaultWrapUp
+	}
+	matched := false
+	for _, role := range roles {
+		r, ok := c.limits.Roles[role]
+		if !ok {
+			continue
+		}
+		if !matched {
+			l.maxDuration, l.idleTimeout = r.MaxDuration, r.IdleTimeout
+			matched = true
+			continue
+		}
+		l.maxDuration = longer(l.maxDuration, r.MaxDuration)
+		l.idleTimeout = longer(l.idleTimeout, r.IdleTimeout)
+	}
+
+	return l
+}
+
+// longer returns the more generous limit, zero doesn't limit
+func longer(a, b time.Duration) time.Duration {
+	if a == 0 || b == 0 {
+		return 0
+	}
+
+	return max(a, b)
+}
+
+// end is when a session started at started has to end and why, zero
+// when its length isn't limited
+func (l *sessionLimits) end(started time.Time) (time.Time, *CloseError) {
+	switch {
+	case l.quota > 0 && (l.maxDuration == 0 || l.quota < l.maxDuration):
+		return started.Add(l.quota), newCloseError(websocket.ClosePolicyViolation, "daily voice minutes used up", ErrQuotaExceeded)
+	case l.maxDuration > 0:
+		return started.Add(l.maxDuration), newCloseError(CloseSessionTooLong, fmt.Sprintf("session reached its maximum length of %s", l.maxDuration), nil)
+	}
+
+	return time.Time{}, nil
+}
+
+// wrapUpBefore is how long before a limit the goodbye is said, at most
+// half the limit so short limits aren't all goodbye
+func (l *sessionLimits) wrapUpBefore(limit time.Duration) time.Duration {
+	return min(l.wrapUp, limit/2)
+}
+
+func (l *sessionLimits) enabled() bool {
+	return l.maxDuration > 0 || l.idleTimeout > 0 || l.quota > 0 || !l.expires.IsZero()
+}
+
+// goodbye is the state of the persona's goodbye, it is asked for once
+// per limit
+type goodbye struct {
+	mu sync.Mutex
+	// responding is set while a response is in progress, OpenAI refuses
+	// to start another one
+	responding bool
+	// pending is the goodbye waiting for the response to finish
+	pending *ResponseCreateEvent
+	// at is when the goodbye was asked for, idle is set when it was for
+	// being idle
+	at   time.Time
+	idle bool
+}
+
+// enforceLimits ends the session at its limits. Before it does, the
+// persona is asked to say goodbye.
+func (s *proxySession) enforceLimits(ctx context.Context) {
+	defer s.readers.Done()
+	ticker := time.NewTicker(limitCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !s.checkLimits(ctx, now) {
+				return
+			}
+		}
+	}
+}
+
+// checkLimits returns false once the session was ended
+func (s *proxySession) checkLimits(ctx context.Context, now time.Time) bool {
+	l := &s.limits
+	if !l.expires.IsZero() && !now.Before(l.expires) {
+		s.cancel(newCloseError(CloseTokenExpired, "token expired", nil))
+		return false
+	}
+	if at, closeErr := l.end(s.started); !at.IsZero() {
+		if !now.Before(at) {
+			s.cancel(closeErr)
+			return false
+		}
+		if !now.Before(at.Add(-l.wrapUpBefore(at.Sub(s.started)))) {
+			s.sayGoodbye(ctx, wrapUpTooLong, false)
+		}
+	}
+
+	if l.idleTimeout > 0 {
+		at := s.lastActivity().Add(l.idleTimeout)
+		if !now.Before(at) {
+			s.cancel(newCloseError(CloseSessionIdle, fmt.Sprintf("session was idle for %s", l.idleTimeout), nil))
+			return false
+		}
+		if !now.Before(at.Add(-l.wrapUpBefore(l.idleTimeout))) {
+			s.sayGoodbye(ctx, wrapUpIdle, true)
+		}
+	}
+
+	return true
+}
+
+// sayGoodbye asks the persona to end the conversation. It is asked once,
+// unless the user came back after an idle goodbye.
+func (s *proxySession) sayGoodbye(ctx context.Context, instructions string, idle bool) {
+	g := &s.goodbye
+	g.mu.Lock()
+	if !g.at.IsZero() && (!g.idle || !s.lastActivity().After(g.at)) {
+		g.mu.Unlock()
+		return
+	}
+	event := &ResponseCreateEvent{
+		Event:    Event{Type: EventTypeResponseCreate},
+		Response: &ResponseConfig{Instructions: s.instructions + "\n\n" + instructions},
+	}
+	g.at, g.idle = time.Now(), idle
+	if g.responding {
+		g.pending = event
+		g.mu.Unlock()
+		return
+	}
+	g.mu.Unlock()
+
+	s.sendUpstream(ctx, event)
+}
+
+// trackResponse follows whether a response is in progress, a goodbye
+// waiting for it is sent once it's done
+func (s *proxySession) trackResponse(ctx context.Context, eventType string) {
+	if !s.limits.enabled() {
+		return
+	}
+	g := &s.goodbye
+	g.mu.Lock()
+	switch eventType {
+	case EventTypeResponseCreated:
+		g.responding = true
+	case EventTypeResponseDone:
+		g.responding = false
+	}
+	pending := g.pending
+	if g.responding {
+		pending = nil
+	} else {
+		g.pending = nil
+	}
+	g.mu.Unlock()
+
+	if pending != nil {
+		s.sendUpstream(ctx, pending)
+	}
+}
+
+// touch marks the client as active
+func (s *proxySession) touch() {
+	s.activity.Store(time.Now().UnixNano())
+}
+
+func (s *proxySession) lastActivity() time.Time {
+	return time.Unix(0, s.activity.Load())
+}
+
+// touchOnSpeech marks the client as active when the appended audio is
+// louder than the VAD threshold, silence from an open microphone
+// doesn't keep a session alive
+func (s *proxySession) touchOnSpeech(pcm string) {
+	if s.limits.idleTimeout <= 0 {
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(pcm)
+	if err != nil {
+		return
+	}
+	if audio.Level(audio.DecodePCM16(data)) >= s.client.allowlist.vad.Threshold {
+		s.touch()
+	}
+}
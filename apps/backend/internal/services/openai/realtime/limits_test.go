@@ -0,0 +1,170 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"proomptmachinee/internal/config"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestLimitsFor(t *testing.T) {
+	c := &Client{limits: config.RealtimeLimits{
+		MaxDuration: 10 * time.Minute,
+		IdleTimeout: 2 * time.Minute,
+		Roles: map[string]config.RealtimeRoleLimits{
+			"member":    {MaxDuration: 30 * time.Minute, IdleTimeout: time.Minute},
+			"listener":  {MaxDuration: 20 * time.Minute, IdleTimeout: 5 * time.Minute},
+			"moderator": {MaxDuration: 0, IdleTimeout: 3 * time.Minute},
+		},
+	}}
+	for _, tc := range []struct {
+		name  string
+		roles []string
+		max   time.Duration
+		idle  time.Duration
+	}{
+		{"defaults", nil, 10 * time.Minute, 2 * time.Minute},
+		{"unknown role", []string{"guest"}, 10 * time.Minute, 2 * time.Minute},
+		{"role replaces the defaults", []string{"member"}, 30 * time.Minute, time.Minute},
+		{"most generous of each", []string{"member", "listener"}, 30 * time.Minute, 5 * time.Minute},
+		{"unlimited wins", []string{"listener", "moderator"}, 0, 5 * time.Minute},
+		{"unknown roles don't count", []string{"guest", "moderator"}, 0, 3 * time.Minute},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := c.limitsFor(tc.roles)
+			if l.maxDuration != tc.max || l.idleTimeout != tc.idle {
+				t.Errorf("got %s and %s idle, want %s and %s idle", l.maxDuration, l.idleTimeout, tc.max, tc.idle)
+			}
+			if l.wrapUp != defaultWrapUp {
+				t.Errorf("wrap up %s, want %s", l.wrapUp, defaultWrapUp)
+			}
+		})
+	}
+}
+
+// limitedSession is a session that isn't running, the test checks its
+// limits and reads what it queued for the upstream
+type limitedSession struct {
+	*proxySession
+	cause error
+}
+
+func newLimitedSession(limits sessionLimits) *limitedSession {
+	ls := &limitedSession{}
+	s := newProxySession(&Client{}, "limited", "", nil, nil)
+	s.limits = limits
+	s.instructions = "You are a test."
+	s.started = time.Now()
+	s.activity.Store(s.started.UnixNano())
+	s.cancel = func(cause error) {
+		if ls.cause == nil {
+			ls.cause = cause
+		}
+	}
+	ls.proxySession = s
+
+	return ls
+}
+
+// goodbyeSent returns the instructions of the goodbye queued for the
+// upstream, empty when none was
+func (ls *limitedSession) goodbyeSent(t *testing.T) string {
+	t.Helper()
+	select {
+	case msg := <-ls.toUpstream:
+		var event ResponseCreateEvent
+		if err := json.Unmarshal(msg.Content, &event); err != nil || event.Type != EventTypeResponseCreate || event.Response == nil {
+			t.Fatalf("upstream got %s, want a response.create", msg.Content)
+		}
+		return event.Response.Instructions
+	default:
+		return ""
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		limits  sessionLimits
+		after   time.Duration
+		goodbye string
+		code    int
+	}{
+		{"before the wrap up", sessionLimits{maxDuration: time.Minute, wrapUp: 20 * time.Second}, 39 * time.Second, "", 0},
+		{"wrap up", sessionLimits{maxDuration: time.Minute, wrapUp: 20 * time.Second}, 40 * time.Second, wrapUpTooLong, 0},
+		{"too long", sessionLimits{maxDuration: time.Minute, wrapUp: 20 * time.Second}, time.Minute, "", CloseSessionTooLong},
+		{"wrap up capped at half", sessionLimits{maxDuration: 10 * time.Second, wrapUp: 20 * time.Second}, 4 * time.Second, "", 0},
+		{"wrap up at half", sessionLimits{maxDuration: 10 * time.Second, wrapUp: 20 * time.Second}, 5 * time.Second, wrapUpTooLong, 0},
+		{"idle wrap up", sessionLimits{idleTimeout: time.Minute, wrapUp: 20 * time.Second}, 40 * time.Second, wrapUpIdle, 0},
+		{"idle", sessionLimits{idleTimeout: time.Minute, wrapUp: 20 * time.Second}, time.Minute, "", CloseSessionIdle},
+		{"idle wrap up capped at half", sessionLimits{idleTimeout: 10 * time.Second, wrapUp: 20 * time.Second}, 5 * time.Second, wrapUpIdle, 0},
+		{"token expired", sessionLimits{expires: time.Now().Add(time.Minute)}, 2 * time.Minute, "", CloseTokenExpired},
+		{"quota used up", sessionLimits{maxDuration: time.Hour, quota: time.Minute, wrapUp: 20 * time.Second}, time.Minute, "", websocket.ClosePolicyViolation},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newLimitedSession(tc.limits)
+			running := s.checkLimits(context.Background(), s.started.Add(tc.after))
+
+			var closeErr *CloseError
+			switch {
+			case tc.code == 0 && (!running || s.cause != nil):
+				t.Fatalf("session ended with %v", s.cause)
+			case tc.code != 0 && (running || !errors.As(s.cause, &closeErr) || closeErr.Code != tc.code):
+				t.Fatalf("session ended with %v, want code %d", s.cause, tc.code)
+			}
+			if got := s.goodbyeSent(t); !strings.HasSuffix(got, tc.goodbye) || (tc.goodbye == "") != (got == "") {
+				t.Errorf("goodbye %q, want %q", got, tc.goodbye)
+			}
+		})
+	}
+}
+
+func TestSayGoodbye(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("once", func(t *testing.T) {
+		s := newLimitedSession(sessionLimits{maxDuration: time.Minute})
+		s.sayGoodbye(ctx, wrapUpTooLong, false)
+		if got := s.goodbyeSent(t); got != "You are a test.\n\n"+wrapUpTooLong {
+			t.Fatalf("goodbye %q", got)
+		}
+		s.touch()
+		s.sayGoodbye(ctx, wrapUpTooLong, false)
+		if got := s.goodbyeSent(t); got != "" {
+			t.Errorf("said goodbye twice: %q", got)
+		}
+	})
+
+	t.Run("waits for the response", func(t *testing.T) {
+		s := newLimitedSession(sessionLimits{maxDuration: time.Minute})
+		s.trackResponse(ctx, EventTypeResponseCreated)
+		s.sayGoodbye(ctx, wrapUpTooLong, false)
+		if got := s.goodbyeSent(t); got != "" {
+			t.Fatalf("goodbye %q sent during a response", got)
+		}
+		s.trackResponse(ctx, EventTypeResponseDone)
+		if got := s.goodbyeSent(t); !strings.HasSuffix(got, wrapUpTooLong) {
+			t.Errorf("goodbye %q after the response", got)
+		}
+	})
+
+	t.Run("idle re-armed by activity", func(t *testing.T) {
+		s := newLimitedSession(sessionLimits{idleTimeout: time.Minute})
+		s.sayGoodbye(ctx, wrapUpIdle, true)
+		s.goodbyeSent(t)
+		s.sayGoodbye(ctx, wrapUpIdle, true)
+		if got := s.goodbyeSent(t); got != "" {
+			t.Fatalf("idle goodbye %q without activity", got)
+		}
+		s.activity.Store(time.Now().Add(time.Second).UnixNano())
+		s.sayGoodbye(ctx, wrapUpIdle, true)
+		if got := s.goodbyeSent(t); !strings.HasSuffix(got, wrapUpIdle) {
+			t.Errorf("idle goodbye %q after the user came back", got)
+		}
+	})
+}
@@ -0,0 +1,422 @@
+package realtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"proomptmachinee/internal/audio"
+	"proomptmachinee/internal/config"
+	"proomptmachinee/internal/services/personas"
+	"strconv"
+	"strings"
+)
+
+const (
+	TurnDetectionServerVad = "server_vad"
+	// TurnDetectionNone disables turn detection, the client commits the
+	// input audio buffer itself
+	TurnDetectionNone = "none"
+	// TurnDetectionProxyVad detects speech in the proxy, which only
+	// forwards speech and commits the buffer itself
+	TurnDetectionProxyVad = "proxy_vad"
+
+	ModalityText  = "text"
+	ModalityAudio = "audio"
+
+	// EventTypeSessionOptions is the first message a client sends when it
+	// opens the session with `handshake=message`
+	EventTypeSessionOptions = "session.options"
+	HandshakeMessage        = "message"
+)
+
+var ErrInvalidOptions = errors.New("invalid session options")
+
+// SessionOptions are what a client may choose for its voice session,
+// either as query parameters of the upgrade request or as the `options`
+// of a `session.options` message. Empty options use the defaults.
+type SessionOptions struct {
+	Persona       string   `json:"persona,omitempty"`
+	Voice         string   `json:"voice,omitempty"`
+	Language      string   `json:"language,omitempty"`
+	Modalities    []string `json:"modalities,omitempty"`
+	TurnDetection string   `json:"turn_detection,omitempty"`
+	// VadThreshold is the server_vad activation threshold, with
+	// proxy_vad it is the RMS level of speech as a fraction of full scale
+	VadThreshold       *float64 `json:"vad_threshold,omitempty"`
+	PrefixPaddingMs    *int     `json:"prefix_padding_ms,omitempty"`
+	SilenceDurationMs  *int     `json:"silence_duration_ms,omitempty"`
+	Transcription      *bool    `json:"transcription,omitempty"`
+	TranscriptionModel string   `json:"transcription_model,omitempty"`
+	Temperature        *float64 `json:"temperature,omitempty"`
+	// RecordingConsent is the user agreeing to the session being recorded
+	RecordingConsent bool `json:"recording_consent,omitempty"`
+	// AudioFormat and SampleRate are the audio the client sends and
+	// receives, the proxy converts it to and from what OpenAI takes.
+	// The default is 24 kHz pcm16, G.711 is always 8 kHz.
+	AudioFormat string `json:"audio_format,omitempty"`
+	SampleRate  int    `json:"sample_rate,omitempty"`
+}
+
+// Audio returns the client audio format the options ask for
+func (o *SessionOptions) Audio() audio.Format {
+	f := audio.Format{Encoding: o.AudioFormat, SampleRate: o.SampleRate}
+	if f.Encoding == "" {
+		f.Encoding = audio.EncodingPCM16
+	}
+	if f.SampleRate == 0 {
+		f.SampleRate = audio.RealtimeSampleRate
+		if f.Encoding != audio.EncodingPCM16 {
+			f.SampleRate = audio.TelephonySampleRate
+		}
+	}
+
+	return f
+}
+
+type sessionOptionsMessage struct {
+	Type    string          `json:"type"`
+	Options *SessionOptions `json:"options"`
+}
+
+func parseOptionsMessage(data []byte) (*SessionOptions, error) {
+	var msg sessionOptionsMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOptions, err)
+	}
+	if msg.Type != EventTypeSessionOptions {
+		return nil, fmt.Errorf("%w: expected a %s message", ErrInvalidOptions, EventTypeSessionOptions)
+	}
+	if msg.Options == nil {
+		return &SessionOptions{}, nil
+	}
+
+	return msg.Options, nil
+}
+
+func parseQueryOptions(q url.Values) (*SessionOptions, error) {
+	opts := &SessionOptions{
+		Persona:            q.Get("persona"),
+		Voice:              q.Get("voice"),
+		Language:           q.Get("language"),
+		TurnDetection:      q.Get("turn_detection"),
+		TranscriptionModel: q.Get("transcription_model"),
+	}
+	if m := q.Get("modalities"); m != "" {
+		opts.Modalities = strings.Split(m, ",")
+	}
+
+	var err error
+	if opts.VadThreshold, err = queryFloat(q, "vad_threshold"); err != nil {
+		return nil, err
+	}
+	if opts.Temperature, err = queryFloat(q, "temperature"); err != nil {
+		return nil, err
+	}
+	if opts.PrefixPaddingMs, err = queryInt(q, "prefix_padding_ms"); err != nil {
+		return nil, err
+	}
+	if opts.SilenceDurationMs, err = queryInt(q, "silence_duration_ms"); err != nil {
+		return nil, err
+	}
+	if v := q.Get("transcription"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: transcription must be a boolean", ErrInvalidOptions)
+		}
+		opts.Transcription = &enabled
+	}
+	opts.AudioFormat = q.Get("audio_format")
+	sampleRate, err := queryInt(q, "sample_rate")
+	if err != nil {
+		return nil, err
+	}
+	if sampleRate != nil {
+		opts.SampleRate = *sampleRate
+	}
+	if v := q.Get("recording_consent"); v != "" {
+		consent, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: recording_consent must be a boolean", ErrInvalidOptions)
+		}
+		opts.RecordingConsent = consent
+	}
+
+	return opts, nil
+}
+
+func queryFloat(q url.Values, key string) (*float64, error) {
+	v := q.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s must be a number", ErrInvalidOptions, key)
+	}
+
+	return &f, nil
+}
+
+func queryInt(q url.Values, key string) (*int, error) {
+	v := q.Get(key)
+	if v == "" {
+		return nil, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s must be an integer", ErrInvalidOptions, key)
+	}
+
+	return &i, nil
+}
+
+var (
+	defaultVoices              = []string{"alloy", "ash", "ballad", "coral", "echo", "sage", "shimmer", "verse"}
+	defaultTranscriptionModels = []string{"whisper-1"}
+	defaultLanguages           = map[string]string{"hr": "Croatian", "en": "English"}
+	defaultTurnDetection       = []string{TurnDetectionServerVad, TurnDetectionNone, TurnDetectionProxyVad}
+)
+
+// Allowlist validates client session options against the realtime
+// config and merges them with the persona into a full session update
+type Allowlist struct {
+	voices              map[string]bool
+	transcriptionModels map[string]bool
+	languages           map[string]string
+	turnDetection       map[string]bool
+	minTemperature      float64
+	maxTemperature      float64
+	maxOutputTokens     int
+	forceTranscription  bool
+	defaults            config.RealtimeDefaults
+	vad                 audio.VADConfig
+}
+
+func NewAllowlist(cfg config.RealtimeConfig) *Allowlist {
+	a := &Allowlist{
+		voices:              toSet(cfg.Voices, defaultVoices),
+		transcriptionModels: toSet(cfg.TranscriptionModels, defaultTranscriptionModels),
+		turnDetection:       toSet(cfg.TurnDetection, defaultTurnDetection),
+		languages:           cfg.Languages,
+		minTemperature:      cfg.MinTemperature,
+		maxTemperature:      cfg.MaxTemperature,
+		maxOutputTokens:     cfg.MaxResponseOutputTokens,
+		forceTranscription:  cfg.StoreTranscripts,
+		defaults:            cfg.Defaults,
+		vad:                 audio.DefaultVADConfig,
+	}
+	if cfg.VAD.Threshold > 0 {
+		a.vad.Threshold = cfg.VAD.Threshold
+	}
+	if cfg.VAD.MaxZeroCrossingRate > 0 {
+		a.vad.MaxZeroCrossingRate = cfg.VAD.MaxZeroCrossingRate
+	}
+	if cfg.VAD.MinSpeechMs > 0 {
+		a.vad.MinSpeechMs = cfg.VAD.MinSpeechMs
+	}
+	if cfg.VAD.SilenceDurationMs > 0 {
+		a.vad.SilenceMs = cfg.VAD.SilenceDurationMs
+	}
+	if cfg.VAD.PrefixPaddingMs > 0 {
+		a.vad.PrefixPaddingMs = cfg.VAD.PrefixPaddingMs
+	}
+	if len(a.languages) == 0 {
+		a.languages = defaultLanguages
+	}
+	// the range OpenAI accepts for realtime models
+	if a.minTemperature == 0 {
+		a.minTemperature = 0.6
+	}
+	if a.maxTemperature == 0 {
+		a.maxTemperature = 1.2
+	}
+	if len(a.defaults.Modalities) == 0 {
+		a.defaults.Modalities = []string{ModalityText, ModalityAudio}
+	}
+	if a.defaults.TurnDetection == "" {
+		a.defaults.TurnDetection = TurnDetectionServerVad
+	}
+	if a.defaults.TranscriptionModel == "" {
+		a.defaults.TranscriptionModel = defaultTranscriptionModels[0]
+	}
+	if a.defaults.Temperature == 0 {
+		a.defaults.Temperature = 0.8
+	}
+
+	return a
+}
+
+func toSet(values, defaults []string) map[string]bool {
+	if len(values) == 0 {
+		values = defaults
+	}
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+
+	return set
+}
+
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidOptions, fmt.Sprintf(format, args...))
+}
+
+// Build validates the options and returns the `session.update` that
+// configures the upstream session for the persona
+func (a *Allowlist) Build(opts *SessionOptions, persona *personas.Persona) (*SessionUpdate, error) {
+	// clients with other formats are converted by the proxy, OpenAI
+	// always gets the format recordings are made in
+	session := &Session{
+		Modalities:        a.defaults.Modalities,
+		Instructions:      persona.Instructions,
+		Voice:             persona.Voice,
+		InputAudioFormat:  audio.EncodingPCM16,
+		OutputAudioFormat: audio.EncodingPCM16,
+		Temperature:       a.defaults.Temperature,
+		ToolChoice:        ToolChoiceNone,
+	}
+	if err := opts.Audio().Validate(); err != nil {
+		return nil, invalid("%v", err)
+	}
+	if a.maxOutputTokens > 0 {
+		maxTokens := MaxTokens(a.maxOutputTokens)
+		session.MaxResponseOutputTokens = &maxTokens
+	}
+
+	if opts.Voice != "" {
+		if !a.voices[opts.Voice] {
+			return nil, invalid("voice %q is not allowed", opts.Voice)
+		}
+		session.Voice = opts.Voice
+	}
+
+	if opts.Language != "" {
+		name, ok := a.languages[opts.Language]
+		if !ok {
+			return nil, invalid("language %q is not allowed", opts.Language)
+		}
+		if opts.Language != persona.Language {
+			session.Instructions = strings.TrimSpace(session.Instructions + "\n\nAlways answer in " + name + ".")
+		}
+	}
+
+	if len(opts.Modalities) > 0 {
+		modalities, err := validateModalities(opts.Modalities)
+		if err != nil {
+			return nil, err
+		}
+		session.Modalities = modalities
+	}
+
+	turnDetection := a.defaults.TurnDetection
+	if opts.TurnDetection != "" {
+		if !a.turnDetection[opts.TurnDetection] {
+			return nil, invalid("turn detection %q is not allowed", opts.TurnDetection)
+		}
+		turnDetection = opts.TurnDetection
+	}
+	session.TurnDetection = &TurnDetection{Type: turnDetection}
+	if turnDetection == TurnDetectionProxyVad {
+		// OpenAI only gets the committed speech
+		session.TurnDetection = &TurnDetection{Type: TurnDetectionNone}
+	}
+	if turnDetection == TurnDetectionServerVad || turnDetection == TurnDetectionProxyVad {
+		if opts.VadThreshold != nil {
+			if *opts.VadThreshold < 0 || *opts.VadThreshold > 1 {
+				return nil, invalid("vad_threshold must be between 0 and 1")
+			}
+			session.TurnDetection.Threshold = opts.VadThreshold
+		}
+		if opts.PrefixPaddingMs != nil {
+			if *opts.PrefixPaddingMs < 0 || *opts.PrefixPaddingMs > 2000 {
+				return nil, invalid("prefix_padding_ms must be between 0 and 2000")
+			}
+			session.TurnDetection.PrefixPaddingMs = opts.PrefixPaddingMs
+		}
+		if opts.SilenceDurationMs != nil {
+			if *opts.SilenceDurationMs < 100 || *opts.SilenceDurationMs > 5000 {
+				return nil, invalid("silence_duration_ms must be between 100 and 5000")
+			}
+			session.TurnDetection.SilenceDurationMs = opts.SilenceDurationMs
+		}
+	}
+
+	transcription := a.defaults.Transcription
+	if opts.Transcription != nil {
+		transcription = *opts.Transcription
+	}
+	// stored transcripts need the user side transcribed
+	if a.forceTranscription {
+		transcription = true
+	}
+	session.InputAudioTranscription = &InputAudioTranscription{}
+	if transcription {
+		model := a.defaults.TranscriptionModel
+		if opts.TranscriptionModel != "" {
+			if !a.transcriptionModels[opts.TranscriptionModel] {
+				return nil, invalid("transcription model %q is not allowed", opts.TranscriptionModel)
+			}
+			model = opts.TranscriptionModel
+		}
+		session.InputAudioTranscription.Model = model
+	}
+
+	if opts.Temperature != nil {
+		if *opts.Temperature < a.minTemperature || *opts.Temperature > a.maxTemperature {
+			return nil, invalid("temperature must be between %.1f and %.1f", a.minTemperature, a.maxTemperature)
+		}
+		session.Temperature = *opts.Temperature
+	}
+
+	return &SessionUpdate{Type: EventTypeSessionUpdate, Session: session}, nil
+}
+
+// VAD returns the detector config of a session using proxy_vad, nil for
+// the other turn detections. The options must have been built.
+func (a *Allowlist) VAD(opts *SessionOptions) *audio.VADConfig {
+	turnDetection := opts.TurnDetection
+	if turnDetection == "" {
+		turnDetection = a.defaults.TurnDetection
+	}
+	if turnDetection != TurnDetectionProxyVad {
+		return nil
+	}
+	cfg := a.vad
+	if opts.VadThreshold != nil {
+		cfg.Threshold = *opts.VadThreshold
+	}
+	if opts.PrefixPaddingMs != nil {
+		cfg.PrefixPaddingMs = *opts.PrefixPaddingMs
+	}
+	if opts.SilenceDurationMs != nil {
+		cfg.SilenceMs = *opts.SilenceDurationMs
+	}
+
+	return &cfg
+}
+
+// validateModalities accepts text alone or text with audio, which are
+// the combinations OpenAI supports
+func validateModalities(modalities []string) ([]string, error) {
+	var text, audio bool
+	for _, m := range modalities {
+		switch m {
+		case ModalityText:
+			text = true
+		case ModalityAudio:
+			audio = true
+		default:
+			return nil, invalid("modality %q is not allowed", m)
+		}
+	}
+	if !text {
+		return nil, invalid("modalities must include text")
+	}
+	if audio {
+		return []string{ModalityText, ModalityAudio}, nil
+	}
+
+	return []string{ModalityText}, nil
+}
@@ -0,0 +1,242 @@
+package realtime
+
+import (
+	"context"
+	"proomptmachinee/internal/audio"
+	"proomptmachinee/internal/services/conversations"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventTypeOutputAudioBufferCleared tells the client to drop the
+// assistant audio it hasn't played yet, it is named like the event
+// OpenAI sends WebRTC clients
+const EventTypeOutputAudioBufferCleared = "output_audio_buffer.cleared"
+
+// OutputAudioBufferClearedEvent is sent when the user talked over the
+// assistant, AudioEndMs is where the item was truncated
+type OutputAudioBufferClearedEvent struct {
+	Event
+	ResponseID string `json:"response_id,omitempty"`
+	ItemID     string `json:"item_id"`
+	AudioEndMs int    `json:"audio_end_ms"`
+}
+
+// playback follows the assistant audio sent to the client. The client
+// plays an item in real time from its first delta on, so what was heard
+// is the time since then, at most what was sent. The item's transcript
+// is stored in full once its response is done, so it comes before the
+// user's next turn, and cut when it turns out it wasn't all heard.
+type playback struct {
+	mu           sync.Mutex
+	responseID   string
+	itemID       string
+	contentIndex int
+	started      time.Time
+	// sent is the pcm16 audio sent so far in bytes
+	sent int
+	// done is set once OpenAI sent all of the item's audio
+	done       bool
+	transcript string
+	// stored is set once the transcript was stored as messageID
+	stored    bool
+	messageID uuid.UUID
+	// last is the item played before, its transcript may only arrive
+	// after it was interrupted
+	last *playedItem
+}
+
+// playedItem is how much of an assistant item the client heard
+type playedItem struct {
+	responseID   string
+	id           string
+	contentIndex int
+	startedAt    time.Time
+	heardMs      int
+	sentMs       int
+	done         bool
+	transcript   string
+	stored       bool
+	messageID    uuid.UUID
+}
+
+// interrupted reports whether the client stopped the item before
+// hearing all of it
+func (p *playedItem) interrupted() bool {
+	return !p.done || p.heardMs < p.sentMs
+}
+
+// heard cuts a transcript of the item to the words that were heard,
+// assuming they are spread evenly over the audio
+func (p *playedItem) heard(transcript string) string {
+	if p.heardMs >= p.sentMs || p.sentMs == 0 {
+		return transcript
+	}
+	words := strings.Fields(transcript)
+
+	return strings.Join(words[:len(words)*p.heardMs/p.sentMs], " ")
+}
+
+// delta counts the audio of a delta, the first one of another item
+// finishes the item before and returns it
+func (p *playback) delta(e *ResponseDeltaEvent, now time.Time) *playedItem {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var finished *playedItem
+	if e.ItemID != p.itemID || e.ContentIndex != p.contentIndex {
+		started := now
+		if finished = p.finishLocked(now); finished != nil {
+			// the client plays the items back to back, the one before
+			// is heard in full
+			started = later(now, finished.startedAt.Add(time.Duration(finished.sentMs)*time.Millisecond))
+			finished.heardMs, finished.done = finished.sentMs, true
+		}
+		p.responseID, p.itemID, p.contentIndex, p.started = e.ResponseID, e.ItemID, e.ContentIndex, started
+	}
+	p.sent += decodedLen(e.Delta)
+
+	return finished
+}
+
+// audioDone marks the audio of an item as complete
+func (p *playback) audioDone(itemID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if itemID == p.itemID {
+		p.done = true
+	}
+}
+
+// transcriptDone returns the part of an item's transcript to store now,
+// false while the item is still playing and the transcript is held
+// until its response is done
+func (p *playback) transcriptDone(itemID, transcript string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case itemID == p.itemID:
+		p.transcript = transcript
+		return "", false
+	case p.last != nil && itemID == p.last.id:
+		return p.last.heard(transcript), true
+	}
+
+	return transcript, true
+}
+
+// responseDone stores the transcript of the playing item once its
+// response is done, store returns the id of the message it's stored as
+func (p *playback) responseDone(responseID string, store func(transcript string) uuid.UUID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.itemID == "" || responseID != p.responseID || p.transcript == "" || p.stored {
+		return
+	}
+	p.messageID, p.stored = store(p.transcript), true
+}
+
+// finish ends the playing item at now and returns it, nil when nothing
+// is playing
+func (p *playback) finish(now time.Time) *playedItem {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.finishLocked(now)
+}
+
+func (p *playback) finishLocked(now time.Time) *playedItem {
+	if p.itemID == "" {
+		return nil
+	}
+	sentMs := p.sent * 1000 / (2 * audio.RealtimeSampleRate)
+	item := &playedItem{
+		responseID:   p.responseID,
+		id:           p.itemID,
+		contentIndex: p.contentIndex,
+		startedAt:    p.started,
+		heardMs:      max(0, min(int(now.Sub(p.started).Milliseconds()), sentMs)),
+		sentMs:       sentMs,
+		done:         p.done,
+		transcript:   p.transcript,
+		stored:       p.stored,
+		messageID:    p.messageID,
+	}
+	p.responseID, p.itemID, p.contentIndex, p.started = "", "", 0, time.Time{}
+	p.sent, p.done, p.transcript, p.last = 0, false, "", item
+	p.stored, p.messageID = false, uuid.Nil
+
+	return item
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
+
+// decodedLen is the length of base64 encoded data without decoding it
+func decodedLen(encoded string) int {
+	return len(encoded)/4*3 - (len(encoded) - len(strings.TrimRight(encoded, "=")))
+}
+
+// interrupt cuts the assistant off where the user started talking over
+// it: OpenAI truncates the item to the audio that was heard, the client
+// drops what it hasn't played yet and only the heard part of the
+// transcript is stored. OpenAI's server_vad cancels the response itself,
+// the proxy's VAD has to.
+func (s *proxySession) interrupt(ctx context.Context) bool {
+	item := s.playback.finish(s.now())
+	if item == nil {
+		return true
+	}
+	s.storeHeard(item)
+	if !item.interrupted() {
+		return true
+	}
+
+	if s.vad != nil && !item.done {
+		if !s.sendUpstream(ctx, &ResponseCancelEvent{Event: Event{Type: EventTypeResponseCancel}, ResponseID: item.responseID}) {
+			return false
+		}
+	}
+	truncate := &ConversationItemTruncateEvent{
+		Event:        Event{Type: EventTypeConversationItemTruncate},
+		ItemID:       item.id,
+		ContentIndex: item.contentIndex,
+		AudioEndMs:   item.heardMs,
+	}
+	if !s.sendUpstream(ctx, truncate) {
+		return false
+	}
+
+	return s.sendEvent(ctx, &OutputAudioBufferClearedEvent{
+		Event:      Event{Type: EventTypeOutputAudioBufferCleared},
+		ResponseID: item.responseID,
+		ItemID:     item.id,
+		AudioEndMs: item.heardMs,
+	})
+}
+
+// storeHeard stores the heard part of a finished item's transcript, or
+// cuts the one stored when its response was done. A transcript that
+// arrives later is cut when it does.
+func (s *proxySession) storeHeard(item *playedItem) {
+	if item.transcript == "" {
+		return
+	}
+	heard := item.heard(item.transcript)
+	switch {
+	case !item.stored:
+		s.transcript.add(conversations.RoleAssistant, heard)
+	case heard != item.transcript:
+		s.transcript.cut(item.messageID, heard)
+	}
+	if item.interrupted() {
+		s.history.truncate(item.id, heard)
+	}
+}
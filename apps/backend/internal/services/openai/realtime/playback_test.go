@@ -0,0 +1,203 @@
+package realtime
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"proomptmachinee/internal/audio"
+	"proomptmachinee/internal/services/conversations"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// audioDelta is a delta of ms of pcm16 audio for an item
+func audioDelta(itemID string, ms int) *ResponseDeltaEvent {
+	pcm := make([]byte, 2*audio.RealtimeSampleRate*ms/1000)
+
+	return &ResponseDeltaEvent{
+		Event:      Event{Type: EventTypeResponseAudioDelta},
+		ResponseID: "resp_1",
+		ItemID:     itemID,
+		Delta:      base64.StdEncoding.EncodeToString(pcm),
+	}
+}
+
+func TestPlayedItemHeard(t *testing.T) {
+	const transcript = "one two three four five six seven eight nine ten"
+	for _, tc := range []struct {
+		name    string
+		heardMs int
+		sentMs  int
+		want    string
+	}{
+		{"all of it", 1000, 1000, transcript},
+		{"nothing sent", 0, 0, transcript},
+		{"nothing heard", 0, 1000, ""},
+		{"half", 500, 1000, "one two three four five"},
+		{"rounded down", 999, 1000, "one two three four five six seven eight nine"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			item := &playedItem{heardMs: tc.heardMs, sentMs: tc.sentMs}
+			if got := item.heard(transcript); got != tc.want {
+				t.Errorf("heard %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPlaybackFinish(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name        string
+		sentMs      []int
+		done        bool
+		after       time.Duration
+		heardMs     int
+		interrupted bool
+	}{
+		{"cut off", []int{200, 200, 200}, false, 250 * time.Millisecond, 250, true},
+		{"played out", []int{300, 300}, true, time.Second, 600, false},
+		{"audio done but still playing", []int{300, 300}, true, 400 * time.Millisecond, 400, true},
+		{"sent but not done", []int{300}, false, time.Second, 300, true},
+		{"clock went back", []int{300}, false, -time.Second, 0, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var p playback
+			for _, ms := range tc.sentMs {
+				p.delta(audioDelta("item_1", ms), start)
+			}
+			if tc.done {
+				p.audioDone("item_1")
+			}
+			item := p.finish(start.Add(tc.after))
+			if item == nil || item.id != "item_1" {
+				t.Fatalf("finished %+v", item)
+			}
+			if item.heardMs != tc.heardMs || item.interrupted() != tc.interrupted {
+				t.Errorf("heard %dms of %dms, interrupted %t, want %dms, %t", item.heardMs, item.sentMs, item.interrupted(), tc.heardMs, tc.interrupted)
+			}
+			if p.finish(start.Add(tc.after)) != nil {
+				t.Error("finished the item twice")
+			}
+		})
+	}
+
+	t.Run("back to back", func(t *testing.T) {
+		var p playback
+		p.delta(audioDelta("item_1", 500), start)
+		// the next item is queued behind the first one on the client
+		if finished := p.delta(audioDelta("item_2", 500), start.Add(100*time.Millisecond)); finished == nil || finished.heardMs != 500 || finished.interrupted() {
+			t.Fatalf("first item finished as %+v", finished)
+		}
+		item := p.finish(start.Add(700 * time.Millisecond))
+		if item.heardMs != 200 {
+			t.Errorf("second item heard %dms, want 200ms from when the first one ended", item.heardMs)
+		}
+	})
+}
+
+// playbackSession is a session that isn't running with a fake clock, the
+// test reads what it queued for the upstream, the client and the
+// transcript
+func playbackSession(now *time.Time) *proxySession {
+	s := newProxySession(&Client{}, "playback", "", nil, nil)
+	s.transcript = &transcript{sessionID: "playback", writes: make(chan *transcriptWrite, 8)}
+	s.now = func() time.Time { return *now }
+
+	return s
+}
+
+// queued returns the types of the events queued on out, with
+// audio_end_ms for truncates and cleared buffers
+func queued(t *testing.T, out chan *Message) []string {
+	t.Helper()
+	var events []string
+	for len(out) > 0 {
+		var e OutputAudioBufferClearedEvent
+		if err := json.Unmarshal((<-out).Content, &e); err != nil {
+			t.Fatalf("couldn't decode event: %v", err)
+		}
+		if e.Type == EventTypeConversationItemTruncate || e.Type == EventTypeOutputAudioBufferCleared {
+			e.Type += "@" + strconv.Itoa(e.AudioEndMs)
+		}
+		events = append(events, e.Type)
+	}
+
+	return events
+}
+
+func TestInterrupt(t *testing.T) {
+	const transcript = "one two three four"
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name         string
+		responseDone bool
+		after        time.Duration
+		// stored are the transcript writes, cuts prefixed with "cut "
+		stored   []string
+		upstream []string
+		client   []string
+	}{
+		{
+			"held transcript", false, 500 * time.Millisecond,
+			[]string{"one two"},
+			[]string{EventTypeConversationItemTruncate + "@500"},
+			[]string{EventTypeOutputAudioBufferCleared + "@500"},
+		},
+		{
+			"stored transcript is cut", true, 500 * time.Millisecond,
+			[]string{transcript, "cut one two"},
+			[]string{EventTypeConversationItemTruncate + "@500"},
+			[]string{EventTypeOutputAudioBufferCleared + "@500"},
+		},
+		{
+			"nothing heard", true, 0,
+			[]string{transcript, "cut "},
+			[]string{EventTypeConversationItemTruncate + "@0"},
+			[]string{EventTypeOutputAudioBufferCleared + "@0"},
+		},
+		{"played out", false, 2 * time.Second, []string{transcript}, nil, nil},
+		{"stored and played out", true, 2 * time.Second, []string{transcript}, nil, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			now := start
+			s := playbackSession(&now)
+			s.playback.delta(audioDelta("item_1", 1000), s.now())
+			s.playback.audioDone("item_1")
+			if _, ok := s.playback.transcriptDone("item_1", transcript); ok {
+				t.Fatal("transcript of the playing item wasn't held")
+			}
+			if tc.responseDone {
+				s.playback.responseDone("resp_1", func(transcript string) uuid.UUID {
+					return s.transcript.add(conversations.RoleAssistant, transcript)
+				})
+			}
+			now = start.Add(tc.after)
+			if !s.interrupt(context.Background()) {
+				t.Fatal("interrupt failed")
+			}
+
+			var stored []string
+			for len(s.transcript.writes) > 0 {
+				w := <-s.transcript.writes
+				if w.cut {
+					w.message.Content = "cut " + w.message.Content
+				}
+				stored = append(stored, w.message.Content)
+			}
+			if !slices.Equal(stored, tc.stored) {
+				t.Errorf("stored %q, want %q", stored, tc.stored)
+			}
+			if got := queued(t, s.toUpstream); !slices.Equal(got, tc.upstream) {
+				t.Errorf("upstream got %v, want %v", got, tc.upstream)
+			}
+			if got := queued(t, s.toClient); !slices.Equal(got, tc.client) {
+				t.Errorf("client got %v, want %v", got, tc.client)
+			}
+		})
+	}
+}
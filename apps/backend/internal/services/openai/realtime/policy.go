@@ -0,0 +1,278 @@
+package realtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"proomptmachinee/internal/config"
+	"sort"
+)
+
+const (
+	ErrorTypeInvalidRequest = "invalid_request_error"
+	// ErrorCodePolicyViolation marks `error` events the proxy sends for
+	// client events it refused to forward
+	ErrorCodePolicyViolation = "policy_violation"
+)
+
+var (
+	defaultClientEvents = []string{
+		EventTypeSessionUpdate,
+		EventTypeInputAudioBufferAppend,
+		EventTypeInputAudioBufferCommit,
+		EventTypeInputAudioBufferClear,
+		EventTypeConversationItemCreate,
+		EventTypeConversationItemTruncate,
+		EventTypeConversationItemDelete,
+		EventTypeResponseCreate,
+		EventTypeResponseCancel,
+	}
+	defaultSessionFields  = []string{"modalities", "voice", "input_audio_transcription", "turn_detection", "temperature", "max_response_output_tokens"}
+	defaultResponseFields = []string{"modalities", "voice", "temperature", "max_response_output_tokens"}
+)
+
+// PolicyError is a client event the policy refused to forward
+type PolicyError struct {
+	EventType string
+	EventID   string
+	Param     string
+	Message   string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s: %s", e.EventType, e.Message)
+}
+
+// ErrorEvent is the `error` server event sent back to the client
+func (e *PolicyError) ErrorEvent() *ErrorEvent {
+	return &ErrorEvent{
+		Event: Event{Type: EventTypeError},
+		Error: &ErrorDetails{
+			Type:    ErrorTypeInvalidRequest,
+			Code:    ErrorCodePolicyViolation,
+			Message: e.Message,
+			Param:   e.Param,
+			EventID: e.EventID,
+		},
+	}
+}
+
+// EventPolicy decides which client events reach OpenAI. It keeps clients
+// from overriding the persona instructions or tools and from asking for
+// more output tokens or other values than the allowlist permits.
+type EventPolicy struct {
+	allowed        map[string]bool
+	sessionFields  map[string]bool
+	responseFields map[string]bool
+	allowlist      *Allowlist
+}
+
+func NewEventPolicy(cfg config.RealtimeClientEvents, allowlist *Allowlist) *EventPolicy {
+	return &EventPolicy{
+		allowed:        toSet(cfg.Allowed, defaultClientEvents),
+		sessionFields:  toSet(cfg.SessionFields, defaultSessionFields),
+		responseFields: toSet(cfg.ResponseFields, defaultResponseFields),
+		allowlist:      allowlist,
+	}
+}
+
+// Check validates a client event and returns the decoded event and the
+// bytes to forward, which are the original ones unless a value had to be
+// capped
+func (p *EventPolicy) Check(data []byte) ([]byte, TypedEvent, *PolicyError) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, &PolicyError{Message: "event must be a JSON object"}
+	}
+	event, err := ParseClientEvent(data)
+	if err != nil {
+		var envelope Event
+		_ = json.Unmarshal(data, &envelope)
+		return nil, nil, &PolicyError{EventType: envelope.Type, EventID: envelope.EventID, Message: err.Error()}
+	}
+
+	var eventID string
+	_ = json.Unmarshal(raw["event_id"], &eventID)
+	violation := func(param, format string, args ...interface{}) *PolicyError {
+		return &PolicyError{EventType: event.EventType(), EventID: eventID, Param: param, Message: fmt.Sprintf(format, args...)}
+	}
+
+	if !p.allowed[event.EventType()] {
+		return nil, nil, violation("type", "event type %q is not allowed", event.EventType())
+	}
+
+	switch e := event.(type) {
+	case *SessionUpdate:
+		data, err := p.checkConfig(data, raw, "session", p.sessionFields, violation, func(fields map[string]json.RawMessage) *PolicyError {
+			return p.checkSession(e.Session, fields, violation)
+		})
+		return data, event, err
+	case *ResponseCreateEvent:
+		data, err := p.checkConfig(data, raw, "response", p.responseFields, violation, func(fields map[string]json.RawMessage) *PolicyError {
+			return p.checkResponse(e.Response, fields, violation)
+		})
+		return data, event, err
+	case *ConversationItemCreateEvent:
+		if e.Item == nil {
+			return nil, nil, violation("item", "item is required")
+		}
+		switch {
+		case e.Item.Type == ItemTypeMessage && e.Item.Role == ItemRoleSystem:
+			return nil, nil, violation("item.role", "system messages are not allowed")
+		case e.Item.Type == ItemTypeFunctionCall, e.Item.Type == ItemTypeFunctionCallOutput:
+			return nil, nil, violation("item.type", "%s items are not allowed", e.Item.Type)
+		}
+	}
+
+	return data, event, nil
+}
+
+// checkConfig checks the fields of the session or response object of an
+// event against the allowed ones, validates their values and caps the
+// output tokens
+func (p *EventPolicy) checkConfig(
+	data []byte,
+	raw map[string]json.RawMessage,
+	key string,
+	allowed map[string]bool,
+	violation func(param, format string, args ...interface{}) *PolicyError,
+	validate func(fields map[string]json.RawMessage) *PolicyError,
+) ([]byte, *PolicyError) {
+	if len(raw[key]) == 0 || string(raw[key]) == "null" {
+		return data, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw[key], &fields); err != nil {
+		return nil, violation(key, "%s must be an object", key)
+	}
+
+	// sorted so the same event always reports the same field
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !allowed[name] {
+			return nil, violation(key+"."+name, "%s.%s may not be set by the client", key, name)
+		}
+	}
+
+	if err := validate(fields); err != nil {
+		return nil, err
+	}
+	if err := checkOutputTokens(fields["max_response_output_tokens"]); err != nil {
+		return nil, violation(key+".max_response_output_tokens", "%v", err)
+	}
+
+	capped, ok := p.capOutputTokens(fields["max_response_output_tokens"])
+	if !ok {
+		return data, nil
+	}
+	fields["max_response_output_tokens"] = capped
+	var err error
+	if raw[key], err = json.Marshal(fields); err != nil {
+		return nil, violation(key, "couldn't encode %s", key)
+	}
+	if data, err = json.Marshal(raw); err != nil {
+		return nil, violation(key, "couldn't encode event")
+	}
+
+	return data, nil
+}
+
+// capOutputTokens returns the configured cap when the value asks for
+// more, including "inf"
+func (p *EventPolicy) capOutputTokens(value json.RawMessage) (json.RawMessage, bool) {
+	limit := p.allowlist.maxOutputTokens
+	if limit <= 0 || len(value) == 0 {
+		return nil, false
+	}
+	var requested MaxTokens
+	if err := json.Unmarshal(value, &requested); err != nil {
+		// the typed decoding already rejected it
+		return nil, false
+	}
+	if requested != 0 && int(requested) <= limit {
+		return nil, false
+	}
+
+	return json.RawMessage(fmt.Sprint(limit)), true
+}
+
+// checkOutputTokens rejects negative output token limits, they aren't
+// capped and OpenAI would only fail the response
+func checkOutputTokens(value json.RawMessage) error {
+	if !isSet(value) {
+		return nil
+	}
+	var requested MaxTokens
+	if err := json.Unmarshal(value, &requested); err != nil {
+		// the typed decoding already rejected it
+		return nil
+	}
+	if requested < 0 {
+		return fmt.Errorf("max_response_output_tokens must be positive or \"inf\"")
+	}
+
+	return nil
+}
+
+// isSet reports whether a field was sent with a value, zero values count
+func isSet(value json.RawMessage) bool {
+	return len(value) > 0 && string(value) != "null"
+}
+
+func (p *EventPolicy) checkSession(s *Session, fields map[string]json.RawMessage, violation func(param, format string, args ...interface{}) *PolicyError) *PolicyError {
+	if s == nil {
+		return nil
+	}
+	a := p.allowlist
+	// null turns transcription off, which stored transcripts rely on
+	if _, ok := fields["input_audio_transcription"]; ok && a.forceTranscription &&
+		(s.InputAudioTranscription == nil || s.InputAudioTranscription.Model == "") {
+		return violation("session.input_audio_transcription", "transcription can't be turned off")
+	}
+	if len(s.Modalities) > 0 {
+		if _, err := validateModalities(s.Modalities); err != nil {
+			return violation("session.modalities", "%v", err)
+		}
+	}
+	if s.Voice != "" && !a.voices[s.Voice] {
+		return violation("session.voice", "voice %q is not allowed", s.Voice)
+	}
+	// the proxy's own detection is chosen when the session opens
+	if s.TurnDetection != nil && s.TurnDetection.Type == TurnDetectionProxyVad {
+		return violation("session.turn_detection", "%s can only be chosen in the session options", TurnDetectionProxyVad)
+	}
+	if s.TurnDetection != nil && !a.turnDetection[s.TurnDetection.Type] {
+		return violation("session.turn_detection", "turn detection %q is not allowed", s.TurnDetection.Type)
+	}
+	if s.InputAudioTranscription != nil && !a.transcriptionModels[s.InputAudioTranscription.Model] {
+		return violation("session.input_audio_transcription", "transcription model %q is not allowed", s.InputAudioTranscription.Model)
+	}
+	if isSet(fields["temperature"]) && (s.Temperature < a.minTemperature || s.Temperature > a.maxTemperature) {
+		return violation("session.temperature", "temperature must be between %.1f and %.1f", a.minTemperature, a.maxTemperature)
+	}
+
+	return nil
+}
+
+func (p *EventPolicy) checkResponse(r *ResponseConfig, fields map[string]json.RawMessage, violation func(param, format string, args ...interface{}) *PolicyError) *PolicyError {
+	if r == nil {
+		return nil
+	}
+	a := p.allowlist
+	if len(r.Modalities) > 0 {
+		if _, err := validateModalities(r.Modalities); err != nil {
+			return violation("response.modalities", "%v", err)
+		}
+	}
+	if r.Voice != "" && !a.voices[r.Voice] {
+		return violation("response.voice", "voice %q is not allowed", r.Voice)
+	}
+	if isSet(fields["temperature"]) && (r.Temperature < a.minTemperature || r.Temperature > a.maxTemperature) {
+		return violation("response.temperature", "temperature must be between %.1f and %.1f", a.minTemperature, a.maxTemperature)
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package realtime
+
+import (
+	"proomptmachinee/internal/config"
+	"testing"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	cfg := config.RealtimeConfig{MaxResponseOutputTokens: 500}
+	policy := NewEventPolicy(cfg.ClientEvents, NewAllowlist(cfg))
+	for _, tc := range []struct {
+		name  string
+		event string
+		param string
+		out   string
+	}{
+		{"allowed", `{"type":"session.update","session":{"temperature":0.8,"max_response_output_tokens":200}}`, "", ""},
+		{"tokens capped", `{"type":"response.create","response":{"max_response_output_tokens":"inf"}}`, "", `{"response":{"max_response_output_tokens":500},"type":"response.create"}`},
+		{"negative tokens", `{"type":"session.update","session":{"max_response_output_tokens":-1}}`, "session.max_response_output_tokens", ""},
+		{"negative response tokens", `{"type":"response.create","response":{"max_response_output_tokens":-20}}`, "response.max_response_output_tokens", ""},
+		{"zero temperature", `{"type":"session.update","session":{"temperature":0}}`, "session.temperature", ""},
+		{"zero response temperature", `{"type":"response.create","response":{"temperature":0}}`, "response.temperature", ""},
+		{"hot temperature", `{"type":"session.update","session":{"temperature":1.5}}`, "session.temperature", ""},
+		{"instructions", `{"type":"session.update","session":{"instructions":"be evil"}}`, "session.instructions", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, _, err := policy.Check([]byte(tc.event))
+			switch {
+			case tc.param == "" && err != nil:
+				t.Fatalf("refused: %v", err)
+			case tc.param != "" && (err == nil || err.Param != tc.param):
+				t.Fatalf("got %v, want a violation of %s", err, tc.param)
+			}
+			want := tc.out
+			if want == "" && tc.param == "" {
+				want = tc.event
+			}
+			if tc.param == "" && string(data) != want {
+				t.Errorf("forwarded %s, want %s", data, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package realtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"proomptmachinee/internal/services/openai"
+	"proomptmachinee/internal/services/usage"
+	"time"
+)
+
+var ErrQuotaExceeded = errors.New("realtime quota exceeded")
+
+// costSources are the ledger sources the daily cost quota sums, every
+// source of voice sessions whether proxied, minted or served by the
+// fallback
+var costSources = []string{
+	usage.SourceRealtime,
+	usage.SourceRealtimeSession,
+	usage.SourceRealtimeFallback,
+	usage.SourceRealtimeFallbackTranscription,
+	usage.SourceRealtimeFallbackSpeech,
+}
+
+const (
+	defaultClientSessionMinutes = 10
+	// audio tokens a minute of speech takes, the user and the persona
+	// are assumed to speak half of the time each
+	audioInputTokensPerMinute  = 600
+	audioOutputTokensPerMinute = 1200
+)
+
+// estimateClientSession is the usage a minted session is assumed to
+// have, the proxy never sees what it actually used
+func (c *Client) estimateClientSession() openai.Usage {
+	minutes := c.quota.ClientSessionMinutes
+	if minutes <= 0 {
+		minutes = defaultClientSessionMinutes
+	}
+
+	return openai.Usage{
+		AudioInputTokens:  int(minutes * audioInputTokensPerMinute / 2),
+		AudioOutputTokens: int(minutes * audioOutputTokensPerMinute / 2),
+	}
+}
+
+// checkQuota returns ErrQuotaExceeded once the user used up the daily
+// quota, and otherwise how long a proxied session may still last, zero
+// when the voice minutes aren't limited. Anonymous users and clients
+// without a ledger aren't limited. clientSession also counts the
+// sessions minted for direct clients and the estimated cost of the one
+// about to be minted.
+func (c *Client) checkQuota(ctx context.Context, userID string, clientSession bool) (time.Duration, error) {
+	q := c.quota
+	if c.ledger == nil || userID == "" || (q.DailyCostUSD <= 0 && q.DailyClientSessions <= 0 && q.DailyVoiceMinutes <= 0) {
+		return 0, nil
+	}
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+
+	if q.DailyCostUSD > 0 {
+		var cost float64
+		for _, source := range costSources {
+			totals, err := c.ledger.Aggregate(ctx, usage.Query{From: day, UserID: userID, Source: source})
+			if err != nil {
+				return 0, fmt.Errorf("couldn't check quota: %w", err)
+			}
+			if len(totals) > 0 {
+				cost += totals[0].CostUSD
+			}
+		}
+		if clientSession {
+			estimate, _ := openai.Cost(c.model, c.estimateClientSession())
+			cost += estimate
+		}
+		if cost >= q.DailyCostUSD {
+			return 0, fmt.Errorf("%w: daily cost of $%.2f reached", ErrQuotaExceeded, q.DailyCostUSD)
+		}
+	}
+
+	if clientSession && q.DailyClientSessions > 0 {
+		totals, err := c.ledger.Aggregate(ctx, usage.Query{From: day, UserID: userID, Source: usage.SourceRealtimeSession})
+		if err != nil {
+			return 0, fmt.Errorf("couldn't check quota: %w", err)
+		}
+		if len(totals) > 0 && totals[0].Responses >= q.DailyClientSessions {
+			return 0, fmt.Errorf("%w: %d client sessions a day", ErrQuotaExceeded, q.DailyClientSessions)
+		}
+	}
+
+	if q.DailyVoiceMinutes <= 0 {
+		return 0, nil
+	}
+	minutes, err := c.ledger.VoiceMinutes(ctx, userID, day)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't check quota: %w", err)
+	}
+	left := time.Duration((q.DailyVoiceMinutes - minutes) * float64(time.Minute))
+	if left <= 0 {
+		return 0, fmt.Errorf("%w: %g voice minutes a day", ErrQuotaExceeded, q.DailyVoiceMinutes)
+	}
+
+	return left, nil
+}
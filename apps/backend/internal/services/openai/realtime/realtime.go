@@ -1,13 +1,22 @@
 package realtime
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"proomptmachinee/internal/services/openai"
+	"proomptmachinee/internal/audio"
+	"proomptmachinee/internal/config"
+	"proomptmachinee/internal/services/conversations"
+	"proomptmachinee/internal/services/personas"
+	"proomptmachinee/internal/services/recordings"
+	"proomptmachinee/internal/services/tools"
+	"proomptmachinee/internal/services/usage"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
@@ -18,38 +27,107 @@ const (
 	OpenAiModelQueryKey   = "model"
 )
 
+// how long a client opening the session with `handshake=message` has
+// to send its options
+const handshakeWait = 5 * time.Second
+
 type Client struct {
-	key     string
-	url     string
-	model   string
-	headers http.Header
+	key       string
+	url       string
+	model     string
+	headers   http.Header
+	dialer    *websocket.Dialer
+	personas  *personas.Catalog
+	allowlist *Allowlist
+	policy    *EventPolicy
+	journal   config.RealtimeJournal
+	telephony config.TelephonyConfig
+	quota     config.RealtimeQuota
+	limits    config.RealtimeLimits
+	sessions  *sessionRegistry
+	// rooms is nil when clients can't share sessions
+	rooms          *roomRegistry
+	allowAnonymous bool
+	// upgrader checks the origin of client connections
+	upgrader            *websocket.Upgrader
+	maxMessageSize      int64
+	maxAppendsPerSecond int
+	writeTimeout        time.Duration
+	// resumeAttempts is how often a dropped OpenAI connection is
+	// redialed, sessions aren't resumed when it isn't positive
+	resumeAttempts int
+	// fallback is nil when sessions fail without OpenAI realtime
+	fallback *Fallback
+	// minter creates the sessions of direct WebRTC clients
+	minter SessionMinter
+	// tools is nil when sessions have no tools
+	tools       *tools.Registry
+	toolTimeout time.Duration
+	// ledger is nil when usage isn't recorded, e.g. when replaying
+	ledger Ledger
+	// conversations is nil when transcripts aren't stored
+	conversations *conversations.Store
+	// recordings is nil when recording is disabled
+	recordings *recordings.Recordings
 }
 
-func NewRealtimeClient(key string, model string) *Client {
+func NewRealtimeClient(key string, model string, cfg config.RealtimeConfig, personas *personas.Catalog, ledger *usage.Ledger, conversations *conversations.Store, recordings *recordings.Recordings, tools *tools.Registry) *Client {
 	headers := http.Header{}
 	headers.Set(OpenAiBetaHeaderKey, OpenAiBetaHeaderValue)
 	authString := fmt.Sprintf("Bearer %s", key)
 	headers.Set("Authorization", authString)
-	return &Client{
-		key:     key,
-		url:     OpenAiRealtimeUrl,
-		model:   model,
-		headers: headers,
+	c := &Client{
+		key:        key,
+		url:        OpenAiRealtimeUrl,
+		model:      model,
+		headers:    headers,
+		dialer:     &websocket.Dialer{HandshakeTimeout: 10 * time.Second},
+		personas:   personas,
+		recordings: recordings,
+		tools:      tools,
+	}
+	// a nil *usage.Ledger would make a non-nil interface
+	if ledger != nil {
+		c.ledger = ledger
+	}
+	c.allowlist = NewAllowlist(cfg)
+	c.policy = NewEventPolicy(cfg.ClientEvents, c.allowlist)
+	c.journal = cfg.Journal
+	c.telephony = cfg.Telephony
+	c.quota = cfg.Quota
+	c.limits = cfg.Limits
+	c.allowAnonymous = cfg.AllowAnonymous
+	c.upgrader = newUpgrader(cfg.Connection.AllowedOrigins)
+	c.maxMessageSize = cfg.Connection.MaxMessageSize
+	if c.maxMessageSize <= 0 {
+		c.maxMessageSize = defaultMaxMessageSize
+	}
+	c.maxAppendsPerSecond = cfg.Connection.MaxAppendsPerSecond
+	c.writeTimeout = cfg.Connection.WriteTimeout
+	if c.writeTimeout <= 0 {
+		c.writeTimeout = defaultWriteTimeout
+	}
+	c.sessions = newSessionRegistry(cfg.MaxSessionsPerUser, cfg.MaxSessions)
+	if cfg.Rooms.Enabled {
+		c.rooms = newRoomRegistry(cfg.Rooms.MaxParticipants, c.writeTimeout)
+	}
+	c.minter = NewSessionMinter(key, cfg.SessionsURL)
+	c.resumeAttempts = cfg.ResumeAttempts
+	if c.resumeAttempts == 0 {
+		c.resumeAttempts = defaultResumeAttempts
+	}
+	c.toolTimeout = cfg.ToolTimeout
+	if c.toolTimeout <= 0 {
+		c.toolTimeout = defaultToolTimeout
+	}
+	if cfg.URL != "" {
+		c.url = cfg.URL
+	}
+	if cfg.StoreTranscripts {
+		c.conversations = conversations
 	}
-}
-
-var upgrader = websocket.Upgrader{
-	// Buffer sizes for real-time audio streaming
-	ReadBufferSize:  8192, // 8 KB for raw PCM (24kHz)
-	WriteBufferSize: 8192, // Can adjust for specific formats
-
-	// Adjust for G.711 if needed
-	// ReadBufferSize:  2048, // 2 KB for G.711 (8kHz)
-	// WriteBufferSize: 2048,
 
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+	return c
 }
 
 type Message struct {
@@ -57,136 +135,413 @@ type Message struct {
 	Type    int
 }
 
-// InputAudioTranscription represents the optional audio transcription settings
+const (
+	EventTypeSessionUpdate = "session.update"
+
+	ToolChoiceAuto = "auto"
+	ToolChoiceNone = "none"
+)
+
+// InputAudioTranscription represents the optional audio transcription
+// settings, an empty Model is sent as null which turns transcription off
 type InputAudioTranscription struct {
-	Enable *bool   `json:"enable,omitempty"`
-	Model  *string `json:"model,omitempty"`
+	Model string `json:"model"`
+}
+
+func (t *InputAudioTranscription) MarshalJSON() ([]byte, error) {
+	if t.Model == "" {
+		return []byte("null"), nil
+	}
+	type alias InputAudioTranscription
+	return json.Marshal((*alias)(t))
+}
+
+// TurnDetection configures voice activity detection, TurnDetectionNone
+// is sent as null which turns it off
+type TurnDetection struct {
+	Type              string   `json:"type"`
+	Threshold         *float64 `json:"threshold,omitempty"`
+	PrefixPaddingMs   *int     `json:"prefix_padding_ms,omitempty"`
+	SilenceDurationMs *int     `json:"silence_duration_ms,omitempty"`
+}
+
+func (t *TurnDetection) MarshalJSON() ([]byte, error) {
+	if t.Type == TurnDetectionNone {
+		return []byte("null"), nil
+	}
+	type alias TurnDetection
+	return json.Marshal((*alias)(t))
+}
+
+// Tool is a function the model can call
+type Tool struct {
+	Type        string                 `json:"type"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
 }
 
 // Session represents the session update details
 type Session struct {
-	Instructions            *string                  `json:"instructions,omitempty"`
+	Modalities              []string                 `json:"modalities,omitempty"`
+	Instructions            string                   `json:"instructions,omitempty"`
+	Voice                   string                   `json:"voice,omitempty"`
+	InputAudioFormat        string                   `json:"input_audio_format,omitempty"`
+	OutputAudioFormat       string                   `json:"output_audio_format,omitempty"`
 	InputAudioTranscription *InputAudioTranscription `json:"input_audio_transcription,omitempty"`
-	Voice                   *string                  `json:"voice,omitempty"`
+	TurnDetection           *TurnDetection           `json:"turn_detection,omitempty"`
+	Tools                   []Tool                   `json:"tools,omitempty"`
+	ToolChoice              string                   `json:"tool_choice,omitempty"`
+	Temperature             float64                  `json:"temperature,omitempty"`
+	MaxResponseOutputTokens *MaxTokens               `json:"max_response_output_tokens,omitempty"`
 }
 
 // SessionUpdate represents the overall update message
 type SessionUpdate struct {
 	Type    string   `json:"type"`
+	EventID string   `json:"event_id,omitempty"`
 	Session *Session `json:"session,omitempty"`
 }
 
-// TODO close connection with OpenAi when client closes the connection
-func (c *Client) WsHandler(w http.ResponseWriter, r *http.Request) error {
+func (u *SessionUpdate) EventType() string {
+	return u.Type
+}
+
+// Caller is who opens a session, the api authenticates it
+type Caller struct {
+	UserID string
+	// Roles are the realm roles picking the session limits
+	Roles []string
+	// ExpiresAt is when the caller's token expires and the session is
+	// closed, zero when it doesn't
+	ExpiresAt time.Time
+}
+
+// WsHandler upgrades the request and proxies it to an OpenAI realtime
+// session. It blocks until both connections are closed.
+func (c *Client) WsHandler(w http.ResponseWriter, r *http.Request, caller *Caller) error {
+	if caller == nil && !c.allowAnonymous {
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return ErrUnauthenticated
+	}
+	roomID := r.URL.Query().Get("room")
+	if roomID != "" && c.rooms == nil {
+		http.Error(w, "rooms are disabled", http.StatusBadRequest)
+		return fmt.Errorf("%w: rooms are disabled", ErrRoomNotFound)
+	}
+	if roomID != "" && roomID != RoomNew {
+		return c.joinRoom(w, r, caller, roomID)
+	}
+	// the other participants didn't agree to their words being stored
+	// in the host's conversation
+	if roomID == RoomNew && r.URL.Query().Has("conversation_id") {
+		http.Error(w, "rooms don't store transcripts", http.StatusBadRequest)
+		return fmt.Errorf("%w: rooms don't store transcripts", ErrInvalidOptions)
+	}
+
+	// transcripts go to the conversation the client continues, or to a
+	// new one it learns about from the upgrade response
+	conversationID := uuid.New()
+	if id := r.URL.Query().Get("conversation_id"); id != "" {
+		var err error
+		if conversationID, err = uuid.Parse(id); err != nil {
+			http.Error(w, "invalid conversation_id", http.StatusBadRequest)
+			return fmt.Errorf("invalid conversation id %q: %w", id, err)
+		}
+	}
+	header := http.Header{}
+	storeTranscripts := c.conversations != nil && roomID == ""
+	if storeTranscripts {
+		header.Set(ConversationIDHeader, conversationID.String())
+	}
+	if roomID == RoomNew {
+		roomID = uuid.NewString()
+		header.Set(RoomIDHeader, roomID)
+	}
+
 	// Upgrade connection with client from Http to WebSocket
-	clientConn, err := upgrader.Upgrade(w, r, nil)
+	clientConn, err := c.upgrade(w, r, header)
 	if err != nil {
-		http.Error(w, "couldn't upgrade connection", http.StatusInternalServerError)
-		return errors.New("couldn't upgrade connection")
-	}
-
-	log.Println("WebSocket connection opened with client", r.Header.Get(""))
-
-	// Open websocket connection with Open Ai
-	openAiConn, resp, err := websocket.DefaultDialer.Dial(OpenAiRealtimeUrl+"?"+OpenAiModelQueryKey+"="+openai.Gpt40RealtimePreview, c.headers)
-	// TODO sent intial message to Open Ai of type `session.update`
-	// to update the session's default configuration
-	// Writing JSON using an inline struct
-	// FIXME refactor
-	// Define session update message
-	instructions := "Molim te, odgovaraj na hrvatskom jeziku. Preuzmi ulogu Isusa Krista tijekom ovog razgovora. Ja sam Hrvat i želim razgovarati o Bibliji."
-	voice := "ash"
-	sessionUpdate := SessionUpdate{
-		Type: "session.update",
-		Session: &Session{
-			Instructions: &instructions,
-			// InputAudioTranscription is nil, so it will be omitted in JSON
-			Voice: &voice,
-		},
-	}
-
-	if err := openAiConn.WriteJSON(sessionUpdate); err != nil {
-		log.Println("Error sending session update:", err)
-		return err
+		// the upgrader already replied with an error
+		return fmt.Errorf("couldn't upgrade connection: %w", err)
 	}
 
-	if err != nil {
-		if resp != nil {
-			return fmt.Errorf("openAi response status: %s", resp.Status)
+	// anonymous clients get the default limits and their usage isn't
+	// attributed
+	sessionID := uuid.NewString()
+	var userID string
+	var roles []string
+	var expiresAt time.Time
+	if caller != nil {
+		userID, roles, expiresAt = caller.UserID, caller.Roles, caller.ExpiresAt
+	}
+	log.Printf("realtime session %s opened with client %s", sessionID, r.RemoteAddr)
+
+	var frames *journal
+	if c.journal.Enabled {
+		if frames, err = openJournal(c.journal.Dir, sessionID); err != nil {
+			log.Printf("realtime session %s: couldn't open journal: %v", sessionID, err)
 		}
-		closeMessage := websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "failed to connect to OpenAi")
-		if writeErr := clientConn.WriteMessage(websocket.CloseMessage, closeMessage); writeErr != nil {
-			return fmt.Errorf("couldn't send close message to client: %v", writeErr)
+		defer frames.close()
+		frames.start(sessionID, r.URL.RawQuery)
+	}
+
+	setup, err := c.handshake(clientConn, r, frames)
+	if err != nil {
+		code, reason := websocket.CloseInternalServerErr, "couldn't configure session"
+		if errors.Is(err, ErrInvalidOptions) || errors.Is(err, personas.ErrNotFound) {
+			code, reason = websocket.ClosePolicyViolation, err.Error()
 		}
-		return fmt.Errorf("failed to connect to OpenAI: %w", err)
-	}
-
-	var openAiReceivedMessages = make(chan *Message, 10)
-
-	// Read messages from OpenAi
-	go func() {
-		defer func() {
-			close(openAiReceivedMessages)
-		}()
-		for {
-			messageType, messageContent, err := openAiConn.ReadMessage()
-			var result interface{}
-			json.Unmarshal(messageContent, &result)
-			log.Printf("received message from Open Ai: %v, %v, %v", messageType, result, err)
-			if err != nil {
-				log.Printf("couldn't read message from OpenAI: %v", err)
-				msg := &Message{
-					Content: websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "OpenAI disconnected"),
-					Type:    websocket.CloseMessage,
-				}
-				openAiReceivedMessages <- msg
-				break
-			}
-			msg := &Message{
-				Content: messageContent,
-				Type:    messageType,
+		writeClose(clientConn, code, reason)
+		clientConn.Close()
+		return fmt.Errorf("handshake failed: %w", err)
+	}
+	// only the host could have consented, the room's audio isn't
+	// recorded
+	if roomID != "" && setup.options.RecordingConsent {
+		writeClose(clientConn, websocket.ClosePolicyViolation, "rooms can't be recorded")
+		clientConn.Close()
+		return fmt.Errorf("%w: rooms can't be recorded", ErrInvalidOptions)
+	}
+
+	if storeTranscripts {
+		_, err := c.conversations.Ensure(r.Context(), conversationID, userID, setup.persona.Name)
+		if err != nil {
+			code, reason := websocket.CloseInternalServerErr, "couldn't open conversation"
+			if errors.Is(err, conversations.ErrNotFound) {
+				code, reason = websocket.ClosePolicyViolation, "conversation not found"
 			}
-			openAiReceivedMessages <- msg
+			writeClose(clientConn, code, reason)
+			clientConn.Close()
+			return fmt.Errorf("couldn't open conversation %s: %w", conversationID, err)
+		}
+	}
+
+	var transcriptID *uuid.UUID
+	if storeTranscripts {
+		transcriptID = &conversationID
+	}
+
+	// the host is the first participant of a new room, the session
+	// serves the room from then on
+	var client wsConn = clientConn
+	if roomID != "" {
+		client = c.rooms.open(roomID, setup.persona.Name, clientConn, userID, expiresAt)
+	}
+
+	return c.serve(r.Context(), client, &sessionParams{
+		id:             sessionID,
+		userID:         userID,
+		roles:          roles,
+		expiresAt:      expiresAt,
+		setup:          setup,
+		journal:        frames,
+		conversationID: transcriptID,
+	})
+}
+
+// sessionParams describe a session whose client side is set up
+type sessionParams struct {
+	id     string
+	userID string
+	// roles pick the session limits
+	roles []string
+	// expiresAt ends the session with the caller's token, zero when it
+	// doesn't
+	expiresAt time.Time
+	setup     *sessionSetup
+	// journal is nil when frames aren't journaled
+	journal *journal
+	// conversationID is where the transcripts go, nil when they aren't
+	// stored
+	conversationID *uuid.UUID
+	// mediaStream is set when the client is a telephony media stream
+	mediaStream *mediaStream
+}
+
+// serve connects the client to an OpenAI realtime session and proxies it
+// until either side ends it
+func (c *Client) serve(ctx context.Context, clientConn wsConn, p *sessionParams) error {
+	quota, err := c.checkQuota(ctx, p.userID, false)
+	if err != nil {
+		code, reason := websocket.CloseInternalServerErr, "couldn't check quota"
+		if errors.Is(err, ErrQuotaExceeded) {
+			code, reason = websocket.ClosePolicyViolation, err.Error()
 		}
-	}()
+		writeClose(clientConn, code, reason)
+		clientConn.Close()
+		return err
+	}
 
-	// Send OpenAi messages back to client
-	go func() {
-		for message := range openAiReceivedMessages {
-			clientConn.WriteMessage(message.Type, message.Content)
+	// the request context is cancelled once the handler returns, the
+	// session is only bound to the server's lifetime and the registry
+	ctx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+	defer cancel(nil)
+	source := SessionSourceWebSocket
+	if p.mediaStream != nil {
+		source = SessionSourceTelephony
+	}
+	_, inRoom := clientConn.(*room)
+	if inRoom {
+		source = SessionSourceRoom
+	}
+	active := ActiveSession{ID: p.id, UserID: p.userID, Persona: p.setup.persona.Name, Source: source, StartedAt: time.Now()}
+	if err := c.sessions.add(active, cancel); err != nil {
+		code, reason := websocket.CloseInternalServerErr, "couldn't start session"
+		var closeErr *CloseError
+		if errors.As(err, &closeErr) {
+			code, reason = closeErr.Code, closeErr.Reason
 		}
-	}()
+		writeClose(clientConn, code, reason)
+		clientConn.Close()
+		return err
+	}
+	defer c.sessions.remove(p.id)
 
-	var clientReceivedMessages = make(chan *Message, 10)
+	upstream, model, err := c.dialUpstream(ctx, p)
+	if err != nil {
+		writeClose(clientConn, websocket.CloseInternalServerErr, "failed to connect to OpenAI")
+		clientConn.Close()
+		return err
+	}
 
-	// Send client messages to OpenAi
-	go func() {
-		for message := range clientReceivedMessages {
-			openAiConn.WriteMessage(message.Type, message.Content)
+	c.configureTools(p.setup)
+	update, err := json.Marshal(p.setup.update)
+	if err != nil {
+		writeClose(clientConn, websocket.CloseInternalServerErr, "failed to configure OpenAI session")
+		clientConn.Close()
+		upstream.Close()
+		return fmt.Errorf("couldn't encode session update: %w", err)
+	}
+	p.journal.frame(PeerProxy, PeerUpstream, &Message{Content: update, Type: websocket.TextMessage})
+	upstream.SetWriteDeadline(time.Now().Add(upstreamWriteWait))
+	if err := upstream.WriteMessage(websocket.TextMessage, update); err != nil {
+		writeClose(clientConn, websocket.CloseInternalServerErr, "failed to configure OpenAI session")
+		clientConn.Close()
+		upstream.Close()
+		return fmt.Errorf("couldn't send session update: %w", err)
+	}
+
+	if c.ledger != nil {
+		if err := c.ledger.StartSession(ctx, p.id, p.userID, model); err != nil {
+			log.Printf("couldn't record realtime session start: %v", err)
 		}
-	}()
-
-	// Receive messages from client
-	go func() {
-		for {
-			messageType, messageContent, err := clientConn.ReadMessage()
-			var result interface{}
-			json.Unmarshal(messageContent, &result)
-			log.Printf("received message from client: %v, %v, %v", messageType, result, err)
-			if err != nil {
-				log.Printf("couldn't read message from client: %v", err)
-				// close connection with OpenAi
-				// TODO rethink this
-				openAiConn.Close()
-				break
-			}
-			msg := &Message{
-				Content: messageContent,
-				Type:    messageType,
-			}
-			clientReceivedMessages <- msg
+	}
+
+	session := newProxySession(c, p.id, p.userID, clientConn, upstream)
+	p.journal.withAudio(c.journal.IncludeAudio && p.setup.options.RecordingConsent)
+	session.journal = p.journal
+	session.mediaStream = p.mediaStream
+	session.limits = c.limitsFor(p.roles)
+	session.limits.quota = quota
+	// a room outlives its host's token, participants leave when theirs
+	// expire
+	if !inRoom {
+		session.limits.expires = p.expiresAt
+	}
+	session.instructions = p.setup.update.Session.Instructions
+	session.tools = p.setup.persona.Tools
+	// the cascaded fallback runs in process, it can't drop. Sessions
+	// that aren't resumed still switch to the fallback.
+	if model != ModelCascade && (c.resumeAttempts > 0 || c.fallback != nil) {
+		session.history = newHistory(p.setup.update.Session)
+	}
+	if format := p.setup.options.Audio(); format != audio.Realtime {
+		// the formats were validated by the handshake
+		session.inputAudio, _ = audio.NewConverter(format, audio.Realtime)
+		session.outputAudio, _ = audio.NewConverter(audio.Realtime, format)
+	}
+	if vad := c.allowlist.VAD(p.setup.options); vad != nil {
+		session.vad = audio.NewVAD(*vad, audio.RealtimeSampleRate)
+	}
+	if p.conversationID != nil {
+		session.transcript = newTranscript(c.conversations, *p.conversationID, p.id, p.setup.persona.Name, c.model)
+	}
+	if c.recordings != nil && p.setup.options.RecordingConsent {
+		if session.recorder, err = c.recordings.Start(p.id); err != nil {
+			// the conversation can go on without the recording
+			log.Printf("realtime session %s: couldn't start recording: %v", p.id, err)
 		}
-	}()
+	}
+	sessionErr := session.run(ctx)
+	log.Printf("realtime session %s closed: %v", p.id, sessionErr)
+
+	if c.ledger != nil {
+		if err := c.ledger.EndSession(context.Background(), p.id, &session.totals, sessionErr); err != nil {
+			log.Printf("couldn't record realtime session end: %v", err)
+		}
+	}
+
+	return sessionErr
+}
+
+// dialUpstream connects to OpenAI realtime, or to the cascaded fallback
+// when it can't be reached. It returns the model serving the session.
+func (c *Client) dialUpstream(ctx context.Context, p *sessionParams) (wsConn, string, error) {
+	conn, err := c.dial(ctx)
+	if err == nil {
+		return conn, c.model, nil
+	}
+	if c.fallback == nil || ctx.Err() != nil {
+		return nil, "", err
+	}
+
+	log.Printf("realtime session %s: falling back to the cascaded pipeline: %v", p.id, err)
+	return c.fallback.open(c, p.userID, p.id), ModelCascade, nil
+}
+
+// dial connects to OpenAI realtime
+func (c *Client) dial(ctx context.Context) (*websocket.Conn, error) {
+	conn, resp, err := c.dialer.DialContext(ctx, c.url+"?"+OpenAiModelQueryKey+"="+c.model, c.headers)
+	if err == nil {
+		return conn, nil
+	}
+	if resp != nil {
+		return nil, fmt.Errorf("failed to connect to OpenAI, response status %s: %w", resp.Status, err)
+	}
+
+	return nil, fmt.Errorf("failed to connect to OpenAI: %w", err)
+}
+
+// sessionSetup is what the client asked for in the handshake
+type sessionSetup struct {
+	options *SessionOptions
+	persona *personas.Persona
+	update  *SessionUpdate
+}
+
+// handshake reads the session options from the query, or from the first
+// client message when the query has `handshake=message`, and builds the
+// session update for them
+func (c *Client) handshake(clientConn *limitedConn, r *http.Request, frames *journal) (*sessionSetup, error) {
+	query := r.URL.Query()
+	var opts *SessionOptions
+	var err error
+	if query.Get("handshake") == HandshakeMessage {
+		clientConn.SetReadDeadline(time.Now().Add(handshakeWait))
+		messageType, data, readErr := clientConn.ReadMessage()
+		if readErr != nil {
+			return nil, fmt.Errorf("couldn't read session options: %w", readErr)
+		}
+		frames.frame(PeerClient, PeerProxy, &Message{Content: data, Type: messageType})
+		clientConn.SetReadDeadline(time.Time{})
+		opts, err = parseOptionsMessage(data)
+	} else {
+		opts, err = parseQueryOptions(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	persona, err := c.personas.Get(opts.Persona)
+	if err != nil {
+		return nil, fmt.Errorf("persona %q: %w", opts.Persona, err)
+	}
+
+	update, err := c.allowlist.Build(opts, persona)
+	if err != nil {
+		return nil, err
+	}
 
-	return nil
+	return &sessionSetup{options: opts, persona: persona, update: update}, nil
 }
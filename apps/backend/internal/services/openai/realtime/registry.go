@@ -0,0 +1,140 @@
+package realtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// CloseSessionKilled is the close code of sessions an admin ended
+const CloseSessionKilled = 4002
+
+var (
+	ErrTooManySessions = errors.New("too many realtime sessions")
+	ErrSessionNotFound = errors.New("realtime session not found")
+	ErrSessionKilled   = errors.New("realtime session killed")
+)
+
+const (
+	SessionSourceWebSocket = "websocket"
+	SessionSourceTelephony = "telephony"
+	SessionSourceRoom      = "room"
+)
+
+// ActiveSession is a proxied session that is open
+type ActiveSession struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id,omitempty"`
+	Persona   string    `json:"persona"`
+	Source    string    `json:"source"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+type registeredSession struct {
+	ActiveSession
+	cancel context.CancelCauseFunc
+}
+
+// sessionRegistry tracks the open sessions so their number can be
+// limited, per user and in total, and an admin can end them. Anonymous
+// sessions only count towards the total.
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[string]*registeredSession
+	perUser  map[string]int
+	// maxPerUser and maxTotal of zero don't limit
+	maxPerUser int
+	maxTotal   int
+}
+
+func newSessionRegistry(maxPerUser, maxTotal int) *sessionRegistry {
+	return &sessionRegistry{
+		sessions:   make(map[string]*registeredSession),
+		perUser:    make(map[string]int),
+		maxPerUser: maxPerUser,
+		maxTotal:   maxTotal,
+	}
+}
+
+// add registers a session unless a limit is reached, cancel ends it when
+// it is killed. The returned error is a *CloseError telling the client
+// which limit it ran into.
+func (r *sessionRegistry) add(session ActiveSession, cancel context.CancelCauseFunc) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxTotal > 0 && len(r.sessions) >= r.maxTotal {
+		return newCloseError(websocket.CloseTryAgainLater, "too many voice sessions, try again later",
+			fmt.Errorf("%w: %d open", ErrTooManySessions, len(r.sessions)))
+	}
+	if session.UserID != "" && r.maxPerUser > 0 && r.perUser[session.UserID] >= r.maxPerUser {
+		return newCloseError(websocket.ClosePolicyViolation, fmt.Sprintf("too many voice sessions for the user, the limit is %d", r.maxPerUser),
+			fmt.Errorf("%w: user %s has %d open", ErrTooManySessions, session.UserID, r.perUser[session.UserID]))
+	}
+
+	r.sessions[session.ID] = &registeredSession{ActiveSession: session, cancel: cancel}
+	if session.UserID != "" {
+		r.perUser[session.UserID]++
+	}
+
+	return nil
+}
+
+func (r *sessionRegistry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session, ok := r.sessions[id]
+	if !ok {
+		return
+	}
+	delete(r.sessions, id)
+	if session.UserID == "" {
+		return
+	}
+	if r.perUser[session.UserID]--; r.perUser[session.UserID] <= 0 {
+		delete(r.perUser, session.UserID)
+	}
+}
+
+// list returns the open sessions, the oldest first
+func (r *sessionRegistry) list() []*ActiveSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sessions := make([]*ActiveSession, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		session := s.ActiveSession
+		sessions = append(sessions, &session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt.Before(sessions[j].StartedAt)
+	})
+
+	return sessions
+}
+
+func (r *sessionRegistry) kill(id string) error {
+	r.mu.Lock()
+	session, ok := r.sessions[id]
+	r.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+	session.cancel(newCloseError(CloseSessionKilled, "session ended by an administrator", ErrSessionKilled))
+
+	return nil
+}
+
+// ActiveSessions lists the open proxied sessions
+func (c *Client) ActiveSessions() []*ActiveSession {
+	return c.sessions.list()
+}
+
+// KillSession ends an open session, the client is told an admin ended
+// it. It returns ErrSessionNotFound when no session has the id.
+func (c *Client) KillSession(id string) error {
+	return c.sessions.kill(id)
+}
@@ -0,0 +1,116 @@
+package realtime
+
+import (
+	"errors"
+	"net/http"
+	"proomptmachinee/internal/config"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRegistryLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		maxPerUser int
+		maxTotal   int
+		open       []string
+		add        string
+		code       int
+	}{
+		{"unlimited", 0, 0, []string{"a", "a", "b"}, "a", 0},
+		{"user limit", 2, 0, []string{"a", "a"}, "a", websocket.ClosePolicyViolation},
+		{"other user", 2, 0, []string{"a", "a"}, "b", 0},
+		{"total limit", 0, 3, []string{"a", "b", "c"}, "d", websocket.CloseTryAgainLater},
+		{"total before user limit", 1, 2, []string{"a", "b"}, "a", websocket.CloseTryAgainLater},
+		{"anonymous aren't limited per user", 1, 0, []string{"", ""}, "", 0},
+		{"anonymous count toward the total", 1, 2, []string{"", ""}, "a", websocket.CloseTryAgainLater},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newSessionRegistry(tc.maxPerUser, tc.maxTotal)
+			for i, userID := range tc.open {
+				if err := r.add(ActiveSession{ID: string(rune('0' + i)), UserID: userID}, func(error) {}); err != nil {
+					t.Fatalf("couldn't add session %d: %v", i, err)
+				}
+			}
+			err := r.add(ActiveSession{ID: "new", UserID: tc.add}, func(error) {})
+			var closeErr *CloseError
+			switch {
+			case tc.code == 0 && err != nil:
+				t.Fatalf("refused: %v", err)
+			case tc.code != 0 && (!errors.As(err, &closeErr) || closeErr.Code != tc.code || !errors.Is(err, ErrTooManySessions)):
+				t.Fatalf("got %v, want code %d", err, tc.code)
+			}
+		})
+	}
+}
+
+func TestRegistryRemove(t *testing.T) {
+	r := newSessionRegistry(1, 2)
+	r.add(ActiveSession{ID: "1", UserID: "a"}, func(error) {})
+	r.add(ActiveSession{ID: "2"}, func(error) {})
+	r.remove("1")
+	r.remove("1")
+	if err := r.add(ActiveSession{ID: "3", UserID: "a"}, func(error) {}); err != nil {
+		t.Fatalf("user's slot wasn't freed: %v", err)
+	}
+	r.remove("2")
+	if err := r.add(ActiveSession{ID: "4", UserID: "b"}, func(error) {}); err != nil {
+		t.Fatalf("anonymous slot wasn't freed: %v", err)
+	}
+	if n := len(r.perUser); n != 2 {
+		t.Errorf("counting %d users, want 2", n)
+	}
+}
+
+func TestRegistryKill(t *testing.T) {
+	r := newSessionRegistry(0, 0)
+	now := time.Now()
+	var cause error
+	r.add(ActiveSession{ID: "late", StartedAt: now}, func(error) {})
+	r.add(ActiveSession{ID: "early", StartedAt: now.Add(-time.Minute)}, func(err error) { cause = err })
+	if sessions := r.list(); len(sessions) != 2 || sessions[0].ID != "early" {
+		t.Fatalf("listed %v, want the oldest first", sessions)
+	}
+
+	if err := r.kill("early"); err != nil {
+		t.Fatalf("couldn't kill: %v", err)
+	}
+	var closeErr *CloseError
+	if !errors.As(cause, &closeErr) || closeErr.Code != CloseSessionKilled || !errors.Is(cause, ErrSessionKilled) {
+		t.Errorf("session was cancelled with %v", cause)
+	}
+	if err := r.kill("missing"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("got %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestKillSession(t *testing.T) {
+	p := newTestProxy(t, config.RealtimeConfig{})
+	client, _, err := websocket.DefaultDialer.Dial(p.url, http.Header{testUserHeader: {"user-1"}})
+	if err != nil {
+		t.Fatalf("couldn't connect to the proxy: %v", err)
+	}
+	defer client.Close()
+	upstream := <-p.upstreams
+	defer upstream.Close()
+
+	sessions := p.client.ActiveSessions()
+	if len(sessions) != 1 || sessions[0].UserID != "user-1" || sessions[0].Source != SessionSourceWebSocket {
+		t.Fatalf("active sessions %v", sessions)
+	}
+	if err := p.client.KillSession(sessions[0].ID); err != nil {
+		t.Fatalf("couldn't kill: %v", err)
+	}
+
+	if closeErr := readClose(t, client); closeErr.Code != CloseSessionKilled {
+		t.Errorf("client got close %d %q, want %d", closeErr.Code, closeErr.Text, CloseSessionKilled)
+	}
+	if err := p.ended(t); !errors.Is(err, ErrSessionKilled) {
+		t.Errorf("session ended with %v", err)
+	}
+	if n := len(p.client.ActiveSessions()); n != 0 {
+		t.Errorf("%d sessions still listed", n)
+	}
+}
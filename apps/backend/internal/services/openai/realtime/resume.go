@@ -0,0 +1,549 @@
+package realtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// EventTypeSessionResumed is sent to the client when the connection
+	// to OpenAI dropped and the session goes on over a new one
+	EventTypeSessionResumed = "session.resumed"
+
+	// how often a dropped OpenAI connection is redialed when the config
+	// doesn't say
+	defaultResumeAttempts = 3
+	// how long the second redial waits, every further one twice as long
+	resumeBackoff = 500 * time.Millisecond
+	// how long OpenAI may take to acknowledge the restored session
+	resumeWait = 10 * time.Second
+)
+
+var ErrResumeFailed = errors.New("couldn't resume session")
+
+// error codes with which OpenAI can't serve the session any longer, the
+// session falls back to the cascaded pipeline instead of redialing
+const (
+	errorCodeInsufficientQuota = "insufficient_quota"
+	errorCodeRateLimitExceeded = "rate_limit_exceeded"
+)
+
+// SessionResumedEvent tells the client that its conversation was
+// replayed to a new OpenAI connection, or to the cascaded fallback when
+// Fallback is set. Skipped items had no text to replay, e.g. user audio
+// that wasn't transcribed. A response that was in progress when the
+// connection dropped is lost and has to be asked for again.
+type SessionResumedEvent struct {
+	Event
+	Items               int  `json:"items"`
+	SkippedItems        int  `json:"skipped_items"`
+	ResponseInterrupted bool `json:"response_interrupted"`
+	Fallback            bool `json:"fallback,omitempty"`
+}
+
+// history is the server side copy of a session's conversation, it is
+// replayed once OpenAI was redialed. Items are kept as text, function
+// calls aren't kept. A nil history keeps nothing, which is what
+// sessions that aren't resumed use.
+type history struct {
+	mu sync.Mutex
+	// session is the config OpenAI confirmed last
+	session *Session
+	items   []*historyItem
+	// responding is set while a response is in progress
+	responding bool
+}
+
+type historyItem struct {
+	id   string
+	role string
+	// text is empty until user audio was transcribed
+	text string
+	// truncated is set once the item was cut to what the user heard,
+	// OpenAI's later events carry the full text
+	truncated bool
+}
+
+func newHistory(session *Session) *history {
+	return &history{session: session}
+}
+
+// track follows the server events that change the session config or the
+// conversation
+func (h *history) track(event TypedEvent) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch e := event.(type) {
+	case *SessionUpdatedEvent:
+		if e.Session != nil {
+			h.session = e.Session
+		}
+	case *ConversationItemCreatedEvent:
+		if e.Item != nil && e.Item.Type == ItemTypeMessage {
+			h.set(e.Item.ID, e.PreviousItemID, e.Item.Role, itemText(e.Item))
+		}
+	case *ResponseOutputItemEvent:
+		if e.Type == EventTypeResponseOutputItemDone && e.Item != nil && e.Item.Type == ItemTypeMessage {
+			h.set(e.Item.ID, "", e.Item.Role, itemText(e.Item))
+		}
+	case *InputAudioTranscriptionCompletedEvent:
+		h.set(e.ItemID, "", ItemRoleUser, e.Transcript)
+	case *ConversationItemDeletedEvent:
+		for i, item := range h.items {
+			if item.id == e.ItemID {
+				h.items = append(h.items[:i], h.items[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// set adds an item after previousID, or at the end when previousID is
+// unknown, or updates its text when it is already there
+func (h *history) set(id, previousID, role, text string) {
+	text = strings.TrimSpace(text)
+	for _, item := range h.items {
+		if item.id == id {
+			if text != "" && !item.truncated {
+				item.text = text
+			}
+			return
+		}
+	}
+	item := &historyItem{id: id, role: role, text: text}
+	for i, previous := range h.items {
+		if previous.id == previousID {
+			h.items = append(h.items[:i+1], append([]*historyItem{item}, h.items[i+1:]...)...)
+			return
+		}
+	}
+	h.items = append(h.items, item)
+}
+
+// truncate cuts an item to the text the user heard
+func (h *history) truncate(id, text string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, item := range h.items {
+		if item.id == id {
+			item.text, item.truncated = strings.TrimSpace(text), true
+			return
+		}
+	}
+}
+
+// trackResponse follows whether a response is in progress
+func (h *history) trackResponse(eventType string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.responding = eventType == EventTypeResponseCreated
+}
+
+// replay returns the events restoring the session on a new connection,
+// the session update first, and how many items have no text to replay
+func (h *history) replay() ([]TypedEvent, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	session := *h.session
+	// a missing turn detection would turn OpenAI's default back on
+	if session.TurnDetection == nil {
+		session.TurnDetection = &TurnDetection{Type: TurnDetectionNone}
+	}
+	events := []TypedEvent{&SessionUpdate{Type: EventTypeSessionUpdate, Session: &session}}
+	skipped := 0
+	for _, item := range h.items {
+		if item.text == "" {
+			skipped++
+			continue
+		}
+		contentType := ContentTypeInputText
+		if item.role == ItemRoleAssistant {
+			contentType = ContentTypeText
+		}
+		events = append(events, &ConversationItemCreateEvent{
+			Event: Event{Type: EventTypeConversationItemCreate},
+			Item: &Item{
+				ID:      item.id,
+				Type:    ItemTypeMessage,
+				Role:    item.role,
+				Content: []*ContentPart{{Type: contentType, Text: item.text}},
+			},
+		})
+	}
+
+	return events, skipped
+}
+
+// resumed reports whether a response was lost with the old connection
+// and forgets it
+func (h *history) resumed() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	interrupted := h.responding
+	h.responding = false
+
+	return interrupted
+}
+
+// itemText is the text of an item's content, the transcript of audio
+func itemText(item *Item) string {
+	var parts []string
+	for _, c := range item.Content {
+		switch {
+		case c.Text != "":
+			parts = append(parts, c.Text)
+		case c.Transcript != "":
+			parts = append(parts, c.Transcript)
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// upstreamUnavailable returns the code of an error or failed response
+// with which OpenAI refuses to go on with the session
+func upstreamUnavailable(data []byte) (string, bool) {
+	if !bytes.Contains(data, []byte(errorCodeInsufficientQuota)) && !bytes.Contains(data, []byte(errorCodeRateLimitExceeded)) {
+		return "", false
+	}
+	var event struct {
+		Type     string        `json:"type"`
+		Error    *ErrorDetails `json:"error"`
+		Response *struct {
+			StatusDetails *struct {
+				Error *ErrorDetails `json:"error"`
+			} `json:"status_details"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil {
+		return "", false
+	}
+	details := event.Error
+	if event.Type == EventTypeResponseDone && event.Response != nil && event.Response.StatusDetails != nil {
+		details = event.Response.StatusDetails.Error
+	}
+	if details == nil || (event.Type != EventTypeError && event.Type != EventTypeResponseDone) {
+		return "", false
+	}
+	code := details.Code
+	if code == "" {
+		code = details.Type
+	}
+
+	return code, code == errorCodeInsufficientQuota || code == errorCodeRateLimitExceeded
+}
+
+// resumableConn is the OpenAI connection of a session that survives
+// drops. A failed read or write redials OpenAI, restores the session
+// config and replays the conversation, then the proxy's reader and
+// writer carry on over the new connection. When redialing fails, or
+// OpenAI is over quota, the conversation goes on with the cascaded
+// fallback if there is one. Only when that fails does the error reach
+// them.
+type resumableConn struct {
+	ctx      context.Context
+	session  *proxySession
+	attempts int
+
+	mu   sync.Mutex
+	conn wsConn
+	// generation counts the connections, a failure of an older one was
+	// already handled by the other direction
+	generation int
+	// failed is set once resuming gave up
+	failed bool
+	// fellBack is set once the cascaded fallback serves the session
+	fellBack bool
+	// replacing is closed once the connection being replaced has a
+	// successor or resuming gave up, nil when none is
+	replacing chan struct{}
+	// closed is set once the session closed the connection
+	closed bool
+}
+
+func newResumableConn(ctx context.Context, s *proxySession, conn wsConn) *resumableConn {
+	return &resumableConn{ctx: ctx, session: s, attempts: s.client.resumeAttempts, conn: conn}
+}
+
+func (r *resumableConn) current() (wsConn, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.conn, r.generation
+}
+
+func (r *resumableConn) ReadMessage() (int, []byte, error) {
+	for {
+		conn, generation := r.current()
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			if !r.resume(generation, err) {
+				return messageType, data, err
+			}
+			continue
+		}
+		// the client gets session.resumed instead of the error
+		code, unavailable := upstreamUnavailable(data)
+		if !unavailable || !r.fallBack(generation, code) {
+			return messageType, data, nil
+		}
+	}
+}
+
+func (r *resumableConn) WriteMessage(messageType int, data []byte) error {
+	conn, generation := r.current()
+	for {
+		err := conn.WriteMessage(messageType, data)
+		if err == nil || !r.resume(generation, err) {
+			return err
+		}
+		// the redial took from the writer's deadline
+		conn, generation = r.current()
+		conn.SetWriteDeadline(time.Now().Add(upstreamWriteWait))
+	}
+}
+
+func (r *resumableConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	conn, _ := r.current()
+	return conn.WriteControl(messageType, data, deadline)
+}
+
+func (r *resumableConn) SetWriteDeadline(t time.Time) error {
+	conn, _ := r.current()
+	return conn.SetWriteDeadline(t)
+}
+
+func (r *resumableConn) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	// the dropped connection was closed when resuming it started
+	if r.failed || r.replacing != nil {
+		return nil
+	}
+
+	return r.conn.Close()
+}
+
+// resume replaces the connection of generation that failed with cause,
+// it returns false when the caller has to give up. OpenAI ending the
+// session on purpose and the session ending aren't resumed.
+func (r *resumableConn) resume(generation int, cause error) bool {
+	if r.ctx.Err() != nil || websocket.IsCloseError(cause, websocket.CloseNormalClosure, websocket.ClosePolicyViolation) {
+		return false
+	}
+
+	return r.replace(generation, cause, true)
+}
+
+// fallBack replaces the connection of generation with the cascaded
+// fallback right away, redialing won't help while OpenAI refuses the
+// session with code
+func (r *resumableConn) fallBack(generation int, code string) bool {
+	if r.session.client.fallback == nil || r.ctx.Err() != nil {
+		return false
+	}
+
+	return r.replace(generation, fmt.Errorf("OpenAI refused the session: %s", code), false)
+}
+
+// replace swaps the connection of generation for a new OpenAI one when
+// redial is set, or for the fallback when that isn't possible. The lock
+// isn't held while dialing, the other direction failing on the same
+// connection waits for the outcome instead.
+func (r *resumableConn) replace(generation int, cause error, redial bool) bool {
+	r.mu.Lock()
+	if r.failed || r.closed {
+		r.mu.Unlock()
+		return false
+	}
+	if r.generation != generation {
+		r.mu.Unlock()
+		return true
+	}
+	if r.replacing != nil {
+		replacing := r.replacing
+		r.mu.Unlock()
+		<-replacing
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return !r.failed
+	}
+	// the fallback doesn't fall back again
+	if !redial && r.fellBack {
+		r.mu.Unlock()
+		return false
+	}
+	replacing := make(chan struct{})
+	r.replacing = replacing
+	old, fellBack := r.conn, r.fellBack
+	r.mu.Unlock()
+
+	log.Printf("realtime session %s: OpenAI connection lost, resuming: %v", r.session.id, cause)
+	// unblocks the other direction, it waits for the new connection
+	old.Close()
+	var conn wsConn
+	var resumed *SessionResumedEvent
+	err := ErrResumeFailed
+	if redial {
+		conn, resumed, err = r.redial()
+	}
+	if err != nil && r.session.client.fallback != nil && !fellBack {
+		if redial {
+			log.Printf("realtime session %s: %v", r.session.id, err)
+		}
+		if conn, resumed, err = r.openFallback(); err == nil {
+			fellBack = true
+		}
+	}
+
+	r.mu.Lock()
+	r.replacing = nil
+	close(replacing)
+	if err == nil && r.closed {
+		// the session ended while the connection was replaced
+		conn.Close()
+		err = fmt.Errorf("%w: session closed", ErrResumeFailed)
+	}
+	if err != nil {
+		r.failed = true
+		r.mu.Unlock()
+		log.Printf("realtime session %s: %v", r.session.id, err)
+		return false
+	}
+	r.conn, r.fellBack = conn, fellBack
+	r.generation++
+	r.mu.Unlock()
+
+	log.Printf("realtime session %s: resumed with %d items, %d skipped", r.session.id, resumed.Items, resumed.SkippedItems)
+	r.session.onResumed(r.ctx, resumed)
+	return true
+}
+
+// redial connects to OpenAI again and restores the session, waiting
+// longer between every attempt
+func (r *resumableConn) redial() (wsConn, *SessionResumedEvent, error) {
+	if r.attempts <= 0 {
+		return nil, nil, fmt.Errorf("%w: resuming is turned off", ErrResumeFailed)
+	}
+	var err error
+	for attempt := range r.attempts {
+		if attempt > 0 {
+			select {
+			case <-time.After(resumeBackoff << (attempt - 1)):
+			case <-r.ctx.Done():
+				return nil, nil, fmt.Errorf("%w: %w", ErrResumeFailed, r.ctx.Err())
+			}
+		}
+		var conn *websocket.Conn
+		if conn, err = r.session.client.dial(r.ctx); err != nil {
+			continue
+		}
+		var resumed *SessionResumedEvent
+		if resumed, err = r.restore(conn); err != nil {
+			conn.Close()
+			continue
+		}
+		return conn, resumed, nil
+	}
+
+	return nil, nil, fmt.Errorf("%w after %d attempts: %w", ErrResumeFailed, r.attempts, err)
+}
+
+// openFallback restores the session on the cascaded fallback
+func (r *resumableConn) openFallback() (wsConn, *SessionResumedEvent, error) {
+	log.Printf("realtime session %s: falling back to the cascaded pipeline", r.session.id)
+	cc := r.session.client.fallback.open(r.session.client, r.session.userID, r.session.id)
+	resumed, err := r.restore(cc)
+	if err != nil {
+		cc.Close()
+		return nil, nil, fmt.Errorf("%w: fallback: %w", ErrResumeFailed, err)
+	}
+	resumed.Fallback = true
+
+	return cc, resumed, nil
+}
+
+// restore sends the session config and the conversation over a new
+// connection and waits for it to acknowledge every event, the client
+// doesn't see the acknowledgements
+func (r *resumableConn) restore(conn wsConn) (*SessionResumedEvent, error) {
+	// the session may end while OpenAI takes its time
+	stop := context.AfterFunc(r.ctx, func() { conn.Close() })
+	defer stop()
+	deadline := time.Now().Add(resumeWait)
+	// the fallback answers in process, it has no read deadline
+	if ws, ok := conn.(*websocket.Conn); ok {
+		ws.SetReadDeadline(deadline)
+		defer ws.SetReadDeadline(time.Time{})
+	}
+	conn.SetWriteDeadline(deadline)
+
+	events, skipped := r.session.history.replay()
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't encode %s event: %w", event.EventType(), err)
+		}
+		msg := &Message{Content: data, Type: websocket.TextMessage}
+		r.session.journal.frame(PeerProxy, PeerUpstream, msg)
+		if err := conn.WriteMessage(msg.Type, msg.Content); err != nil {
+			return nil, fmt.Errorf("couldn't restore session: %w", err)
+		}
+	}
+
+	for pending := len(events); pending > 0; {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't restore session: %w", err)
+		}
+		r.session.journal.frame(PeerUpstream, PeerProxy, &Message{Content: data, Type: messageType})
+		var envelope Event
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			continue
+		}
+		switch envelope.Type {
+		case EventTypeSessionUpdated, EventTypeConversationItemCreated:
+			pending--
+		case EventTypeError:
+			var e ErrorEvent
+			if err := json.Unmarshal(data, &e); err == nil && e.Error != nil {
+				return nil, fmt.Errorf("OpenAI refused the restored session: %s", e.Error.Message)
+			}
+			return nil, errors.New("OpenAI refused the restored session")
+		}
+	}
+
+	return &SessionResumedEvent{
+		Event:        Event{Type: EventTypeSessionResumed},
+		Items:        len(events) - 1,
+		SkippedItems: skipped,
+	}, nil
+}
+
+// onResumed tells the client that the session goes on, a response lost
+// with the old connection no longer holds up a goodbye
+func (s *proxySession) onResumed(ctx context.Context, resumed *SessionResumedEvent) {
+	if resumed.ResponseInterrupted = s.history.resumed(); resumed.ResponseInterrupted {
+		s.trackResponse(ctx, EventTypeResponseDone)
+	}
+	s.sendEvent(ctx, resumed)
+}
@@ -0,0 +1,151 @@
+package realtime
+
+import (
+	"encoding/json"
+	"proomptmachinee/internal/config"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// readResumed reads from the client until the session is resumed
+func readResumed(t *testing.T, client *websocket.Conn) SessionResumedEvent {
+	t.Helper()
+	client.SetReadDeadline(time.Now().Add(testWait))
+	for {
+		_, data, err := client.ReadMessage()
+		if err != nil {
+			t.Fatalf("session wasn't resumed: %v", err)
+		}
+		var resumed SessionResumedEvent
+		if json.Unmarshal(data, &resumed) == nil && resumed.Type == EventTypeSessionResumed {
+			return resumed
+		}
+	}
+}
+
+func TestUpstreamUnavailable(t *testing.T) {
+	for _, tc := range []struct {
+		event string
+		code  string
+		ok    bool
+	}{
+		{`{"type":"error","error":{"type":"insufficient_quota","code":"insufficient_quota","message":"quota"}}`, "insufficient_quota", true},
+		{`{"type":"error","error":{"type":"requests","code":"rate_limit_exceeded","message":"slow down"}}`, "rate_limit_exceeded", true},
+		{`{"type":"response.done","response":{"status":"failed","status_details":{"type":"failed","error":{"type":"insufficient_quota","code":"insufficient_quota"}}}}`, "insufficient_quota", true},
+		{`{"type":"error","error":{"type":"invalid_request_error","code":"unknown_parameter","message":"insufficient_quota isn't a parameter"}}`, "unknown_parameter", false},
+		{`{"type":"response.text.delta","delta":"insufficient_quota"}`, "", false},
+		{`{"type":"session.updated"}`, "", false},
+	} {
+		if code, ok := upstreamUnavailable([]byte(tc.event)); code != tc.code || ok != tc.ok {
+			t.Errorf("%s: got %q %v, want %q %v", tc.event, code, ok, tc.code, tc.ok)
+		}
+	}
+}
+
+func TestFallbackOnQuota(t *testing.T) {
+	p := newTestProxy(t, config.RealtimeConfig{})
+	p.client.UseFallback(NewFakeFallback("hello", "hi there"))
+	baseline := runtime.NumGoroutine()
+	client, upstream := p.open(t)
+	defer upstream.Close()
+
+	upstream.WriteMessage(websocket.TextMessage, []byte(`{"type":"error","error":{"type":"insufficient_quota","code":"insufficient_quota","message":"You exceeded your current quota"}}`))
+
+	if resumed := readResumed(t, client); !resumed.Fallback {
+		t.Error("session resumed on OpenAI, expected the fallback")
+	}
+	client.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"), time.Now().Add(time.Second))
+	if err := p.ended(t); err != nil {
+		t.Errorf("session on the fallback ended with %v", err)
+	}
+	client.Close()
+	upstream.Close()
+	checkGoroutines(t, baseline)
+}
+
+func TestFallbackOnFailedResume(t *testing.T) {
+	// resuming is turned off, the drop can't be redialed
+	p := newTestProxy(t, config.RealtimeConfig{})
+	p.client.UseFallback(NewFakeFallback("hello", "hi there"))
+	baseline := runtime.NumGoroutine()
+	client, upstream := p.open(t)
+
+	upstream.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "boom"), time.Now().Add(time.Second))
+	upstream.Close()
+
+	if resumed := readResumed(t, client); !resumed.Fallback {
+		t.Error("session resumed on OpenAI, expected the fallback")
+	}
+	client.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"), time.Now().Add(time.Second))
+	if err := p.ended(t); err != nil {
+		t.Errorf("session on the fallback ended with %v", err)
+	}
+	client.Close()
+	checkGoroutines(t, baseline)
+}
+
+func TestResumeReplaysConversation(t *testing.T) {
+	p := newTestProxy(t, config.RealtimeConfig{ResumeAttempts: 1})
+	baseline := runtime.NumGoroutine()
+	client, upstream := p.open(t)
+	defer client.Close()
+
+	upstream.WriteMessage(websocket.TextMessage, []byte(`{"type":"conversation.item.created","item":{"id":"item_1","type":"message","role":"user","content":[{"type":"input_text","text":"hello"}]}}`))
+	upstream.WriteMessage(websocket.TextMessage, []byte(`{"type":"response.output_item.done","response_id":"resp_1","item":{"id":"item_2","type":"message","role":"assistant","content":[{"type":"audio","transcript":"hi there"}]}}`))
+	readEvent(t, client, EventTypeResponseOutputItemDone)
+	// dropped without a close frame
+	upstream.Close()
+
+	var redialed *websocket.Conn
+	select {
+	case redialed = <-p.upstreams:
+	case <-time.After(testWait):
+		t.Fatal("proxy didn't redial upstream")
+	}
+	defer redialed.Close()
+	redialed.SetReadDeadline(time.Now().Add(testWait))
+	_, data, err := redialed.ReadMessage()
+	if err != nil {
+		t.Fatalf("couldn't read the restored session: %v", err)
+	}
+	var update SessionUpdate
+	if json.Unmarshal(data, &update); update.Type != EventTypeSessionUpdate || update.Session == nil || update.Session.Instructions != "You are a test." {
+		t.Fatalf("restored session with %s", data)
+	}
+	redialed.WriteMessage(websocket.TextMessage, []byte(`{"type":"session.updated","session":{}}`))
+	for _, want := range []struct{ id, role, contentType, text string }{
+		{"item_1", ItemRoleUser, ContentTypeInputText, "hello"},
+		{"item_2", ItemRoleAssistant, ContentTypeText, "hi there"},
+	} {
+		_, data, err := redialed.ReadMessage()
+		if err != nil {
+			t.Fatalf("couldn't read replayed %s: %v", want.id, err)
+		}
+		var create ConversationItemCreateEvent
+		json.Unmarshal(data, &create)
+		item := create.Item
+		if create.Type != EventTypeConversationItemCreate || item == nil || item.ID != want.id || item.Role != want.role ||
+			len(item.Content) != 1 || item.Content[0].Type != want.contentType || item.Content[0].Text != want.text {
+			t.Fatalf("replayed %s, want %s", data, want.id)
+		}
+		redialed.WriteMessage(websocket.TextMessage, []byte(`{"type":"conversation.item.created","item":{"id":"`+want.id+`","type":"message"}}`))
+	}
+
+	resumed := readResumed(t, client)
+	if resumed.Items != 2 || resumed.SkippedItems != 0 || resumed.ResponseInterrupted || resumed.Fallback {
+		t.Errorf("got %+v", resumed)
+	}
+
+	// the session goes on over the new connection
+	client.WriteMessage(websocket.TextMessage, []byte(`{"type":"response.create"}`))
+	readEvent(t, redialed, EventTypeResponseCreate)
+	client.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"), time.Now().Add(time.Second))
+	if err := p.ended(t); err != nil {
+		t.Errorf("resumed session ended with %v", err)
+	}
+	redialed.Close()
+	checkGoroutines(t, baseline)
+}
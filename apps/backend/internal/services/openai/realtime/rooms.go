@@ -0,0 +1,511 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// RoomNew as the `room` query parameter opens a new room, its host
+	// learns the id from the upgrade response and the room.joined event
+	RoomNew = "new"
+	// RoomIDHeader carries the id of a new room back to its host
+	RoomIDHeader = "X-Room-Id"
+
+	// EventTypeRoomSpeakerUpdate is the client event handing the floor
+	// on, the proxy handles it and it never reaches OpenAI
+	EventTypeRoomSpeakerUpdate = "room.speaker.update"
+	EventTypeRoomJoined        = "room.joined"
+	EventTypeRoomUpdated       = "room.updated"
+
+	// how many messages a participant may fall behind before it is
+	// dropped, about ten seconds of audio
+	participantBufferSize = 256
+)
+
+var (
+	ErrRoomNotFound = errors.New("room not found")
+	ErrRoomFull     = errors.New("room is full")
+)
+
+type RoomParticipant struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id,omitempty"`
+}
+
+// RoomJoinedEvent is sent to a participant once it joined a room
+type RoomJoinedEvent struct {
+	Event
+	RoomID        string `json:"room_id"`
+	ParticipantID string `json:"participant_id"`
+}
+
+// RoomUpdatedEvent is sent to every participant when someone joined or
+// left or the speaker changed
+type RoomUpdatedEvent struct {
+	Event
+	RoomID string `json:"room_id"`
+	HostID string `json:"host_id"`
+	// SpeakerID is empty while nobody has the floor
+	SpeakerID    string             `json:"speaker_id"`
+	Participants []*RoomParticipant `json:"participants"`
+}
+
+// RoomSpeakerUpdateEvent hands the floor to a participant, an empty
+// SpeakerID frees it. The speaker and the host may hand it on, anyone
+// may take a free floor.
+type RoomSpeakerUpdateEvent struct {
+	Event
+	SpeakerID string `json:"speaker_id"`
+}
+
+// roomRegistry tracks the open rooms so participants can join them
+type roomRegistry struct {
+	mu    sync.Mutex
+	rooms map[string]*room
+	// maxParticipants of zero doesn't limit
+	maxParticipants int
+	writeTimeout    time.Duration
+}
+
+func newRoomRegistry(maxParticipants int, writeTimeout time.Duration) *roomRegistry {
+	return &roomRegistry{rooms: make(map[string]*room), maxParticipants: maxParticipants, writeTimeout: writeTimeout}
+}
+
+// open creates a room with its host as the first participant and
+// speaker
+func (r *roomRegistry) open(id, persona string, conn *limitedConn, userID string, expiresAt time.Time) *room {
+	rm := &room{
+		id:       id,
+		persona:  persona,
+		registry: r,
+		in:       make(chan *Message, messageBufferSize),
+		done:     make(chan struct{}),
+	}
+	r.mu.Lock()
+	r.rooms[id] = rm
+	r.mu.Unlock()
+
+	host, _ := rm.join(conn, userID, expiresAt)
+	rm.mu.Lock()
+	rm.speaker = host
+	rm.mu.Unlock()
+	rm.update()
+
+	return rm
+}
+
+func (r *roomRegistry) get(id string) (*room, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rm, ok := r.rooms[id]
+
+	return rm, ok
+}
+
+func (r *roomRegistry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.rooms, id)
+}
+
+// participant is a client connection in a room, each has its own reader
+// and writer so a slow one can't hold up the others
+type participant struct {
+	RoomParticipant
+	conn *limitedConn
+	out  chan *Message
+	// done is closed once the participant left
+	done      chan struct{}
+	closeOnce sync.Once
+	// expiry removes the participant when its token expires, nil when
+	// it doesn't
+	expiry *time.Timer
+}
+
+// queue hands msg to the participant's writer, it returns false when the
+// participant fell too far behind
+func (p *participant) queue(msg *Message) bool {
+	select {
+	case p.out <- msg:
+		return true
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *participant) sendEvent(event TypedEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("couldn't encode %s event: %v", event.EventType(), err)
+		return
+	}
+	p.queue(&Message{Content: data, Type: websocket.TextMessage})
+}
+
+func (p *participant) close() {
+	p.closeOnce.Do(func() {
+		if p.expiry != nil {
+			p.expiry.Stop()
+		}
+		close(p.done)
+		p.conn.Close()
+	})
+}
+
+// room shares one realtime session among its participants. It stands in
+// for the session's client connection: the speaker's events are read
+// from it and everything written to it goes out to every participant.
+// The host opened the room, when it leaves the participant that joined
+// next takes over. The room ends once everyone left.
+type room struct {
+	id string
+	// persona of the room's session
+	persona  string
+	registry *roomRegistry
+	// in carries the speaker's events to the session
+	in chan *Message
+	// done is closed once the room ended
+	done chan struct{}
+
+	mu sync.Mutex
+	// participants are in the order they joined
+	participants []*participant
+	host         *participant
+	// speaker is nil while the floor is free
+	speaker *participant
+	closed  bool
+}
+
+// join adds a participant and starts its reader and writer, it is
+// removed once expiresAt passed unless that is zero
+func (r *room) join(conn *limitedConn, userID string, expiresAt time.Time) (*participant, error) {
+	p := &participant{
+		RoomParticipant: RoomParticipant{ID: uuid.NewString(), UserID: userID},
+		conn:            conn,
+		out:             make(chan *Message, participantBufferSize),
+		done:            make(chan struct{}),
+	}
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s ended", ErrRoomNotFound, r.id)
+	}
+	if limit := r.registry.maxParticipants; limit > 0 && len(r.participants) >= limit {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s has %d participants", ErrRoomFull, r.id, limit)
+	}
+	r.participants = append(r.participants, p)
+	if r.host == nil {
+		r.host = p
+	}
+	if !expiresAt.IsZero() {
+		p.expiry = time.AfterFunc(time.Until(expiresAt), func() {
+			r.leave(p, newCloseError(CloseTokenExpired, "token expired", nil))
+		})
+	}
+	r.mu.Unlock()
+
+	go r.read(p)
+	go r.write(p)
+	p.sendEvent(&RoomJoinedEvent{Event: Event{Type: EventTypeRoomJoined}, RoomID: r.id, ParticipantID: p.ID})
+	log.Printf("realtime room %s: participant %s joined", r.id, p.ID)
+
+	return p, nil
+}
+
+// leave removes a participant, closeErr tells it why when it didn't go
+// away itself
+func (r *room) leave(p *participant, closeErr *CloseError) {
+	r.mu.Lock()
+	i := slices.Index(r.participants, p)
+	if i < 0 {
+		r.mu.Unlock()
+		return
+	}
+	r.participants = slices.Delete(r.participants, i, i+1)
+	if r.speaker == p {
+		r.speaker = nil
+	}
+	if r.host == p {
+		r.host = nil
+		if len(r.participants) > 0 {
+			r.host = r.participants[0]
+		}
+	}
+	empty := len(r.participants) == 0
+	r.mu.Unlock()
+
+	if closeErr != nil {
+		writeClose(p.conn, closeErr.Code, closeErr.Reason)
+	}
+	p.close()
+	log.Printf("realtime room %s: participant %s left", r.id, p.ID)
+	if empty {
+		r.end()
+		return
+	}
+	r.update()
+}
+
+// end unblocks the session's reader, it then ends the session
+func (r *room) end() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	r.closed = true
+	close(r.done)
+	r.registry.remove(r.id)
+}
+
+// update tells every participant who is in the room and who speaks
+func (r *room) update() {
+	r.mu.Lock()
+	event := &RoomUpdatedEvent{Event: Event{Type: EventTypeRoomUpdated}, RoomID: r.id}
+	if r.host != nil {
+		event.HostID = r.host.ID
+	}
+	if r.speaker != nil {
+		event.SpeakerID = r.speaker.ID
+	}
+	for _, p := range r.participants {
+		event.Participants = append(event.Participants, &p.RoomParticipant)
+	}
+	r.mu.Unlock()
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("couldn't encode %s event: %v", event.EventType(), err)
+		return
+	}
+	r.broadcast(&Message{Content: data, Type: websocket.TextMessage})
+}
+
+// broadcast queues msg for every participant, one that fell too far
+// behind is dropped
+func (r *room) broadcast(msg *Message) {
+	r.mu.Lock()
+	participants := slices.Clone(r.participants)
+	r.mu.Unlock()
+	for _, p := range participants {
+		if !p.queue(msg) {
+			r.leave(p, newCloseError(websocket.CloseTryAgainLater, "fell too far behind the room", nil))
+		}
+	}
+}
+
+// read passes the speaker's events on to the session, the others may
+// only hand the floor on
+func (r *room) read(p *participant) {
+	for {
+		messageType, data, err := p.conn.ReadMessage()
+		if errors.Is(err, ErrMessageTooBig) {
+			r.leave(p, newCloseError(websocket.ClosePolicyViolation, fmt.Sprintf("messages are limited to %d bytes", p.conn.limit), err))
+			return
+		}
+		if err != nil {
+			r.leave(p, nil)
+			return
+		}
+		if messageType == websocket.TextMessage && r.onRoomEvent(p, data) {
+			continue
+		}
+		r.mu.Lock()
+		speaking := r.speaker == p
+		r.mu.Unlock()
+		if !speaking {
+			p.sendEvent((&PolicyError{Message: "only the speaker of the room may send events"}).ErrorEvent())
+			continue
+		}
+		select {
+		case r.in <- &Message{Content: data, Type: messageType}:
+		case <-p.done:
+			return
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (r *room) write(p *participant) {
+	for {
+		select {
+		case msg := <-p.out:
+			p.conn.SetWriteDeadline(time.Now().Add(r.registry.writeTimeout))
+			if err := p.conn.WriteMessage(msg.Type, msg.Content); err != nil {
+				var closeErr *CloseError
+				if isTimeout(err) {
+					closeErr = newCloseError(websocket.ClosePolicyViolation, "participant doesn't read fast enough", err)
+				}
+				r.leave(p, closeErr)
+				return
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// onRoomEvent handles the events of the room itself, it returns false
+// for the events meant for the session
+func (r *room) onRoomEvent(p *participant, data []byte) bool {
+	var envelope Event
+	if err := json.Unmarshal(data, &envelope); err != nil || envelope.Type != EventTypeRoomSpeakerUpdate {
+		return false
+	}
+	var e RoomSpeakerUpdateEvent
+	if err := json.Unmarshal(data, &e); err != nil {
+		p.sendEvent((&PolicyError{EventType: envelope.Type, EventID: envelope.EventID, Message: err.Error()}).ErrorEvent())
+		return true
+	}
+
+	r.mu.Lock()
+	var next *participant
+	for _, candidate := range r.participants {
+		if candidate.ID == e.SpeakerID {
+			next = candidate
+		}
+	}
+	previous := r.speaker
+	var refused string
+	switch {
+	case e.SpeakerID != "" && next == nil:
+		refused = "no such participant in the room"
+	case p != r.host && p != previous && !(previous == nil && next == p):
+		refused = "only the speaker and the host may hand the floor on"
+	default:
+		r.speaker = next
+	}
+	r.mu.Unlock()
+	if refused != "" {
+		p.sendEvent((&PolicyError{EventType: e.Type, EventID: e.EventID, Param: "speaker_id", Message: refused}).ErrorEvent())
+		return true
+	}
+
+	if previous != nil && previous != next {
+		// what the previous speaker said but didn't commit isn't the
+		// next one's
+		clear, _ := json.Marshal(&InputAudioBufferClearEvent{Event: Event{Type: EventTypeInputAudioBufferClear}})
+		select {
+		case r.in <- &Message{Content: clear, Type: websocket.TextMessage}:
+		case <-r.done:
+		}
+	}
+	r.update()
+
+	return true
+}
+
+func (r *room) ReadMessage() (int, []byte, error) {
+	select {
+	case msg := <-r.in:
+		return msg.Type, msg.Content, nil
+	case <-r.done:
+		return 0, nil, &websocket.CloseError{Code: websocket.CloseNormalClosure, Text: "everyone left the room"}
+	}
+}
+
+func (r *room) WriteMessage(messageType int, data []byte) error {
+	r.broadcast(&Message{Content: data, Type: messageType})
+	return nil
+}
+
+// WriteControl sends a control frame, the close frame of the session,
+// to every participant
+func (r *room) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	r.mu.Lock()
+	participants := slices.Clone(r.participants)
+	r.mu.Unlock()
+	for _, p := range participants {
+		_ = p.conn.WriteControl(messageType, data, deadline)
+	}
+
+	return nil
+}
+
+// SetWriteDeadline does nothing, every participant's writer has its own
+func (r *room) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// Close ends the room and closes every participant's connection
+func (r *room) Close() error {
+	r.mu.Lock()
+	participants := r.participants
+	r.participants = nil
+	r.mu.Unlock()
+	r.end()
+	for _, p := range participants {
+		p.close()
+	}
+
+	return nil
+}
+
+// joinRoom upgrades the request and adds the client to an open room, it
+// blocks until the client left. The participant counts towards its
+// user's sessions like the host does.
+func (c *Client) joinRoom(w http.ResponseWriter, r *http.Request, caller *Caller, id string) error {
+	rm, ok := c.rooms.get(id)
+	if !ok {
+		http.Error(w, "room not found", http.StatusNotFound)
+		return fmt.Errorf("%w: %s", ErrRoomNotFound, id)
+	}
+	conn, err := c.upgrade(w, r, nil)
+	if err != nil {
+		return fmt.Errorf("couldn't upgrade connection: %w", err)
+	}
+
+	var userID string
+	var expiresAt time.Time
+	if caller != nil {
+		userID, expiresAt = caller.UserID, caller.ExpiresAt
+	}
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+	active := ActiveSession{ID: uuid.NewString(), UserID: userID, Persona: rm.persona, Source: SessionSourceRoom, StartedAt: time.Now()}
+	if err := c.sessions.add(active, cancel); err != nil {
+		code, reason := websocket.CloseInternalServerErr, "couldn't join room"
+		var closeErr *CloseError
+		if errors.As(err, &closeErr) {
+			code, reason = closeErr.Code, closeErr.Reason
+		}
+		writeClose(conn, code, reason)
+		conn.Close()
+		return err
+	}
+	defer c.sessions.remove(active.ID)
+
+	p, err := rm.join(conn, userID, expiresAt)
+	if err != nil {
+		writeClose(conn, websocket.ClosePolicyViolation, err.Error())
+		conn.Close()
+		return err
+	}
+	// an admin killed the participant
+	stop := context.AfterFunc(ctx, func() {
+		var closeErr *CloseError
+		if errors.As(context.Cause(ctx), &closeErr) {
+			rm.leave(p, closeErr)
+		}
+	})
+	defer stop()
+	rm.update()
+	<-p.done
+
+	return nil
+}
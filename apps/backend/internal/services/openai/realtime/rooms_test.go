@@ -0,0 +1,211 @@
+package realtime
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"proomptmachinee/internal/config"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+func TestRoomParticipantsCountPerUser(t *testing.T) {
+	p := newTestProxy(t, config.RealtimeConfig{MaxSessionsPerUser: 1, Rooms: config.RealtimeRooms{Enabled: true}})
+	dial := func(userID, room string) (*websocket.Conn, *http.Response) {
+		t.Helper()
+		conn, resp, err := websocket.DefaultDialer.Dial(p.url+"?room="+room, http.Header{testUserHeader: {userID}})
+		if err != nil {
+			t.Fatalf("couldn't connect to the proxy: %v", err)
+		}
+		return conn, resp
+	}
+
+	host, resp := dial("alice", RoomNew)
+	defer host.Close()
+	upstream := <-p.upstreams
+	defer upstream.Close()
+	roomID := resp.Header.Get(RoomIDHeader)
+
+	// the host's session is alice's only one
+	again, _ := dial("alice", roomID)
+	defer again.Close()
+	if closeErr := readClose(t, again); closeErr.Code != websocket.ClosePolicyViolation {
+		t.Errorf("second participant of the user got close %d %q, want a policy violation", closeErr.Code, closeErr.Text)
+	}
+	if err := p.ended(t); err == nil {
+		t.Error("joining over the user's limit didn't fail")
+	}
+
+	other, _ := dial("bob", roomID)
+	defer other.Close()
+	// the participant is registered before it joins
+	other.SetReadDeadline(time.Now().Add(testWait))
+	for {
+		var event Event
+		if err := other.ReadJSON(&event); err != nil {
+			t.Fatalf("bob didn't join: %v", err)
+		}
+		if event.Type == EventTypeRoomJoined {
+			break
+		}
+	}
+	if n := len(p.client.ActiveSessions()); n != 2 {
+		t.Errorf("%d sessions are registered, want the host's and bob's", n)
+	}
+}
+
+// join connects a participant to a room, a new one for RoomNew, and
+// returns its id and the room's
+func (p *testProxy) join(t *testing.T, room string) (*websocket.Conn, string, string) {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial(p.url+"?room="+room, nil)
+	if err != nil {
+		t.Fatalf("couldn't connect to the proxy: %v", err)
+	}
+	if room == RoomNew {
+		room = resp.Header.Get(RoomIDHeader)
+	}
+	var joined RoomJoinedEvent
+	json.Unmarshal(readEvent(t, conn, EventTypeRoomJoined), &joined)
+	if joined.RoomID != room {
+		t.Fatalf("joined room %q, want %q", joined.RoomID, room)
+	}
+
+	return conn, joined.ParticipantID, room
+}
+
+// readRoomUpdate reads room.updated events until one with n participants
+// and the speaker
+func readRoomUpdate(t *testing.T, conn *websocket.Conn, n int, speakerID string) *RoomUpdatedEvent {
+	t.Helper()
+	for {
+		var e RoomUpdatedEvent
+		json.Unmarshal(readEvent(t, conn, EventTypeRoomUpdated), &e)
+		if len(e.Participants) == n && e.SpeakerID == speakerID {
+			return &e
+		}
+	}
+}
+
+// openRoom opens a room with a host and a guest that joined it
+func openRoom(t *testing.T, p *testProxy) (host, guest, upstream *websocket.Conn, hostID, guestID string) {
+	t.Helper()
+	host, hostID, roomID := p.join(t, RoomNew)
+	t.Cleanup(func() { host.Close() })
+	upstream = <-p.upstreams
+	t.Cleanup(func() { upstream.Close() })
+	// the session update comes first
+	if _, _, err := upstream.ReadMessage(); err != nil {
+		t.Fatalf("couldn't read session update: %v", err)
+	}
+	guest, guestID, _ = p.join(t, roomID)
+	t.Cleanup(func() { guest.Close() })
+	readRoomUpdate(t, host, 2, hostID)
+
+	return host, guest, upstream, hostID, guestID
+}
+
+// readUpstream reads the next event the upstream got
+func readUpstream(t *testing.T, upstream *websocket.Conn) string {
+	t.Helper()
+	upstream.SetReadDeadline(time.Now().Add(testWait))
+	_, data, err := upstream.ReadMessage()
+	if err != nil {
+		t.Fatalf("upstream didn't get an event: %v", err)
+	}
+	var e Event
+	json.Unmarshal(data, &e)
+
+	return e.Type
+}
+
+func TestRoomHandOff(t *testing.T) {
+	p := newTestProxy(t, config.RealtimeConfig{Rooms: config.RealtimeRooms{Enabled: true}})
+	host, guest, upstream, hostID, guestID := openRoom(t, p)
+
+	guest.WriteJSON(&Event{Type: EventTypeResponseCreate})
+	readEvent(t, guest, EventTypeError)
+
+	// the guest can't take a floor that isn't free
+	guest.WriteJSON(&RoomSpeakerUpdateEvent{Event: Event{Type: EventTypeRoomSpeakerUpdate}, SpeakerID: guestID})
+	readEvent(t, guest, EventTypeError)
+
+	host.WriteJSON(&RoomSpeakerUpdateEvent{Event: Event{Type: EventTypeRoomSpeakerUpdate}, SpeakerID: guestID})
+	if got := readUpstream(t, upstream); got != EventTypeInputAudioBufferClear {
+		t.Fatalf("upstream got %s, want the host's audio cleared", got)
+	}
+	if e := readRoomUpdate(t, guest, 2, guestID); e.HostID != hostID {
+		t.Fatalf("host %s after the hand off", e.HostID)
+	}
+	guest.WriteJSON(&Event{Type: EventTypeResponseCreate})
+	if got := readUpstream(t, upstream); got != EventTypeResponseCreate {
+		t.Fatalf("upstream got %s, want the guest's response.create", got)
+	}
+	host.WriteJSON(&Event{Type: EventTypeResponseCreate})
+	readEvent(t, host, EventTypeError)
+
+	// the guest frees the floor and the host takes it back
+	guest.WriteJSON(&RoomSpeakerUpdateEvent{Event: Event{Type: EventTypeRoomSpeakerUpdate}})
+	if got := readUpstream(t, upstream); got != EventTypeInputAudioBufferClear {
+		t.Fatalf("upstream got %s, want the guest's audio cleared", got)
+	}
+	readRoomUpdate(t, host, 2, "")
+	host.WriteJSON(&RoomSpeakerUpdateEvent{Event: Event{Type: EventTypeRoomSpeakerUpdate}, SpeakerID: hostID})
+	readRoomUpdate(t, guest, 2, hostID)
+
+	// the guest takes over once the host left
+	host.Close()
+	if e := readRoomUpdate(t, guest, 1, ""); e.HostID != guestID {
+		t.Errorf("host %s after the host left, want the guest", e.HostID)
+	}
+}
+
+func TestRoomFanOut(t *testing.T) {
+	p := newTestProxy(t, config.RealtimeConfig{Rooms: config.RealtimeRooms{Enabled: true}})
+	host, guest, upstream, hostID, _ := openRoom(t, p)
+
+	upstream.WriteJSON(&Event{Type: EventTypeInputAudioBufferCommitted})
+	readEvent(t, host, EventTypeInputAudioBufferCommitted)
+	readEvent(t, guest, EventTypeInputAudioBufferCommitted)
+
+	// the room goes on without the guest, and ends with the host
+	guest.Close()
+	readRoomUpdate(t, host, 1, hostID)
+	upstream.WriteJSON(&Event{Type: EventTypeInputAudioBufferCleared})
+	readEvent(t, host, EventTypeInputAudioBufferCleared)
+	host.Close()
+	if err := p.ended(t); err != nil {
+		t.Errorf("room ended with %v", err)
+	}
+}
+
+func TestRoomConsent(t *testing.T) {
+	p := newTestProxy(t, config.RealtimeConfig{Rooms: config.RealtimeRooms{Enabled: true}})
+
+	t.Run("recording", func(t *testing.T) {
+		client, _, err := websocket.DefaultDialer.Dial(p.url+"?room=new&recording_consent=true", nil)
+		if err != nil {
+			t.Fatalf("couldn't connect to the proxy: %v", err)
+		}
+		defer client.Close()
+		if closeErr := readClose(t, client); closeErr.Code != websocket.ClosePolicyViolation {
+			t.Errorf("got close %d %q, want a policy violation", closeErr.Code, closeErr.Text)
+		}
+		if err := p.ended(t); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("session ended with %v", err)
+		}
+	})
+
+	t.Run("transcripts", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial(p.url+"?room=new&conversation_id="+uuid.NewString(), nil)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("got %v, want a bad request", err)
+		}
+		if err := p.ended(t); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("session ended with %v", err)
+		}
+	})
+}
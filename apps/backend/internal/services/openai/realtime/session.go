@@ -0,0 +1,529 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"proomptmachinee/internal/audio"
+	"proomptmachinee/internal/services/conversations"
+	"proomptmachinee/internal/services/recordings"
+	"proomptmachinee/internal/services/usage"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// how long a single frame to OpenAI may take to be written before
+	// it is considered stalled
+	upstreamWriteWait = 10 * time.Second
+	// how long close frames may take while tearing down
+	closeWait = time.Second
+	// control frame payloads are limited to 125 bytes, 2 go to the code
+	maxCloseReasonLength = 123
+
+	messageBufferSize = 10
+
+	// how long storing the recording may take once the session ended
+	recordingSaveWait = 30 * time.Second
+)
+
+var (
+	ErrClientClosed   = errors.New("client closed the connection")
+	ErrUpstreamClosed = errors.New("upstream closed the connection")
+)
+
+// CloseError is the cause a session ended with, Code and Reason are sent
+// to the client in the close frame
+type CloseError struct {
+	Code   int
+	Reason string
+	Err    error
+}
+
+func (e *CloseError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Reason, e.Err)
+	}
+
+	return e.Reason
+}
+
+func (e *CloseError) Unwrap() error {
+	return e.Err
+}
+
+func newCloseError(code int, reason string, err error) *CloseError {
+	return &CloseError{Code: code, Reason: reason, Err: err}
+}
+
+// wsConn is what the proxy uses of a websocket connection, the cascaded
+// fallback stands in for the OpenAI one with it
+type wsConn interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
+// proxySession pipes messages between a client and an OpenAI realtime
+// connection. Each direction has a reader and a writer goroutine joined
+// by a buffered channel, the first one to fail cancels the session
+// context with the cause and run tears both connections down.
+type proxySession struct {
+	id     string
+	userID string
+	client *Client
+	// clientWs is a room when several clients share the session
+	clientWs wsConn
+	upstream wsConn
+	// transcript is nil when transcripts aren't stored
+	transcript *transcript
+	// recorder is nil when the session isn't recorded
+	recorder *recordings.Recorder
+	// journal is nil when frames aren't journaled
+	journal *journal
+	// inputAudio and outputAudio convert the client audio format from
+	// and to what OpenAI takes, they are nil when the formats match
+	inputAudio  *audio.Converter
+	outputAudio *audio.Converter
+	// vad is set when the proxy detects speech itself
+	vad *audio.VAD
+	// mediaStream is set when the client is a phone call, its messages
+	// are translated from and to realtime events
+	mediaStream *mediaStream
+	// history is set when a dropped OpenAI connection is resumed
+	history *history
+	// playback follows the assistant audio the client was sent
+	playback playback
+
+	toClient   chan *Message
+	toUpstream chan *Message
+	// tools are the persona's, the model may only call them
+	tools []string
+	// toolCalls are the function calls running for a response, only the
+	// upstream reader touches the map
+	toolCalls map[string]*sync.WaitGroup
+	// totals is the usage of the responses so far, only the upstream
+	// reader touches it until the session ended
+	totals usage.SessionTotals
+	// limits end the session, the persona is asked to say goodbye with
+	// its instructions first
+	limits       sessionLimits
+	instructions string
+	goodbye      goodbye
+	started      time.Time
+	// now is the clock the playback is followed with
+	now func() time.Time
+	// activity is the unix nano time of the last client event or speech
+	activity atomic.Int64
+	appends  appendRate
+
+	cancel  context.CancelCauseFunc
+	readers sync.WaitGroup
+	writers sync.WaitGroup
+	// tasks are the tool calls the upstream reader started, they end
+	// with the session context
+	tasks sync.WaitGroup
+}
+
+func newProxySession(c *Client, id, userID string, clientWs wsConn, upstream wsConn) *proxySession {
+	return &proxySession{
+		id:       id,
+		userID:   userID,
+		client:   c,
+		clientWs: clientWs,
+		upstream: upstream,
+
+		toClient:   make(chan *Message, messageBufferSize),
+		toUpstream: make(chan *Message, messageBufferSize),
+		toolCalls:  make(map[string]*sync.WaitGroup),
+		appends:    appendRate{max: c.maxAppendsPerSecond},
+		now:        time.Now,
+	}
+}
+
+// run blocks until the session ends and every goroutine it started has
+// returned. The returned error is the cause the session ended with, it
+// is nil when the client closed the connection.
+func (s *proxySession) run(ctx context.Context) error {
+	ctx, s.cancel = context.WithCancelCause(ctx)
+	defer s.cancel(nil)
+	s.started = time.Now()
+	s.touch()
+	if s.history != nil {
+		s.upstream = newResumableConn(ctx, s, s.upstream)
+	}
+
+	s.readers.Add(2)
+	go s.read(ctx, s.clientWs, s.onClientMessage, s.clientReadError)
+	go s.read(ctx, s.upstream, s.onUpstreamMessage, s.upstreamReadError)
+	if s.limits.enabled() {
+		s.readers.Add(1)
+		go s.enforceLimits(ctx)
+	}
+	s.writers.Add(2)
+	go s.write(ctx, s.upstream, s.toUpstream, upstreamWriteWait, s.upstreamWriteError)
+	go s.write(ctx, s.clientWs, s.toClient, s.client.writeTimeout, s.clientWriteError)
+
+	<-ctx.Done()
+	cause := context.Cause(ctx)
+	// writers flush what is buffered first, e.g. the last events OpenAI
+	// sent before it closed the connection
+	s.writers.Wait()
+	s.teardown(cause)
+	s.readers.Wait()
+	s.tasks.Wait()
+	if item := s.playback.finish(s.now()); item != nil {
+		s.storeHeard(item)
+	}
+	s.transcript.close()
+	s.closeRecorder()
+
+	// the client going away, cleanly or not, isn't a failure of the session
+	if errors.Is(cause, ErrClientClosed) {
+		return nil
+	}
+
+	return cause
+}
+
+// read hands every message of conn to onMessage until conn fails or the
+// session ends, onMessage returns false once the session ended
+func (s *proxySession) read(ctx context.Context, conn wsConn, onMessage func(context.Context, *Message) bool, onError func(error) error) {
+	defer s.readers.Done()
+	for {
+		messageType, content, err := conn.ReadMessage()
+		if err != nil {
+			s.cancel(onError(err))
+			return
+		}
+
+		if !onMessage(ctx, &Message{Content: content, Type: messageType}) {
+			return
+		}
+	}
+}
+
+// send queues msg for a writer, it returns false once the session ended
+func (s *proxySession) send(ctx context.Context, out chan<- *Message, msg *Message) bool {
+	select {
+	case out <- msg:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sendEvent queues a server event for the client, a phone call can't
+// take them so they are only logged
+func (s *proxySession) sendEvent(ctx context.Context, event TypedEvent) bool {
+	if s.mediaStream != nil {
+		if e, ok := event.(*ErrorEvent); ok {
+			log.Printf("realtime session %s: %s", s.id, e.Error.Message)
+		}
+		return true
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("couldn't encode %s event: %v", event.EventType(), err)
+		return true
+	}
+	msg := &Message{Content: data, Type: websocket.TextMessage}
+	s.journal.frame(PeerProxy, PeerClient, msg)
+
+	return s.send(ctx, s.toClient, msg)
+}
+
+// write sends messages to conn, a write that doesn't finish within
+// timeout ends the session so a stalled peer can't block it forever.
+// Once the session ends the buffered messages are flushed with a short
+// deadline.
+func (s *proxySession) write(ctx context.Context, conn wsConn, in <-chan *Message, timeout time.Duration, onError func(error) error) {
+	defer s.writers.Done()
+	for {
+		select {
+		case msg := <-in:
+			conn.SetWriteDeadline(time.Now().Add(timeout))
+			if err := conn.WriteMessage(msg.Type, msg.Content); err != nil {
+				s.cancel(onError(err))
+				return
+			}
+		case <-ctx.Done():
+			conn.SetWriteDeadline(time.Now().Add(closeWait))
+			for {
+				select {
+				case msg := <-in:
+					if err := conn.WriteMessage(msg.Type, msg.Content); err != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// onClientMessage forwards the client events the policy allows, the
+// others are answered with an `error` event
+func (s *proxySession) onClientMessage(ctx context.Context, msg *Message) bool {
+	s.journal.frame(PeerClient, PeerUpstream, msg)
+	if msg.Type != websocket.TextMessage {
+		return s.sendEvent(ctx, (&PolicyError{Message: "only text messages are accepted"}).ErrorEvent())
+	}
+	if s.mediaStream != nil {
+		return s.onMediaStreamMessage(ctx, msg)
+	}
+
+	return s.forwardClientEvent(ctx, msg)
+}
+
+// forwardClientEvent checks a client event against the policy, converts
+// its audio and queues it for OpenAI
+func (s *proxySession) forwardClientEvent(ctx context.Context, msg *Message) bool {
+	data, event, violation := s.client.policy.Check(msg.Content)
+	if violation != nil {
+		log.Printf("realtime session %s: refused client event: %v", s.id, violation)
+		return s.sendEvent(ctx, violation.ErrorEvent())
+	}
+	msg.Content = data
+	e, ok := event.(*InputAudioBufferAppendEvent)
+	if !ok {
+		s.touch()
+		return s.send(ctx, s.toUpstream, msg)
+	}
+	// phone calls stream at the fixed rate of the telephony provider
+	if s.mediaStream == nil && !s.appends.allow(time.Now()) {
+		s.cancel(newCloseError(websocket.ClosePolicyViolation, "too many audio appends", nil))
+		return false
+	}
+	if s.inputAudio != nil {
+		converted, err := s.convert(s.inputAudio, data, "audio", e.Audio)
+		if err != nil {
+			violation := &PolicyError{EventType: e.Type, EventID: e.EventID, Param: "audio", Message: err.Error()}
+			return s.sendEvent(ctx, violation.ErrorEvent())
+		}
+		msg.Content, e.Audio = converted.data, converted.audio
+	}
+	// the converted audio is recorded, not what the client sent
+	s.record(recordings.SideUser, e.Audio)
+	if s.vad != nil {
+		return s.detectSpeech(ctx, msg, e)
+	}
+	s.touchOnSpeech(e.Audio)
+
+	return s.send(ctx, s.toUpstream, msg)
+}
+
+func (s *proxySession) onUpstreamMessage(ctx context.Context, msg *Message) bool {
+	s.journal.frame(PeerUpstream, PeerClient, msg)
+	if msg.Type == websocket.TextMessage {
+		if msg.Content = s.onServerEvent(ctx, msg.Content); msg.Content == nil {
+			return true
+		}
+	}
+	if s.mediaStream != nil {
+		if msg = s.toMediaStream(msg.Content); msg == nil {
+			return true
+		}
+	}
+
+	return s.send(ctx, s.toClient, msg)
+}
+
+// onServerEvent handles the server events the proxy acts on and returns
+// the event to pass on to the client, nil when the client doesn't get
+// it. The envelope is decoded first so audio deltas are only scanned
+// once.
+func (s *proxySession) onServerEvent(ctx context.Context, data []byte) []byte {
+	var envelope Event
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return data
+	}
+	switch envelope.Type {
+	case EventTypeResponseCreated:
+		s.trackResponse(ctx, envelope.Type)
+		s.history.trackResponse(envelope.Type)
+		return data
+	case EventTypeInputAudioBufferSpeechStarted:
+		s.interrupt(ctx)
+		return data
+	case EventTypeResponseDone, EventTypeInputAudioTranscriptionCompleted, EventTypeResponseAudioTranscriptDone,
+		EventTypeResponseAudioDelta, EventTypeResponseAudioDone:
+	case EventTypeSessionUpdated, EventTypeConversationItemDeleted:
+		if s.history == nil {
+			return data
+		}
+	case EventTypeResponseFunctionCallArgumentsDelta, EventTypeResponseFunctionCallArgumentsDone,
+		EventTypeResponseOutputItemAdded, EventTypeResponseOutputItemDone, EventTypeConversationItemCreated:
+	default:
+		return data
+	}
+	event, err := ParseServerEvent(data)
+	if err != nil {
+		log.Printf("realtime session %s: %v", s.id, err)
+		return data
+	}
+	s.history.track(event)
+
+	switch e := event.(type) {
+	case *ResponseDoneEvent:
+		s.trackResponse(ctx, e.Type)
+		s.history.trackResponse(e.Type)
+		s.meter(e)
+		if e.Response != nil {
+			s.playback.responseDone(e.Response.ID, func(transcript string) uuid.UUID {
+				return s.transcript.add(conversations.RoleAssistant, transcript)
+			})
+		}
+		return s.onToolEvent(ctx, e, data)
+	case *InputAudioTranscriptionCompletedEvent:
+		s.transcript.add(conversations.RoleUser, e.Transcript)
+	case *ResponseAudioTranscriptDoneEvent:
+		// the transcript of the playing item is held until its response is done
+		if heard, ok := s.playback.transcriptDone(e.ItemID, e.Transcript); ok {
+			s.transcript.add(conversations.RoleAssistant, heard)
+			if heard != e.Transcript {
+				s.history.truncate(e.ItemID, heard)
+			}
+		}
+	case *ResponseAudioDoneEvent:
+		s.playback.audioDone(e.ItemID)
+	case *ResponseDeltaEvent:
+		if finished := s.playback.delta(e, s.now()); finished != nil {
+			s.storeHeard(finished)
+		}
+		s.record(recordings.SideAssistant, e.Delta)
+		if s.outputAudio != nil {
+			converted, err := s.convert(s.outputAudio, data, "delta", e.Delta)
+			if err != nil {
+				log.Printf("realtime session %s: couldn't convert audio: %v", s.id, err)
+				return data
+			}
+			return converted.data
+		}
+	default:
+		return s.onToolEvent(ctx, event, data)
+	}
+
+	return data
+}
+
+type convertedAudio struct {
+	// data is the event with the converted audio
+	data  []byte
+	audio string
+}
+
+// convert converts the base64 audio in field of an event
+func (s *proxySession) convert(c *audio.Converter, data []byte, field, encoded string) (*convertedAudio, error) {
+	converted, err := c.ConvertBase64(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = setStringField(data, field, converted); err != nil {
+		return nil, err
+	}
+
+	return &convertedAudio{data: data, audio: converted}, nil
+}
+
+func (s *proxySession) record(side recordings.Side, data string) {
+	if s.recorder == nil {
+		return
+	}
+	if err := s.recorder.WriteBase64(side, data); err != nil {
+		log.Printf("realtime session %s: couldn't record %s audio: %v", s.id, side, err)
+	}
+}
+
+// closeRecorder stores the recording once nothing writes to it anymore
+func (s *proxySession) closeRecorder() {
+	if s.recorder == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), recordingSaveWait)
+	defer cancel()
+	if err := s.recorder.Close(ctx); err != nil {
+		log.Printf("realtime session %s: couldn't store recording: %v", s.id, err)
+	}
+}
+
+func (s *proxySession) clientReadError(err error) error {
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+		return fmt.Errorf("%w: %w", ErrClientClosed, err)
+	}
+	if errors.Is(err, ErrMessageTooBig) {
+		return newCloseError(websocket.ClosePolicyViolation, fmt.Sprintf("messages are limited to %d bytes", s.client.maxMessageSize), err)
+	}
+
+	return newCloseError(websocket.CloseProtocolError, "couldn't read from client", fmt.Errorf("%w: %w", ErrClientClosed, err))
+}
+
+// clientWriteError closes a client that doesn't keep up with the session
+// as a policy violation
+func (s *proxySession) clientWriteError(err error) error {
+	if isTimeout(err) {
+		return newCloseError(websocket.ClosePolicyViolation, "client doesn't read fast enough", err)
+	}
+
+	return newCloseError(websocket.CloseInternalServerErr, "couldn't write to client", err)
+}
+
+func (s *proxySession) upstreamWriteError(err error) error {
+	return newCloseError(websocket.CloseInternalServerErr, "couldn't write to upstream", err)
+}
+
+func (s *proxySession) upstreamReadError(err error) error {
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) && closeErr.Code == websocket.CloseNormalClosure {
+		return newCloseError(websocket.CloseNormalClosure, "OpenAI ended the session", fmt.Errorf("%w: %w", ErrUpstreamClosed, err))
+	}
+
+	return newCloseError(websocket.CloseInternalServerErr, "OpenAI disconnected", fmt.Errorf("%w: %w", ErrUpstreamClosed, err))
+}
+
+// teardown sends close frames with the reason to both legs and closes
+// the connections, which unblocks the readers and any stuck writer
+func (s *proxySession) teardown(cause error) {
+	clientCode, clientReason := websocket.CloseNormalClosure, ""
+	var closeErr *CloseError
+	switch {
+	case errors.As(cause, &closeErr):
+		clientCode, clientReason = closeErr.Code, closeErr.Reason
+	case errors.Is(cause, context.Canceled), errors.Is(cause, context.DeadlineExceeded):
+		clientCode, clientReason = websocket.CloseGoingAway, "server is shutting down"
+	}
+
+	upstreamReason := "client disconnected"
+	if !errors.Is(cause, ErrClientClosed) {
+		upstreamReason = "session ended"
+		writeClose(s.clientWs, clientCode, clientReason)
+	}
+	if !errors.Is(cause, ErrUpstreamClosed) {
+		writeClose(s.upstream, websocket.CloseNormalClosure, upstreamReason)
+	}
+
+	if err := s.clientWs.Close(); err != nil {
+		log.Printf("couldn't close client connection: %v", err)
+	}
+	if err := s.upstream.Close(); err != nil {
+		log.Printf("couldn't close OpenAI connection: %v", err)
+	}
+}
+
+func writeClose(conn wsConn, code int, reason string) {
+	if len(reason) > maxCloseReasonLength {
+		reason = reason[:maxCloseReasonLength]
+	}
+	msg := websocket.FormatCloseMessage(code, reason)
+	// the peer may already be gone, there is nothing to do about it
+	_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeWait))
+}
@@ -0,0 +1,254 @@
+package realtime
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"proomptmachinee/internal/config"
+	"proomptmachinee/internal/services/personas"
+	"proomptmachinee/internal/services/tools"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	testWait = 5 * time.Second
+	// testUserHeader signs the client in as the user it names
+	testUserHeader = "X-Test-User"
+)
+
+// testProxy runs a Client against a fake OpenAI upstream, every
+// upstream connection the proxy opens is handed to the test
+type testProxy struct {
+	client    *Client
+	url       string
+	upstreams chan *websocket.Conn
+	// sessions gets what WsHandler returned once a session ended
+	sessions chan error
+}
+
+func newTestProxy(t *testing.T, cfg config.RealtimeConfig) *testProxy {
+	t.Helper()
+	return newToolProxy(t, cfg, nil, nil)
+}
+
+// newToolProxy is a testProxy whose persona has tools of the registry
+func newToolProxy(t *testing.T, cfg config.RealtimeConfig, registry *tools.Registry, personaTools []string) *testProxy {
+	t.Helper()
+	p := &testProxy{upstreams: make(chan *websocket.Conn, 1), sessions: make(chan error, 1)}
+	upgrader := websocket.Upgrader{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		p.upstreams <- conn
+	}))
+	t.Cleanup(upstream.Close)
+
+	if cfg.URL == "" {
+		cfg.URL = "ws" + strings.TrimPrefix(upstream.URL, "http")
+	}
+	cfg.AllowAnonymous = true
+	// a dropped upstream ends the session unless the test resumes it
+	if cfg.ResumeAttempts == 0 {
+		cfg.ResumeAttempts = -1
+	}
+	catalog := personas.NewCatalog([]config.PersonaConfig{{Name: "test", Instructions: "You are a test.", Tools: personaTools}})
+	client := NewRealtimeClient("test", "gpt-4o-realtime-preview", cfg, catalog, nil, nil, nil, registry)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var caller *Caller
+		if userID := r.Header.Get(testUserHeader); userID != "" {
+			caller = &Caller{UserID: userID}
+		}
+		p.sessions <- client.WsHandler(w, r, caller)
+	}))
+	t.Cleanup(proxy.Close)
+	p.url = "ws" + strings.TrimPrefix(proxy.URL, "http")
+	p.client = client
+
+	return p
+}
+
+// open connects a client and waits for the proxy to dial upstream
+func (p *testProxy) open(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	t.Helper()
+	return p.openQuery(t, "")
+}
+
+// openQuery opens a session with the options of the query
+func (p *testProxy) openQuery(t *testing.T, query string) (*websocket.Conn, *websocket.Conn) {
+	t.Helper()
+	client, _, err := websocket.DefaultDialer.Dial(p.url+"?"+query, nil)
+	if err != nil {
+		t.Fatalf("couldn't connect to the proxy: %v", err)
+	}
+	select {
+	case upstream := <-p.upstreams:
+		// the session update comes first
+		if _, _, err := upstream.ReadMessage(); err != nil {
+			t.Fatalf("couldn't read session update: %v", err)
+		}
+		return client, upstream
+	case <-time.After(testWait):
+		t.Fatal("proxy didn't dial upstream")
+		return nil, nil
+	}
+}
+
+// ended waits for WsHandler to return
+func (p *testProxy) ended(t *testing.T) error {
+	t.Helper()
+	select {
+	case err := <-p.sessions:
+		return err
+	case <-time.After(testWait):
+		t.Fatal("session didn't end")
+		return nil
+	}
+}
+
+// readEvent reads from conn until an event of eventType arrives
+func readEvent(t *testing.T, conn *websocket.Conn, eventType string) []byte {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(testWait))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("didn't get %s: %v", eventType, err)
+		}
+		var e Event
+		if json.Unmarshal(data, &e) == nil && e.Type == eventType {
+			return data
+		}
+	}
+}
+
+// readClose reads from conn until the close frame and returns it
+func readClose(t *testing.T, conn *websocket.Conn) *websocket.CloseError {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(testWait))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			t.Fatalf("expected a close frame, got %v", err)
+		}
+		return closeErr
+	}
+}
+
+// checkGoroutines fails when more goroutines run than before the
+// session, once they had a moment to return
+func checkGoroutines(t *testing.T, baseline int) {
+	t.Helper()
+	deadline := time.Now().Add(testWait)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines leaked:\n%s", runtime.NumGoroutine()-baseline, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSessionUpstreamDrop(t *testing.T) {
+	p := newTestProxy(t, config.RealtimeConfig{})
+	baseline := runtime.NumGoroutine()
+	client, upstream := p.open(t)
+
+	upstream.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "boom"), time.Now().Add(time.Second))
+	upstream.Close()
+
+	closeErr := readClose(t, client)
+	if closeErr.Code != websocket.CloseInternalServerErr || closeErr.Text != "OpenAI disconnected" {
+		t.Errorf("client got close %d %q", closeErr.Code, closeErr.Text)
+	}
+	if err := p.ended(t); !errors.Is(err, ErrUpstreamClosed) {
+		t.Errorf("session ended with %v, expected the upstream to be closed", err)
+	}
+	client.Close()
+	checkGoroutines(t, baseline)
+}
+
+func TestSessionClientClose(t *testing.T) {
+	p := newTestProxy(t, config.RealtimeConfig{})
+	baseline := runtime.NumGoroutine()
+	client, upstream := p.open(t)
+
+	client.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"), time.Now().Add(time.Second))
+
+	closeErr := readClose(t, upstream)
+	if closeErr.Code != websocket.CloseNormalClosure || closeErr.Text != "client disconnected" {
+		t.Errorf("upstream got close %d %q", closeErr.Code, closeErr.Text)
+	}
+	if err := p.ended(t); err != nil {
+		t.Errorf("a client closing isn't a failure, session ended with %v", err)
+	}
+	client.Close()
+	upstream.Close()
+	checkGoroutines(t, baseline)
+}
+
+func TestSessionStalledClient(t *testing.T) {
+	p := newTestProxy(t, config.RealtimeConfig{Connection: config.RealtimeConnection{WriteTimeout: 200 * time.Millisecond}})
+	baseline := runtime.NumGoroutine()
+	client, upstream := p.open(t)
+
+	// the client never reads, the proxy's writes stall once the socket
+	// buffers are full
+	delta := []byte(`{"type":"response.text.delta","delta":"` + strings.Repeat("a", 64<<10) + `"}`)
+	flooded := make(chan struct{})
+	go func() {
+		defer close(flooded)
+		for {
+			upstream.SetWriteDeadline(time.Now().Add(testWait))
+			if err := upstream.WriteMessage(websocket.TextMessage, delta); err != nil {
+				return
+			}
+		}
+	}()
+
+	closeErr := readClose(t, upstream)
+	if closeErr.Code != websocket.CloseNormalClosure || closeErr.Text != "session ended" {
+		t.Errorf("upstream got close %d %q", closeErr.Code, closeErr.Text)
+	}
+	var closeErr2 *CloseError
+	if err := p.ended(t); !errors.As(err, &closeErr2) || closeErr2.Code != websocket.ClosePolicyViolation {
+		t.Errorf("session ended with %v, expected a stalled client", err)
+	}
+	upstream.Close()
+	<-flooded
+	client.Close()
+	checkGoroutines(t, baseline)
+}
+
+func TestSessionDialFailure(t *testing.T) {
+	// nothing listens on a closed server
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	p := newTestProxy(t, config.RealtimeConfig{URL: "ws" + strings.TrimPrefix(dead.URL, "http")})
+	baseline := runtime.NumGoroutine()
+	client, _, err := websocket.DefaultDialer.Dial(p.url, nil)
+	if err != nil {
+		t.Fatalf("couldn't connect to the proxy: %v", err)
+	}
+
+	closeErr := readClose(t, client)
+	if closeErr.Code != websocket.CloseInternalServerErr || closeErr.Text != "failed to connect to OpenAI" {
+		t.Errorf("client got close %d %q", closeErr.Code, closeErr.Text)
+	}
+	if err := p.ended(t); err == nil {
+		t.Error("session without upstream ended without an error")
+	}
+	client.Close()
+	checkGoroutines(t, baseline)
+}
@@ -0,0 +1,153 @@
+package realtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"proomptmachinee/internal/audio"
+	"proomptmachinee/internal/services/usage"
+	"time"
+)
+
+const OpenAiRealtimeSessionsUrl = "https://api.openai.com/v1/realtime/sessions"
+
+// SessionRequest creates an upstream session configured like the
+// `session.update` the proxy would send
+type SessionRequest struct {
+	Model string `json:"model"`
+	*Session
+}
+
+type ClientSecret struct {
+	Value string `json:"value"`
+	// ExpiresAt is a unix timestamp, the secret only opens the session
+	// which may outlive it
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+// ClientSession is an upstream session a browser connects to directly,
+// authenticating with the ephemeral client secret
+type ClientSession struct {
+	ID           string       `json:"id"`
+	Model        string       `json:"model"`
+	Persona      string       `json:"persona,omitempty"`
+	Modalities   []string     `json:"modalities,omitempty"`
+	Voice        string       `json:"voice,omitempty"`
+	ClientSecret ClientSecret `json:"client_secret"`
+}
+
+// SessionMinter creates upstream sessions for direct clients, it can be
+// swapped with a fake
+type SessionMinter interface {
+	CreateSession(ctx context.Context, req *SessionRequest) (*ClientSession, error)
+}
+
+type httpSessionMinter struct {
+	key    string
+	url    string
+	client *http.Client
+}
+
+func NewSessionMinter(key, url string) SessionMinter {
+	if url == "" {
+		url = OpenAiRealtimeSessionsUrl
+	}
+
+	return &httpSessionMinter{key: key, url: url, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (m *httpSessionMinter) CreateSession(ctx context.Context, req *SessionRequest) (*ClientSession, error) {
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal json: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", m.key))
+
+	resp, err := m.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("error: %s\nBody: %s", resp.Status, string(bodyBytes))
+	}
+
+	var session ClientSession
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		return nil, fmt.Errorf("couldn't decode session: %w", err)
+	}
+	if session.ClientSecret.Value == "" {
+		return nil, fmt.Errorf("session %s has no client secret", session.ID)
+	}
+
+	return &session, nil
+}
+
+// UseSessionMinter replaces the upstream that mints client sessions
+func (c *Client) UseSessionMinter(m SessionMinter) {
+	c.minter = m
+}
+
+// CreateClientSession mints an upstream session for a browser that
+// connects over WebRTC without the proxy. The session is configured for
+// the persona like a proxied one, the quota is checked first and the
+// session is recorded in the ledger with its estimated usage. The proxy
+// never sees the client's events, so only options the upstream applies
+// itself are accepted.
+func (c *Client) CreateClientSession(ctx context.Context, userID string, opts *SessionOptions) (*ClientSession, error) {
+	if opts.Audio() != audio.Realtime {
+		return nil, invalid("audio conversion needs the proxied session")
+	}
+	if opts.RecordingConsent {
+		return nil, invalid("recording needs the proxied session")
+	}
+	p, err := c.personas.Get(opts.Persona)
+	if err != nil {
+		return nil, fmt.Errorf("persona %q: %w", opts.Persona, err)
+	}
+	update, err := c.allowlist.Build(opts, p)
+	if err != nil {
+		return nil, err
+	}
+	if c.allowlist.VAD(opts) != nil {
+		return nil, invalid("%s needs the proxied session", TurnDetectionProxyVad)
+	}
+	if _, err := c.checkQuota(ctx, userID, true); err != nil {
+		return nil, err
+	}
+
+	session, err := c.minter.CreateSession(ctx, &SessionRequest{Model: c.model, Session: update.Session})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create client session: %w", err)
+	}
+	session.Persona = p.Name
+	session.Modalities = update.Session.Modalities
+	session.Voice = update.Session.Voice
+	log.Printf("realtime client session %s minted for user %s with %s", session.ID, userID, p.Name)
+
+	if c.ledger != nil {
+		err := c.ledger.Record(ctx, &usage.Entry{
+			UserID:    userID,
+			SessionID: session.ID,
+			Source:    usage.SourceRealtimeSession,
+			Model:     c.model,
+			Usage:     c.estimateClientSession(),
+		})
+		if err != nil {
+			log.Printf("couldn't record client session %s: %v", session.ID, err)
+		}
+	}
+
+	return session, nil
+}
@@ -0,0 +1,181 @@
+package realtime
+
+import (
+	"context"
+	"errors"
+	"proomptmachinee/internal/config"
+	"proomptmachinee/internal/services/openai"
+	"proomptmachinee/internal/services/personas"
+	"proomptmachinee/internal/services/usage"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLedger keeps the entries in memory, Aggregate only filters by
+// user and source
+type fakeLedger struct {
+	mu      sync.Mutex
+	entries []*usage.Entry
+	minutes float64
+}
+
+func (l *fakeLedger) Record(ctx context.Context, e *usage.Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e.CostUSD, _ = openai.Cost(e.Model, e.Usage)
+	l.entries = append(l.entries, e)
+
+	return nil
+}
+
+func (l *fakeLedger) Aggregate(ctx context.Context, q usage.Query) ([]*usage.Aggregate, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	total := &usage.Aggregate{}
+	for _, e := range l.entries {
+		if (q.UserID != "" && e.UserID != q.UserID) || (q.Source != "" && e.Source != q.Source) {
+			continue
+		}
+		total.Responses++
+		total.InputTokens += int64(e.Usage.InputTokens)
+		total.OutputTokens += int64(e.Usage.OutputTokens)
+		total.AudioInputTokens += int64(e.Usage.AudioInputTokens)
+		total.AudioOutputTokens += int64(e.Usage.AudioOutputTokens)
+		total.CostUSD += e.CostUSD
+	}
+	if total.Responses == 0 {
+		return nil, nil
+	}
+
+	return []*usage.Aggregate{total}, nil
+}
+
+func (l *fakeLedger) VoiceMinutes(ctx context.Context, userID string, from time.Time) (float64, error) {
+	return l.minutes, nil
+}
+
+func (l *fakeLedger) StartSession(ctx context.Context, id, userID, model string) error {
+	return nil
+}
+
+func (l *fakeLedger) EndSession(ctx context.Context, id string, totals *usage.SessionTotals, sessionErr error) error {
+	return nil
+}
+
+// bySource returns the recorded entries of source
+func (l *fakeLedger) bySource(source string) []*usage.Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var entries []*usage.Entry
+	for _, e := range l.entries {
+		if e.Source == source {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries
+}
+
+func newSessionsClient(quota config.RealtimeQuota) (*Client, *FakeSessionMinter, *fakeLedger) {
+	catalog := personas.NewCatalog([]config.PersonaConfig{{Name: "test", Instructions: "You are a test."}})
+	client := NewRealtimeClient("test", openai.Gpt40RealtimePreview, config.RealtimeConfig{Quota: quota}, catalog, nil, nil, nil, nil)
+	minter := &FakeSessionMinter{}
+	client.UseSessionMinter(minter)
+	ledger := &fakeLedger{}
+	client.UseLedger(ledger)
+
+	return client, minter, ledger
+}
+
+func TestCreateClientSession(t *testing.T) {
+	client, minter, ledger := newSessionsClient(config.RealtimeQuota{})
+	session, err := client.CreateClientSession(context.Background(), "user-1", &SessionOptions{Voice: "alloy"})
+	if err != nil {
+		t.Fatalf("couldn't mint: %v", err)
+	}
+	if session.Persona != "test" || session.ClientSecret.Value == "" {
+		t.Errorf("got %+v", session)
+	}
+	if len(minter.Requests) != 1 || minter.Requests[0].Session.Instructions != "You are a test." {
+		t.Fatalf("minter got %+v", minter.Requests)
+	}
+
+	entries := ledger.bySource(usage.SourceRealtimeSession)
+	if len(entries) != 1 {
+		t.Fatalf("recorded %d sessions, want 1", len(entries))
+	}
+	// 10 minutes, half of them heard at $100 and half spoken at $200
+	// per 1M audio tokens
+	if e := entries[0]; e.UserID != "user-1" || e.SessionID != session.ID || e.CostUSD < 1.49 || e.CostUSD > 1.51 {
+		t.Errorf("recorded %+v", e)
+	}
+}
+
+func TestCreateClientSessionRejectsProxyOptions(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts *SessionOptions
+	}{
+		{"audio conversion", &SessionOptions{AudioFormat: "g711_ulaw"}},
+		{"recording", &SessionOptions{RecordingConsent: true}},
+		{"proxy vad", &SessionOptions{TurnDetection: TurnDetectionProxyVad}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, minter, ledger := newSessionsClient(config.RealtimeQuota{})
+			_, err := client.CreateClientSession(context.Background(), "user-1", tc.opts)
+			if !errors.Is(err, ErrInvalidOptions) {
+				t.Fatalf("got %v, want ErrInvalidOptions", err)
+			}
+			if len(minter.Requests) != 0 || len(ledger.entries) != 0 {
+				t.Errorf("minted %d sessions and recorded %d entries", len(minter.Requests), len(ledger.entries))
+			}
+		})
+	}
+}
+
+func TestCreateClientSessionQuota(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		quota   config.RealtimeQuota
+		entries []*usage.Entry
+		refused bool
+	}{
+		{"within", config.RealtimeQuota{DailyCostUSD: 5, DailyClientSessions: 2}, nil, false},
+		{"estimate over the cost", config.RealtimeQuota{DailyCostUSD: 1}, nil, true},
+		{"shorter estimate", config.RealtimeQuota{DailyCostUSD: 1, ClientSessionMinutes: 5}, nil, false},
+		{"proxied usage", config.RealtimeQuota{DailyCostUSD: 2}, []*usage.Entry{
+			{UserID: "user-1", Source: usage.SourceRealtime, Usage: openai.Usage{AudioOutputTokens: 5000}},
+		}, true},
+		{"minted sessions", config.RealtimeQuota{DailyCostUSD: 2.5}, []*usage.Entry{
+			{UserID: "user-1", Source: usage.SourceRealtimeSession, Usage: openai.Usage{AudioOutputTokens: 6000}},
+		}, true},
+		{"fallback usage", config.RealtimeQuota{DailyCostUSD: 2}, []*usage.Entry{
+			{UserID: "user-1", Source: usage.SourceRealtimeFallback, Usage: openai.Usage{AudioOutputTokens: 5000}},
+		}, true},
+		{"other user", config.RealtimeQuota{DailyCostUSD: 2}, []*usage.Entry{
+			{UserID: "user-2", Source: usage.SourceRealtime, Usage: openai.Usage{AudioOutputTokens: 5000}},
+		}, false},
+		{"session count", config.RealtimeQuota{DailyClientSessions: 1}, []*usage.Entry{
+			{UserID: "user-1", Source: usage.SourceRealtimeSession},
+		}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, minter, ledger := newSessionsClient(tc.quota)
+			for _, e := range tc.entries {
+				e.Model = openai.Gpt40RealtimePreview
+				_ = ledger.Record(context.Background(), e)
+			}
+			_, err := client.CreateClientSession(context.Background(), "user-1", &SessionOptions{})
+			switch {
+			case tc.refused && !errors.Is(err, ErrQuotaExceeded):
+				t.Fatalf("got %v, want ErrQuotaExceeded", err)
+			case !tc.refused && err != nil:
+				t.Fatalf("couldn't mint: %v", err)
+			}
+			if minted := len(minter.Requests) == 1; minted == tc.refused {
+				t.Errorf("minted %d sessions", len(minter.Requests))
+			}
+		})
+	}
+}
@@ -0,0 +1,393 @@
+package realtime
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"proomptmachinee/internal/audio"
+	"proomptmachinee/internal/services/personas"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Media stream events, the telephony protocol in the style of Twilio
+// Media Streams. The provider sends connected, start, media, mark, dtmf
+// and stop, the proxy answers with media, mark and clear.
+const (
+	MediaStreamEventConnected = "connected"
+	MediaStreamEventStart     = "start"
+	MediaStreamEventMedia     = "media"
+	MediaStreamEventMark      = "mark"
+	MediaStreamEventDTMF      = "dtmf"
+	MediaStreamEventStop      = "stop"
+	MediaStreamEventClear     = "clear"
+
+	MediaEncodingMulaw = "audio/x-mulaw"
+	MediaEncodingAlaw  = "audio/x-alaw"
+)
+
+// Custom parameters of the start event the bridge understands
+const (
+	CallParameterTo               = "to"
+	CallParameterPersona          = "persona"
+	CallParameterLanguage         = "language"
+	CallParameterRecordingConsent = "recording_consent"
+	CallParameterSecret           = "secret"
+)
+
+// TwilioSignatureHeader signs the stream upgrade with the account's auth
+// token
+const TwilioSignatureHeader = "X-Twilio-Signature"
+
+var (
+	ErrInvalidCall = errors.New("invalid call")
+	// ErrTelephonyUnsecured would let anyone open a voice session by
+	// calling the media stream endpoint
+	ErrTelephonyUnsecured = errors.New("telephony is enabled without a secret or auth token")
+)
+
+type MediaStreamMessage struct {
+	Event          string            `json:"event"`
+	SequenceNumber string            `json:"sequenceNumber,omitempty"`
+	StreamSid      string            `json:"streamSid,omitempty"`
+	Protocol       string            `json:"protocol,omitempty"`
+	Version        string            `json:"version,omitempty"`
+	Start          *MediaStreamStart `json:"start,omitempty"`
+	Media          *MediaStreamMedia `json:"media,omitempty"`
+	Mark           *MediaStreamMark  `json:"mark,omitempty"`
+	Stop           *MediaStreamStop  `json:"stop,omitempty"`
+}
+
+type MediaStreamStart struct {
+	AccountSid       string            `json:"accountSid,omitempty"`
+	StreamSid        string            `json:"streamSid"`
+	CallSid          string            `json:"callSid,omitempty"`
+	Tracks           []string          `json:"tracks,omitempty"`
+	CustomParameters map[string]string `json:"customParameters,omitempty"`
+	MediaFormat      MediaFormat       `json:"mediaFormat"`
+}
+
+func (s *MediaStreamStart) secret() (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	secret, ok := s.CustomParameters[CallParameterSecret]
+
+	return secret, ok
+}
+
+type MediaFormat struct {
+	Encoding   string `json:"encoding"`
+	SampleRate int    `json:"sampleRate"`
+	Channels   int    `json:"channels"`
+}
+
+type MediaStreamMedia struct {
+	Track     string `json:"track,omitempty"`
+	Chunk     string `json:"chunk,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+	// Payload is base64 encoded audio in the format of the start event
+	Payload string `json:"payload"`
+}
+
+type MediaStreamMark struct {
+	Name string `json:"name"`
+}
+
+type MediaStreamStop struct {
+	AccountSid string `json:"accountSid,omitempty"`
+	CallSid    string `json:"callSid,omitempty"`
+}
+
+// mediaStream is the call a session is bridged to
+type mediaStream struct {
+	streamSid string
+	callSid   string
+}
+
+// TelephonyHandler upgrades a media stream of a phone call and bridges it
+// into an OpenAI realtime session, converting the audio both ways. It
+// blocks until the call or the session ends.
+func (c *Client) TelephonyHandler(w http.ResponseWriter, r *http.Request) error {
+	if !c.telephony.Enabled {
+		http.NotFound(w, r)
+		return nil
+	}
+	if c.telephony.Secret == "" && c.telephony.AuthToken == "" {
+		http.Error(w, "telephony isn't configured", http.StatusServiceUnavailable)
+		return ErrTelephonyUnsecured
+	}
+	if c.telephony.AuthToken != "" {
+		if err := c.checkSignature(r); err != nil {
+			http.Error(w, "invalid signature", http.StatusForbidden)
+			return err
+		}
+	}
+
+	conn, err := c.upgrade(w, r, nil)
+	if err != nil {
+		return fmt.Errorf("couldn't upgrade connection: %w", err)
+	}
+
+	sessionID := uuid.NewString()
+	log.Printf("realtime session %s opened for a call from %s", sessionID, r.RemoteAddr)
+
+	var frames *journal
+	if c.journal.Enabled {
+		if frames, err = openJournal(c.journal.Dir, sessionID); err != nil {
+			log.Printf("realtime session %s: couldn't open journal: %v", sessionID, err)
+		}
+		defer frames.close()
+		frames.start(sessionID, r.URL.RawQuery)
+	}
+
+	start, err := c.waitForStart(conn, frames)
+	if err == nil {
+		err = c.checkCall(start)
+	}
+	var setup *sessionSetup
+	if err == nil {
+		setup, err = c.callSetup(start)
+	}
+	if err != nil {
+		code, reason := websocket.CloseInternalServerErr, "couldn't configure session"
+		if errors.Is(err, ErrInvalidCall) || errors.Is(err, ErrInvalidOptions) || errors.Is(err, personas.ErrNotFound) {
+			code, reason = websocket.ClosePolicyViolation, err.Error()
+		}
+		writeClose(conn, code, reason)
+		conn.Close()
+		return fmt.Errorf("call setup failed: %w", err)
+	}
+	log.Printf("realtime session %s: call %s answered by %s", sessionID, start.CallSid, setup.persona.Name)
+
+	params := &sessionParams{
+		id:          sessionID,
+		setup:       setup,
+		journal:     frames,
+		mediaStream: &mediaStream{streamSid: start.StreamSid, callSid: start.CallSid},
+	}
+	if c.conversations != nil {
+		conversationID := uuid.New()
+		if _, err := c.conversations.Ensure(r.Context(), conversationID, "", setup.persona.Name); err != nil {
+			writeClose(conn, websocket.CloseInternalServerErr, "couldn't open conversation")
+			conn.Close()
+			return fmt.Errorf("couldn't open conversation %s: %w", conversationID, err)
+		}
+		params.conversationID = &conversationID
+	}
+
+	return c.serve(r.Context(), conn, params)
+}
+
+// waitForStart reads the stream until its start event
+func (c *Client) waitForStart(conn *limitedConn, frames *journal) (*MediaStreamStart, error) {
+	deadline := time.Now().Add(handshakeWait)
+	conn.SetReadDeadline(deadline)
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read start event: %w", err)
+		}
+		var msg MediaStreamMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCall, err)
+		}
+		journaled := data
+		if _, ok := msg.Start.secret(); ok {
+			// the secret has no business in the journal
+			start := *msg.Start
+			start.CustomParameters = maps.Clone(start.CustomParameters)
+			delete(start.CustomParameters, CallParameterSecret)
+			redacted := msg
+			redacted.Start = &start
+			if data, err := json.Marshal(&redacted); err == nil {
+				journaled = data
+			}
+		}
+		frames.frame(PeerClient, PeerProxy, &Message{Content: journaled, Type: messageType})
+
+		switch msg.Event {
+		case MediaStreamEventConnected:
+			continue
+		case MediaStreamEventStart:
+			if msg.Start == nil {
+				return nil, fmt.Errorf("%w: start event without details", ErrInvalidCall)
+			}
+			return msg.Start, nil
+		default:
+			return nil, fmt.Errorf("%w: expected a start event, got %q", ErrInvalidCall, msg.Event)
+		}
+	}
+}
+
+// checkSignature verifies the provider's signature of the upgrade, an
+// HMAC-SHA1 of the stream URL under the auth token
+func (c *Client) checkSignature(r *http.Request) error {
+	signature, err := base64.StdEncoding.DecodeString(r.Header.Get(TwilioSignatureHeader))
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("%w: missing signature", ErrInvalidCall)
+	}
+	if !hmac.Equal(signature, SignStreamURL(c.telephony.AuthToken, c.streamURL(r))) {
+		return fmt.Errorf("%w: wrong signature", ErrInvalidCall)
+	}
+
+	return nil
+}
+
+// streamURL is the URL the provider connected to and signed
+func (c *Client) streamURL(r *http.Request) string {
+	if c.telephony.PublicURL != "" {
+		return strings.TrimSuffix(c.telephony.PublicURL, "/") + r.URL.RequestURI()
+	}
+	scheme := "ws"
+	if r.TLS != nil {
+		scheme = "wss"
+	}
+
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+// SignStreamURL is the signature the provider sends for a stream URL
+func SignStreamURL(authToken, url string) []byte {
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(url))
+
+	return mac.Sum(nil)
+}
+
+// checkCall verifies the shared secret before the start event is trusted,
+// without one the upgrade's signature was checked
+func (c *Client) checkCall(start *MediaStreamStart) error {
+	if c.telephony.Secret == "" {
+		return nil
+	}
+	secret, _ := start.secret()
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(c.telephony.Secret)) != 1 {
+		return fmt.Errorf("%w: wrong secret", ErrInvalidCall)
+	}
+
+	return nil
+}
+
+// callSetup maps the call to a persona, the called number first, then
+// the persona parameter and the configured default last
+func (c *Client) callSetup(start *MediaStreamStart) (*sessionSetup, error) {
+	var encoding string
+	switch start.MediaFormat.Encoding {
+	case MediaEncodingMulaw:
+		encoding = audio.EncodingMulaw
+	case MediaEncodingAlaw:
+		encoding = audio.EncodingAlaw
+	default:
+		return nil, fmt.Errorf("%w: media encoding %q is not supported", ErrInvalidCall, start.MediaFormat.Encoding)
+	}
+
+	params := start.CustomParameters
+	persona := c.telephony.Numbers[params[CallParameterTo]]
+	if persona == "" {
+		persona = params[CallParameterPersona]
+	}
+	if persona == "" {
+		persona = c.telephony.Persona
+	}
+	consent, _ := strconv.ParseBool(params[CallParameterRecordingConsent])
+	opts := &SessionOptions{
+		Persona:  persona,
+		Language: params[CallParameterLanguage],
+		// a phone can't commit the audio buffer itself
+		TurnDetection:    TurnDetectionServerVad,
+		AudioFormat:      encoding,
+		SampleRate:       start.MediaFormat.SampleRate,
+		RecordingConsent: consent,
+	}
+
+	p, err := c.personas.Get(opts.Persona)
+	if err != nil {
+		return nil, fmt.Errorf("persona %q: %w", opts.Persona, err)
+	}
+	update, err := c.allowlist.Build(opts, p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionSetup{options: opts, persona: p, update: update}, nil
+}
+
+// onMediaStreamMessage turns media into `input_audio_buffer.append`
+// events and ends the session when the call stops
+func (s *proxySession) onMediaStreamMessage(ctx context.Context, msg *Message) bool {
+	var event MediaStreamMessage
+	if err := json.Unmarshal(msg.Content, &event); err != nil {
+		log.Printf("realtime session %s: invalid media stream message: %v", s.id, err)
+		return true
+	}
+
+	switch event.Event {
+	case MediaStreamEventMedia:
+		if event.Media == nil || (event.Media.Track != "" && event.Media.Track != "inbound") {
+			return true
+		}
+		data, err := json.Marshal(&InputAudioBufferAppendEvent{
+			Event: Event{Type: EventTypeInputAudioBufferAppend},
+			Audio: event.Media.Payload,
+		})
+		if err != nil {
+			return true
+		}
+		return s.forwardClientEvent(ctx, &Message{Content: data, Type: websocket.TextMessage})
+	case MediaStreamEventStop:
+		s.cancel(fmt.Errorf("%w: call %s ended", ErrClientClosed, s.mediaStream.callSid))
+		return false
+	}
+
+	return true
+}
+
+// toMediaStream translates a server event for the call, audio is played
+// and speech from the caller clears what is still queued for playback so
+// the persona stops talking. Other events have no media stream
+// counterpart and are dropped.
+func (s *proxySession) toMediaStream(data []byte) *Message {
+	var envelope Event
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil
+	}
+
+	reply := &MediaStreamMessage{StreamSid: s.mediaStream.streamSid}
+	switch envelope.Type {
+	case EventTypeResponseAudioDelta:
+		var delta ResponseDeltaEvent
+		if err := json.Unmarshal(data, &delta); err != nil {
+			return nil
+		}
+		reply.Event = MediaStreamEventMedia
+		reply.Media = &MediaStreamMedia{Payload: delta.Delta}
+	case EventTypeInputAudioBufferSpeechStarted:
+		reply.Event = MediaStreamEventClear
+	case EventTypeError:
+		log.Printf("realtime session %s: OpenAI error: %s", s.id, data)
+		return nil
+	default:
+		return nil
+	}
+
+	content, err := json.Marshal(reply)
+	if err != nil {
+		return nil
+	}
+
+	return &Message{Content: content, Type: websocket.TextMessage}
+}
@@ -0,0 +1,54 @@
+package realtime
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"proomptmachinee/internal/config"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTelephonyAuth(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		telephony config.TelephonyConfig
+		sign      string
+		status    int
+		err       error
+	}{
+		{"no secret", config.TelephonyConfig{Enabled: true}, "", http.StatusServiceUnavailable, ErrTelephonyUnsecured},
+		{"unsigned", config.TelephonyConfig{Enabled: true, AuthToken: "token"}, "", http.StatusForbidden, ErrInvalidCall},
+		{"wrong token", config.TelephonyConfig{Enabled: true, AuthToken: "token"}, "other", http.StatusForbidden, ErrInvalidCall},
+		{"signed", config.TelephonyConfig{Enabled: true, AuthToken: "token"}, "token", http.StatusSwitchingProtocols, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := NewRealtimeClient("test", "gpt-4o-realtime-preview", config.RealtimeConfig{Telephony: tc.telephony}, nil, nil, nil, nil, nil)
+			errs := make(chan error, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				errs <- client.TelephonyHandler(w, r)
+			}))
+			defer server.Close()
+
+			url := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/telephony/media-stream"
+			header := http.Header{}
+			if tc.sign != "" {
+				header.Set(TwilioSignatureHeader, base64.StdEncoding.EncodeToString(SignStreamURL(tc.sign, url)))
+			}
+			conn, resp, _ := websocket.DefaultDialer.Dial(url, header)
+			if resp == nil || resp.StatusCode != tc.status {
+				t.Fatalf("upgrade answered %v, want %d", resp, tc.status)
+			}
+			if conn != nil {
+				// the call never starts
+				conn.Close()
+			}
+			if err := <-errs; tc.err != nil && !errors.Is(err, tc.err) {
+				t.Errorf("handler returned %v, want %v", err, tc.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"proomptmachinee/internal/services/tools"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	ToolTypeFunction = "function"
+
+	// how long a tool may run when the config doesn't say
+	defaultToolTimeout = 10 * time.Second
+)
+
+// configureTools registers the persona's tools in the session. The tools
+// run on the server, clients can neither add tools nor answer calls.
+func (c *Client) configureTools(setup *sessionSetup) {
+	if c.tools == nil || len(setup.persona.Tools) == 0 {
+		return
+	}
+	definitions, err := c.tools.Definitions(setup.persona.Tools)
+	if err != nil {
+		log.Printf("persona %s: %v", setup.persona.Name, err)
+		return
+	}
+	session := setup.update.Session
+	for _, d := range definitions {
+		session.Tools = append(session.Tools, Tool{
+			Type:        ToolTypeFunction,
+			Name:        d.Name,
+			Description: d.Description,
+			Parameters:  d.Parameters,
+		})
+	}
+	session.ToolChoice = ToolChoiceAuto
+}
+
+// isToolItem reports items of function calls, which stay between the
+// proxy and OpenAI
+func isToolItem(item *Item) bool {
+	return item != nil && (item.Type == ItemTypeFunctionCall || item.Type == ItemTypeFunctionCallOutput)
+}
+
+// onToolEvent handles the server events of function calls. It returns
+// the event to pass on to the client, nil for events the client doesn't
+// see.
+func (s *proxySession) onToolEvent(ctx context.Context, event TypedEvent, data []byte) []byte {
+	switch e := event.(type) {
+	case *ResponseFunctionCallArgumentsDeltaEvent:
+		return nil
+	case *ResponseFunctionCallArgumentsDoneEvent:
+		calls, ok := s.toolCalls[e.ResponseID]
+		if !ok {
+			calls = &sync.WaitGroup{}
+			s.toolCalls[e.ResponseID] = calls
+		}
+		calls.Add(1)
+		s.tasks.Add(1)
+		go func() {
+			defer s.tasks.Done()
+			defer calls.Done()
+			s.runTool(ctx, e)
+		}()
+		return nil
+	case *ResponseOutputItemEvent:
+		if isToolItem(e.Item) {
+			return nil
+		}
+	case *ConversationItemCreatedEvent:
+		if isToolItem(e.Item) {
+			return nil
+		}
+	case *ResponseDoneEvent:
+		if e.Response == nil {
+			return data
+		}
+		calls, ok := s.toolCalls[e.Response.ID]
+		if !ok {
+			return data
+		}
+		delete(s.toolCalls, e.Response.ID)
+		// the model answers once every output is in the conversation
+		s.tasks.Add(1)
+		go func() {
+			defer s.tasks.Done()
+			calls.Wait()
+			s.sendUpstream(ctx, &ResponseCreateEvent{Event: Event{Type: EventTypeResponseCreate}})
+		}()
+		return withoutToolItems(data)
+	}
+
+	return data
+}
+
+// runTool runs a function call and adds its output to the conversation
+func (s *proxySession) runTool(ctx context.Context, e *ResponseFunctionCallArgumentsDoneEvent) {
+	callCtx, cancel := context.WithTimeout(ctx, s.client.toolTimeout)
+	defer cancel()
+	started := time.Now()
+	output, err := s.client.tools.Run(callCtx, &tools.Call{
+		ID:        e.CallID,
+		Name:      e.Name,
+		Arguments: e.Arguments,
+		UserID:    s.userID,
+		SessionID: s.id,
+		Allowed:   s.tools,
+	})
+	if err != nil {
+		log.Printf("realtime session %s: tool %s failed: %v", s.id, e.Name, err)
+	} else {
+		log.Printf("realtime session %s: tool %s ran in %s", s.id, e.Name, time.Since(started).Round(time.Millisecond))
+	}
+
+	s.sendUpstream(ctx, &ConversationItemCreateEvent{
+		Event: Event{Type: EventTypeConversationItemCreate},
+		Item:  &Item{Type: ItemTypeFunctionCallOutput, CallID: e.CallID, Output: output},
+	})
+}
+
+// sendUpstream queues a client event of the proxy itself for OpenAI
+func (s *proxySession) sendUpstream(ctx context.Context, event TypedEvent) bool {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("couldn't encode %s event: %v", event.EventType(), err)
+		return true
+	}
+	msg := &Message{Content: data, Type: websocket.TextMessage}
+	s.journal.frame(PeerProxy, PeerUpstream, msg)
+
+	return s.send(ctx, s.toUpstream, msg)
+}
+
+// withoutToolItems removes the function calls from the output of a
+// `response.done` event, everything else is passed on as it came
+func withoutToolItems(data []byte) []byte {
+	var event map[string]json.RawMessage
+	if err := json.Unmarshal(data, &event); err != nil {
+		return data
+	}
+	var response map[string]json.RawMessage
+	if err := json.Unmarshal(event["response"], &response); err != nil {
+		return data
+	}
+	var output []json.RawMessage
+	if err := json.Unmarshal(response["output"], &output); err != nil {
+		return data
+	}
+
+	kept := make([]json.RawMessage, 0, len(output))
+	for _, raw := range output {
+		var item Item
+		if err := json.Unmarshal(raw, &item); err == nil && isToolItem(&item) {
+			continue
+		}
+		kept = append(kept, raw)
+	}
+
+	var err error
+	if response["output"], err = json.Marshal(kept); err != nil {
+		return data
+	}
+	if event["response"], err = json.Marshal(response); err != nil {
+		return data
+	}
+	stripped, err := json.Marshal(event)
+	if err != nil {
+		return data
+	}
+
+	return stripped
+}
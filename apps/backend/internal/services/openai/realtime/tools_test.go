@@ -0,0 +1,92 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"proomptmachinee/internal/config"
+	"proomptmachinee/internal/services/tools"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func newTestTools(started chan<- string) *tools.Registry {
+	registry := tools.NewRegistry()
+	for _, name := range []string{"allowed", "other"} {
+		registry.Register(tools.Definition{Name: name}, func(ctx context.Context, call *tools.Call) (string, error) {
+			started <- call.Name
+			// runs until the session ends
+			<-ctx.Done()
+			return "", ctx.Err()
+		})
+	}
+
+	return registry
+}
+
+func sendFunctionCall(t *testing.T, upstream *websocket.Conn, name string) {
+	t.Helper()
+	data, _ := json.Marshal(&ResponseFunctionCallArgumentsDoneEvent{
+		Event:      Event{Type: EventTypeResponseFunctionCallArgumentsDone},
+		ResponseID: "resp_1",
+		CallID:     "call_" + name,
+		Name:       name,
+		Arguments:  "{}",
+	})
+	if err := upstream.WriteMessage(websocket.TextMessage, data); err != nil {
+		t.Fatalf("couldn't send function call: %v", err)
+	}
+}
+
+func TestToolNotAllowed(t *testing.T) {
+	started := make(chan string, 2)
+	p := newToolProxy(t, config.RealtimeConfig{}, newTestTools(started), []string{"allowed"})
+	client, upstream := p.open(t)
+	defer client.Close()
+	defer upstream.Close()
+
+	sendFunctionCall(t, upstream, "other")
+
+	upstream.SetReadDeadline(time.Now().Add(testWait))
+	_, data, err := upstream.ReadMessage()
+	if err != nil {
+		t.Fatalf("no function call output: %v", err)
+	}
+	var created ConversationItemCreateEvent
+	if err := json.Unmarshal(data, &created); err != nil || created.Item == nil || created.Item.CallID != "call_other" {
+		t.Fatalf("expected the call's output, got %s", data)
+	}
+	if !strings.Contains(created.Item.Output, "tool not allowed") {
+		t.Errorf("call of another persona's tool answered with %q", created.Item.Output)
+	}
+	select {
+	case name := <-started:
+		t.Errorf("tool %s ran", name)
+	default:
+	}
+}
+
+func TestToolCallTeardown(t *testing.T) {
+	started := make(chan string, 2)
+	p := newToolProxy(t, config.RealtimeConfig{ToolTimeout: time.Minute}, newTestTools(started), []string{"allowed"})
+	baseline := runtime.NumGoroutine()
+	client, upstream := p.open(t)
+
+	sendFunctionCall(t, upstream, "allowed")
+	select {
+	case <-started:
+	case <-time.After(testWait):
+		t.Fatal("tool didn't run")
+	}
+	client.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+
+	// the tool is cancelled with the session instead of running into
+	// its timeout
+	p.ended(t)
+	client.Close()
+	upstream.Close()
+	checkGoroutines(t, baseline)
+}
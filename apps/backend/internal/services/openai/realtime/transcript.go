@@ -0,0 +1,145 @@
+package realtime
+
+import (
+	"context"
+	"log"
+	"proomptmachinee/internal/services/conversations"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// ConversationIDHeader carries the conversation the transcripts of a
+	// session are stored in back to the client
+	ConversationIDHeader = "X-Conversation-Id"
+
+	transcriptBufferSize = 32
+	transcriptWriteWait  = 5 * time.Second
+)
+
+// transcript stores both sides of a voice session as messages of the
+// user's conversation. The writes happen on their own goroutine so a
+// slow database doesn't hold up the audio. A nil transcript drops
+// everything, which is what sessions without transcripts use.
+type transcript struct {
+	store          *conversations.Store
+	conversationID uuid.UUID
+	sessionID      string
+	persona        string
+	model          string
+
+	writes chan *transcriptWrite
+	done   chan struct{}
+}
+
+// transcriptWrite stores a message, or cuts the content of one stored
+// before when cut is set
+type transcriptWrite struct {
+	message *conversations.Message
+	cut     bool
+}
+
+func newTranscript(store *conversations.Store, conversationID uuid.UUID, sessionID, persona, model string) *transcript {
+	t := &transcript{
+		store:          store,
+		conversationID: conversationID,
+		sessionID:      sessionID,
+		persona:        persona,
+		model:          model,
+		writes:         make(chan *transcriptWrite, transcriptBufferSize),
+		done:           make(chan struct{}),
+	}
+	go t.run()
+
+	return t
+}
+
+// add queues a transcript and returns the id it's stored under, it must
+// not be called after close. When the database fell so far behind that
+// the queue is full the transcript is dropped, the audio isn't held up
+// for it.
+func (t *transcript) add(role, content string) uuid.UUID {
+	content = strings.TrimSpace(content)
+	if t == nil || content == "" {
+		return uuid.Nil
+	}
+	m := &conversations.Message{
+		ID:             uuid.New(),
+		ConversationID: t.conversationID,
+		Role:           role,
+		Content:        content,
+		Persona:        t.persona,
+		Model:          t.model,
+		Source:         conversations.SourceVoice,
+		SessionID:      t.sessionID,
+	}
+	if !t.queue(&transcriptWrite{message: m}) {
+		return uuid.Nil
+	}
+
+	return m.ID
+}
+
+// cut replaces the content of an answer added before with the part the
+// user heard
+func (t *transcript) cut(id uuid.UUID, content string) {
+	if t == nil || id == uuid.Nil {
+		return
+	}
+	m := &conversations.Message{ID: id, Role: conversations.RoleAssistant, Content: strings.TrimSpace(content)}
+	t.queue(&transcriptWrite{message: m, cut: true})
+}
+
+func (t *transcript) queue(w *transcriptWrite) bool {
+	select {
+	case t.writes <- w:
+		return true
+	default:
+		log.Printf("realtime session %s: transcripts are backed up, dropped %s transcript", t.sessionID, w.message.Role)
+		return false
+	}
+}
+
+// close waits for the queued transcripts to be stored
+func (t *transcript) close() {
+	if t == nil {
+		return
+	}
+	close(t.writes)
+	<-t.done
+}
+
+func (t *transcript) run() {
+	defer close(t.done)
+	// answers point at the user turn they followed, like in text chats.
+	// The user transcription may complete after the answer started, it
+	// then is the parent of the next answer.
+	var prompt *uuid.UUID
+	for w := range t.writes {
+		m := w.message
+		ctx, cancel := context.WithTimeout(context.Background(), transcriptWriteWait)
+		if w.cut {
+			err := t.store.UpdateMessageContent(ctx, m.ID, m.Content)
+			cancel()
+			if err != nil {
+				log.Printf("realtime session %s: couldn't cut transcript: %v", t.sessionID, err)
+			}
+			continue
+		}
+		if m.Role == conversations.RoleAssistant {
+			m.ParentID = prompt
+		}
+		err := t.store.AddMessage(ctx, m)
+		cancel()
+		if err != nil {
+			log.Printf("realtime session %s: couldn't store %s transcript: %v", t.sessionID, m.Role, err)
+			continue
+		}
+		if m.Role == conversations.RoleUser {
+			id := m.ID
+			prompt = &id
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package realtime
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTranscriptAddDoesntBlock(t *testing.T) {
+	// nothing stores the queued transcripts, like a stalled database
+	tr := &transcript{sessionID: "test", writes: make(chan *transcriptWrite, 1)}
+	added := make(chan struct{})
+	go func() {
+		defer close(added)
+		tr.add("user", "first")
+		tr.add("assistant", "second")
+	}()
+
+	select {
+	case <-added:
+	case <-time.After(testWait):
+		t.Fatal("add blocked on a full queue")
+	}
+	if n := len(tr.writes); n != 1 {
+		t.Errorf("%d transcripts queued, want the first one", n)
+	}
+	if w := <-tr.writes; w.message.Content != "first" {
+		t.Errorf("queued %q, want the first transcript", w.message.Content)
+	}
+}
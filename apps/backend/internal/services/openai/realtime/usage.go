@@ -0,0 +1,96 @@
+package realtime
+
+import (
+	"context"
+	"log"
+	"proomptmachinee/internal/services/openai"
+	"proomptmachinee/internal/services/usage"
+	"time"
+)
+
+const EventTypeResponseDone = "response.done"
+
+// Ledger stores the usage of sessions and answers the quota, it is
+// implemented by *usage.Ledger and can be swapped with a fake
+type Ledger interface {
+	Record(ctx context.Context, e *usage.Entry) error
+	Aggregate(ctx context.Context, q usage.Query) ([]*usage.Aggregate, error)
+	VoiceMinutes(ctx context.Context, userID string, from time.Time) (float64, error)
+	StartSession(ctx context.Context, id, userID, model string) error
+	EndSession(ctx context.Context, id string, totals *usage.SessionTotals, sessionErr error) error
+}
+
+// UseLedger replaces the ledger usage is recorded in, nil stops recording
+func (c *Client) UseLedger(l Ledger) {
+	c.ledger = l
+}
+
+// ResponseUsage is the `usage` of a `response.done` server event
+type ResponseUsage struct {
+	TotalTokens       int `json:"total_tokens"`
+	InputTokens       int `json:"input_tokens"`
+	OutputTokens      int `json:"output_tokens"`
+	InputTokenDetails struct {
+		CachedTokens        int `json:"cached_tokens"`
+		TextTokens          int `json:"text_tokens"`
+		AudioTokens         int `json:"audio_tokens"`
+		CachedTokensDetails struct {
+			TextTokens  int `json:"text_tokens"`
+			AudioTokens int `json:"audio_tokens"`
+		} `json:"cached_tokens_details"`
+	} `json:"input_token_details"`
+	OutputTokenDetails struct {
+		TextTokens  int `json:"text_tokens"`
+		AudioTokens int `json:"audio_tokens"`
+	} `json:"output_token_details"`
+}
+
+func (u *ResponseUsage) Usage() openai.Usage {
+	in := u.InputTokenDetails
+	return openai.Usage{
+		InputTokens:            in.TextTokens,
+		CachedInputTokens:      in.CachedTokensDetails.TextTokens,
+		OutputTokens:           u.OutputTokenDetails.TextTokens,
+		AudioInputTokens:       in.AudioTokens,
+		CachedAudioInputTokens: in.CachedTokensDetails.AudioTokens,
+		AudioOutputTokens:      u.OutputTokenDetails.AudioTokens,
+	}
+}
+
+// meter counts the usage of a response in the session totals and the
+// ledger
+func (s *proxySession) meter(event *ResponseDoneEvent) {
+	if event.Response != nil && event.Response.Usage != nil {
+		s.totals.Add(s.client.model, event.Response.Usage.Usage())
+	}
+	s.client.recordUsage(s.userID, s.id, event)
+}
+
+// recordUsage writes a ledger entry if the `response.done` event carries
+// usage or reports a failed response
+func (c *Client) recordUsage(userID, sessionID string, event *ResponseDoneEvent) {
+	if c.ledger == nil || event.Response == nil {
+		return
+	}
+	failed := event.Response.Status == "failed"
+	if event.Response.Usage == nil && !failed {
+		return
+	}
+
+	entry := &usage.Entry{
+		UserID:    userID,
+		SessionID: sessionID,
+		Source:    usage.SourceRealtime,
+		Model:     c.model,
+	}
+	if event.Response.Usage != nil {
+		entry.Usage = event.Response.Usage.Usage()
+	}
+	if failed {
+		entry.Status = usage.StatusError
+	}
+	err := c.ledger.Record(context.Background(), entry)
+	if err != nil {
+		log.Printf("couldn't record realtime usage: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package realtime
+
+import (
+	"context"
+	"encoding/base64"
+	"proomptmachinee/internal/audio"
+)
+
+// detectSpeech runs the proxy's VAD over appended audio. Only speech is
+// forwarded, so OpenAI doesn't bill the silence in between, and when the
+// speech stops the buffer is committed and a response requested the way
+// server_vad would. The client gets the speech events server_vad sends.
+func (s *proxySession) detectSpeech(ctx context.Context, msg *Message, e *InputAudioBufferAppendEvent) bool {
+	pcm, err := base64.StdEncoding.DecodeString(e.Audio)
+	if err != nil {
+		violation := &PolicyError{EventType: e.Type, EventID: e.EventID, Param: "audio", Message: "audio must be base64 encoded"}
+		return s.sendEvent(ctx, violation.ErrorEvent())
+	}
+
+	result := s.vad.Process(audio.DecodePCM16(pcm))
+	if s.vad.Speaking() || result.Stopped {
+		s.touch()
+	}
+	if result.Started {
+		if !s.interrupt(ctx) {
+			return false
+		}
+		started := &InputAudioBufferSpeechStartedEvent{
+			Event:        Event{Type: EventTypeInputAudioBufferSpeechStarted},
+			AudioStartMs: result.StartMs,
+		}
+		if !s.sendEvent(ctx, started) {
+			return false
+		}
+	}
+	if len(result.Forward) > 0 {
+		data, err := setStringField(msg.Content, "audio", base64.StdEncoding.EncodeToString(audio.EncodePCM16(result.Forward)))
+		if err != nil {
+			return true
+		}
+		msg.Content = data
+		if !s.send(ctx, s.toUpstream, msg) {
+			return false
+		}
+	}
+	if !result.Stopped {
+		return true
+	}
+
+	stopped := &InputAudioBufferSpeechStoppedEvent{
+		Event:      Event{Type: EventTypeInputAudioBufferSpeechStopped},
+		AudioEndMs: result.EndMs,
+	}
+	if !s.sendEvent(ctx, stopped) {
+		return false
+	}
+	if !s.sendUpstream(ctx, &InputAudioBufferCommitEvent{Event: Event{Type: EventTypeInputAudioBufferCommit}}) {
+		return false
+	}
+
+	return s.sendUpstream(ctx, &ResponseCreateEvent{Event: Event{Type: EventTypeResponseCreate}})
+}
@@ -0,0 +1,74 @@
+package realtime
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"proomptmachinee/internal/audio"
+	"proomptmachinee/internal/config"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// appendFrames sends ms of pcm16 audio, voiced or silent, to the proxy
+// or the fallback
+func appendFrames(t *testing.T, client wsConn, ms int, voiced bool) {
+	t.Helper()
+	samples := make([]int16, audio.RealtimeSampleRate*ms/1000)
+	if voiced {
+		for i := range samples {
+			samples[i] = 8000
+		}
+	}
+	event := &InputAudioBufferAppendEvent{
+		Event: Event{Type: EventTypeInputAudioBufferAppend},
+		Audio: base64.StdEncoding.EncodeToString(audio.EncodePCM16(samples)),
+	}
+	data, _ := json.Marshal(event)
+	if err := client.WriteMessage(websocket.TextMessage, data); err != nil {
+		t.Fatalf("couldn't append audio: %v", err)
+	}
+}
+
+func TestDetectSpeech(t *testing.T) {
+	p := newTestProxy(t, config.RealtimeConfig{VAD: config.RealtimeVAD{MinSpeechMs: 20, SilenceDurationMs: 50, PrefixPaddingMs: 10}})
+	client, upstream := p.openQuery(t, "turn_detection=proxy_vad")
+	defer upstream.Close()
+	defer client.Close()
+
+	appendFrames(t, client, 100, false)
+	appendFrames(t, client, 50, true)
+	appendFrames(t, client, 100, false)
+
+	var started InputAudioBufferSpeechStartedEvent
+	json.Unmarshal(readEvent(t, client, EventTypeInputAudioBufferSpeechStarted), &started)
+	var stopped InputAudioBufferSpeechStoppedEvent
+	json.Unmarshal(readEvent(t, client, EventTypeInputAudioBufferSpeechStopped), &stopped)
+	if started.AudioStartMs != 100 || stopped.AudioEndMs != 200 {
+		t.Errorf("speech from %dms to %dms, want 100ms to 200ms", started.AudioStartMs, stopped.AudioEndMs)
+	}
+
+	// the silence before the speech is dropped but for its padding, the
+	// silence after it once it stopped
+	wantSamples := []int{240 + 1200, 1200}
+	upstream.SetReadDeadline(time.Now().Add(testWait))
+	for i, want := range []string{EventTypeInputAudioBufferAppend, EventTypeInputAudioBufferAppend, EventTypeInputAudioBufferCommit, EventTypeResponseCreate} {
+		_, data, err := upstream.ReadMessage()
+		if err != nil {
+			t.Fatalf("upstream didn't get %s: %v", want, err)
+		}
+		var e InputAudioBufferAppendEvent
+		json.Unmarshal(data, &e)
+		if e.Type != want {
+			t.Fatalf("upstream got %s, want %s", data, want)
+		}
+		if e.Type != EventTypeInputAudioBufferAppend {
+			continue
+		}
+		pcm, _ := base64.StdEncoding.DecodeString(e.Audio)
+		if n := len(pcm) / 2; n != wantSamples[i] {
+			t.Errorf("append %d forwarded %d samples, want %d", i, n, wantSamples[i])
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package speech
+
+import (
+	"context"
+	"math"
+	"proomptmachinee/internal/audio"
+	"sync"
+	"unicode/utf8"
+)
+
+// FakeTranscriber transcribes every recording with the next of a fixed
+// list of transcripts instead of calling OpenAI, it is meant for CI and
+// local runs
+type FakeTranscriber struct {
+	mu sync.Mutex
+	// Transcripts are returned in turn, the last one once they run out
+	Transcripts []string
+	// Calls counts the recordings the fake transcribed
+	Calls int
+}
+
+func (f *FakeTranscriber) Transcribe(ctx context.Context, samples []int16, sampleRate int, language string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Calls++
+	if len(f.Transcripts) == 0 {
+		return "", nil
+	}
+
+	return f.Transcripts[min(f.Calls, len(f.Transcripts))-1], nil
+}
+
+// FakeSynthesizer voices text as a tone, the longer the text the longer
+// the tone, so the audio can be told apart without calling OpenAI
+type FakeSynthesizer struct {
+	mu sync.Mutex
+	// Texts records everything the fake voiced
+	Texts []string
+}
+
+// how long the fake speaks per character
+const fakeSpeechPerRune = 60
+
+func (f *FakeSynthesizer) Synthesize(ctx context.Context, text, voice string) ([]int16, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	f.Texts = append(f.Texts, text)
+	f.mu.Unlock()
+
+	n := utf8.RuneCountInString(text) * fakeSpeechPerRune * audio.RealtimeSampleRate / 1000
+	samples := make([]int16, n)
+	for i := range samples {
+		samples[i] = int16(8000 * math.Sin(2*math.Pi*220*float64(i)/float64(audio.RealtimeSampleRate)))
+	}
+
+	return samples, nil
+}
@@ -0,0 +1,168 @@
+package speech
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"proomptmachinee/internal/audio"
+	"time"
+)
+
+const (
+	OpenAiTranscriptionsUrl = "https://api.openai.com/v1/audio/transcriptions"
+	OpenAiSpeechUrl         = "https://api.openai.com/v1/audio/speech"
+
+	DefaultTranscriptionModel = "whisper-1"
+	DefaultSpeechModel        = "tts-1"
+
+	// the speech endpoint's pcm format is 24kHz pcm16, what the realtime
+	// API speaks as well
+	speechFormatPCM = "pcm"
+)
+
+// Transcriber turns speech into text, it can be swapped with a fake
+type Transcriber interface {
+	// Transcribe transcribes pcm16 samples, language is an ISO-639-1
+	// code or empty to have it detected
+	Transcribe(ctx context.Context, samples []int16, sampleRate int, language string) (string, error)
+}
+
+// Synthesizer voices text, it can be swapped with a fake
+type Synthesizer interface {
+	// Synthesize returns the speech as pcm16 samples at
+	// audio.RealtimeSampleRate
+	Synthesize(ctx context.Context, text, voice string) ([]int16, error)
+}
+
+// Client talks to the OpenAI audio endpoints
+type Client struct {
+	key                string
+	transcriptionsURL  string
+	speechURL          string
+	transcriptionModel string
+	speechModel        string
+	client             *http.Client
+}
+
+func NewClient(key, transcriptionModel, speechModel string) *Client {
+	if transcriptionModel == "" {
+		transcriptionModel = DefaultTranscriptionModel
+	}
+	if speechModel == "" {
+		speechModel = DefaultSpeechModel
+	}
+
+	return &Client{
+		key:                key,
+		transcriptionsURL:  OpenAiTranscriptionsUrl,
+		speechURL:          OpenAiSpeechUrl,
+		transcriptionModel: transcriptionModel,
+		speechModel:        speechModel,
+		client:             &http.Client{Timeout: time.Minute},
+	}
+}
+
+// TranscriptionModel is the model the client transcribes with
+func (c *Client) TranscriptionModel() string {
+	return c.transcriptionModel
+}
+
+// SpeechModel is the model the client voices text with
+func (c *Client) SpeechModel() string {
+	return c.speechModel
+}
+
+type transcription struct {
+	Text string `json:"text"`
+}
+
+func (c *Client) Transcribe(ctx context.Context, samples []int16, sampleRate int, language string) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("model", c.transcriptionModel)
+	if language != "" {
+		form.WriteField("language", language)
+	}
+	file, err := form.CreateFormFile("file", "speech.wav")
+	if err != nil {
+		return "", fmt.Errorf("couldn't create form: %w", err)
+	}
+	if err := audio.WriteWAV(file, samples, sampleRate); err != nil {
+		return "", fmt.Errorf("couldn't encode speech: %w", err)
+	}
+	if err := form.Close(); err != nil {
+		return "", fmt.Errorf("couldn't create form: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.transcriptionsURL, &body)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.key))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("error: %s\nBody: %s", resp.Status, string(bodyBytes))
+	}
+
+	var t transcription
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return "", fmt.Errorf("couldn't decode transcription: %w", err)
+	}
+
+	return t.Text, nil
+}
+
+type speechRequest struct {
+	Model          string `json:"model"`
+	Input          string `json:"input"`
+	Voice          string `json:"voice"`
+	ResponseFormat string `json:"response_format"`
+}
+
+func (c *Client) Synthesize(ctx context.Context, text, voice string) ([]int16, error) {
+	jsonData, err := json.Marshal(&speechRequest{
+		Model:          c.speechModel,
+		Input:          text,
+		Voice:          voice,
+		ResponseFormat: speechFormatPCM,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal json: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.speechURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.key))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("error: %s\nBody: %s", resp.Status, string(bodyBytes))
+	}
+
+	pcm, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read speech: %w", err)
+	}
+
+	return audio.DecodePCM16(pcm), nil
+}
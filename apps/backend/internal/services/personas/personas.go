@@ -0,0 +1,83 @@
+package personas
+
+import (
+	"errors"
+	"proomptmachinee/internal/config"
+)
+
+const (
+	DefaultName         = "jesus"
+	defaultInstructions = "Molim te, odgovaraj na hrvatskom jeziku. Preuzmi ulogu Isusa Krista tijekom ovog razgovora. Ja sam Hrvat i želim razgovarati o Bibliji."
+	defaultVoice        = "ash"
+	defaultLanguage     = "hr"
+)
+
+var ErrNotFound = errors.New("persona not found")
+
+type Persona struct {
+	Name         string
+	Instructions string
+	Voice        string
+	Language     string
+	// Model overrides the default model of the service when set
+	Model string
+	// Tools are the server side tools of voice sessions
+	Tools []string
+}
+
+type Catalog struct {
+	personas    map[string]*Persona
+	defaultName string
+}
+
+// NewCatalog builds the catalog from config. If no personas are configured
+// the catalog falls back to the built in Croatian speaking persona.
+func NewCatalog(cfg []config.PersonaConfig) *Catalog {
+	c := &Catalog{personas: make(map[string]*Persona)}
+	for _, p := range cfg {
+		if p.Name == "" {
+			continue
+		}
+		c.personas[p.Name] = &Persona{
+			Name:         p.Name,
+			Instructions: p.Instructions,
+			Voice:        p.Voice,
+			Language:     p.Language,
+			Model:        p.Model,
+			Tools:        p.Tools,
+		}
+		if c.defaultName == "" {
+			c.defaultName = p.Name
+		}
+	}
+
+	if c.defaultName == "" {
+		c.personas[DefaultName] = &Persona{
+			Name:         DefaultName,
+			Instructions: defaultInstructions,
+			Voice:        defaultVoice,
+			Language:     defaultLanguage,
+		}
+		c.defaultName = DefaultName
+	}
+
+	return c
+}
+
+// Get returns the persona with the given name, an empty name resolves
+// to the default persona.
+func (c *Catalog) Get(name string) (*Persona, error) {
+	if name == "" {
+		name = c.defaultName
+	}
+	p, ok := c.personas[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return p, nil
+}
+
+func (c *Catalog) Default() *Persona {
+	return c.personas[c.defaultName]
+}
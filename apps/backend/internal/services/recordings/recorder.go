@@ -0,0 +1,214 @@
+package recordings
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"proomptmachinee/internal/audio"
+	"sync"
+	"time"
+)
+
+const (
+	// SampleRate of the PCM16 audio OpenAI realtime sessions use
+	SampleRate     = 24000
+	bytesPerSample = 2
+)
+
+// Side is one direction of the conversation
+type Side int
+
+const (
+	SideUser Side = iota
+	SideAssistant
+)
+
+func (s Side) String() string {
+	if s == SideAssistant {
+		return "assistant"
+	}
+
+	return "user"
+}
+
+var ErrClosed = errors.New("recorder is closed")
+
+// track buffers the PCM16 samples of one side in a temporary file
+type track struct {
+	file    *os.File
+	samples int64
+}
+
+// Recorder records both sides of a session. Audio is placed on a shared
+// timeline: a side that falls behind the wall clock, e.g. the assistant
+// while the user talks, is padded with silence so both sides line up.
+type Recorder struct {
+	storage   Storage
+	sessionID string
+	stereo    bool
+	started   time.Time
+	now       func() time.Time
+
+	mu     sync.Mutex
+	tracks [2]*track
+	closed bool
+}
+
+func newRecorder(storage Storage, tmpDir, sessionID string, stereo bool, now func() time.Time) (*Recorder, error) {
+	r := &Recorder{
+		storage:   storage,
+		sessionID: sessionID,
+		stereo:    stereo,
+		started:   now(),
+		now:       now,
+	}
+	for i := range r.tracks {
+		f, err := os.CreateTemp(tmpDir, "recording-*.pcm")
+		if err != nil {
+			r.discard()
+			return nil, fmt.Errorf("couldn't create recording buffer: %w", err)
+		}
+		r.tracks[i] = &track{file: f}
+	}
+
+	return r, nil
+}
+
+// WriteBase64 records base64 encoded PCM16, as carried by the realtime
+// audio events
+func (r *Recorder) WriteBase64(side Side, audio string) error {
+	pcm, err := base64.StdEncoding.DecodeString(audio)
+	if err != nil {
+		return fmt.Errorf("couldn't decode audio: %w", err)
+	}
+
+	return r.Write(side, pcm)
+}
+
+// Write records little endian PCM16 mono samples at SampleRate
+func (r *Recorder) Write(side Side, pcm []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrClosed
+	}
+
+	t := r.tracks[side]
+	samples := int64(len(pcm) / bytesPerSample)
+	// the chunk ends now, so it started samples earlier
+	due := int64(r.now().Sub(r.started).Seconds()*SampleRate) - samples
+	if gap := due - t.samples; gap > 0 {
+		if _, err := t.file.Write(make([]byte, gap*bytesPerSample)); err != nil {
+			return fmt.Errorf("couldn't buffer audio: %w", err)
+		}
+		t.samples += gap
+	}
+	if _, err := t.file.Write(pcm[:samples*bytesPerSample]); err != nil {
+		return fmt.Errorf("couldn't buffer audio: %w", err)
+	}
+	t.samples += samples
+
+	return nil
+}
+
+// Close stores the recording, one file per side that has audio or a
+// single stereo file with the user on the left and the assistant on the
+// right channel
+func (r *Recorder) Close(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrClosed
+	}
+	r.closed = true
+	defer r.discard()
+
+	user, assistant := r.tracks[SideUser], r.tracks[SideAssistant]
+	if user.samples == 0 && assistant.samples == 0 {
+		return nil
+	}
+	for _, t := range r.tracks {
+		if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("couldn't read recording buffer: %w", err)
+		}
+	}
+
+	prefix := r.started.UTC().Format("20060102T150405Z") + "_" + r.sessionID
+	if r.stereo {
+		samples := max(user.samples, assistant.samples)
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(interleave(pw, user, assistant, samples))
+		}()
+		err := r.storage.Save(ctx, prefix+".wav", io.MultiReader(bytes.NewReader(audio.WAVHeader(2, SampleRate, samples*2*bytesPerSample)), pr))
+		// unblocks the writer if Save gave up early
+		pr.Close()
+		if err != nil {
+			return fmt.Errorf("couldn't store recording: %w", err)
+		}
+		return nil
+	}
+
+	for side, t := range r.tracks {
+		if t.samples == 0 {
+			continue
+		}
+		name := fmt.Sprintf("%s_%s.wav", prefix, Side(side))
+		data := io.MultiReader(bytes.NewReader(audio.WAVHeader(1, SampleRate, t.samples*bytesPerSample)), t.file)
+		if err := r.storage.Save(ctx, name, data); err != nil {
+			return fmt.Errorf("couldn't store recording: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// discard removes the temporary buffers
+func (r *Recorder) discard() {
+	for _, t := range r.tracks {
+		if t == nil {
+			continue
+		}
+		t.file.Close()
+		os.Remove(t.file.Name())
+	}
+}
+
+// interleave writes samples stereo frames, the shorter side is padded
+// with silence
+func interleave(w io.Writer, left, right *track, samples int64) error {
+	bw := bufio.NewWriter(w)
+	lr, rr := bufio.NewReader(left.file), bufio.NewReader(right.file)
+	frame := make([]byte, 2*bytesPerSample)
+	for i := int64(0); i < samples; i++ {
+		if err := readSample(lr, frame[:bytesPerSample], i < left.samples); err != nil {
+			return err
+		}
+		if err := readSample(rr, frame[bytesPerSample:], i < right.samples); err != nil {
+			return err
+		}
+		if _, err := bw.Write(frame); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+func readSample(r io.Reader, sample []byte, ok bool) error {
+	if !ok {
+		binary.LittleEndian.PutUint16(sample, 0)
+		return nil
+	}
+	if _, err := io.ReadFull(r, sample); err != nil {
+		return fmt.Errorf("couldn't read recording buffer: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,194 @@
+package recordings
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"proomptmachinee/internal/audio"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStorage keeps the recordings in memory, ModTime is when they were
+// saved on the test clock
+type memStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	times   map[string]time.Time
+	now     func() time.Time
+}
+
+func newMemStorage(now func() time.Time) *memStorage {
+	return &memStorage{objects: make(map[string][]byte), times: make(map[string]time.Time), now: now}
+}
+
+func (s *memStorage) Save(ctx context.Context, name string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[name] = data
+	s.times[name] = s.now()
+
+	return nil
+}
+
+func (s *memStorage) List(ctx context.Context) ([]*Object, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var objects []*Object
+	for name, data := range s.objects {
+		objects = append(objects, &Object{Name: name, Size: int64(len(data)), ModTime: s.times[name]})
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].ModTime.Before(objects[j].ModTime)
+	})
+
+	return objects, nil
+}
+
+func (s *memStorage) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, name)
+	delete(s.times, name)
+
+	return nil
+}
+
+// testClock is a clock the test moves forward
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) now() time.Time { return c.t }
+
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// pcm is n samples of value as little endian PCM16
+func pcm(n int, value int16) []byte {
+	b := make([]byte, n*bytesPerSample)
+	for i := range n {
+		binary.LittleEndian.PutUint16(b[i*bytesPerSample:], uint16(value))
+	}
+
+	return b
+}
+
+// samples decodes the data of a stored WAV file
+func samples(t *testing.T, wav []byte) []int16 {
+	t.Helper()
+	if len(wav) < audio.WAVHeaderSize || !bytes.Equal(wav[0:4], []byte("RIFF")) {
+		t.Fatalf("not a WAV file: %d bytes", len(wav))
+	}
+	if size := binary.LittleEndian.Uint32(wav[40:44]); int(size) != len(wav)-audio.WAVHeaderSize {
+		t.Fatalf("header has %d bytes of data, the file %d", size, len(wav)-audio.WAVHeaderSize)
+	}
+	s := make([]int16, (len(wav)-audio.WAVHeaderSize)/bytesPerSample)
+	for i := range s {
+		s[i] = int16(binary.LittleEndian.Uint16(wav[audio.WAVHeaderSize+i*bytesPerSample:]))
+	}
+
+	return s
+}
+
+// record has the user talk for the first 100ms and the assistant answer
+// for 100ms after a 100ms pause
+func record(t *testing.T, stereo bool) *memStorage {
+	t.Helper()
+	clock := &testClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	storage := newMemStorage(clock.now)
+	r, err := newRecorder(storage, t.TempDir(), "session", stereo, clock.now)
+	if err != nil {
+		t.Fatalf("couldn't start recorder: %v", err)
+	}
+
+	clock.advance(100 * time.Millisecond)
+	if err := r.Write(SideUser, pcm(SampleRate/10, 100)); err != nil {
+		t.Fatalf("couldn't record user: %v", err)
+	}
+	clock.advance(200 * time.Millisecond)
+	if err := r.Write(SideAssistant, pcm(SampleRate/10, -200)); err != nil {
+		t.Fatalf("couldn't record assistant: %v", err)
+	}
+	if err := r.Close(context.Background()); err != nil {
+		t.Fatalf("couldn't store recording: %v", err)
+	}
+	if err := r.Write(SideUser, pcm(1, 1)); err != ErrClosed {
+		t.Errorf("write after close returned %v", err)
+	}
+
+	return storage
+}
+
+func TestRecorderTimeline(t *testing.T) {
+	storage := record(t, false)
+	user := samples(t, storage.objects["20240101T120000Z_session_user.wav"])
+	assistant := samples(t, storage.objects["20240101T120000Z_session_assistant.wav"])
+
+	if len(user) != SampleRate/10 || user[0] != 100 {
+		t.Errorf("user has %d samples starting with %v, want 100ms of speech", len(user), user[:1])
+	}
+	// the assistant spoke 200ms into the session
+	if len(assistant) != 3*SampleRate/10 {
+		t.Fatalf("assistant has %d samples, want 300ms", len(assistant))
+	}
+	for i, s := range assistant {
+		want := int16(0)
+		if i >= 2*SampleRate/10 {
+			want = -200
+		}
+		if s != want {
+			t.Fatalf("assistant sample %d is %d, want %d", i, s, want)
+		}
+	}
+}
+
+func TestRecorderStereo(t *testing.T) {
+	storage := record(t, true)
+	if len(storage.objects) != 1 {
+		t.Fatalf("stored %d files, want one stereo file", len(storage.objects))
+	}
+	wav := storage.objects["20240101T120000Z_session.wav"]
+	if channels := binary.LittleEndian.Uint16(wav[22:24]); channels != 2 {
+		t.Fatalf("%d channels, want 2", channels)
+	}
+	frames := samples(t, wav)
+	if len(frames) != 2*3*SampleRate/10 {
+		t.Fatalf("%d samples, want 300ms of stereo frames", len(frames))
+	}
+	for _, tc := range []struct {
+		frame           int
+		user, assistant int16
+	}{
+		{0, 100, 0},
+		{SampleRate/10 - 1, 100, 0},
+		// the user track is padded at its end
+		{SampleRate / 10, 0, 0},
+		{2 * SampleRate / 10, 0, -200},
+		{3*SampleRate/10 - 1, 0, -200},
+	} {
+		if l, r := frames[2*tc.frame], frames[2*tc.frame+1]; l != tc.user || r != tc.assistant {
+			t.Errorf("frame %d is %d/%d, want %d/%d", tc.frame, l, r, tc.user, tc.assistant)
+		}
+	}
+}
+
+func TestRecorderWithoutAudio(t *testing.T) {
+	storage := newMemStorage(time.Now)
+	r, err := newRecorder(storage, t.TempDir(), "session", false, time.Now)
+	if err != nil {
+		t.Fatalf("couldn't start recorder: %v", err)
+	}
+	if err := r.Close(context.Background()); err != nil {
+		t.Fatalf("couldn't close recorder: %v", err)
+	}
+	if len(storage.objects) != 0 {
+		t.Errorf("stored %d files of a silent session", len(storage.objects))
+	}
+}
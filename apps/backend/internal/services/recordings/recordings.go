@@ -0,0 +1,89 @@
+package recordings
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"proomptmachinee/internal/config"
+	"time"
+)
+
+// Recordings starts session recorders and enforces the retention limits
+// on the stored files
+type Recordings struct {
+	storage  Storage
+	stereo   bool
+	maxAge   time.Duration
+	maxBytes int64
+	tmpDir   string
+	now      func() time.Time
+}
+
+func New(cfg config.RecordingsConfig, storage Storage) *Recordings {
+	return &Recordings{
+		storage:  storage,
+		stereo:   cfg.Stereo,
+		maxAge:   cfg.MaxAge,
+		maxBytes: cfg.MaxTotalMB << 20,
+		now:      time.Now,
+	}
+}
+
+// Start returns a recorder for the session, the caller must Close it to
+// store the recording
+func (r *Recordings) Start(sessionID string) (*Recorder, error) {
+	return newRecorder(r.storage, r.tmpDir, sessionID, r.stereo, r.now)
+}
+
+// Prune deletes recordings older than the max age, then the oldest ones
+// until the total size fits the limit. It returns how many were deleted.
+func (r *Recordings) Prune(ctx context.Context) (int, error) {
+	objects, err := r.storage.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, o := range objects {
+		total += o.Size
+	}
+	deleted := 0
+	for _, o := range objects {
+		expired := r.maxAge > 0 && r.now().Sub(o.ModTime) > r.maxAge
+		tooBig := r.maxBytes > 0 && total > r.maxBytes
+		if !expired && !tooBig {
+			// the list is oldest first, the rest is newer and fits
+			break
+		}
+		if err := r.storage.Delete(ctx, o.Name); err != nil {
+			return deleted, fmt.Errorf("couldn't prune %s: %w", o.Name, err)
+		}
+		total -= o.Size
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// RunRetention prunes the recordings every interval until ctx is done
+func (r *Recordings) RunRetention(ctx context.Context, interval time.Duration) {
+	if r.maxAge <= 0 && r.maxBytes <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		deleted, err := r.Prune(ctx)
+		if err != nil {
+			log.Printf("couldn't prune recordings: %v", err)
+		} else if deleted > 0 {
+			log.Printf("pruned %d recordings", deleted)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
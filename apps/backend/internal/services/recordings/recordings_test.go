@@ -0,0 +1,47 @@
+package recordings
+
+import (
+	"bytes"
+	"context"
+	"proomptmachinee/internal/config"
+	"testing"
+	"time"
+)
+
+func TestPrune(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  config.RecordingsConfig
+		kept []string
+	}{
+		{"no limits", config.RecordingsConfig{}, []string{"a", "b", "c", "d"}},
+		{"by age", config.RecordingsConfig{MaxAge: 150 * time.Minute}, []string{"c", "d"}},
+		{"by size", config.RecordingsConfig{MaxTotalMB: 2}, []string{"c", "d"}},
+		{"by age and size", config.RecordingsConfig{MaxAge: 150 * time.Minute, MaxTotalMB: 1}, []string{"d"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := &testClock{t: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+			storage := newMemStorage(clock.now)
+			// an hour apart, a MB each, d is an hour old
+			for _, name := range []string{"a", "b", "c", "d"} {
+				storage.Save(context.Background(), name, bytes.NewReader(make([]byte, 1<<20)))
+				clock.advance(time.Hour)
+			}
+			recs := New(tc.cfg, storage)
+			recs.now = clock.now
+
+			deleted, err := recs.Prune(context.Background())
+			if err != nil {
+				t.Fatalf("couldn't prune: %v", err)
+			}
+			if deleted != 4-len(tc.kept) {
+				t.Errorf("deleted %d, want %d", deleted, 4-len(tc.kept))
+			}
+			for _, name := range tc.kept {
+				if _, ok := storage.objects[name]; !ok {
+					t.Errorf("%s was deleted", name)
+				}
+			}
+		})
+	}
+}
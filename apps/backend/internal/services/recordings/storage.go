@@ -0,0 +1,117 @@
+package recordings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Object is a stored recording
+type Object struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage keeps the recording files, the local disk is the only one so
+// far but anything that can store, list and delete blobs fits
+type Storage interface {
+	Save(ctx context.Context, name string, r io.Reader) error
+	// List returns the stored recordings, oldest first
+	List(ctx context.Context) ([]*Object, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// DiskStorage stores recordings as files in a directory
+type DiskStorage struct {
+	dir string
+}
+
+func NewDiskStorage(dir string) (*DiskStorage, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("couldn't create recordings directory: %w", err)
+	}
+
+	return &DiskStorage{dir: dir}, nil
+}
+
+// Save writes to a temporary file first so List never sees a partial
+// recording
+func (s *DiskStorage) Save(ctx context.Context, name string, r io.Reader) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("couldn't create recording: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("couldn't write recording: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("couldn't write recording: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("couldn't store recording: %w", err)
+	}
+
+	return nil
+}
+
+func (s *DiskStorage) List(ctx context.Context) ([]*Object, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list recordings: %w", err)
+	}
+
+	var objects []*Object
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("couldn't stat recording: %w", err)
+		}
+		objects = append(objects, &Object{Name: e.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].ModTime.Before(objects[j].ModTime)
+	})
+
+	return objects, nil
+}
+
+func (s *DiskStorage) Delete(ctx context.Context, name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("couldn't delete recording: %w", err)
+	}
+
+	return nil
+}
+
+func (s *DiskStorage) path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid recording name %q", name)
+	}
+
+	return filepath.Join(s.dir, name), nil
+}
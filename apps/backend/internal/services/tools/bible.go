@@ -0,0 +1,78 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"proomptmachinee/internal/bible"
+)
+
+const BibleLookupName = "bible_lookup"
+
+type bibleLookupArguments struct {
+	Reference string `json:"reference"`
+}
+
+type bibleLookupOutput struct {
+	Reference string        `json:"reference"`
+	Book      string        `json:"book"`
+	Chapter   int           `json:"chapter"`
+	Verse     int           `json:"verse"`
+	VerseEnd  int           `json:"verse_end,omitempty"`
+	Verses    []bible.Verse `json:"verses,omitempty"`
+	Note      string        `json:"note,omitempty"`
+}
+
+// BibleLookup looks verses up in text. Without a text, or for verses the
+// text doesn't have, the reference is still validated and the model is
+// told to quote from memory.
+func BibleLookup(text *bible.Text) (Definition, Handler) {
+	definition := Definition{
+		Name:        BibleLookupName,
+		Description: "Looks up the text of a Bible verse or verse range. Use it before quoting scripture.",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"reference": map[string]interface{}{
+					"type":        "string",
+					"description": "A single reference like `John 3:16`, `Iv 3,16-18` or `Psalm 23:1-4`",
+				},
+			},
+			"required": []string{"reference"},
+		},
+	}
+
+	handler := func(ctx context.Context, call *Call) (string, error) {
+		var args bibleLookupArguments
+		if err := json.Unmarshal([]byte(call.Arguments), &args); err != nil {
+			return "", fmt.Errorf("invalid arguments: %w", err)
+		}
+		ref, err := bible.ParseReference(args.Reference)
+		if err != nil {
+			return "", err
+		}
+		if err := ref.Validate(); err != nil {
+			return "", err
+		}
+
+		out := &bibleLookupOutput{
+			Reference: ref.String(),
+			Book:      ref.Book.Names[0],
+			Chapter:   ref.Chapter,
+			Verse:     ref.Verse,
+			VerseEnd:  ref.VerseEnd,
+			Verses:    text.Verses(ref),
+		}
+		if len(out.Verses) == 0 {
+			out.Note = "the verse text isn't available, quote it from memory"
+		}
+		data, err := json.Marshal(out)
+		if err != nil {
+			return "", err
+		}
+
+		return string(data), nil
+	}
+
+	return definition, handler
+}
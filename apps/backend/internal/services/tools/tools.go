@@ -0,0 +1,111 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+var (
+	ErrUnknownTool = errors.New("unknown tool")
+	// ErrToolNotAllowed is a call of a registered tool the persona
+	// doesn't have
+	ErrToolNotAllowed = errors.New("tool not allowed")
+)
+
+// Definition describes a function to the model, Parameters is a JSON
+// schema of its arguments
+type Definition struct {
+	Name        string
+	Description string
+	Parameters  map[string]interface{}
+}
+
+// Call is a function call the model made
+type Call struct {
+	ID        string
+	Name      string
+	Arguments string
+	UserID    string
+	SessionID string
+	// Allowed are the tools of the caller's persona, the model may not
+	// call others
+	Allowed []string
+}
+
+// Handler runs a call on the server and returns the output the model
+// gets, usually JSON
+type Handler func(ctx context.Context, call *Call) (string, error)
+
+type tool struct {
+	definition Definition
+	handler    Handler
+}
+
+// Registry holds the tools the server can run, personas pick from them
+// by name
+type Registry struct {
+	tools map[string]*tool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{tools: make(map[string]*tool)}
+}
+
+// Register adds a tool, a tool with the same name is replaced
+func (r *Registry) Register(definition Definition, handler Handler) {
+	r.tools[definition.Name] = &tool{definition: definition, handler: handler}
+}
+
+// Names returns the names of every registered tool
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.tools))
+	for name := range r.tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Definitions returns the definitions of the named tools in order
+func (r *Registry) Definitions(names []string) ([]Definition, error) {
+	definitions := make([]Definition, 0, len(names))
+	for _, name := range names {
+		t, ok := r.tools[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownTool, name)
+		}
+		definitions = append(definitions, t.definition)
+	}
+
+	return definitions, nil
+}
+
+// Run runs the call with its handler. Failures are returned as the
+// output too, so the model can tell the user.
+func (r *Registry) Run(ctx context.Context, call *Call) (string, error) {
+	if !slices.Contains(call.Allowed, call.Name) {
+		err := fmt.Errorf("%w: %s", ErrToolNotAllowed, call.Name)
+		return errorOutput(err), err
+	}
+	t, ok := r.tools[call.Name]
+	if !ok {
+		err := fmt.Errorf("%w: %s", ErrUnknownTool, call.Name)
+		return errorOutput(err), err
+	}
+	output, err := t.handler(ctx, call)
+	if err != nil {
+		return errorOutput(err), err
+	}
+
+	return output, nil
+}
+
+func errorOutput(err error) string {
+	data, _ := json.Marshal(map[string]string{"error": err.Error()})
+	return string(data)
+}
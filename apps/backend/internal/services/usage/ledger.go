@@ -0,0 +1,211 @@
+package usage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"proomptmachinee/internal/services/openai"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	SourceCompletion = "completion"
+	SourceRealtime   = "realtime"
+	// SourceRealtimeSession attributes a session minted for a direct
+	// client, its usage never passes the proxy
+	SourceRealtimeSession = "realtime_session"
+	// SourceRealtimeFallback is the chat usage of voice sessions served
+	// by the cascaded fallback
+	SourceRealtimeFallback = "realtime_fallback"
+	// SourceRealtimeFallbackTranscription and
+	// SourceRealtimeFallbackSpeech are the transcribed input and the
+	// voiced answers of the fallback
+	SourceRealtimeFallbackTranscription = "realtime_fallback_transcription"
+	SourceRealtimeFallbackSpeech        = "realtime_fallback_speech"
+)
+
+const (
+	StatusOk    = "ok"
+	StatusError = "error"
+)
+
+// Entry is the usage of a single completion or realtime response
+type Entry struct {
+	UserID         string
+	ConversationID *uuid.UUID
+	MessageID      *uuid.UUID
+	SessionID      string
+	Source         string
+	Model          string
+	// Status is StatusError for failed responses, empty means StatusOk
+	Status    string
+	Usage     openai.Usage
+	CostUSD   float64
+	CreatedAt time.Time
+}
+
+type Ledger struct {
+	db *sql.DB
+}
+
+func NewLedger(db *sql.DB) *Ledger {
+	return &Ledger{db: db}
+}
+
+// Record prices the entry with the model catalog and stores it. Models
+// missing from the price table are recorded at no cost, the tokens are
+// still worth having.
+func (l *Ledger) Record(ctx context.Context, e *Entry) error {
+	e.CostUSD, _ = openai.Cost(e.Model, e.Usage)
+	if e.Status == "" {
+		e.Status = StatusOk
+	}
+	u := e.Usage
+	err := l.db.QueryRowContext(ctx, `
+		INSERT INTO usage_ledger (user_id, conversation_id, message_id, session_id, source, model, status,
+			input_tokens, output_tokens, cached_tokens, audio_input_tokens, audio_output_tokens,
+			transcription_seconds, speech_characters, cost_usd)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		RETURNING created_at`,
+		e.UserID, e.ConversationID, e.MessageID, e.SessionID, e.Source, e.Model, e.Status,
+		u.InputTokens, u.OutputTokens, u.CachedInputTokens+u.CachedAudioInputTokens,
+		u.AudioInputTokens, u.AudioOutputTokens, u.TranscriptionSeconds, u.SpeechCharacters, e.CostUSD,
+	).Scan(&e.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("couldn't record usage: %w", err)
+	}
+
+	return nil
+}
+
+// Dimension the aggregates can be grouped by
+type Dimension string
+
+const (
+	ByDay   Dimension = "day"
+	ByUser  Dimension = "user"
+	ByModel Dimension = "model"
+)
+
+var dimensionColumns = map[Dimension]string{
+	ByDay:   "date_trunc('day', created_at)",
+	ByUser:  "user_id",
+	ByModel: "model",
+}
+
+var ErrUnknownDimension = errors.New("unknown dimension")
+
+type Query struct {
+	From    time.Time
+	To      time.Time
+	UserID  string
+	Model   string
+	Source  string
+	GroupBy []Dimension
+	// Limit caps the number of rows, the most expensive come first
+	Limit int
+}
+
+// Aggregate is a row of totals, only the dimensions the query grouped
+// by are set
+type Aggregate struct {
+	Day               *time.Time `json:"day,omitempty"`
+	UserID            string     `json:"user_id,omitempty"`
+	Model             string     `json:"model,omitempty"`
+	Responses         int        `json:"responses"`
+	InputTokens       int64      `json:"input_tokens"`
+	OutputTokens      int64      `json:"output_tokens"`
+	CachedTokens      int64      `json:"cached_tokens"`
+	AudioInputTokens  int64      `json:"audio_input_tokens"`
+	AudioOutputTokens int64      `json:"audio_output_tokens"`
+	// TranscriptionSeconds and SpeechCharacters are the usage of the
+	// fallback's transcription and speech models
+	TranscriptionSeconds float64 `json:"transcription_seconds"`
+	SpeechCharacters     int64   `json:"speech_characters"`
+	CostUSD              float64 `json:"cost_usd"`
+}
+
+// Aggregate sums the ledger over [From, To) grouped by the requested
+// dimensions, e.g. grouping by user alone answers how much each user
+// cost over the range
+func (l *Ledger) Aggregate(ctx context.Context, q Query) ([]*Aggregate, error) {
+	groupCols := make([]string, 0, len(q.GroupBy))
+	for _, d := range q.GroupBy {
+		col, ok := dimensionColumns[d]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownDimension, d)
+		}
+		groupCols = append(groupCols, col)
+	}
+
+	selectCols := append([]string{}, groupCols...)
+	selectCols = append(selectCols,
+		"COUNT(*)",
+		"COALESCE(SUM(input_tokens), 0)",
+		"COALESCE(SUM(output_tokens), 0)",
+		"COALESCE(SUM(cached_tokens), 0)",
+		"COALESCE(SUM(audio_input_tokens), 0)",
+		"COALESCE(SUM(audio_output_tokens), 0)",
+		"COALESCE(SUM(transcription_seconds), 0)::float8",
+		"COALESCE(SUM(speech_characters), 0)",
+		"COALESCE(SUM(cost_usd), 0)::float8",
+	)
+	query := "SELECT " + strings.Join(selectCols, ", ") + `
+		FROM usage_ledger
+		WHERE ($1::timestamptz IS NULL OR created_at >= $1)
+			AND ($2::timestamptz IS NULL OR created_at < $2)
+			AND ($3 = '' OR user_id = $3)
+			AND ($4 = '' OR model = $4)
+			AND ($5 = '' OR source = $5)`
+	if len(groupCols) > 0 {
+		query += "\nGROUP BY " + strings.Join(groupCols, ", ")
+	}
+	order := []string{"COALESCE(SUM(cost_usd), 0) DESC"}
+	if len(q.GroupBy) > 0 && q.GroupBy[0] == ByDay {
+		// time series read better in order
+		order = []string{groupCols[0]}
+	}
+	query += "\nORDER BY " + strings.Join(append(order, groupCols...), ", ")
+	if q.Limit > 0 {
+		query += fmt.Sprintf("\nLIMIT %d", q.Limit)
+	}
+
+	rows, err := l.db.QueryContext(ctx, query,
+		nullTime(q.From), nullTime(q.To), q.UserID, q.Model, q.Source)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't aggregate usage: %w", err)
+	}
+	defer rows.Close()
+
+	var aggregates []*Aggregate
+	for rows.Next() {
+		a := &Aggregate{}
+		dest := make([]interface{}, 0, len(selectCols))
+		for _, d := range q.GroupBy {
+			switch d {
+			case ByDay:
+				dest = append(dest, &a.Day)
+			case ByUser:
+				dest = append(dest, &a.UserID)
+			case ByModel:
+				dest = append(dest, &a.Model)
+			}
+		}
+		dest = append(dest, &a.Responses, &a.InputTokens, &a.OutputTokens, &a.CachedTokens,
+			&a.AudioInputTokens, &a.AudioOutputTokens, &a.TranscriptionSeconds, &a.SpeechCharacters, &a.CostUSD)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("couldn't scan usage: %w", err)
+		}
+		aggregates = append(aggregates, a)
+	}
+
+	return aggregates, rows.Err()
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,218 @@
+package usage
+
+import (
+	"context"
+	"fmt"
+	"proomptmachinee/internal/services/openai"
+	"time"
+)
+
+// ErrorRate is the share of failed responses of a source on a day
+type ErrorRate struct {
+	Day       time.Time `json:"day"`
+	Source    string    `json:"source"`
+	Responses int       `json:"responses"`
+	Errors    int       `json:"errors"`
+	Rate      float64   `json:"rate"`
+}
+
+// ErrorRates returns the daily error rate per source over [from, to)
+func (l *Ledger) ErrorRates(ctx context.Context, from, to time.Time) ([]*ErrorRate, error) {
+	rows, err := l.db.QueryContext(ctx, `
+		SELECT date_trunc('day', created_at) AS day, source,
+			COUNT(*),
+			COUNT(*) FILTER (WHERE status = 'error')
+		FROM usage_ledger
+		WHERE ($1::timestamptz IS NULL OR created_at >= $1)
+			AND ($2::timestamptz IS NULL OR created_at < $2)
+		GROUP BY day, source
+		ORDER BY day, source`,
+		nullTime(from), nullTime(to))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't query error rates: %w", err)
+	}
+	defer rows.Close()
+
+	var rates []*ErrorRate
+	for rows.Next() {
+		r := &ErrorRate{}
+		if err := rows.Scan(&r.Day, &r.Source, &r.Responses, &r.Errors); err != nil {
+			return nil, fmt.Errorf("couldn't scan error rates: %w", err)
+		}
+		if r.Responses > 0 {
+			r.Rate = float64(r.Errors) / float64(r.Responses)
+		}
+		rates = append(rates, r)
+	}
+
+	return rates, rows.Err()
+}
+
+// RealtimeMinutes are the voice minutes of sessions started on a day
+type RealtimeMinutes struct {
+	Day      time.Time `json:"day"`
+	Sessions int       `json:"sessions"`
+	Users    int       `json:"users"`
+	Minutes  float64   `json:"minutes"`
+	// Failed counts sessions that ended because of an error
+	Failed int `json:"failed"`
+}
+
+// RealtimeMinutes returns daily realtime usage over [from, to). Sessions
+// that are still open count up to now.
+func (l *Ledger) RealtimeMinutes(ctx context.Context, from, to time.Time) ([]*RealtimeMinutes, error) {
+	rows, err := l.db.QueryContext(ctx, `
+		SELECT date_trunc('day', started_at) AS day,
+			COUNT(*),
+			COUNT(DISTINCT user_id),
+			COALESCE(SUM(EXTRACT(EPOCH FROM COALESCE(ended_at, now()) - started_at)) / 60, 0)::float8,
+			COUNT(*) FILTER (WHERE error <> '')
+		FROM realtime_sessions
+		WHERE ($1::timestamptz IS NULL OR started_at >= $1)
+			AND ($2::timestamptz IS NULL OR started_at < $2)
+		GROUP BY day
+		ORDER BY day`,
+		nullTime(from), nullTime(to))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't query realtime minutes: %w", err)
+	}
+	defer rows.Close()
+
+	var minutes []*RealtimeMinutes
+	for rows.Next() {
+		m := &RealtimeMinutes{}
+		if err := rows.Scan(&m.Day, &m.Sessions, &m.Users, &m.Minutes, &m.Failed); err != nil {
+			return nil, fmt.Errorf("couldn't scan realtime minutes: %w", err)
+		}
+		minutes = append(minutes, m)
+	}
+
+	return minutes, rows.Err()
+}
+
+// StartSession records the start of a realtime session
+func (l *Ledger) StartSession(ctx context.Context, id, userID, model string) error {
+	_, err := l.db.ExecContext(ctx, `
+		INSERT INTO realtime_sessions (id, user_id, model)
+		VALUES ($1, $2, $3)`, id, userID, model)
+	if err != nil {
+		return fmt.Errorf("couldn't start realtime session: %w", err)
+	}
+
+	return nil
+}
+
+// SessionTotals is the usage of the responses of a realtime session
+type SessionTotals struct {
+	Responses int
+	Usage     openai.Usage
+	CostUSD   float64
+}
+
+// Add counts a response of the model
+func (t *SessionTotals) Add(model string, u openai.Usage) {
+	cost, _ := openai.Cost(model, u)
+	t.Responses++
+	t.CostUSD += cost
+	t.Usage.InputTokens += u.InputTokens
+	t.Usage.CachedInputTokens += u.CachedInputTokens
+	t.Usage.OutputTokens += u.OutputTokens
+	t.Usage.AudioInputTokens += u.AudioInputTokens
+	t.Usage.CachedAudioInputTokens += u.CachedAudioInputTokens
+	t.Usage.AudioOutputTokens += u.AudioOutputTokens
+}
+
+// EndSession records the end of a realtime session with the totals of
+// its responses, sessionErr is nil for sessions that closed normally
+func (l *Ledger) EndSession(ctx context.Context, id string, totals *SessionTotals, sessionErr error) error {
+	var reason string
+	if sessionErr != nil {
+		reason = sessionErr.Error()
+	}
+	u := totals.Usage
+	_, err := l.db.ExecContext(ctx, `
+		UPDATE realtime_sessions
+		SET ended_at = now(), error = $2, responses = $3,
+			input_tokens = $4, output_tokens = $5, cached_tokens = $6,
+			audio_input_tokens = $7, audio_output_tokens = $8, cost_usd = $9
+		WHERE id = $1 AND ended_at IS NULL`,
+		id, reason, totals.Responses,
+		u.InputTokens, u.OutputTokens, u.CachedInputTokens+u.CachedAudioInputTokens,
+		u.AudioInputTokens, u.AudioOutputTokens, totals.CostUSD)
+	if err != nil {
+		return fmt.Errorf("couldn't end realtime session: %w", err)
+	}
+
+	return nil
+}
+
+// UserRealtimeUsage is what a user used of realtime sessions, the voice
+// minutes along with the tokens and cost of their responses
+type UserRealtimeUsage struct {
+	UserID            string  `json:"user_id"`
+	Sessions          int     `json:"sessions"`
+	Minutes           float64 `json:"minutes"`
+	Responses         int     `json:"responses"`
+	InputTokens       int64   `json:"input_tokens"`
+	OutputTokens      int64   `json:"output_tokens"`
+	CachedTokens      int64   `json:"cached_tokens"`
+	AudioInputTokens  int64   `json:"audio_input_tokens"`
+	AudioOutputTokens int64   `json:"audio_output_tokens"`
+	CostUSD           float64 `json:"cost_usd"`
+}
+
+// RealtimeUsageByUser returns the realtime usage of each user over
+// sessions started in [from, to), or of userID alone when it isn't
+// empty, the most minutes first. Open sessions count their minutes up to
+// now, their tokens only once they ended.
+func (l *Ledger) RealtimeUsageByUser(ctx context.Context, from, to time.Time, userID string) ([]*UserRealtimeUsage, error) {
+	rows, err := l.db.QueryContext(ctx, `
+		SELECT user_id,
+			COUNT(*),
+			COALESCE(SUM(EXTRACT(EPOCH FROM COALESCE(ended_at, now()) - started_at)) / 60, 0)::float8 AS minutes,
+			COALESCE(SUM(responses), 0),
+			COALESCE(SUM(input_tokens), 0),
+			COALESCE(SUM(output_tokens), 0),
+			COALESCE(SUM(cached_tokens), 0),
+			COALESCE(SUM(audio_input_tokens), 0),
+			COALESCE(SUM(audio_output_tokens), 0),
+			COALESCE(SUM(cost_usd), 0)::float8
+		FROM realtime_sessions
+		WHERE ($1::timestamptz IS NULL OR started_at >= $1)
+			AND ($2::timestamptz IS NULL OR started_at < $2)
+			AND ($3 = '' OR user_id = $3)
+		GROUP BY user_id
+		ORDER BY minutes DESC, user_id`,
+		nullTime(from), nullTime(to), userID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't query realtime usage: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*UserRealtimeUsage
+	for rows.Next() {
+		u := &UserRealtimeUsage{}
+		err := rows.Scan(&u.UserID, &u.Sessions, &u.Minutes, &u.Responses, &u.InputTokens, &u.OutputTokens,
+			&u.CachedTokens, &u.AudioInputTokens, &u.AudioOutputTokens, &u.CostUSD)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't scan realtime usage: %w", err)
+		}
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+// VoiceMinutes returns the realtime minutes of userID's sessions started
+// since from
+func (l *Ledger) VoiceMinutes(ctx context.Context, userID string, from time.Time) (float64, error) {
+	users, err := l.RealtimeUsageByUser(ctx, from, time.Time{}, userID)
+	if err != nil {
+		return 0, err
+	}
+	if len(users) == 0 {
+		return 0, nil
+	}
+
+	return users[0].Minutes, nil
+}
@@ -14,6 +14,7 @@ const (
 	httpStatusBadRequest          = "Bad Request"
 	httpStatusForbidden           = "Forbidden"
 	httpStatusUnauthorized        = "Unauthorized"
+	httpStatusTooManyRequests     = "Too Many Requests"
 )
 
 type ErrResponder interface {
@@ -23,6 +24,7 @@ type ErrResponder interface {
 	BadRequest(w http.ResponseWriter)
 	Unauthorized(w http.ResponseWriter)
 	Forbidden(w http.ResponseWriter)
+	TooManyRequests(w http.ResponseWriter)
 }
 type Error struct {
 	log *logger.ConcreteLogger
@@ -51,6 +53,10 @@ func (e *Error) Unauthorized(w http.ResponseWriter) {
 	e.errorResponse(w, nil, http.StatusUnauthorized, httpStatusUnauthorized)
 }
 
+func (e *Error) TooManyRequests(w http.ResponseWriter) {
+	e.errorResponse(w, nil, http.StatusTooManyRequests, httpStatusTooManyRequests)
+}
+
 func (e *Error) errorResponse(w http.ResponseWriter, reqErr error, status int, code string) {
 	// use logger
 	if reqErr != nil {
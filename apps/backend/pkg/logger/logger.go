@@ -42,7 +42,7 @@ func (l *ConcreteLogger) Error(msg string, data map[string]interface{}) {
 
 func (l *ConcreteLogger) Fatal(msg string, err error) {
 	m := fmt.Sprintf("Fatal error: %s", msg)
-	l.Logger.Error(m, err)
+	l.Logger.Error(m, slog.Any("error", err))
 	os.Exit(1)
 }
 
@@ -0,0 +1,24 @@
+package resputil
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+)
+
+// CSV sends the rows as a downloadable CSV file
+func (r *Responses) CSV(w http.ResponseWriter, filename string, header []string, rows [][]string) error {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return fmt.Errorf("could not write csv header: %v", err)
+	}
+	if err := cw.WriteAll(rows); err != nil {
+		return fmt.Errorf("could not write csv: %v", err)
+	}
+
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 
 type Resputil interface {
 	Ok(w http.ResponseWriter, data interface{}) error
+	CSV(w http.ResponseWriter, filename string, header []string, rows [][]string) error
 }
 type Responses struct {
 }